@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"lan-relay/internal/config"
+	"lan-relay/internal/hub"
+	"lan-relay/internal/logger"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	agentHubURL string
+	agentName   string
+)
+
+// agentCmd connects the home network to a hub
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Connect this network to a LAN Relay hub",
+	Long: `Connect out to a LAN Relay hub (lan-relay hub) and let it reach targets
+on this network. The agent authenticates with the pre-shared key in AGENT_KEY,
+reconnects with backoff when the connection drops, and only connects the hub to
+addresses in AGENT_ALLOWED_NETWORKS. No port has to be opened for it. The hub
+must be reached over HTTPS, unless it runs on this host, and must answer with a
+signature made with the same key.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runAgent()
+	},
+	SilenceErrors: true,
+	SilenceUsage:  true,
+}
+
+func init() {
+	agentCmd.Flags().StringVar(&agentHubURL, "hub", "", "URL of the hub, such as https://hub.example.com (default: AGENT_HUB_URL)")
+	agentCmd.Flags().StringVar(&agentName, "name", "", "Name the agent connects as (default: AGENT_NAME, or the hostname)")
+	rootCmd.AddCommand(agentCmd)
+}
+
+func runAgent() error {
+	cfg := config.Load()
+	logger.Init(cfg.LogLevel)
+
+	if agentHubURL != "" {
+		cfg.AgentHubURL = agentHubURL
+	}
+	if agentName != "" {
+		cfg.AgentName = agentName
+	}
+	if cfg.AgentName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("set the agent's name with --name or AGENT_NAME")
+		}
+		cfg.AgentName = hostname
+	}
+	if cfg.AgentHubURL == "" {
+		return fmt.Errorf("set the hub's URL with --hub or AGENT_HUB_URL")
+	}
+	if cfg.AgentKey == "" {
+		return fmt.Errorf("set the agent's pre-shared key in AGENT_KEY")
+	}
+
+	allowed := make([]*net.IPNet, 0, len(cfg.AgentAllowedNetworks))
+	for _, cidr := range cfg.AgentAllowedNetworks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_ALLOWED_NETWORKS entry %q: %v", cidr, err)
+		}
+		allowed = append(allowed, network)
+	}
+
+	agent, err := hub.NewAgent(hub.AgentConfig{
+		HubURL:  cfg.AgentHubURL,
+		Name:    cfg.AgentName,
+		Key:     cfg.AgentKey,
+		Allowed: allowed,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info(fmt.Sprintf("🛰️  Agent %q connecting to %s", cfg.AgentName, cfg.AgentHubURL))
+	agent.Run(ctx)
+	logger.Info("✅ Agent stopped")
+	return nil
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// auditCmd groups the audit log commands
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect the audit log",
+	Long: `The audit log records who changed what from where. Events are append-only
+and hash-chained, so an event that is edited or removed breaks the chain.`,
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the audit log hash chain for tampering",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return verifyAudit()
+	},
+}
+
+func init() {
+	auditVerifyCmd.SilenceErrors = true
+	auditVerifyCmd.SilenceUsage = true
+	auditCmd.AddCommand(auditVerifyCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+func verifyAudit() error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := db.VerifyAuditChain()
+	if err != nil {
+		return err
+	}
+	if !result.Valid {
+		return fmt.Errorf("audit log chain broken at event %d: %s", result.BrokenAt, result.Reason)
+	}
+
+	fmt.Printf("✅ %d audit events verified\n", result.Events)
+	if result.Head != "" {
+		fmt.Printf("Head hash: %s\n", result.Head)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lan-relay/internal/config"
+	"lan-relay/internal/database"
+	"lan-relay/internal/models"
+	"lan-relay/internal/pki"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	certName string
+	certDays int
+	certOut  string
+)
+
+// certCmd groups the client certificate commands
+var certCmd = &cobra.Command{
+	Use:   "cert",
+	Short: "Manage client certificates for the mTLS listener",
+	Long: `Issue and revoke the client certificates accepted by the TLS listener
+(TLS_PORT). Certificates are signed by a CA that the relay creates in PKI_DIR on
+first use, and act for the user they are issued to.`,
+}
+
+var certIssueCmd = &cobra.Command{
+	Use:   "issue <username>",
+	Short: "Issue a client certificate for a user",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return issueCert(args[0])
+	},
+}
+
+var certRevokeCmd = &cobra.Command{
+	Use:   "revoke <serial>",
+	Short: "Revoke a client certificate and update the CRL",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return revokeCert(args[0])
+	},
+}
+
+var certListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List issued client certificates",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listCerts()
+	},
+}
+
+func init() {
+	certIssueCmd.Flags().StringVar(&certName, "name", "", "Label for the device or service holding the certificate (default: the username)")
+	certIssueCmd.Flags().IntVar(&certDays, "days", 365, "Days until the certificate expires")
+	certIssueCmd.Flags().StringVar(&certOut, "out", ".", "Directory to write the certificate, key and CA certificate to")
+
+	for _, command := range []*cobra.Command{certIssueCmd, certRevokeCmd, certListCmd} {
+		command.SilenceErrors = true
+		command.SilenceUsage = true
+	}
+	certCmd.AddCommand(certIssueCmd, certRevokeCmd, certListCmd)
+	rootCmd.AddCommand(certCmd)
+}
+
+func issueCert(username string) error {
+	if certDays <= 0 {
+		return fmt.Errorf("--days must be positive")
+	}
+	name := strings.TrimSpace(certName)
+	if name == "" {
+		name = username
+	}
+	if strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("--name can't contain slashes")
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user %q not found", username)
+	}
+
+	authority, err := pki.Load(config.Load().PKIDir)
+	if err != nil {
+		return err
+	}
+	cert, certPEM, keyPEM, err := authority.IssueClient(user.Username, name, time.Duration(certDays)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(certOut, 0700); err != nil {
+		return err
+	}
+	certPath := filepath.Join(certOut, name+".crt")
+	keyPath := filepath.Join(certOut, name+".key")
+	caPath := filepath.Join(certOut, pki.CACertFile)
+	if err := writeNewFile(keyPath, keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := writeNewFile(certPath, certPEM, 0644); err != nil {
+		os.Remove(keyPath)
+		return err
+	}
+	if err := os.WriteFile(caPath, authority.CACertPEM(), 0644); err != nil {
+		return err
+	}
+
+	record := &models.ClientCert{
+		Serial:    pki.FormatSerial(cert.SerialNumber),
+		Name:      name,
+		UserID:    user.ID,
+		ExpiresAt: cert.NotAfter,
+	}
+	if err := db.InsertClientCert(record); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Issued certificate %s for %q (role %s), expires %s\n",
+		record.Serial, user.Username, user.Role, cert.NotAfter.Local().Format("2006-01-02"))
+	fmt.Printf("   Certificate: %s\n   Key:         %s\n   CA:          %s\n", certPath, keyPath, caPath)
+	return nil
+}
+
+func revokeCert(serial string) error {
+	parsed, ok := pki.ParseSerial(serial)
+	if !ok {
+		return fmt.Errorf("invalid serial %q", serial)
+	}
+	serial = pki.FormatSerial(parsed)
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	cert, err := db.GetClientCert(serial)
+	if err != nil {
+		return err
+	}
+	if cert == nil {
+		return fmt.Errorf("certificate %s not found", serial)
+	}
+	if err := db.RevokeClientCert(serial); err != nil {
+		return err
+	}
+	if err := writeCRL(db); err != nil {
+		return err
+	}
+
+	if cert.RevokedAt != nil {
+		fmt.Printf("Certificate %s was already revoked, the CRL has been rewritten\n", serial)
+		return nil
+	}
+	fmt.Printf("✅ Revoked certificate %s (%s, user %q)\n", serial, cert.Name, cert.Username)
+	return nil
+}
+
+func listCerts() error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	certs, err := db.GetClientCerts()
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		fmt.Println("No client certificates issued")
+		return nil
+	}
+
+	for _, cert := range certs {
+		status := "valid until " + cert.ExpiresAt.Format("2006-01-02")
+		if cert.RevokedAt != nil {
+			status = "revoked " + cert.RevokedAt.Format("2006-01-02 15:04")
+		} else if !cert.ExpiresAt.After(time.Now()) {
+			status = "expired " + cert.ExpiresAt.Format("2006-01-02")
+		}
+		username := cert.Username
+		if username == "" {
+			username = "(deleted user)"
+		}
+		fmt.Printf("%-32s %-20s %-20s %s\n", cert.Serial, cert.Name, username, status)
+	}
+	return nil
+}
+
+// writeCRL rewrites the CRL from the revoked certificates in the database.
+// The server rereads it on the next handshake.
+func writeCRL(db *database.DB) error {
+	authority, err := pki.Load(config.Load().PKIDir)
+	if err != nil {
+		return err
+	}
+
+	certs, err := db.GetRevokedClientCerts()
+	if err != nil {
+		return err
+	}
+	revoked := make([]pki.RevokedCert, 0, len(certs))
+	for _, cert := range certs {
+		revoked = append(revoked, pki.RevokedCert{Serial: cert.Serial, RevokedAt: *cert.RevokedAt})
+	}
+	return authority.WriteCRL(revoked)
+}
+
+// writeNewFile writes a file that must not exist yet, so issuing a second
+// certificate with the same name doesn't overwrite the first one's key
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
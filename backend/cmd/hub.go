@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// hubCmd runs the relay as a hub for agents on home networks
+var hubCmd = &cobra.Command{
+	Use:   "hub",
+	Short: "Start the LAN Relay server as a hub for agents",
+	Long: `Start the LAN Relay server on a public host as a hub. Agents on home
+networks (lan-relay agent) connect out to it with a pre-shared key from
+HUB_AGENT_KEYS, and /proxy requests are sent through them. Pick an agent with
+/proxy/@AGENT/HOST:PORT/path; other requests go to HUB_DEFAULT_AGENT, or the
+only connected agent.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		startServer(true)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hubCmd)
+}
@@ -9,14 +9,21 @@ import (
 	"syscall"
 	"time"
 
+	"lan-relay/internal/auth"
 	"lan-relay/internal/config"
 	"lan-relay/internal/database"
 	"lan-relay/internal/handlers"
+	"lan-relay/internal/hub"
+	"lan-relay/internal/inventory"
 	"lan-relay/internal/logger"
+	"lan-relay/internal/pki"
+	"lan-relay/internal/share"
+	"lan-relay/internal/vault"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/cobra"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // startCmd represents the start command
@@ -27,7 +34,7 @@ var startCmd = &cobra.Command{
 The server will listen on the specified port and provide both
 API endpoints and a web interface for managing proxy connections.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		startServer()
+		startServer(false)
 	},
 }
 
@@ -35,7 +42,9 @@ func init() {
 	rootCmd.AddCommand(startCmd)
 }
 
-func startServer() {
+// startServer runs the relay. A hub reaches its targets through the agents
+// that connect to it instead of the network it runs on.
+func startServer(hubMode bool) {
 	// Load configuration
 	cfg := config.Load()
 
@@ -55,52 +64,300 @@ func startServer() {
 	}
 	defer db.Close()
 
+	// Create the first admin account on a fresh install
+	if cfg.AuthEnabled {
+		password, err := auth.EnsureAdmin(db, cfg.AdminUsername, cfg.AdminPassword)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to create the admin user: %v", err))
+			os.Exit(1)
+		}
+		if password != "" && cfg.AdminPassword == "" {
+			logger.Warn(fmt.Sprintf("🔑 Created user %q with password %q, change it after signing in", cfg.AdminUsername, password))
+		} else if password != "" {
+			logger.Info(fmt.Sprintf("🔑 Created user %q from ADMIN_PASSWORD", cfg.AdminUsername))
+		}
+		if err := db.DeleteExpiredSessions(); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to remove expired sessions: %v", err))
+		}
+	} else {
+		logger.Warn("⚠️  Authentication is disabled, anyone who can reach the relay can use it")
+	}
+
 	// Initialize Gin router
 	if cfg.Environment == "production" || daemon {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	r := gin.Default()
-
-	// CORS middleware
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
-		AllowCredentials: true,
+	r := gin.New()
+	r.Use(handlers.RequestID())
+	r.Use(handlers.AbortBrokenStreams())
+	r.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		requestID, _ := param.Keys["request_id"].(string)
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v [%s]\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			param.StatusCode,
+			param.Latency,
+			param.ClientIP,
+			param.Method,
+			param.Path,
+			requestID,
+			param.ErrorMessage,
+		)
 	}))
+	r.Use(gin.Recovery())
+
+	// Load the credential vault
+	credentialVault, err := vault.Load(cfg.VaultKey, cfg.VaultKeyFile)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Credential vault unavailable, secret settings can't be read or saved: %v", err))
+		credentialVault = nil
+	}
+
+	// Secret settings are encrypted with the vault key, including any left
+	// in plaintext by older versions
+	if credentialVault != nil {
+		db.UseVault(credentialVault)
+		if count, err := db.EncryptSettingsSecrets(); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to encrypt secret settings: %v", err))
+		} else if count > 0 {
+			logger.Info(fmt.Sprintf("🔐 Encrypted %d secret settings stored in plaintext", count))
+		}
+	}
 
 	// Initialize handlers
-	h := handlers.New(db)
+	h := handlers.New(db, cfg, credentialVault)
+
+	var agents *hub.Hub
+	if hubMode {
+		keys, err := hub.ParseKeys(cfg.HubAgentKeys)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Invalid HUB_AGENT_KEYS: %v", err))
+			os.Exit(1)
+		}
+		if len(keys) == 0 {
+			logger.Error("A hub needs agents, set HUB_AGENT_KEYS to NAME:KEY entries")
+			os.Exit(1)
+		}
+		if cfg.HubDefaultAgent != "" && keys[cfg.HubDefaultAgent] == "" {
+			logger.Error(fmt.Sprintf("HUB_DEFAULT_AGENT %q has no key in HUB_AGENT_KEYS", cfg.HubDefaultAgent))
+			os.Exit(1)
+		}
+		agents = hub.New(keys, cfg.HubDefaultAgent)
+		h.UseHub(agents)
+
+		// The hub's own neighbors aren't the agents' devices
+		cfg.InventoryEnabled = false
+	}
+
+	// Share links are signed with their own key, so rotating the vault key
+	// doesn't invalidate them
+	if shareKey, err := vault.LoadKey(cfg.ShareKey, cfg.ShareKeyFile); err != nil {
+		logger.Warn(fmt.Sprintf("Share links unavailable: %v", err))
+	} else if signer, err := share.NewSigner(shareKey); err != nil {
+		logger.Warn(fmt.Sprintf("Share links unavailable: %v", err))
+	} else {
+		h.UseShareSigner(signer)
+		if err := db.DeleteExpiredShareVisits(); err != nil {
+			logger.Warn(fmt.Sprintf("Failed to remove expired share visits: %v", err))
+		}
+	}
+
+	// Only trusted proxies, such as the tunnel agent, may say who the client is
+	r.RemoteIPHeaders = cfg.RemoteIPHeaders
+	if err := r.SetTrustedProxies(h.TrustedProxies()); err != nil {
+		logger.Error(fmt.Sprintf("Invalid TRUSTED_PROXIES: %v", err))
+		os.Exit(1)
+	}
+
+	// Tag requests as local, tunnel or other and apply the origin policies
+	r.Use(h.OriginPolicy())
+
+	// Relay-wide client IP rules and automatic bans
+	r.Use(h.IPFilter())
+
+	// CORS, separately for the API and /proxy
+	r.Use(h.CORS())
+
+	// Native gRPC clients address targets with a header instead of the /proxy prefix
+	r.Use(h.RouteGRPC())
+
+	// Public API routes
+	public := r.Group("/api")
+	{
+		public.GET("/health", h.HealthCheck)
+		public.POST("/auth/login", h.Login)
+		public.POST("/auth/login/2fa", h.LoginTwoFactor)
+		public.POST("/auth/logout", h.Logout)
+		public.GET("/auth/sso", h.GetSSOConfig)
+		public.GET("/auth/oidc/login", h.OIDCLogin)
+		public.GET("/auth/oidc/callback", h.OIDCCallback)
+
+		// Agents authenticate with their pre-shared key
+		public.GET("/agents/connect", h.ConnectAgent)
+	}
 
 	// API routes
-	api := r.Group("/api")
+	api := r.Group("/api", h.RequireAuth())
 	{
-		api.GET("/health", h.HealthCheck)
 		api.GET("/status", h.GetStatus)
 		api.GET("/logs", h.GetLogs)
+		api.GET("/logs/har", h.ExportHAR)
+		api.GET("/logs/:id", h.GetLogDetail)
+		api.POST("/logs/:id/replay", h.ReplayLog)
 		api.POST("/logs/clear", h.ClearLogs)
 
+		// Header rewrite rules
+		api.GET("/rules", h.GetHeaderRules)
+		api.POST("/rules", h.CreateHeaderRule)
+		api.POST("/rules/dry-run", h.DryRunHeaderRules)
+		api.PUT("/rules/:id", h.UpdateHeaderRule)
+		api.DELETE("/rules/:id", h.DeleteHeaderRule)
+
+		// Upstream credential vault
+		api.GET("/credentials", h.GetCredentials)
+		api.POST("/credentials", h.CreateCredential)
+		api.PUT("/credentials/:id", h.UpdateCredential)
+		api.POST("/credentials/:id/rotate", h.RotateCredential)
+		api.DELETE("/credentials/:id", h.DeleteCredential)
+
+		// Registered targets and LAN discovery
+		api.GET("/targets", h.GetTargets)
+		api.POST("/targets", h.CreateTarget)
+		api.PUT("/targets/:id", h.UpdateTarget)
+		api.DELETE("/targets/:id", h.DeleteTarget)
+		api.GET("/discovery", h.GetDiscovery)
+		api.POST("/discovery/:id/register", h.RegisterDiscovered)
+
+		// Device inventory
+		api.GET("/devices", h.GetDevices)
+		api.GET("/devices/:id", h.GetDevice)
+		api.PUT("/devices/:id", h.UpdateDevice)
+		api.DELETE("/devices/:id", h.DeleteDevice)
+		api.POST("/devices/:id/wake", h.WakeDevice)
+
+		// Accounts
+		api.GET("/auth/me", h.GetCurrentUser)
+		api.POST("/auth/password", h.ChangePassword)
+		api.GET("/auth/2fa", h.GetTwoFactorStatus)
+		api.POST("/auth/2fa/setup", h.SetupTwoFactor)
+		api.POST("/auth/2fa/enable", h.EnableTwoFactor)
+		api.POST("/auth/2fa/disable", h.DisableTwoFactor)
+		api.POST("/auth/2fa/recovery-codes", h.RegenerateRecoveryCodes)
+		api.GET("/users", h.GetUsers)
+		api.POST("/users", h.CreateUser)
+		api.PUT("/users/:id/password", h.ResetUserPassword)
+		api.PUT("/users/:id/role", h.SetUserRole)
+		api.DELETE("/users/:id", h.DeleteUser)
+		api.GET("/tokens", h.GetAPITokens)
+		api.POST("/tokens", h.CreateAPIToken)
+		api.DELETE("/tokens/:id", h.DeleteAPIToken)
+
+		// Roles, groups and target grants
+		api.GET("/roles", h.GetRoles)
+		api.GET("/authz/explain", h.ExplainAccess)
+		api.GET("/groups", h.GetGroups)
+		api.POST("/groups", h.CreateGroup)
+		api.PUT("/groups/:id/members", h.SetGroupMembers)
+		api.DELETE("/groups/:id", h.DeleteGroup)
+		api.GET("/grants", h.GetTargetGrants)
+		api.POST("/grants", h.CreateTargetGrant)
+		api.DELETE("/grants/:id", h.DeleteTargetGrant)
+		api.GET("/audit", h.GetAuditEvents)
+		api.GET("/audit/verify", h.VerifyAuditLog)
+
+		// Share links
+		api.GET("/shares", h.GetShareLinks)
+		api.POST("/shares", h.CreateShareLink)
+		api.DELETE("/shares/:id", h.RevokeShareLink)
+
+		// Client IP rules
+		api.GET("/ip-rules", h.GetIPRules)
+		api.POST("/ip-rules", h.CreateIPRule)
+		api.DELETE("/ip-rules/:id", h.DeleteIPRule)
+
 		// Settings routes
 		api.GET("/settings", h.GetSettings)
 		api.POST("/settings", h.UpdateSettings)
 
-		// Ngrok routes
+		// Tunnel routes
+		api.GET("/tunnel", h.GetTunnel)
+		api.POST("/tunnel/start", h.StartTunnel)
+		api.POST("/tunnel/stop", h.StopTunnel)
+
+		// Ngrok routes, kept for older clients
 		api.POST("/ngrok/start", h.StartNgrokTunnel)
 		api.POST("/ngrok/stop", h.StopNgrokTunnel)
 		api.POST("/ngrok/test", h.TestNgrokToken)
+
+		// Hub agents
+		api.GET("/agents", h.GetAgents)
 	}
 
 	// Proxy routes - catch-all for proxy requests
-	r.Any("/proxy/*path", h.ProxyRequest)
+	r.Any("/proxy/*path", h.RequireAuth(), h.ProxyRequest)
+
+	// Share links carry their own authorization
+	r.POST("/share/:token", h.UnlockShareLink)
+	r.Any("/share/:token/*path", h.ShareProxy)
 
 	// Serve embedded frontend
 	setupStaticRoutes(r)
 
-	// Create HTTP server
+	// Routes without a permission are denied to everyone
+	for _, route := range handlers.UnmappedRoutes(r.Routes()) {
+		logger.Warn(fmt.Sprintf("No permission defined for %s, it will be denied", route))
+	}
+
+	// Create HTTP server, accepting cleartext HTTP/2 (h2c) for gRPC clients
 	srv := &http.Server{
 		Addr:    ":" + cfg.Port,
-		Handler: r,
+		Handler: h2c.NewHandler(r, &http2.Server{}),
+	}
+
+	// Connections an in-process tunnel accepts are served apart, so their
+	// requests are known to come through the tunnel
+	tunnelSrv := &http.Server{
+		Handler:     srv.Handler,
+		ConnContext: handlers.TunnelConnContext,
+	}
+
+	// Optional TLS listener for clients with relay-issued certificates
+	var tlsSrv *http.Server
+	if cfg.TLSPort != "" {
+		authority, err := pki.Load(cfg.PKIDir)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to load the relay CA: %v", err))
+			os.Exit(1)
+		}
+		tlsConfig, err := authority.ServerConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSHostnames)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to set up the TLS listener: %v", err))
+			os.Exit(1)
+		}
+		if !cfg.AuthEnabled {
+			logger.Warn("⚠️  Authentication is disabled, client certificates are checked but not mapped to users")
+		}
+		tlsSrv = &http.Server{
+			Addr:      ":" + cfg.TLSPort,
+			Handler:   r,
+			TLSConfig: tlsConfig,
+		}
+	}
+
+	// Background jobs stop when the server shuts down
+	background, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	// Record the devices in the neighbor table
+	if cfg.InventoryEnabled {
+		oui, err := inventory.LoadOUI(cfg.OUIFile)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Failed to load OUI file, using the built-in sample of vendors: %v", err))
+		} else if cfg.OUIFile == "" {
+			logger.Info("Device vendors come from a small built-in sample; set OUI_FILE to the IEEE oui.txt to name every vendor")
+		}
+		interval := time.Duration(cfg.InventoryIntervalSeconds) * time.Second
+		go inventory.New(db, cfg.NeighborTablePath, interval, oui).Run(background)
 	}
 
 	// Start server in goroutine
@@ -108,6 +365,9 @@ func startServer() {
 		logger.Info(fmt.Sprintf("🚀 LAN Relay Server starting on port %s", cfg.Port))
 		logger.Info(fmt.Sprintf("📊 Dashboard: http://localhost:%s", cfg.Port))
 		logger.Info(fmt.Sprintf("🔧 API: http://localhost:%s/api", cfg.Port))
+		if agents != nil {
+			logger.Info(fmt.Sprintf("🛰️  Hub mode, %d agents may connect to %s", len(agents.Agents()), hub.ConnectPath))
+		}
 
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logger.Error(fmt.Sprintf("Failed to start server: %v", err))
@@ -115,12 +375,35 @@ func startServer() {
 		}
 	}()
 
+	go func() {
+		if err := tunnelSrv.Serve(h.TunnelListener()); err != nil && err != http.ErrServerClosed {
+			logger.Error(fmt.Sprintf("Tunnel server stopped: %v", err))
+		}
+	}()
+
+	if tlsSrv != nil {
+		go func() {
+			logger.Info(fmt.Sprintf("🔒 mTLS listener on port %s, clients need a certificate from %s", cfg.TLSPort, cfg.PKIDir))
+
+			if err := tlsSrv.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+				logger.Error(fmt.Sprintf("Failed to start TLS listener: %v", err))
+				os.Exit(1)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	logger.Info("🛑 Shutting down server...")
+	stopBackground()
+
+	// Agent connections are hijacked, so the server doesn't close them
+	if agents != nil {
+		agents.Close()
+	}
 
 	// Create a deadline for shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -130,6 +413,14 @@ func startServer() {
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error(fmt.Sprintf("Server forced to shutdown: %v", err))
 	}
+	if err := tunnelSrv.Shutdown(ctx); err != nil {
+		logger.Error(fmt.Sprintf("Tunnel server forced to shutdown: %v", err))
+	}
+	if tlsSrv != nil {
+		if err := tlsSrv.Shutdown(ctx); err != nil {
+			logger.Error(fmt.Sprintf("TLS listener forced to shutdown: %v", err))
+		}
+	}
 
 	logger.Info("✅ Server stopped")
 }
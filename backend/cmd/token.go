@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/config"
+	"lan-relay/internal/models"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	tokenUser        string
+	tokenScopes      []string
+	tokenTargets     []string
+	tokenExpiresDays int
+)
+
+// tokenCmd groups the API token commands
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage API tokens for scripts and CI",
+	Long: `Manage long-lived API tokens. Tokens are sent as "Authorization: Bearer <token>"
+(or in an X-Relay-Token header) and act for their owner within their scopes:
+` + strings.Join(auth.Scopes, ", "),
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create an API token and print it once",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return createToken(args[0])
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API tokens",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listTokens()
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke an API token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return revokeToken(args[0])
+	},
+}
+
+func init() {
+	tokenCreateCmd.Flags().StringVar(&tokenUser, "user", "", "Owner of the token (default: the admin user)")
+	tokenCreateCmd.Flags().StringSliceVar(&tokenScopes, "scope", nil, "Scope to grant, repeatable: "+strings.Join(auth.Scopes, ", "))
+	tokenCreateCmd.Flags().StringSliceVar(&tokenTargets, "target", nil, "Only allow this HOST, HOST:PORT or CIDR range, repeatable")
+	tokenCreateCmd.Flags().IntVar(&tokenExpiresDays, "expires-days", 0, "Expire the token after this many days (default: never)")
+
+	for _, command := range []*cobra.Command{tokenCreateCmd, tokenListCmd, tokenRevokeCmd} {
+		command.SilenceErrors = true
+		command.SilenceUsage = true
+	}
+	tokenCmd.AddCommand(tokenCreateCmd, tokenListCmd, tokenRevokeCmd)
+	rootCmd.AddCommand(tokenCmd)
+}
+
+func createToken(name string) error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	username := tokenUser
+	if username == "" {
+		username = config.Load().AdminUsername
+	}
+	user, err := db.GetUserByUsername(username)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user %q not found", username)
+	}
+
+	token := &models.APIToken{
+		Name:    name,
+		UserID:  user.ID,
+		Scopes:  tokenScopes,
+		Targets: tokenTargets,
+	}
+	if tokenExpiresDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, tokenExpiresDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	secret, err := auth.IssueAPIToken(db, token, user.Role)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Created API token %q (id %d) for %q\n", token.Name, token.ID, user.Username)
+	fmt.Println("   Store it now, it can't be shown again:")
+	fmt.Println(secret)
+	return nil
+}
+
+func listTokens() error {
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tokens, err := db.GetAPITokens(0)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		fmt.Println("No API tokens")
+		return nil
+	}
+
+	for _, token := range tokens {
+		expires := "never"
+		if token.ExpiresAt != nil {
+			expires = token.ExpiresAt.Format("2006-01-02")
+			if token.Expired() {
+				expires += " (expired)"
+			}
+		}
+		lastUsed := "never"
+		if token.LastUsedAt != nil {
+			lastUsed = token.LastUsedAt.Format("2006-01-02 15:04")
+		}
+		targets := "all targets"
+		if len(token.Targets) > 0 {
+			targets = strings.Join(token.Targets, ",")
+		}
+		fmt.Printf("%-4d %-20s %s…  owner: %s  scopes: %s  targets: %s  expires: %s  last used: %s\n",
+			token.ID, token.Name, token.Prefix, token.Username, strings.Join(token.Scopes, ","), targets, expires, lastUsed)
+	}
+	return nil
+}
+
+func revokeToken(arg string) error {
+	id, err := strconv.Atoi(arg)
+	if err != nil {
+		return fmt.Errorf("invalid token ID %q", arg)
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	token, err := db.GetAPIToken(id)
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		return fmt.Errorf("API token %d not found", id)
+	}
+	if err := db.DeleteAPIToken(id); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Revoked API token %q\n", token.Name)
+	return nil
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"lan-relay/internal/config"
+	"lan-relay/internal/vault"
+
+	"github.com/spf13/cobra"
+)
+
+var rotateForce bool
+
+// vaultCmd groups the vault key commands
+var vaultCmd = &cobra.Command{
+	Use:   "vault",
+	Short: "Manage the key that encrypts stored secrets",
+	Long: `The vault key (VAULT_KEY, or the key file at VAULT_KEY_FILE) encrypts the
+secrets the relay stores: the tunnel tokens, SSH key and SSO client secret
+in the settings, upstream credentials and TOTP secrets.`,
+}
+
+var vaultRotateCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "Re-encrypt all stored secrets with a new vault key",
+	Long: `Generate a new vault key and re-encrypt every stored secret with it. The
+relay must be stopped while the key is rotated. With a key file the file is
+replaced; with VAULT_KEY the new key is printed and VAULT_KEY must be updated
+before the relay is started again.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rotateVaultKey()
+	},
+}
+
+func init() {
+	vaultRotateCmd.Flags().BoolVar(&rotateForce, "force", false, "Rotate even if a relay answers on --port")
+	vaultRotateCmd.SilenceErrors = true
+	vaultRotateCmd.SilenceUsage = true
+	vaultCmd.AddCommand(vaultRotateCmd)
+	rootCmd.AddCommand(vaultCmd)
+}
+
+func rotateVaultKey() error {
+	// A running relay keeps the old key in memory and would write secrets the
+	// new key can't read
+	if !rotateForce && relayRunning() {
+		return fmt.Errorf("a relay is running on port %s, stop it before rotating the key (or pass --force)", port)
+	}
+
+	cfg := config.Load()
+	oldVault, err := vault.Load(cfg.VaultKey, cfg.VaultKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the current key: %v", err)
+	}
+
+	newKey, err := vault.GenerateKey()
+	if err != nil {
+		return err
+	}
+	newVault, err := vault.Load(newKey, "")
+	if err != nil {
+		return err
+	}
+
+	// Keep the new key on disk before any secret depends on it
+	pendingFile := ""
+	if cfg.VaultKey == "" {
+		pendingFile = cfg.VaultKeyFile + ".new"
+		if err := vault.WriteKeyFile(pendingFile, newKey); err != nil {
+			return err
+		}
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	counts, err := db.RotateVaultKey(oldVault, newVault)
+	if err != nil {
+		if pendingFile != "" {
+			os.Remove(pendingFile)
+		}
+		return fmt.Errorf("rotation failed, nothing was changed: %v", err)
+	}
+
+	fmt.Printf("✅ Re-encrypted %d settings, %d credentials and %d TOTP secrets\n",
+		counts.Settings, counts.Credentials, counts.TOTPSecrets)
+
+	if pendingFile == "" {
+		fmt.Println("Set VAULT_KEY to the new key before starting the relay again:")
+		fmt.Println(newKey)
+		return nil
+	}
+	if err := os.Rename(pendingFile, cfg.VaultKeyFile); err != nil {
+		return fmt.Errorf("secrets now use the key in %s, but it couldn't replace %s: %v", pendingFile, cfg.VaultKeyFile, err)
+	}
+	fmt.Printf("🔑 New key written to %s\n", cfg.VaultKeyFile)
+	return nil
+}
+
+// relayRunning reports whether a relay answers health checks on --port
+func relayRunning() bool {
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%s/api/health", port))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
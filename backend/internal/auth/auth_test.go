@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		valid    bool
+	}{
+		{"alice", true},
+		{"alice.smith@example.com", true},
+		{"ci-bot_2", true},
+		{strings.Repeat("a", 64), true},
+		{"", false},
+		{strings.Repeat("a", 65), false},
+		{"alice smith", false},
+		{"alice:admin", false},
+		{"ålice", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateUsername(tt.username); (err == nil) != tt.valid {
+			t.Errorf("ValidateUsername(%q) error = %v, want valid %v", tt.username, err, tt.valid)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		password string
+		valid    bool
+	}{
+		{"", false},
+		{"short12", false},
+		{"eight888", true},
+		{strings.Repeat("x", 72), true},
+		// bcrypt ignores anything past 72 bytes
+		{strings.Repeat("x", 73), false},
+	}
+	for _, tt := range tests {
+		if err := ValidatePassword(tt.password); (err == nil) != tt.valid {
+			t.Errorf("ValidatePassword(%d bytes) error = %v, want valid %v", len(tt.password), err, tt.valid)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == "correct horse" {
+		t.Fatal("HashPassword() returned the password")
+	}
+
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+	}{
+		{"right password", hash, "correct horse", true},
+		{"wrong password", hash, "correct horse battery", false},
+		{"empty password", hash, "", false},
+		{"unknown user", "", "correct horse", false},
+		{"unknown user, empty password", "", "", false},
+	}
+	for _, tt := range tests {
+		if got := CheckPassword(tt.hash, tt.password); got != tt.want {
+			t.Errorf("%s: CheckPassword() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	first, err := NewToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := NewToken()
+	if first == second || len(first) != 43 {
+		t.Errorf("NewToken() = %q then %q, want two different 43-character tokens", first, second)
+	}
+
+	if HashToken(first) != HashToken(first) {
+		t.Error("HashToken() isn't stable")
+	}
+	if HashToken(first) == HashToken(second) || strings.Contains(HashToken(first), first) {
+		t.Error("HashToken() doesn't hide the token")
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"lan-relay/internal/database"
+	"lan-relay/internal/models"
+)
+
+// EnsureAdmin creates the first account when there are no users yet. If
+// password is empty a random one is generated. It returns the password used,
+// or "" if accounts already existed.
+func EnsureAdmin(db *database.DB, username, password string) (string, error) {
+	count, err := db.CountUsers()
+	if err != nil || count > 0 {
+		return "", err
+	}
+
+	if err := ValidateUsername(username); err != nil {
+		return "", err
+	}
+	if password == "" {
+		if password, err = GeneratePassword(); err != nil {
+			return "", err
+		}
+	} else if err := ValidatePassword(password); err != nil {
+		return "", err
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return "", err
+	}
+	if err := db.InsertUser(&models.User{Username: username, PasswordHash: hash, Role: RoleAdmin}); err != nil {
+		return "", err
+	}
+	return password, nil
+}
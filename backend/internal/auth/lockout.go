@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"strings"
+	"time"
+)
+
+// LockoutPolicy decides how long sign-in is blocked after repeated
+// failures. Each failure past the limit doubles the lockout, up to Max.
+type LockoutPolicy struct {
+	MaxFailures int
+	Base        time.Duration
+	Max         time.Duration
+}
+
+// LockFor returns how long to block sign-in after a number of consecutive
+// failures, or 0 while it's below the limit
+func (p LockoutPolicy) LockFor(failures int) time.Duration {
+	if p.MaxFailures <= 0 || failures < p.MaxFailures {
+		return 0
+	}
+
+	lock := p.Base
+	for i := p.MaxFailures; i < failures && lock < p.Max; i++ {
+		lock *= 2
+	}
+	if lock > p.Max {
+		lock = p.Max
+	}
+	return lock
+}
+
+// AccountLockoutKey is the key failed sign-ins to an account are counted under
+func AccountLockoutKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+// IPLockoutKey is the key failed sign-ins from an address are counted under
+func IPLockoutKey(ip string) string {
+	return "ip:" + ip
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockFor(t *testing.T) {
+	policy := LockoutPolicy{MaxFailures: 3, Base: 30 * time.Second, Max: 5 * time.Minute}
+	tests := []struct {
+		name     string
+		policy   LockoutPolicy
+		failures int
+		want     time.Duration
+	}{
+		{"no failures", policy, 0, 0},
+		{"below the limit", policy, 2, 0},
+		{"at the limit", policy, 3, 30 * time.Second},
+		{"one past the limit", policy, 4, time.Minute},
+		{"two past the limit", policy, 5, 2 * time.Minute},
+		{"doubling reaches the cap", policy, 7, 5 * time.Minute},
+		{"far past the limit", policy, 1000, 5 * time.Minute},
+		{"lockout off", LockoutPolicy{Base: time.Minute, Max: time.Hour}, 1000, 0},
+		{"base above the cap", LockoutPolicy{MaxFailures: 1, Base: time.Hour, Max: time.Minute}, 1, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.LockFor(tt.failures); got != tt.want {
+			t.Errorf("%s: LockFor(%d) = %s, want %s", tt.name, tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLockoutKeys(t *testing.T) {
+	if AccountLockoutKey("Alice") != AccountLockoutKey("alice") {
+		t.Error("AccountLockoutKey() depends on the case of the username")
+	}
+	if AccountLockoutKey("10.0.0.1") == IPLockoutKey("10.0.0.1") {
+		t.Error("a username can share a lockout key with an address")
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// User roles
+const (
+	RoleAdmin     = "admin"      // everything, including users and settings
+	RoleOperator  = "operator"   // proxy, logs, tunnel and targets, but not settings or users
+	RoleViewer    = "viewer"     // read-only access to logs and targets, and GET requests through the proxy
+	RoleProxyOnly = "proxy-only" // only the proxy
+)
+
+// Roles lists every role a user can have
+var Roles = []string{RoleAdmin, RoleOperator, RoleViewer, RoleProxyOnly}
+
+// Permissions beyond the API token scopes. Token scopes double as the
+// permissions for the endpoints they cover.
+const (
+	PermLogsWrite    = "logs:write"    // clearing and replaying logs
+	PermTargetsRead  = "targets:read"  // targets, discovery and devices
+	PermTargetsWrite = "targets:write" // changing targets and devices
+	PermUsersManage  = "users:manage"  // users, groups, grants, everyone's tokens and the audit log
+	PermAccount      = "account"       // the signed-in user's own account and tokens
+)
+
+var rolePermissions = map[string][]string{
+	RoleAdmin: {
+		PermAccount, ScopeProxyRead, ScopeProxyWrite, ScopeLogsRead, PermLogsWrite, ScopeTunnelManage,
+		PermTargetsRead, PermTargetsWrite, ScopeSettingsWrite, PermUsersManage,
+	},
+	RoleOperator: {
+		PermAccount, ScopeProxyRead, ScopeProxyWrite, ScopeLogsRead, PermLogsWrite, ScopeTunnelManage,
+		PermTargetsRead, PermTargetsWrite,
+	},
+	RoleViewer:    {PermAccount, ScopeProxyRead, ScopeLogsRead, PermTargetsRead},
+	RoleProxyOnly: {PermAccount, ScopeProxyRead, ScopeProxyWrite},
+}
+
+// ValidateRole checks that a role exists
+func ValidateRole(role string) error {
+	if _, ok := rolePermissions[role]; !ok {
+		return fmt.Errorf("unknown role %q, expected one of %s", role, strings.Join(Roles, ", "))
+	}
+	return nil
+}
+
+// RolePermissions returns the permissions of a role
+func RolePermissions(role string) []string {
+	return rolePermissions[role]
+}
+
+// RoleAllows reports whether a role has a permission
+func RoleAllows(role, permission string) bool {
+	return HasScope(rolePermissions[role], permission)
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"lan-relay/internal/database"
+	"lan-relay/internal/models"
+)
+
+// API token scopes
+const (
+	ScopeProxyRead     = "proxy:read"     // GET, HEAD and OPTIONS requests through the proxy
+	ScopeProxyWrite    = "proxy:write"    // any other proxied request
+	ScopeLogsRead      = "logs:read"      // request logs and exports
+	ScopeTunnelManage  = "tunnel:manage"  // starting and stopping the tunnel
+	ScopeSettingsWrite = "settings:write" // reading and changing settings
+)
+
+// Scopes lists every scope a token can be granted
+var Scopes = []string{ScopeProxyRead, ScopeProxyWrite, ScopeLogsRead, ScopeTunnelManage, ScopeSettingsWrite}
+
+// APITokenPrefix marks relay API tokens, so they're recognisable in scripts and secret scanners
+const APITokenPrefix = "lrt_"
+
+// NewAPIToken returns a random API token
+func NewAPIToken() (string, error) {
+	token, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+	return APITokenPrefix + token, nil
+}
+
+// ValidateScopes checks that at least one scope is given and all are known
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("at least one scope is required (%s)", strings.Join(Scopes, ", "))
+	}
+	for _, scope := range scopes {
+		if !HasScope(Scopes, scope) {
+			return fmt.Errorf("unknown scope %q, expected one of %s", scope, strings.Join(Scopes, ", "))
+		}
+	}
+	return nil
+}
+
+// HasScope reports whether scope is in scopes
+func HasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyTarget is the target pattern that matches every target
+const AnyTarget = "*"
+
+// ValidateTargetPattern checks a token target allowlist entry or target
+// grant: HOST, HOST:PORT, a CIDR range or * for every target
+func ValidateTargetPattern(pattern string) error {
+	if pattern == AnyTarget {
+		return nil
+	}
+	if strings.Contains(pattern, "/") {
+		if _, _, err := net.ParseCIDR(pattern); err != nil {
+			return fmt.Errorf("invalid target range %q", pattern)
+		}
+		return nil
+	}
+	if host, port, err := net.SplitHostPort(pattern); err == nil {
+		if host == "" || port == "" {
+			return fmt.Errorf("invalid target %q, expected HOST, HOST:PORT, a CIDR range or *", pattern)
+		}
+		return nil
+	}
+	if net.ParseIP(pattern) != nil {
+		return nil
+	}
+	if pattern == "" || strings.ContainsAny(pattern, " :") {
+		return fmt.Errorf("invalid target %q, expected HOST, HOST:PORT, a CIDR range or *", pattern)
+	}
+	return nil
+}
+
+// TargetAllowed reports whether a target matches an allowlist. An empty
+// allowlist allows every target.
+func TargetAllowed(patterns []string, host, port string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	for _, pattern := range patterns {
+		if pattern == AnyTarget {
+			return true
+		}
+		if strings.Contains(pattern, "/") {
+			if _, network, err := net.ParseCIDR(pattern); err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if patternHost, patternPort, err := net.SplitHostPort(pattern); err == nil {
+			if strings.EqualFold(patternHost, host) && patternPort == port {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateAPIToken checks the name, scopes and target allowlist of a new
+// token. Its scopes can't go beyond what the owner's role allows.
+func ValidateAPIToken(token *models.APIToken, ownerRole string) error {
+	token.Name = strings.TrimSpace(token.Name)
+	if token.Name == "" || len(token.Name) > 100 {
+		return fmt.Errorf("token name must be 1-100 characters")
+	}
+	if err := ValidateScopes(token.Scopes); err != nil {
+		return err
+	}
+	for _, scope := range token.Scopes {
+		if !RoleAllows(ownerRole, scope) {
+			return fmt.Errorf("role %s can't grant the %s scope", ownerRole, scope)
+		}
+	}
+	for _, target := range token.Targets {
+		if err := ValidateTargetPattern(target); err != nil {
+			return err
+		}
+	}
+	if token.Expired() {
+		return fmt.Errorf("expiry must be in the future")
+	}
+	return nil
+}
+
+// IssueAPIToken validates and stores a new token for token.UserID, returning
+// the token itself. It can't be recovered later.
+func IssueAPIToken(db *database.DB, token *models.APIToken, ownerRole string) (string, error) {
+	if err := ValidateAPIToken(token, ownerRole); err != nil {
+		return "", err
+	}
+
+	secret, err := NewAPIToken()
+	if err != nil {
+		return "", err
+	}
+	token.TokenHash = HashToken(secret)
+	token.Prefix = secret[:len(APITokenPrefix)+6]
+
+	if err := db.InsertAPIToken(token); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+func TestValidateTargetPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		valid   bool
+	}{
+		{"192.168.1.20", true},
+		{"192.168.1.20:443", true},
+		{"nas.lan", true},
+		{"nas.lan:8080", true},
+		{"[fd00::1]:80", true},
+		{"fd00::1", true},
+		{"10.0.0.0/24", true},
+		{AnyTarget, true},
+		{"", false},
+		{"10.0.0.0/33", false},
+		{"nas lan", false},
+		{"nas.lan:", false},
+		{":80", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateTargetPattern(tt.pattern); (err == nil) != tt.valid {
+			t.Errorf("ValidateTargetPattern(%q) error = %v, want valid %v", tt.pattern, err, tt.valid)
+		}
+	}
+}
+
+func TestTargetAllowed(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		host     string
+		port     string
+		want     bool
+	}{
+		{"empty allowlist", nil, "10.0.0.5", "80", true},
+		{"host on any port", []string{"10.0.0.5"}, "10.0.0.5", "8443", true},
+		{"host name ignores case", []string{"NAS.lan"}, "nas.LAN", "80", true},
+		{"other host", []string{"10.0.0.5"}, "10.0.0.6", "80", false},
+		{"host and port", []string{"10.0.0.5:80"}, "10.0.0.5", "80", true},
+		{"host on another port", []string{"10.0.0.5:80"}, "10.0.0.5", "81", false},
+		{"inside a range", []string{"10.0.0.0/24"}, "10.0.0.200", "22", true},
+		{"outside a range", []string{"10.0.0.0/24"}, "10.0.1.1", "22", false},
+		{"name against a range", []string{"10.0.0.0/24"}, "nas.lan", "80", false},
+		{"IPv6 range", []string{"fd00::/8"}, "fd00::1", "80", true},
+		{"any target", []string{AnyTarget}, "example.com", "443", true},
+		{"second pattern", []string{"10.0.0.5:80", "10.0.0.6"}, "10.0.0.6", "22", true},
+		{"pattern is not a prefix", []string{"10.0.0.5"}, "10.0.0.50", "80", false},
+	}
+	for _, tt := range tests {
+		if got := TargetAllowed(tt.patterns, tt.host, tt.port); got != tt.want {
+			t.Errorf("%s: TargetAllowed(%q, %s, %s) = %v, want %v", tt.name, tt.patterns, tt.host, tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestValidateAPIToken(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name    string
+		role    string
+		token   models.APIToken
+		wantErr string
+	}{
+		{"admin with every scope", RoleAdmin, models.APIToken{Name: "ci", Scopes: Scopes}, ""},
+		{"operator proxying", RoleOperator, models.APIToken{Name: "ci", Scopes: []string{ScopeProxyRead, ScopeProxyWrite, ScopeTunnelManage}}, ""},
+		{"operator changing settings", RoleOperator, models.APIToken{Name: "ci", Scopes: []string{ScopeSettingsWrite}}, "role operator can't grant the settings:write scope"},
+		{"viewer reading", RoleViewer, models.APIToken{Name: "ci", Scopes: []string{ScopeProxyRead, ScopeLogsRead}}, ""},
+		{"viewer writing", RoleViewer, models.APIToken{Name: "ci", Scopes: []string{ScopeProxyWrite}}, "role viewer can't grant the proxy:write scope"},
+		{"proxy-only reading logs", RoleProxyOnly, models.APIToken{Name: "ci", Scopes: []string{ScopeLogsRead}}, "role proxy-only can't grant the logs:read scope"},
+		{"permission that isn't a scope", RoleAdmin, models.APIToken{Name: "ci", Scopes: []string{PermUsersManage}}, "unknown scope"},
+		{"no scopes", RoleAdmin, models.APIToken{Name: "ci"}, "at least one scope"},
+		{"no name", RoleAdmin, models.APIToken{Name: "  ", Scopes: Scopes}, "token name"},
+		{"long name", RoleAdmin, models.APIToken{Name: strings.Repeat("n", 101), Scopes: Scopes}, "token name"},
+		{"bad target", RoleAdmin, models.APIToken{Name: "ci", Scopes: Scopes, Targets: []string{"10.0.0.0/99"}}, "invalid target range"},
+		{"expired", RoleAdmin, models.APIToken{Name: "ci", Scopes: Scopes, ExpiresAt: &past}, "expiry must be in the future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAPIToken(&tt.token, tt.role)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateAPIToken() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateAPIToken() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewAPIToken(t *testing.T) {
+	token, err := NewAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, APITokenPrefix) || len(token) != len(APITokenPrefix)+43 {
+		t.Errorf("NewAPIToken() = %q, want %s and 43 random characters", token, APITokenPrefix)
+	}
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret from the RFC 6238 test vectors,
+// "12345678901234567890", base32-encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestVerifyTOTP(t *testing.T) {
+	// The RFC vectors are eight digits; six-digit codes are their last six
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, v := range vectors {
+		step, ok := VerifyTOTP(rfcSecret, v.code, time.Unix(v.unix, 0), 0)
+		if !ok || step != v.unix/totpPeriod {
+			t.Errorf("VerifyTOTP(%s) at %d = %d, %v, want step %d", v.code, v.unix, step, ok, v.unix/totpPeriod)
+		}
+	}
+
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	key, err := base32NoPadding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := totpCode(key, current)
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current code", rfcSecret, code, 0, current, true},
+		{"previous period", rfcSecret, totpCode(key, current-1), 0, current - 1, true},
+		{"next period", rfcSecret, totpCode(key, current+1), 0, current + 1, true},
+		{"two periods old", rfcSecret, totpCode(key, current-2), 0, 0, false},
+		{"two periods ahead", rfcSecret, totpCode(key, current+2), 0, 0, false},
+		{"spaces typed", rfcSecret, " " + code[:3] + " " + code[3:] + " ", 0, current, true},
+		{"lower-case padded secret", strings.ToLower(rfcSecret) + "====", code, 0, current, true},
+		{"replayed code", rfcSecret, code, current, 0, false},
+		{"later code after an earlier one", rfcSecret, totpCode(key, current+1), current, current + 1, true},
+		{"too short", rfcSecret, code[:5], 0, 0, false},
+		{"too long", rfcSecret, code + "0", 0, 0, false},
+		{"secret that isn't base32", "not base32!", code, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := VerifyTOTP(tt.secret, tt.code, now, tt.lastStep)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Fatalf("VerifyTOTP(%q) = %d, %v, want %d, %v", tt.code, step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewTOTPSecret(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := base32NoPadding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("NewTOTPSecret() = %q, decodes to %d bytes, %v", secret, len(key), err)
+	}
+
+	uri := TOTPURI("LAN Relay", "alice", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/LAN%20Relay:alice?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("TOTPURI() = %q", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatalf("NewRecoveryCodes() returned %d codes, want %d", len(codes), RecoveryCodeCount)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Errorf("recovery code %q is malformed or repeated", code)
+		}
+		seen[code] = true
+	}
+
+	tests := []struct {
+		typed string
+		match bool
+	}{
+		{"k7fq2-mxw9d", true},
+		{"K7FQ2-MXW9D", true},
+		{" k7fq2mxw9d ", true},
+		{"k7fq2 mxw9d", true},
+		{"k7fq2-mxw9e", false},
+	}
+	want := HashRecoveryCode("k7fq2-mxw9d")
+	for _, tt := range tests {
+		if got := HashRecoveryCode(tt.typed) == want; got != tt.match {
+			t.Errorf("HashRecoveryCode(%q) matches = %v, want %v", tt.typed, got, tt.match)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -11,6 +13,112 @@ type Config struct {
 	Environment  string
 	NgrokToken   string
 	NgrokDomain  string
+
+	// Request/response capture (opt-in)
+	CaptureEnabled      bool
+	CaptureMaxBodyBytes int
+	CaptureContentTypes []string
+
+	// Credential vault master key (base64), or a key file generated on first use
+	VaultKey     string
+	VaultKeyFile string
+
+	// gRPC proxying: HOST:PORT targets that speak TLS rather than h2c,
+	// and whether gRPC-Web calls are translated to native gRPC
+	GRPCTLSTargets    []string
+	GRPCTLSSkipVerify bool
+	GRPCWebEnabled    bool
+
+	// LAN discovery: mDNS and SSDP listen windows, and the private subnets
+	// and ports to probe (scanning is off unless subnets are given)
+	DiscoveryMDNS      bool
+	DiscoverySSDP      bool
+	DiscoveryTimeoutMs int
+	DiscoverySubnets   []string
+	DiscoveryPorts     []int
+
+	// Device inventory from the kernel neighbor table
+	InventoryEnabled         bool
+	InventoryIntervalSeconds int
+	NeighborTablePath        string
+	OUIFile                  string
+	WOLBroadcast             string
+
+	// Authentication for the dashboard, API and proxy. The bootstrap admin is
+	// created on first run, with a generated password unless one is given.
+	AuthEnabled     bool
+	SessionTTLHours int
+	AdminUsername   string
+	AdminPassword   string
+
+	// Sign-in lockout: after MaxFailures failed sign-ins for an account (or
+	// MaxFailuresPerIP from one address) sign-in is blocked, starting at
+	// LockoutSeconds and doubling with each further failure. TOTPIssuer names
+	// the relay in authenticator apps.
+	LoginMaxFailures       int
+	LoginMaxFailuresPerIP  int
+	LoginLockoutSeconds    int
+	LoginLockoutMaxSeconds int
+	TOTPIssuer             string
+
+	// Optional TLS listener that requires client certificates signed by the
+	// relay CA kept in PKIDir. The server certificate is issued by the same
+	// CA for TLSHostnames unless a certificate file is given.
+	TLSPort      string
+	PKIDir       string
+	TLSHostnames []string
+	TLSCertFile  string
+	TLSKeyFile   string
+
+	// Cross-origin access. The API accepts browser calls from the dashboard's
+	// own origin, the tunnel URL and CORSAllowedOrigins. /proxy accepts them
+	// from ProxyCORSAllowedOrigins ("*" for any, without cookies); with none,
+	// the relay adds no CORS headers and upstream ones pass through. CSRFMode
+	// is "origin" (reject cross-site state changes) or "token" (also require
+	// the CSRF cookie echoed in a header).
+	CORSAllowedOrigins      []string
+	ProxyCORSAllowedOrigins []string
+	CSRFMode                string
+	SessionCookieSameSite   string
+
+	// Share links are signed with ShareKey (base64, 32 bytes), or a key kept
+	// in ShareKeyFile, and last at most ShareMaxHours
+	ShareKey      string
+	ShareKeyFile  string
+	ShareMaxHours int
+
+	// Request origins. The client IP is taken from RemoteIPHeaders only when
+	// the connection comes from one of TrustedProxies (IPs or CIDRs), such as
+	// the local tunnel agent. Requests for the running tunnel's host or
+	// TunnelHosts, or forwarded by a trusted proxy from outside the LAN, come
+	// from the "tunnel"; others from private addresses are "local", the rest
+	// "other". LocalOnlyRoutes ("METHOD /route" or "/route") are refused
+	// unless local, and /proxy only requires sign-in from ProxyAuthOrigins.
+	TrustedProxies   []string
+	RemoteIPHeaders  []string
+	TunnelHosts      []string
+	LocalOnlyRoutes  []string
+	ProxyAuthOrigins []string
+
+	// Client IP rules. Local clients skip allowlists (not deny lists) unless
+	// IPAllowlistLocal is set. A client that gets AutoBanThreshold 401, 403
+	// or 429 responses from the relay within AutoBanWindowSeconds is denied
+	// for AutoBanSeconds; a threshold of 0 disables automatic bans.
+	IPAllowlistLocal     bool
+	AutoBanThreshold     int
+	AutoBanWindowSeconds int
+	AutoBanSeconds       int
+
+	// Hub and agent mode. The hub accepts agents listed in HubAgentKeys
+	// (NAME:KEY) and sends /proxy requests without an @AGENT prefix to
+	// HubDefaultAgent, or the only connected agent. The agent dials the hub
+	// at AgentHubURL and only connects it to AgentAllowedNetworks.
+	HubAgentKeys         []string
+	HubDefaultAgent      string
+	AgentHubURL          string
+	AgentName            string
+	AgentKey             string
+	AgentAllowedNetworks []string
 }
 
 func Load() *Config {
@@ -21,6 +129,74 @@ func Load() *Config {
 		Environment:  getEnv("ENVIRONMENT", "development"),
 		NgrokToken:   getEnv("NGROK_TOKEN", ""),
 		NgrokDomain:  getEnv("NGROK_DOMAIN", ""),
+
+		CaptureEnabled:      getEnvBool("CAPTURE_ENABLED", false),
+		CaptureMaxBodyBytes: getEnvInt("CAPTURE_MAX_BODY_BYTES", 64*1024),
+		CaptureContentTypes: getEnvList("CAPTURE_CONTENT_TYPES",
+			"text/,application/json,application/xml,application/x-www-form-urlencoded,application/javascript"),
+
+		VaultKey:     getEnv("VAULT_KEY", ""),
+		VaultKeyFile: getEnv("VAULT_KEY_FILE", "vault.key"),
+
+		GRPCTLSTargets:    getEnvList("GRPC_TLS_TARGETS", ""),
+		GRPCTLSSkipVerify: getEnvBool("GRPC_TLS_SKIP_VERIFY", false),
+		GRPCWebEnabled:    getEnvBool("GRPC_WEB_ENABLED", false),
+
+		DiscoveryMDNS:      getEnvBool("DISCOVERY_MDNS", true),
+		DiscoverySSDP:      getEnvBool("DISCOVERY_SSDP", true),
+		DiscoveryTimeoutMs: getEnvInt("DISCOVERY_TIMEOUT_MS", 3000),
+		DiscoverySubnets:   getEnvList("DISCOVERY_SCAN_SUBNETS", ""),
+		DiscoveryPorts:     getEnvIntList("DISCOVERY_SCAN_PORTS", "80,443,3000,5000,8000,8080,8123,8443,9000"),
+
+		InventoryEnabled:         getEnvBool("INVENTORY_ENABLED", true),
+		InventoryIntervalSeconds: getEnvInt("INVENTORY_INTERVAL_SECONDS", 60),
+		NeighborTablePath:        getEnv("NEIGHBOR_TABLE_PATH", "/proc/net/arp"),
+		OUIFile:                  getEnv("OUI_FILE", ""),
+		WOLBroadcast:             getEnv("WOL_BROADCAST", "255.255.255.255:9"),
+
+		AuthEnabled:     getEnvBool("AUTH_ENABLED", true),
+		SessionTTLHours: getEnvInt("SESSION_TTL_HOURS", 7*24),
+		AdminUsername:   getEnv("ADMIN_USERNAME", "admin"),
+		AdminPassword:   getEnv("ADMIN_PASSWORD", ""),
+
+		LoginMaxFailures:       getEnvInt("LOGIN_MAX_FAILURES", 5),
+		LoginMaxFailuresPerIP:  getEnvInt("LOGIN_MAX_FAILURES_PER_IP", 20),
+		LoginLockoutSeconds:    getEnvInt("LOGIN_LOCKOUT_SECONDS", 30),
+		LoginLockoutMaxSeconds: getEnvInt("LOGIN_LOCKOUT_MAX_SECONDS", 3600),
+		TOTPIssuer:             getEnv("TOTP_ISSUER", "LAN Relay"),
+
+		TLSPort:      getEnv("TLS_PORT", ""),
+		PKIDir:       getEnv("PKI_DIR", "pki"),
+		TLSHostnames: getEnvList("TLS_HOSTNAMES", "localhost,127.0.0.1"),
+		TLSCertFile:  getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:   getEnv("TLS_KEY_FILE", ""),
+
+		CORSAllowedOrigins:      getEnvList("CORS_ALLOWED_ORIGINS", ""),
+		ProxyCORSAllowedOrigins: getEnvList("PROXY_CORS_ALLOWED_ORIGINS", ""),
+		CSRFMode:                strings.ToLower(getEnv("CSRF_MODE", "origin")),
+		SessionCookieSameSite:   strings.ToLower(getEnv("SESSION_COOKIE_SAMESITE", "lax")),
+
+		ShareKey:      getEnv("SHARE_KEY", ""),
+		ShareKeyFile:  getEnv("SHARE_KEY_FILE", "share.key"),
+		ShareMaxHours: getEnvInt("SHARE_MAX_HOURS", 720),
+
+		TrustedProxies:   getEnvList("TRUSTED_PROXIES", "127.0.0.1,::1"),
+		RemoteIPHeaders:  getEnvList("REMOTE_IP_HEADERS", "X-Forwarded-For,X-Real-IP"),
+		TunnelHosts:      getEnvList("TUNNEL_HOSTS", ""),
+		LocalOnlyRoutes:  getEnvList("LOCAL_ONLY_ROUTES", ""),
+		ProxyAuthOrigins: getEnvList("PROXY_AUTH_ORIGINS", "local,tunnel,other"),
+
+		IPAllowlistLocal:     getEnvBool("IP_ALLOWLIST_LOCAL", false),
+		AutoBanThreshold:     getEnvInt("AUTO_BAN_THRESHOLD", 30),
+		AutoBanWindowSeconds: getEnvInt("AUTO_BAN_WINDOW_SECONDS", 60),
+		AutoBanSeconds:       getEnvInt("AUTO_BAN_SECONDS", 900),
+
+		HubAgentKeys:         getEnvList("HUB_AGENT_KEYS", ""),
+		HubDefaultAgent:      getEnv("HUB_DEFAULT_AGENT", ""),
+		AgentHubURL:          getEnv("AGENT_HUB_URL", ""),
+		AgentName:            getEnv("AGENT_NAME", ""),
+		AgentKey:             getEnv("AGENT_KEY", ""),
+		AgentAllowedNetworks: getEnvList("AGENT_ALLOWED_NETWORKS", "10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,169.254.0.0/16"),
 	}
 }
 
@@ -29,4 +205,40 @@ func getEnv(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
-} 
\ No newline at end of file
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvList splits a comma-separated variable, dropping empty items
+func getEnvList(key, defaultValue string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnvIntList splits a comma-separated list of integers, dropping invalid items
+func getEnvIntList(key, defaultValue string) []int {
+	values := make([]int, 0)
+	for _, item := range getEnvList(key, defaultValue) {
+		if value, err := strconv.Atoi(item); err == nil {
+			values = append(values, value)
+		}
+	}
+	return values
+}
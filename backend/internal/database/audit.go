@@ -0,0 +1,295 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+// auditTriggers make audit events append-only. An event may only be updated
+// while its hash is still empty, which is how InsertAuditEvent and
+// chainAuditEvents complete an event.
+const auditTriggers = `
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update
+BEFORE UPDATE ON audit_events WHEN OLD.hash != ''
+BEGIN
+	SELECT RAISE(ABORT, 'audit events are append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete
+BEFORE DELETE ON audit_events
+BEGIN
+	SELECT RAISE(ABORT, 'audit events are append-only');
+END;
+`
+
+const auditColumns = `id, timestamp, COALESCE(user_id, 0), COALESCE(username, ''), action, COALESCE(resource, ''),
+	COALESCE(detail, ''), COALESCE(changes, ''), COALESCE(source_ip, ''), COALESCE(request_id, ''),
+	COALESCE(prev_hash, ''), COALESCE(hash, '')`
+
+// AuditFilter narrows down audit queries. Zero values are ignored.
+type AuditFilter struct {
+	Action   string
+	Username string
+	Resource string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	Offset   int
+}
+
+// where builds the WHERE clause and arguments for the filter
+func (f AuditFilter) where() (string, []interface{}) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if f.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.Username != "" {
+		conditions = append(conditions, "username = ?")
+		args = append(args, f.Username)
+	}
+	if f.Resource != "" {
+		conditions = append(conditions, "resource = ?")
+		args = append(args, f.Resource)
+	}
+	if !f.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, localTime(f.Since))
+	}
+	if !f.Until.IsZero() {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, localTime(f.Until))
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// auditRecord is an event as it's stored, with changes kept as the stored
+// JSON text so the hash covers exactly what's in the database
+type auditRecord struct {
+	models.AuditEvent
+	changes string
+}
+
+// auditHash returns the chain hash of an event: a SHA-256 over its fields and
+// the previous event's hash
+func auditHash(record auditRecord, prevHash string) string {
+	payload, _ := json.Marshal(struct {
+		PrevHash  string `json:"prev_hash"`
+		Timestamp string `json:"timestamp"`
+		UserID    int    `json:"user_id"`
+		Username  string `json:"username"`
+		Action    string `json:"action"`
+		Resource  string `json:"resource"`
+		Detail    string `json:"detail"`
+		Changes   string `json:"changes"`
+		SourceIP  string `json:"source_ip"`
+		RequestID string `json:"request_id"`
+	}{
+		PrevHash:  prevHash,
+		Timestamp: record.Timestamp.UTC().Format(time.RFC3339Nano),
+		UserID:    record.UserID,
+		Username:  record.Username,
+		Action:    record.Action,
+		Resource:  record.Resource,
+		Detail:    record.Detail,
+		Changes:   record.changes,
+		SourceIP:  record.SourceIP,
+		RequestID: record.RequestID,
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// InsertAuditEvent appends an audit event to the hash chain and sets its ID
+// and hashes
+func (db *DB) InsertAuditEvent(event *models.AuditEvent) error {
+	changes := ""
+	if len(event.Changes) > 0 {
+		data, err := json.Marshal(event.Changes)
+		if err != nil {
+			return err
+		}
+		changes = string(data)
+	}
+
+	db.auditMu.Lock()
+	defer db.auditMu.Unlock()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The insert takes the write lock, so no other event can be appended
+	// between reading the previous hash and completing this one
+	result, err := tx.Exec(`
+	INSERT INTO audit_events (timestamp, user_id, username, action, resource, detail, changes, source_ip, request_id, prev_hash, hash)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE((SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1), ''), '')
+	`, event.Timestamp, nullInt(event.UserID), event.Username, event.Action, event.Resource, event.Detail, changes, event.SourceIP, event.RequestID)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	var prevHash string
+	if err := tx.QueryRow(`SELECT prev_hash FROM audit_events WHERE id = ?`, id).Scan(&prevHash); err != nil {
+		return err
+	}
+	hash := auditHash(auditRecord{AuditEvent: *event, changes: changes}, prevHash)
+	if _, err := tx.Exec(`UPDATE audit_events SET hash = ? WHERE id = ?`, hash, id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	event.ID = int(id)
+	event.PrevHash = prevHash
+	event.Hash = hash
+	return nil
+}
+
+// chainAuditEvents adds events that have no hash yet, those recorded before
+// hash chaining, to the chain in ID order
+func (db *DB) chainAuditEvents() error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT ` + auditColumns + ` FROM audit_events ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	records := make([]auditRecord, 0)
+	for rows.Next() {
+		record, err := scanAuditRecord(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		records = append(records, record)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	prevHash := ""
+	for _, record := range records {
+		if record.Hash == "" {
+			record.Hash = auditHash(record, prevHash)
+			if _, err := tx.Exec(`UPDATE audit_events SET prev_hash = ?, hash = ? WHERE id = ?`, prevHash, record.Hash, record.ID); err != nil {
+				return err
+			}
+		}
+		prevHash = record.Hash
+	}
+
+	return tx.Commit()
+}
+
+func scanAuditRecord(row rowScanner) (auditRecord, error) {
+	var record auditRecord
+	event := &record.AuditEvent
+	err := row.Scan(&event.ID, &event.Timestamp, &event.UserID, &event.Username, &event.Action,
+		&event.Resource, &event.Detail, &record.changes, &event.SourceIP, &event.RequestID,
+		&event.PrevHash, &event.Hash)
+	if err != nil {
+		return record, err
+	}
+	if record.changes != "" {
+		if err := json.Unmarshal([]byte(record.changes), &event.Changes); err != nil {
+			return record, fmt.Errorf("audit event %d: invalid changes: %v", event.ID, err)
+		}
+	}
+	return record, nil
+}
+
+// GetAuditEvents returns audit events matching a filter, newest first
+func (db *DB) GetAuditEvents(filter AuditFilter) ([]models.AuditEvent, error) {
+	where, args := filter.where()
+	query := `SELECT ` + auditColumns + ` FROM audit_events ` + where + ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]models.AuditEvent, 0)
+	for rows.Next() {
+		record, err := scanAuditRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, record.AuditEvent)
+	}
+
+	return events, rows.Err()
+}
+
+// VerifyAuditChain recomputes every event's hash in order and reports the
+// first event that was changed, or whose predecessor was removed
+func (db *DB) VerifyAuditChain() (*models.AuditVerification, error) {
+	rows, err := db.conn.Query(`SELECT ` + auditColumns + ` FROM audit_events ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &models.AuditVerification{Valid: true}
+	prevHash := ""
+	for rows.Next() {
+		var record auditRecord
+		event := &record.AuditEvent
+		// Scan the raw changes without decoding, a tampered value may not be valid JSON
+		err := rows.Scan(&event.ID, &event.Timestamp, &event.UserID, &event.Username, &event.Action,
+			&event.Resource, &event.Detail, &record.changes, &event.SourceIP, &event.RequestID,
+			&event.PrevHash, &event.Hash)
+		if err != nil {
+			return nil, err
+		}
+		result.Events++
+
+		switch {
+		case event.PrevHash != prevHash:
+			result.Reason = "previous hash doesn't match, an earlier event was removed or changed"
+		case event.Hash != auditHash(record, prevHash):
+			result.Reason = "event was modified after it was recorded"
+		}
+		if result.Reason != "" {
+			result.Valid = false
+			result.BrokenAt = event.ID
+			return result, nil
+		}
+		prevHash = event.Hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result.Head = prevHash
+	return result, nil
+}
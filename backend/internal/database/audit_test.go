@@ -0,0 +1,114 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+// auditedDB returns a database with three chained audit events, IDs 1 to 3
+func auditedDB(t *testing.T) *DB {
+	t.Helper()
+	db := newTestDB(t)
+	for _, action := range []string{"auth.login", "settings.update", "auth.logout"} {
+		event := &models.AuditEvent{
+			Timestamp: time.Now(),
+			Username:  "alice",
+			Action:    action,
+			Resource:  "user:alice",
+			Changes:   []models.AuditChange{{Field: "role", From: "viewer", To: "admin"}},
+		}
+		if err := db.InsertAuditEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestAuditEventsAreAppendOnly(t *testing.T) {
+	db := auditedDB(t)
+	if _, err := db.conn.Exec(`UPDATE audit_events SET detail = 'edited' WHERE id = 2`); err == nil {
+		t.Error("an audit event was updated")
+	}
+	if _, err := db.conn.Exec(`DELETE FROM audit_events WHERE id = 2`); err == nil {
+		t.Error("an audit event was deleted")
+	}
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	tests := []struct {
+		name         string
+		tamper       []string
+		wantValid    bool
+		wantBrokenAt int
+		wantEvents   int
+	}{
+		{"untouched", nil, true, 0, 3},
+		{"detail edited", []string{`UPDATE audit_events SET detail = 'edited' WHERE id = 2`}, false, 2, 2},
+		{"changes edited", []string{`UPDATE audit_events SET changes = '[]' WHERE id = 1`}, false, 1, 1},
+		{"changes no longer JSON", []string{`UPDATE audit_events SET changes = '{' WHERE id = 3`}, false, 3, 3},
+		{"timestamp edited", []string{`UPDATE audit_events SET timestamp = '2001-01-01 00:00:00' WHERE id = 3`}, false, 3, 3},
+		{"event removed", []string{`DELETE FROM audit_events WHERE id = 2`}, false, 3, 2},
+		{"last event removed", []string{`DELETE FROM audit_events WHERE id = 3`}, true, 0, 2},
+		{"edit with its hash recomputed", []string{
+			`UPDATE audit_events SET username = 'mallory', hash = 'forged' WHERE id = 2`,
+		}, false, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := auditedDB(t)
+			// Tampering happens outside the relay, with the triggers gone
+			if tt.tamper != nil {
+				if _, err := db.conn.Exec(`DROP TRIGGER audit_events_no_update; DROP TRIGGER audit_events_no_delete`); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, query := range tt.tamper {
+				if _, err := db.conn.Exec(query); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result, err := db.VerifyAuditChain()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Valid != tt.wantValid || result.BrokenAt != tt.wantBrokenAt || result.Events != tt.wantEvents {
+				t.Fatalf("VerifyAuditChain() = %+v, want valid %v, broken at %d after %d events", result, tt.wantValid, tt.wantBrokenAt, tt.wantEvents)
+			}
+			if result.Valid && result.Head == "" {
+				t.Error("VerifyAuditChain() returned no head hash")
+			}
+			if !result.Valid && result.Reason == "" {
+				t.Error("VerifyAuditChain() gave no reason")
+			}
+		})
+	}
+}
+
+// Events recorded before hash chaining are chained in ID order on start
+func TestChainAuditEvents(t *testing.T) {
+	db := newTestDB(t)
+	for _, action := range []string{"auth.login", "auth.logout"} {
+		if _, err := db.conn.Exec(`INSERT INTO audit_events (timestamp, username, action, prev_hash, hash) VALUES (?, 'alice', ?, '', '')`,
+			localTime(time.Now()), action); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.chainAuditEvents(); err != nil {
+		t.Fatal(err)
+	}
+
+	event := &models.AuditEvent{Timestamp: time.Now(), Username: "alice", Action: "auth.login"}
+	if err := db.InsertAuditEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	result, err := db.VerifyAuditChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid || result.Events != 3 || result.Head != event.Hash {
+		t.Fatalf("VerifyAuditChain() = %+v, want 3 valid events ending at %s", result, event.Hash)
+	}
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+const clientCertColumns = `client_certs.serial, client_certs.name, client_certs.user_id, COALESCE(users.username, ''),
+	client_certs.created_at, client_certs.expires_at, client_certs.revoked_at`
+
+const clientCertFrom = ` FROM client_certs LEFT JOIN users ON users.id = client_certs.user_id`
+
+func scanClientCert(row rowScanner) (models.ClientCert, error) {
+	var cert models.ClientCert
+	var revokedAt sql.NullTime
+	err := row.Scan(
+		&cert.Serial,
+		&cert.Name,
+		&cert.UserID,
+		&cert.Username,
+		&cert.CreatedAt,
+		&cert.ExpiresAt,
+		&revokedAt,
+	)
+	if revokedAt.Valid {
+		cert.RevokedAt = &revokedAt.Time
+	}
+	return cert, err
+}
+
+func (db *DB) queryClientCerts(query string, args ...interface{}) ([]models.ClientCert, error) {
+	rows, err := db.conn.Query(`SELECT `+clientCertColumns+clientCertFrom+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	certs := make([]models.ClientCert, 0)
+	for rows.Next() {
+		cert, err := scanClientCert(rows)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, rows.Err()
+}
+
+// GetClientCerts returns the issued client certificates, newest first
+func (db *DB) GetClientCerts() ([]models.ClientCert, error) {
+	return db.queryClientCerts(` ORDER BY client_certs.created_at DESC`)
+}
+
+// GetRevokedClientCerts returns the revoked certificates that haven't
+// expired yet, which are the ones the CRL needs to list
+func (db *DB) GetRevokedClientCerts() ([]models.ClientCert, error) {
+	return db.queryClientCerts(` WHERE client_certs.revoked_at IS NOT NULL AND client_certs.expires_at > ?
+	ORDER BY client_certs.revoked_at`, time.Now())
+}
+
+// GetClientCert returns a client certificate by serial, or nil
+func (db *DB) GetClientCert(serial string) (*models.ClientCert, error) {
+	cert, err := scanClientCert(db.conn.QueryRow(`SELECT `+clientCertColumns+clientCertFrom+` WHERE client_certs.serial = ?`, serial))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// InsertClientCert records an issued client certificate
+func (db *DB) InsertClientCert(cert *models.ClientCert) error {
+	cert.CreatedAt = time.Now()
+	cert.ExpiresAt = localTime(cert.ExpiresAt)
+	_, err := db.conn.Exec(`
+	INSERT INTO client_certs (serial, name, user_id, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?)
+	`, cert.Serial, cert.Name, cert.UserID, cert.CreatedAt, cert.ExpiresAt)
+	return err
+}
+
+// RevokeClientCert marks a client certificate as revoked. Revoking it again
+// keeps the original time.
+func (db *DB) RevokeClientCert(serial string) error {
+	_, err := db.conn.Exec(`UPDATE client_certs SET revoked_at = ? WHERE serial = ? AND revoked_at IS NULL`, time.Now(), serial)
+	return err
+}
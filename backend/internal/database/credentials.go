@@ -0,0 +1,220 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+const credentialColumns = `id, name, type, COALESCE(username, ''), COALESCE(param, ''), secret, created_at, rotated_at, last_used_at`
+
+func scanCredential(row rowScanner) (models.Credential, error) {
+	var credential models.Credential
+	var rotatedAt, lastUsedAt sql.NullTime
+	err := row.Scan(
+		&credential.ID,
+		&credential.Name,
+		&credential.Type,
+		&credential.Username,
+		&credential.Param,
+		&credential.EncryptedSecret,
+		&credential.CreatedAt,
+		&rotatedAt,
+		&lastUsedAt,
+	)
+	if rotatedAt.Valid {
+		credential.RotatedAt = &rotatedAt.Time
+	}
+	if lastUsedAt.Valid {
+		credential.LastUsedAt = &lastUsedAt.Time
+	}
+	credential.Targets = make([]string, 0)
+	return credential, err
+}
+
+// GetCredentials returns all credentials with their bound targets
+func (db *DB) GetCredentials() ([]models.Credential, error) {
+	rows, err := db.conn.Query(`SELECT ` + credentialColumns + ` FROM credentials ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := make([]models.Credential, 0)
+	for rows.Next() {
+		credential, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range credentials {
+		if credentials[i].Targets, err = db.getCredentialTargets(credentials[i].ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return credentials, nil
+}
+
+// GetCredential returns a single credential, or nil if it doesn't exist
+func (db *DB) GetCredential(id int) (*models.Credential, error) {
+	row := db.conn.QueryRow(`SELECT `+credentialColumns+` FROM credentials WHERE id = ?`, id)
+	credential, err := scanCredential(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if credential.Targets, err = db.getCredentialTargets(id); err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// GetCredentialsForTarget returns the credentials bound to a target
+func (db *DB) GetCredentialsForTarget(target string) ([]models.Credential, error) {
+	query := `
+	SELECT ` + credentialColumns + `
+	FROM credentials
+	WHERE id IN (SELECT credential_id FROM credential_bindings WHERE target = ?)
+	ORDER BY id
+	`
+
+	rows, err := db.conn.Query(query, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credentials := make([]models.Credential, 0)
+	for rows.Next() {
+		credential, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+func (db *DB) getCredentialTargets(id int) ([]string, error) {
+	rows, err := db.conn.Query(`SELECT target FROM credential_bindings WHERE credential_id = ? ORDER BY target`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := make([]string, 0)
+	for rows.Next() {
+		var target string
+		if err := rows.Scan(&target); err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, rows.Err()
+}
+
+// InsertCredential stores a new credential with its bindings and sets its ID
+func (db *DB) InsertCredential(credential *models.Credential) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	credential.CreatedAt = time.Now()
+	result, err := tx.Exec(`
+	INSERT INTO credentials (name, type, username, param, secret, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`, credential.Name, credential.Type, credential.Username, credential.Param, credential.EncryptedSecret, credential.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	credential.ID = int(id)
+
+	if err := setCredentialTargets(tx, credential.ID, credential.Targets); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateCredential updates a credential's metadata and bindings, leaving the secret untouched
+func (db *DB) UpdateCredential(credential *models.Credential) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE credentials SET name = ?, type = ?, username = ?, param = ? WHERE id = ?
+	`, credential.Name, credential.Type, credential.Username, credential.Param, credential.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := setCredentialTargets(tx, credential.ID, credential.Targets); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setCredentialTargets(tx *sql.Tx, id int, targets []string) error {
+	if _, err := tx.Exec(`DELETE FROM credential_bindings WHERE credential_id = ?`, id); err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO credential_bindings (credential_id, target) VALUES (?, ?)`, id, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RotateCredential replaces the encrypted secret and records the rotation time
+func (db *DB) RotateCredential(id int, encryptedSecret []byte) error {
+	_, err := db.conn.Exec(`UPDATE credentials SET secret = ?, rotated_at = ? WHERE id = ?`, encryptedSecret, time.Now(), id)
+	return err
+}
+
+// TouchCredential records that a credential was just injected into a request
+func (db *DB) TouchCredential(id int) error {
+	_, err := db.conn.Exec(`UPDATE credentials SET last_used_at = ? WHERE id = ?`, time.Now(), id)
+	return err
+}
+
+// DeleteCredential removes a credential and its bindings
+func (db *DB) DeleteCredential(id int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM credential_bindings WHERE credential_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM credentials WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
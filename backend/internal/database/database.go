@@ -2,15 +2,22 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"lan-relay/internal/models"
+	"lan-relay/internal/vault"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type DB struct {
-	conn *sql.DB
+	conn    *sql.DB
+	vault   *vault.Vault // encrypts secret settings, see UseVault
+	auditMu sync.Mutex   // serialises appends to the audit hash chain
 }
 
 func Init(dbPath string) (*DB, error) {
@@ -25,6 +32,10 @@ func Init(dbPath string) (*DB, error) {
 		return nil, err
 	}
 
+	if err := db.migrate(); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
@@ -58,19 +69,331 @@ func (db *DB) createTables() error {
 	);
 
 	INSERT OR IGNORE INTO settings (id, ngrok_token, ngrok_domain) VALUES (1, '', '');
+
+	CREATE TABLE IF NOT EXISTS header_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		target TEXT NOT NULL,
+		direction TEXT NOT NULL,
+		action TEXT NOT NULL,
+		name TEXT NOT NULL,
+		value TEXT DEFAULT '',
+		position INTEGER DEFAULT 0,
+		enabled BOOLEAN DEFAULT 1,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_header_rules_target ON header_rules(target);
+
+	CREATE TABLE IF NOT EXISTS credentials (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		type TEXT NOT NULL,
+		username TEXT DEFAULT '',
+		param TEXT DEFAULT '',
+		secret BLOB NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		rotated_at DATETIME,
+		last_used_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS credential_bindings (
+		credential_id INTEGER NOT NULL,
+		target TEXT NOT NULL,
+		PRIMARY KEY (credential_id, target)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_credential_bindings_target ON credential_bindings(target);
+
+	CREATE TABLE IF NOT EXISTS log_captures (
+		log_id INTEGER PRIMARY KEY,
+		request_proto TEXT,
+		request_query TEXT,
+		request_headers TEXT,
+		request_body BLOB,
+		request_body_size INTEGER,
+		request_body_truncated BOOLEAN,
+		response_proto TEXT,
+		response_headers TEXT,
+		response_body BLOB,
+		response_body_size INTEGER,
+		response_body_truncated BOOLEAN
+	);
+
+	CREATE TABLE IF NOT EXISTS targets (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		host TEXT NOT NULL,
+		port INTEGER NOT NULL,
+		source TEXT DEFAULT 'manual',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (host, port)
+	);
+
+	CREATE TABLE IF NOT EXISTS devices (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		mac TEXT NOT NULL UNIQUE,
+		ip TEXT NOT NULL,
+		name TEXT DEFAULT '',
+		hostname TEXT DEFAULT '',
+		vendor TEXT DEFAULT '',
+		interface TEXT DEFAULT '',
+		first_seen DATETIME NOT NULL,
+		last_seen DATETIME NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_devices_ip ON devices(ip);
+
+	CREATE TABLE IF NOT EXISTS device_addresses (
+		device_id INTEGER NOT NULL,
+		ip TEXT NOT NULL,
+		first_seen DATETIME NOT NULL,
+		last_seen DATETIME NOT NULL,
+		PRIMARY KEY (device_id, ip)
+	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		password_hash TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		last_login_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS sessions (
+		token_hash TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		last_seen_at DATETIME NOT NULL,
+		ip TEXT DEFAULT '',
+		user_agent TEXT DEFAULT ''
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+
+	CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		user_id INTEGER NOT NULL,
+		scopes TEXT NOT NULL,
+		targets TEXT DEFAULT '',
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME,
+		last_used_at DATETIME,
+		last_used_ip TEXT DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		code_hash TEXT NOT NULL,
+		used_at DATETIME
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_recovery_codes_user ON recovery_codes(user_id);
+
+	CREATE TABLE IF NOT EXISTS login_failures (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL,
+		last_failure DATETIME NOT NULL,
+		locked_until DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS client_certs (
+		serial TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS share_links (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		target TEXT NOT NULL,
+		path_prefix TEXT DEFAULT '',
+		methods TEXT DEFAULT '',
+		max_uses INTEGER DEFAULT 0,
+		uses INTEGER DEFAULT 0,
+		password_hash TEXT DEFAULT '',
+		created_by INTEGER,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		revoked_at DATETIME,
+		last_used_at DATETIME,
+		last_used_ip TEXT DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS share_visits (
+		token_hash TEXT PRIMARY KEY,
+		link_id INTEGER NOT NULL,
+		ip TEXT DEFAULT '',
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS ip_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		cidr TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT DEFAULT '',
+		reason TEXT DEFAULT '',
+		automatic INTEGER DEFAULT 0,
+		created_by INTEGER,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS groups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE COLLATE NOCASE,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS group_members (
+		group_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		PRIMARY KEY (group_id, user_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS target_grants (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER,
+		group_id INTEGER,
+		target TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME NOT NULL,
+		user_id INTEGER,
+		username TEXT DEFAULT '',
+		action TEXT NOT NULL,
+		resource TEXT DEFAULT '',
+		detail TEXT DEFAULT '',
+		source_ip TEXT DEFAULT '',
+		request_id TEXT DEFAULT ''
+	);
 	`
 
 	_, err := db.conn.Exec(query)
 	return err
 }
 
+// migrate adds columns introduced after a table was first created
+func (db *DB) migrate() error {
+	columns := []struct {
+		table, name, definition string
+	}{
+		{"log_entries", "replay_of", "INTEGER"},
+		{"log_entries", "request_id", "TEXT DEFAULT ''"},
+		{"log_entries", "response_bytes", "INTEGER DEFAULT 0"},
+		{"log_entries", "streamed", "BOOLEAN DEFAULT 0"},
+		{"log_entries", "grpc_status", "INTEGER"},
+		{"log_entries", "api_token_id", "INTEGER"},
+		{"log_entries", "identity", "TEXT DEFAULT ''"},
+		// Accounts created before roles existed keep full access
+		{"users", "role", "TEXT NOT NULL DEFAULT 'admin'"},
+		{"users", "oidc_subject", "TEXT"},
+		{"log_entries", "share_id", "INTEGER"},
+		{"log_entries", "origin", "TEXT"},
+		{"log_entries", "agent", "TEXT"},
+		{"audit_events", "changes", "TEXT DEFAULT ''"},
+		{"audit_events", "prev_hash", "TEXT DEFAULT ''"},
+		{"audit_events", "hash", "TEXT DEFAULT ''"},
+		{"users", "totp_secret", "BLOB"},
+		{"users", "totp_enabled", "BOOLEAN DEFAULT 0"},
+		{"users", "totp_last_step", "INTEGER DEFAULT 0"},
+		{"settings", "oidc_enabled", "BOOLEAN DEFAULT 0"},
+		{"settings", "oidc_issuer", "TEXT DEFAULT ''"},
+		{"settings", "oidc_client_id", "TEXT DEFAULT ''"},
+		{"settings", "oidc_client_secret", "TEXT DEFAULT ''"},
+		{"settings", "oidc_redirect_url", "TEXT DEFAULT ''"},
+		{"settings", "oidc_scopes", "TEXT DEFAULT 'openid profile email groups'"},
+		{"settings", "oidc_username_claim", "TEXT DEFAULT 'preferred_username'"},
+		{"settings", "oidc_groups_claim", "TEXT DEFAULT 'groups'"},
+		{"settings", "oidc_role_mapping", "TEXT DEFAULT ''"},
+		{"settings", "oidc_default_role", "TEXT DEFAULT ''"},
+		{"settings", "tunnel_provider", "TEXT DEFAULT 'ngrok'"},
+		{"settings", "cloudflared_token", "TEXT DEFAULT ''"},
+		{"settings", "cloudflared_hostname", "TEXT DEFAULT ''"},
+		{"settings", "frp_server", "TEXT DEFAULT ''"},
+		{"settings", "frp_token", "TEXT DEFAULT ''"},
+		{"settings", "frp_domain", "TEXT DEFAULT ''"},
+		{"settings", "ssh_server", "TEXT DEFAULT ''"},
+		{"settings", "ssh_user", "TEXT DEFAULT ''"},
+		{"settings", "ssh_private_key", "TEXT DEFAULT ''"},
+		{"settings", "ssh_host_key", "TEXT DEFAULT ''"},
+		{"settings", "ssh_remote_addr", "TEXT DEFAULT ''"},
+		{"settings", "ssh_public_url", "TEXT DEFAULT ''"},
+	}
+
+	for _, column := range columns {
+		if err := db.addColumnIfMissing(column.table, column.name, column.definition); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.conn.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject ON users(oidc_subject)`); err != nil {
+		return err
+	}
+
+	// Events recorded before hash chaining join the chain, then the table is
+	// made append-only
+	if err := db.chainAuditEvents(); err != nil {
+		return err
+	}
+	if _, err := db.conn.Exec(auditTriggers); err != nil {
+		return err
+	}
+
+	_, err := db.conn.Exec(`CREATE INDEX IF NOT EXISTS idx_request_id ON log_entries(request_id)`)
+	return err
+}
+
+func (db *DB) addColumnIfMissing(table, name, definition string) error {
+	rows, err := db.conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid          int
+			columnName   string
+			columnType   string
+			notNull      bool
+			defaultValue sql.NullString
+			primaryKey   int
+		)
+		if err := rows.Scan(&cid, &columnName, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return err
+		}
+		if columnName == name {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition))
+	return err
+}
+
+// InsertLogEntry stores a log entry and sets its ID
 func (db *DB) InsertLogEntry(entry *models.LogEntry) error {
 	query := `
-	INSERT INTO log_entries (timestamp, source_ip, method, target_host, target_port, path, status_code, duration_ms, error)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	INSERT INTO log_entries (timestamp, source_ip, method, target_host, target_port, path, status_code, duration_ms, error, replay_of, request_id, response_bytes, streamed, grpc_status, api_token_id, identity, share_id, origin, agent)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := db.conn.Exec(query,
+	result, err := db.conn.Exec(query,
 		entry.Timestamp,
 		entry.SourceIP,
 		entry.Method,
@@ -80,20 +403,186 @@ func (db *DB) InsertLogEntry(entry *models.LogEntry) error {
 		entry.StatusCode,
 		entry.Duration,
 		entry.Error,
+		nullInt(entry.ReplayOf),
+		entry.RequestID,
+		entry.ResponseBytes,
+		entry.Streamed,
+		entry.GRPCStatus,
+		nullInt(entry.APITokenID),
+		entry.Identity,
+		nullInt(entry.ShareID),
+		entry.Origin,
+		entry.Agent,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	entry.ID = int(id)
+	return nil
 }
 
-func (db *DB) GetLogs(limit, offset int) ([]models.LogEntry, error) {
-	query := `
-	SELECT id, timestamp, source_ip, method, target_host, target_port, path, status_code, duration_ms, COALESCE(error, '')
-	FROM log_entries
-	ORDER BY timestamp DESC
-	LIMIT ? OFFSET ?
-	`
+// LogFilter narrows down log queries. Zero values are ignored.
+type LogFilter struct {
+	Since      time.Time
+	Until      time.Time
+	TargetHost string
+	Method     string
+	StatusCode int
+	RequestID  string
+	APITokenID int
+	ShareID    int
+	Identity   string
+	Origin     string
+	Agent      string
+	// Targets, when not nil, limits entries to these targets
+	Targets []LogTarget
+	Limit   int
+	Offset  int
+}
 
-	rows, err := db.conn.Query(query, limit, offset)
+// LogTarget is a target host and port as the logs record them
+type LogTarget struct {
+	Host string
+	Port string
+}
+
+// where builds the WHERE clause and arguments for the filter
+func (f LogFilter) where() (string, []interface{}) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if !f.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, localTime(f.Since))
+	}
+	if !f.Until.IsZero() {
+		conditions = append(conditions, "timestamp <= ?")
+		args = append(args, localTime(f.Until))
+	}
+	if f.TargetHost != "" {
+		conditions = append(conditions, "target_host = ?")
+		args = append(args, f.TargetHost)
+	}
+	if f.Method != "" {
+		conditions = append(conditions, "method = ?")
+		args = append(args, strings.ToUpper(f.Method))
+	}
+	if f.StatusCode != 0 {
+		conditions = append(conditions, "status_code = ?")
+		args = append(args, f.StatusCode)
+	}
+	if f.RequestID != "" {
+		conditions = append(conditions, "request_id = ?")
+		args = append(args, f.RequestID)
+	}
+	if f.APITokenID != 0 {
+		conditions = append(conditions, "api_token_id = ?")
+		args = append(args, f.APITokenID)
+	}
+	if f.ShareID != 0 {
+		conditions = append(conditions, "share_id = ?")
+		args = append(args, f.ShareID)
+	}
+	if f.Origin != "" {
+		conditions = append(conditions, "origin = ?")
+		args = append(args, f.Origin)
+	}
+	if f.Agent != "" {
+		conditions = append(conditions, "agent = ?")
+		args = append(args, f.Agent)
+	}
+	if f.Identity != "" {
+		conditions = append(conditions, "identity = ?")
+		args = append(args, f.Identity)
+	}
+	if f.Targets != nil {
+		if len(f.Targets) == 0 {
+			conditions = append(conditions, "0")
+		} else {
+			values := make([]string, len(f.Targets))
+			for i, target := range f.Targets {
+				values[i] = "(?, ?)"
+				args = append(args, target.Host, target.Port)
+			}
+			conditions = append(conditions, "(target_host, target_port) IN (VALUES "+strings.Join(values, ", ")+")")
+		}
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+const logColumns = `id, timestamp, source_ip, method, target_host, target_port, path, status_code, duration_ms, COALESCE(error, ''),
+	COALESCE(replay_of, 0), COALESCE(request_id, ''),
+	COALESCE(response_bytes, 0), COALESCE(streamed, 0), grpc_status, COALESCE(api_token_id, 0),
+	COALESCE(identity, ''), COALESCE(share_id, 0), COALESCE(origin, ''), COALESCE(agent, ''), EXISTS(SELECT 1 FROM log_captures WHERE log_captures.log_id = log_entries.id)`
+
+// nullInt stores zero IDs as NULL
+func nullInt(value int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(value), Valid: value != 0}
+}
+
+// localTime converts a time for storage or comparison. Timestamps are stored
+// as text in local time (as time.Now() writes them) and compared as text, so
+// a time in any other zone would sort wrongly against them.
+func localTime(t time.Time) time.Time {
+	return t.Local()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanLogEntry(row rowScanner) (models.LogEntry, error) {
+	var log models.LogEntry
+	var grpcStatus sql.NullInt64
+	err := row.Scan(
+		&log.ID,
+		&log.Timestamp,
+		&log.SourceIP,
+		&log.Method,
+		&log.TargetHost,
+		&log.TargetPort,
+		&log.Path,
+		&log.StatusCode,
+		&log.Duration,
+		&log.Error,
+		&log.ReplayOf,
+		&log.RequestID,
+		&log.ResponseBytes,
+		&log.Streamed,
+		&grpcStatus,
+		&log.APITokenID,
+		&log.Identity,
+		&log.ShareID,
+		&log.Origin,
+		&log.Agent,
+		&log.HasCapture,
+	)
+	if grpcStatus.Valid {
+		code := int(grpcStatus.Int64)
+		log.GRPCStatus = &code
+	}
+	return log, err
+}
+
+// GetLogs returns log entries matching the filter, newest first
+func (db *DB) GetLogs(filter LogFilter) ([]models.LogEntry, error) {
+	where, args := filter.where()
+	query := `SELECT ` + logColumns + ` FROM log_entries ` + where + ` ORDER BY timestamp DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := db.conn.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -101,19 +590,7 @@ func (db *DB) GetLogs(limit, offset int) ([]models.LogEntry, error) {
 
 	logs := make([]models.LogEntry, 0)
 	for rows.Next() {
-		var log models.LogEntry
-		err := rows.Scan(
-			&log.ID,
-			&log.Timestamp,
-			&log.SourceIP,
-			&log.Method,
-			&log.TargetHost,
-			&log.TargetPort,
-			&log.Path,
-			&log.StatusCode,
-			&log.Duration,
-			&log.Error,
-		)
+		log, err := scanLogEntry(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -123,7 +600,119 @@ func (db *DB) GetLogs(limit, offset int) ([]models.LogEntry, error) {
 	return logs, rows.Err()
 }
 
+// GetLogTargets returns every target that has log entries
+func (db *DB) GetLogTargets() ([]LogTarget, error) {
+	rows, err := db.conn.Query(`SELECT DISTINCT target_host, target_port FROM log_entries`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := make([]LogTarget, 0)
+	for rows.Next() {
+		var target LogTarget
+		if err := rows.Scan(&target.Host, &target.Port); err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, rows.Err()
+}
+
+// GetLogEntry returns a single log entry, or nil if it doesn't exist
+func (db *DB) GetLogEntry(id int) (*models.LogEntry, error) {
+	row := db.conn.QueryRow(`SELECT `+logColumns+` FROM log_entries WHERE id = ?`, id)
+	log, err := scanLogEntry(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &log, nil
+}
+
+// InsertLogCapture stores captured request/response data for a log entry
+func (db *DB) InsertLogCapture(capture *models.LogCapture) error {
+	requestHeaders, err := json.Marshal(capture.RequestHeaders)
+	if err != nil {
+		return err
+	}
+	responseHeaders, err := json.Marshal(capture.ResponseHeaders)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT OR REPLACE INTO log_captures (
+		log_id, request_proto, request_query, request_headers, request_body, request_body_size, request_body_truncated,
+		response_proto, response_headers, response_body, response_body_size, response_body_truncated
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err = db.conn.Exec(query,
+		capture.LogID,
+		capture.RequestProto,
+		capture.RequestQuery,
+		string(requestHeaders),
+		capture.RequestBody,
+		capture.RequestBodySize,
+		capture.RequestBodyTruncated,
+		capture.ResponseProto,
+		string(responseHeaders),
+		capture.ResponseBody,
+		capture.ResponseBodySize,
+		capture.ResponseBodyTruncated,
+	)
+	return err
+}
+
+// GetLogCapture returns the capture for a log entry, or nil if none was recorded
+func (db *DB) GetLogCapture(logID int) (*models.LogCapture, error) {
+	query := `
+	SELECT log_id, request_proto, request_query, request_headers, request_body, request_body_size, request_body_truncated,
+		response_proto, response_headers, response_body, response_body_size, response_body_truncated
+	FROM log_captures
+	WHERE log_id = ?
+	`
+
+	var capture models.LogCapture
+	var requestHeaders, responseHeaders string
+	err := db.conn.QueryRow(query, logID).Scan(
+		&capture.LogID,
+		&capture.RequestProto,
+		&capture.RequestQuery,
+		&requestHeaders,
+		&capture.RequestBody,
+		&capture.RequestBodySize,
+		&capture.RequestBodyTruncated,
+		&capture.ResponseProto,
+		&responseHeaders,
+		&capture.ResponseBody,
+		&capture.ResponseBodySize,
+		&capture.ResponseBodyTruncated,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(requestHeaders), &capture.RequestHeaders); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(responseHeaders), &capture.ResponseHeaders); err != nil {
+		return nil, err
+	}
+
+	return &capture, nil
+}
+
 func (db *DB) ClearLogs() error {
+	if _, err := db.conn.Exec("DELETE FROM log_captures"); err != nil {
+		return err
+	}
 	_, err := db.conn.Exec("DELETE FROM log_entries")
 	return err
 }
@@ -142,25 +731,106 @@ func (db *DB) GetRecentLogCount(since time.Time) (int, error) {
 
 func (db *DB) GetSettings() (*models.Settings, error) {
 	var settings models.Settings
-	query := `SELECT id, ngrok_token, ngrok_domain, updated_at FROM settings WHERE id = 1`
+	query := `
+	SELECT id, ngrok_token, ngrok_domain, COALESCE(oidc_enabled, 0), COALESCE(oidc_issuer, ''), COALESCE(oidc_client_id, ''),
+		COALESCE(oidc_client_secret, ''), COALESCE(oidc_redirect_url, ''), COALESCE(oidc_scopes, ''),
+		COALESCE(oidc_username_claim, ''), COALESCE(oidc_groups_claim, ''), COALESCE(oidc_role_mapping, ''),
+		COALESCE(oidc_default_role, ''), COALESCE(tunnel_provider, ''), COALESCE(cloudflared_token, ''),
+		COALESCE(cloudflared_hostname, ''), COALESCE(frp_server, ''), COALESCE(frp_token, ''), COALESCE(frp_domain, ''),
+		COALESCE(ssh_server, ''), COALESCE(ssh_user, ''), COALESCE(ssh_private_key, ''), COALESCE(ssh_host_key, ''),
+		COALESCE(ssh_remote_addr, ''), COALESCE(ssh_public_url, ''), updated_at
+	FROM settings WHERE id = 1`
 
 	err := db.conn.QueryRow(query).Scan(
 		&settings.ID,
 		&settings.NgrokToken,
 		&settings.NgrokDomain,
+		&settings.OIDC.Enabled,
+		&settings.OIDC.Issuer,
+		&settings.OIDC.ClientID,
+		&settings.OIDC.ClientSecret,
+		&settings.OIDC.RedirectURL,
+		&settings.OIDC.Scopes,
+		&settings.OIDC.UsernameClaim,
+		&settings.OIDC.GroupsClaim,
+		&settings.OIDC.RoleMapping,
+		&settings.OIDC.DefaultRole,
+		&settings.Tunnel.Provider,
+		&settings.Tunnel.CloudflaredToken,
+		&settings.Tunnel.CloudflaredHostname,
+		&settings.Tunnel.FRPServer,
+		&settings.Tunnel.FRPToken,
+		&settings.Tunnel.FRPDomain,
+		&settings.Tunnel.SSHServer,
+		&settings.Tunnel.SSHUser,
+		&settings.Tunnel.SSHPrivateKey,
+		&settings.Tunnel.SSHHostKey,
+		&settings.Tunnel.SSHRemoteAddr,
+		&settings.Tunnel.SSHPublicURL,
 		&settings.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
 
-	return &settings, err
+	if settings.NgrokToken, err = db.openSetting(settings.NgrokToken); err != nil {
+		return nil, err
+	}
+	if settings.OIDC.ClientSecret, err = db.openSetting(settings.OIDC.ClientSecret); err != nil {
+		return nil, err
+	}
+	if settings.Tunnel.CloudflaredToken, err = db.openSetting(settings.Tunnel.CloudflaredToken); err != nil {
+		return nil, err
+	}
+	if settings.Tunnel.FRPToken, err = db.openSetting(settings.Tunnel.FRPToken); err != nil {
+		return nil, err
+	}
+	if settings.Tunnel.SSHPrivateKey, err = db.openSetting(settings.Tunnel.SSHPrivateKey); err != nil {
+		return nil, err
+	}
+	return &settings, nil
 }
 
 func (db *DB) UpdateSettings(settings *models.Settings) error {
 	query := `
 	UPDATE settings 
-	SET ngrok_token = ?, ngrok_domain = ?, updated_at = CURRENT_TIMESTAMP 
+	SET ngrok_token = ?, ngrok_domain = ?, oidc_enabled = ?, oidc_issuer = ?, oidc_client_id = ?,
+		oidc_client_secret = ?, oidc_redirect_url = ?, oidc_scopes = ?, oidc_username_claim = ?,
+		oidc_groups_claim = ?, oidc_role_mapping = ?, oidc_default_role = ?, tunnel_provider = ?,
+		cloudflared_token = ?, cloudflared_hostname = ?, frp_server = ?, frp_token = ?, frp_domain = ?,
+		ssh_server = ?, ssh_user = ?, ssh_private_key = ?, ssh_host_key = ?, ssh_remote_addr = ?, ssh_public_url = ?,
+		updated_at = CURRENT_TIMESTAMP 
 	WHERE id = 1
 	`
 
-	_, err := db.conn.Exec(query, settings.NgrokToken, settings.NgrokDomain)
+	// Secrets are encrypted with the vault key
+	ngrokToken, err := db.sealSetting(settings.NgrokToken)
+	if err != nil {
+		return err
+	}
+	oidc := settings.OIDC
+	clientSecret, err := db.sealSetting(oidc.ClientSecret)
+	if err != nil {
+		return err
+	}
+
+	tunnel := settings.Tunnel
+	cloudflaredToken, err := db.sealSetting(tunnel.CloudflaredToken)
+	if err != nil {
+		return err
+	}
+	frpToken, err := db.sealSetting(tunnel.FRPToken)
+	if err != nil {
+		return err
+	}
+	sshKey, err := db.sealSetting(tunnel.SSHPrivateKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.conn.Exec(query, ngrokToken, settings.NgrokDomain, oidc.Enabled, oidc.Issuer, oidc.ClientID,
+		clientSecret, oidc.RedirectURL, oidc.Scopes, oidc.UsernameClaim, oidc.GroupsClaim, oidc.RoleMapping, oidc.DefaultRole,
+		tunnel.Provider, cloudflaredToken, tunnel.CloudflaredHostname, tunnel.FRPServer, frpToken, tunnel.FRPDomain,
+		tunnel.SSHServer, tunnel.SSHUser, sshKey, tunnel.SSHHostKey, tunnel.SSHRemoteAddr, tunnel.SSHPublicURL)
 	return err
 }
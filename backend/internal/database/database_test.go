@@ -0,0 +1,65 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := Init(filepath.Join(t.TempDir(), "relay.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Times from other zones must compare against stored timestamps by instant,
+// not by their text
+func TestTimesFromOtherZones(t *testing.T) {
+	db := newTestDB(t)
+	east := time.FixedZone("UTC+14", 14*3600)
+	west := time.FixedZone("UTC-12", -12*3600)
+	now := time.Now()
+
+	if err := db.InsertLogEntry(&models.LogEntry{Timestamp: now, Method: "GET", TargetHost: "10.0.0.5", TargetPort: "80"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		filter LogFilter
+		want   int
+	}{
+		{"since earlier in the east", LogFilter{Since: now.Add(-time.Minute).In(east)}, 1},
+		{"since later in the west", LogFilter{Since: now.Add(time.Minute).In(west)}, 0},
+		{"until earlier in the east", LogFilter{Until: now.Add(-time.Minute).In(east)}, 0},
+		{"until later in the west", LogFilter{Until: now.Add(time.Minute).In(west)}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs, err := db.GetLogs(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(logs) != tt.want {
+				t.Errorf("GetLogs() returned %d entries, want %d", len(logs), tt.want)
+			}
+		})
+	}
+
+	link := &models.ShareLink{Name: "west", Target: "10.0.0.5:80", ExpiresAt: now.Add(time.Hour).In(west)}
+	if err := db.InsertShareLink(link); err != nil {
+		t.Fatal(err)
+	}
+	links, err := db.GetShareLinks(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 1 {
+		t.Errorf("GetShareLinks() returned %d active links, want the one expiring in an hour", len(links))
+	}
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+// DeviceFilter narrows the device inventory
+type DeviceFilter struct {
+	Query       string // matches name, hostname, IP, MAC or vendor
+	Vendor      string
+	SeenSince   time.Time
+	Online      *bool
+	OnlineSince time.Time // devices seen after this are online
+	Limit       int
+}
+
+func (f DeviceFilter) where() (string, []interface{}) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if f.Query != "" {
+		like := "%" + f.Query + "%"
+		conditions = append(conditions, "(name LIKE ? OR hostname LIKE ? OR ip LIKE ? OR mac LIKE ? OR vendor LIKE ?)")
+		args = append(args, like, like, like, like, like)
+	}
+	if f.Vendor != "" {
+		conditions = append(conditions, "vendor LIKE ?")
+		args = append(args, "%"+f.Vendor+"%")
+	}
+	if !f.SeenSince.IsZero() {
+		conditions = append(conditions, "last_seen >= ?")
+		args = append(args, localTime(f.SeenSince))
+	}
+	if f.Online != nil {
+		if *f.Online {
+			conditions = append(conditions, "last_seen >= ?")
+		} else {
+			conditions = append(conditions, "last_seen < ?")
+		}
+		args = append(args, localTime(f.OnlineSince))
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+const deviceColumns = `id, mac, ip, COALESCE(name, ''), COALESCE(hostname, ''), COALESCE(vendor, ''), COALESCE(interface, ''), first_seen, last_seen`
+
+func scanDevice(row rowScanner) (models.Device, error) {
+	var device models.Device
+	err := row.Scan(
+		&device.ID,
+		&device.MAC,
+		&device.IP,
+		&device.Name,
+		&device.Hostname,
+		&device.Vendor,
+		&device.Interface,
+		&device.FirstSeen,
+		&device.LastSeen,
+	)
+	return device, err
+}
+
+// GetDevices returns the devices matching the filter, most recently seen first
+func (db *DB) GetDevices(filter DeviceFilter) ([]models.Device, error) {
+	where, args := filter.where()
+	query := `SELECT ` + deviceColumns + ` FROM devices ` + where + ` ORDER BY last_seen DESC, ip`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := make([]models.Device, 0)
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, rows.Err()
+}
+
+// GetDevice returns a single device, or nil if it doesn't exist
+func (db *DB) GetDevice(id int) (*models.Device, error) {
+	row := db.conn.QueryRow(`SELECT `+deviceColumns+` FROM devices WHERE id = ?`, id)
+	device, err := scanDevice(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// GetDeviceAddresses returns the IP addresses a device has used, most recent first
+func (db *DB) GetDeviceAddresses(id int) ([]models.DeviceAddress, error) {
+	rows, err := db.conn.Query(`
+	SELECT ip, first_seen, last_seen FROM device_addresses WHERE device_id = ? ORDER BY last_seen DESC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := make([]models.DeviceAddress, 0)
+	for rows.Next() {
+		var address models.DeviceAddress
+		if err := rows.Scan(&address.IP, &address.FirstSeen, &address.LastSeen); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+
+	return addresses, rows.Err()
+}
+
+// RecordDeviceSighting stores a device seen in the neighbor table, creating it
+// on first sight and otherwise updating its address and last-seen time.
+// Empty hostname and vendor values don't overwrite previously known ones.
+func (db *DB) RecordDeviceSighting(device *models.Device) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO devices (mac, ip, hostname, vendor, interface, first_seen, last_seen)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(mac) DO UPDATE SET
+		ip = excluded.ip,
+		hostname = COALESCE(NULLIF(excluded.hostname, ''), devices.hostname),
+		vendor = COALESCE(NULLIF(excluded.vendor, ''), devices.vendor),
+		interface = excluded.interface,
+		last_seen = excluded.last_seen
+	`, device.MAC, device.IP, device.Hostname, device.Vendor, device.Interface, device.LastSeen, device.LastSeen)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO device_addresses (device_id, ip, first_seen, last_seen)
+	SELECT id, ?, ?, ? FROM devices WHERE mac = ?
+	ON CONFLICT(device_id, ip) DO UPDATE SET last_seen = excluded.last_seen
+	`, device.IP, device.LastSeen, device.LastSeen, device.MAC)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateDeviceName sets a device's friendly name
+func (db *DB) UpdateDeviceName(id int, name string) error {
+	_, err := db.conn.Exec(`UPDATE devices SET name = ? WHERE id = ?`, name, id)
+	return err
+}
+
+// DeleteDevice removes a device and its address history
+func (db *DB) DeleteDevice(id int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM device_addresses WHERE device_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM devices WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
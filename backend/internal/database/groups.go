@@ -0,0 +1,229 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+// GetGroups returns all groups with their members
+func (db *DB) GetGroups() ([]models.Group, error) {
+	rows, err := db.conn.Query(`SELECT id, name, created_at FROM groups ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := make([]models.Group, 0)
+	for rows.Next() {
+		var group models.Group
+		if err := rows.Scan(&group.ID, &group.Name, &group.CreatedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range groups {
+		if groups[i].Members, err = db.getGroupMembers(groups[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// GetGroup returns a single group with its members, or nil if it doesn't exist
+func (db *DB) GetGroup(id int) (*models.Group, error) {
+	var group models.Group
+	err := db.conn.QueryRow(`SELECT id, name, created_at FROM groups WHERE id = ?`, id).Scan(&group.ID, &group.Name, &group.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if group.Members, err = db.getGroupMembers(id); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (db *DB) getGroupMembers(id int) ([]string, error) {
+	rows, err := db.conn.Query(`
+	SELECT users.username FROM group_members
+	JOIN users ON users.id = group_members.user_id
+	WHERE group_members.group_id = ?
+	ORDER BY users.username
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		members = append(members, username)
+	}
+
+	return members, rows.Err()
+}
+
+// GetUserGroups returns the names of the groups a user belongs to
+func (db *DB) GetUserGroups(userID int) ([]string, error) {
+	rows, err := db.conn.Query(`
+	SELECT groups.name FROM group_members
+	JOIN groups ON groups.id = group_members.group_id
+	WHERE group_members.user_id = ?
+	ORDER BY groups.name
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// InsertGroup stores a new group and sets its ID
+func (db *DB) InsertGroup(group *models.Group) error {
+	group.CreatedAt = time.Now()
+	result, err := db.conn.Exec(`INSERT INTO groups (name, created_at) VALUES (?, ?)`, group.Name, group.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	group.ID = int(id)
+	return nil
+}
+
+// SetGroupMembers replaces the members of a group
+func (db *DB) SetGroupMembers(id int, userIDs []int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM group_members WHERE group_id = ?`, id); err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO group_members (group_id, user_id) VALUES (?, ?)`, id, userID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteGroup removes a group with its memberships and grants
+func (db *DB) DeleteGroup(id int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM group_members WHERE group_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM target_grants WHERE group_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM groups WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const grantColumns = `target_grants.id, COALESCE(target_grants.user_id, 0), COALESCE(target_grants.group_id, 0),
+	COALESCE(users.username, groups.name, ''), target_grants.target, target_grants.created_at`
+
+const grantFrom = ` FROM target_grants
+	LEFT JOIN users ON users.id = target_grants.user_id
+	LEFT JOIN groups ON groups.id = target_grants.group_id`
+
+func (db *DB) queryGrants(where string, args ...interface{}) ([]models.TargetGrant, error) {
+	rows, err := db.conn.Query(`SELECT `+grantColumns+grantFrom+` `+where+` ORDER BY target_grants.id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := make([]models.TargetGrant, 0)
+	for rows.Next() {
+		var grant models.TargetGrant
+		if err := rows.Scan(&grant.ID, &grant.UserID, &grant.GroupID, &grant.Subject, &grant.Target, &grant.CreatedAt); err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+// GetTargetGrants returns all target grants
+func (db *DB) GetTargetGrants() ([]models.TargetGrant, error) {
+	return db.queryGrants("")
+}
+
+// GetTargetGrant returns a single target grant, or nil if it doesn't exist
+func (db *DB) GetTargetGrant(id int) (*models.TargetGrant, error) {
+	grants, err := db.queryGrants(`WHERE target_grants.id = ?`, id)
+	if err != nil || len(grants) == 0 {
+		return nil, err
+	}
+	return &grants[0], nil
+}
+
+// GetUserTargetGrants returns the grants that apply to a user, given
+// directly or through a group
+func (db *DB) GetUserTargetGrants(userID int) ([]models.TargetGrant, error) {
+	return db.queryGrants(`WHERE target_grants.user_id = ?
+	OR target_grants.group_id IN (SELECT group_id FROM group_members WHERE user_id = ?)`, userID, userID)
+}
+
+// InsertTargetGrant stores a new target grant and sets its ID
+func (db *DB) InsertTargetGrant(grant *models.TargetGrant) error {
+	grant.CreatedAt = time.Now()
+	result, err := db.conn.Exec(`INSERT INTO target_grants (user_id, group_id, target, created_at) VALUES (?, ?, ?, ?)`,
+		nullInt(grant.UserID), nullInt(grant.GroupID), grant.Target, grant.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	grant.ID = int(id)
+	return nil
+}
+
+// DeleteTargetGrant removes a target grant
+func (db *DB) DeleteTargetGrant(id int) error {
+	_, err := db.conn.Exec(`DELETE FROM target_grants WHERE id = ?`, id)
+	return err
+}
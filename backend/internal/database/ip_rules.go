@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+const ipRuleColumns = `ip_rules.id, ip_rules.cidr, ip_rules.action, COALESCE(ip_rules.target, ''),
+	COALESCE(ip_rules.reason, ''), COALESCE(ip_rules.automatic, 0), COALESCE(ip_rules.created_by, 0),
+	COALESCE(users.username, ''), ip_rules.created_at, ip_rules.expires_at`
+
+const ipRuleFrom = ` FROM ip_rules LEFT JOIN users ON users.id = ip_rules.created_by`
+
+func scanIPRule(row rowScanner) (models.IPRule, error) {
+	var rule models.IPRule
+	var expiresAt sql.NullTime
+	err := row.Scan(
+		&rule.ID,
+		&rule.CIDR,
+		&rule.Action,
+		&rule.Target,
+		&rule.Reason,
+		&rule.Automatic,
+		&rule.CreatedBy,
+		&rule.CreatorName,
+		&rule.CreatedAt,
+		&expiresAt,
+	)
+	if expiresAt.Valid {
+		rule.ExpiresAt = &expiresAt.Time
+	}
+	return rule, err
+}
+
+// GetIPRules returns the client IP rules, oldest first. Unless all is set
+// expired rules are left out.
+func (db *DB) GetIPRules(all bool) ([]models.IPRule, error) {
+	query := `SELECT ` + ipRuleColumns + ipRuleFrom
+	args := make([]interface{}, 0)
+	if !all {
+		query += ` WHERE ip_rules.expires_at IS NULL OR ip_rules.expires_at > ?`
+		args = append(args, time.Now())
+	}
+	query += ` ORDER BY ip_rules.id`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]models.IPRule, 0)
+	for rows.Next() {
+		rule, err := scanIPRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// GetIPRule returns a client IP rule by ID, or nil
+func (db *DB) GetIPRule(id int) (*models.IPRule, error) {
+	rule, err := scanIPRule(db.conn.QueryRow(`SELECT `+ipRuleColumns+ipRuleFrom+` WHERE ip_rules.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// InsertIPRule stores a client IP rule and sets its ID
+func (db *DB) InsertIPRule(rule *models.IPRule) error {
+	rule.CreatedAt = time.Now()
+	var expiresAt interface{}
+	if rule.ExpiresAt != nil {
+		local := localTime(*rule.ExpiresAt)
+		rule.ExpiresAt = &local
+		expiresAt = local
+	}
+
+	result, err := db.conn.Exec(`
+	INSERT INTO ip_rules (cidr, action, target, reason, automatic, created_by, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.CIDR, rule.Action, rule.Target, rule.Reason, rule.Automatic, nullInt(rule.CreatedBy), rule.CreatedAt, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	rule.ID = int(id)
+	return nil
+}
+
+// DeleteIPRule removes a client IP rule
+func (db *DB) DeleteIPRule(id int) error {
+	_, err := db.conn.Exec(`DELETE FROM ip_rules WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+const headerRuleColumns = `id, target, direction, action, name, COALESCE(value, ''), position, enabled, created_at`
+
+func scanHeaderRule(row rowScanner) (models.HeaderRule, error) {
+	var rule models.HeaderRule
+	err := row.Scan(
+		&rule.ID,
+		&rule.Target,
+		&rule.Direction,
+		&rule.Action,
+		&rule.Name,
+		&rule.Value,
+		&rule.Position,
+		&rule.Enabled,
+		&rule.CreatedAt,
+	)
+	return rule, err
+}
+
+// GetHeaderRules returns the header rules for a target in evaluation order.
+// An empty target returns the rules for every target.
+func (db *DB) GetHeaderRules(target string) ([]models.HeaderRule, error) {
+	query := `SELECT ` + headerRuleColumns + ` FROM header_rules`
+	args := make([]interface{}, 0)
+	if target != "" {
+		query += ` WHERE target = ?`
+		args = append(args, target)
+	}
+	query += ` ORDER BY target, position, id`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]models.HeaderRule, 0)
+	for rows.Next() {
+		rule, err := scanHeaderRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+// GetHeaderRule returns a single header rule, or nil if it doesn't exist
+func (db *DB) GetHeaderRule(id int) (*models.HeaderRule, error) {
+	row := db.conn.QueryRow(`SELECT `+headerRuleColumns+` FROM header_rules WHERE id = ?`, id)
+	rule, err := scanHeaderRule(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// InsertHeaderRule stores a new header rule and sets its ID
+func (db *DB) InsertHeaderRule(rule *models.HeaderRule) error {
+	query := `
+	INSERT INTO header_rules (target, direction, action, name, value, position, enabled, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	rule.CreatedAt = time.Now()
+	result, err := db.conn.Exec(query, rule.Target, rule.Direction, rule.Action, rule.Name, rule.Value, rule.Position, rule.Enabled, rule.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	rule.ID = int(id)
+	return nil
+}
+
+// UpdateHeaderRule replaces an existing header rule
+func (db *DB) UpdateHeaderRule(rule *models.HeaderRule) error {
+	query := `
+	UPDATE header_rules
+	SET target = ?, direction = ?, action = ?, name = ?, value = ?, position = ?, enabled = ?
+	WHERE id = ?
+	`
+
+	_, err := db.conn.Exec(query, rule.Target, rule.Direction, rule.Action, rule.Name, rule.Value, rule.Position, rule.Enabled, rule.ID)
+	return err
+}
+
+// DeleteHeaderRule removes a header rule
+func (db *DB) DeleteHeaderRule(id int) error {
+	_, err := db.conn.Exec("DELETE FROM header_rules WHERE id = ?", id)
+	return err
+}
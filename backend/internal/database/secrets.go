@@ -0,0 +1,194 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"lan-relay/internal/vault"
+)
+
+// encryptedPrefix marks a settings value sealed with the vault key. Values
+// without it are plaintext written before settings were encrypted.
+const encryptedPrefix = "enc:v1:"
+
+// settingsSecretColumns are the settings columns stored encrypted
+var settingsSecretColumns = []string{"ngrok_token", "oidc_client_secret", "cloudflared_token", "frp_token", "ssh_private_key"}
+
+// UseVault sets the key that secret settings are encrypted with. Without
+// one, encrypted settings can't be read and secrets can't be saved.
+func (db *DB) UseVault(v *vault.Vault) {
+	db.vault = v
+}
+
+// sealSetting encrypts a secret setting for storage
+func (db *DB) sealSetting(value string) (string, error) {
+	return sealSetting(db.vault, value)
+}
+
+// openSetting decrypts a stored secret setting, passing plaintext through
+func (db *DB) openSetting(value string) (string, error) {
+	return openSetting(db.vault, value)
+}
+
+func sealSetting(v *vault.Vault, value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	if v == nil {
+		return "", fmt.Errorf("secret settings can't be saved without the vault key")
+	}
+
+	sealed, err := v.Encrypt([]byte(value))
+	if err != nil {
+		return "", err
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func openSetting(v *vault.Vault, value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	if v == nil {
+		return "", fmt.Errorf("secret settings are encrypted and the vault key isn't available")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted setting: %v", err)
+	}
+	plaintext, err := v.Decrypt(sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// EncryptSettingsSecrets encrypts secret settings still stored in plaintext,
+// returning how many were encrypted
+func (db *DB) EncryptSettingsSecrets() (int, error) {
+	if db.vault == nil {
+		return 0, fmt.Errorf("no vault key")
+	}
+
+	encrypted := 0
+	for _, column := range settingsSecretColumns {
+		var value string
+		err := db.conn.QueryRow(`SELECT COALESCE(` + column + `, '') FROM settings WHERE id = 1`).Scan(&value)
+		if err != nil {
+			return encrypted, err
+		}
+		if value == "" || strings.HasPrefix(value, encryptedPrefix) {
+			continue
+		}
+
+		sealed, err := db.sealSetting(value)
+		if err != nil {
+			return encrypted, err
+		}
+		// Only replace the value that was read, in case the server changed it meanwhile
+		if _, err := db.conn.Exec(`UPDATE settings SET `+column+` = ? WHERE id = 1 AND `+column+` = ?`, sealed, value); err != nil {
+			return encrypted, err
+		}
+		encrypted++
+	}
+	return encrypted, nil
+}
+
+// RotationCounts reports how many secrets a key rotation re-encrypted
+type RotationCounts struct {
+	Settings    int
+	Credentials int
+	TOTPSecrets int
+}
+
+// RotateVaultKey re-encrypts everything sealed with the vault key (secret
+// settings, upstream credentials and TOTP secrets) from oldVault to
+// newVault in one transaction, and uses newVault from then on. Plaintext
+// settings are encrypted along the way.
+func (db *DB) RotateVaultKey(oldVault, newVault *vault.Vault) (RotationCounts, error) {
+	var counts RotationCounts
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return counts, err
+	}
+	defer tx.Rollback()
+
+	for _, column := range settingsSecretColumns {
+		var value string
+		if err := tx.QueryRow(`SELECT COALESCE(` + column + `, '') FROM settings WHERE id = 1`).Scan(&value); err != nil {
+			return counts, err
+		}
+		if value == "" {
+			continue
+		}
+		plaintext, err := openSetting(oldVault, value)
+		if err != nil {
+			return counts, fmt.Errorf("settings.%s: %v", column, err)
+		}
+		sealed, err := sealSetting(newVault, plaintext)
+		if err != nil {
+			return counts, err
+		}
+		if _, err := tx.Exec(`UPDATE settings SET `+column+` = ? WHERE id = 1`, sealed); err != nil {
+			return counts, err
+		}
+		counts.Settings++
+	}
+
+	counts.Credentials, err = rotateBlobs(tx, oldVault, newVault, `SELECT id, secret FROM credentials`, `UPDATE credentials SET secret = ? WHERE id = ?`)
+	if err != nil {
+		return counts, fmt.Errorf("credentials: %v", err)
+	}
+	counts.TOTPSecrets, err = rotateBlobs(tx, oldVault, newVault,
+		`SELECT id, totp_secret FROM users WHERE totp_secret IS NOT NULL`, `UPDATE users SET totp_secret = ? WHERE id = ?`)
+	if err != nil {
+		return counts, fmt.Errorf("TOTP secrets: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return counts, err
+	}
+	db.vault = newVault
+	return counts, nil
+}
+
+// rotateBlobs re-encrypts a column of vault-sealed blobs keyed by id
+func rotateBlobs(tx *sql.Tx, oldVault, newVault *vault.Vault, selectQuery, updateQuery string) (int, error) {
+	rows, err := tx.Query(selectQuery)
+	if err != nil {
+		return 0, err
+	}
+	sealed := make(map[int][]byte)
+	for rows.Next() {
+		var id int
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		sealed[id] = data
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for id, data := range sealed {
+		plaintext, err := oldVault.Decrypt(data)
+		if err != nil {
+			return 0, fmt.Errorf("id %d: %v", id, err)
+		}
+		resealed, err := newVault.Encrypt(plaintext)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(updateQuery, resealed, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(sealed), nil
+}
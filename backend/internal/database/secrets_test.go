@@ -0,0 +1,157 @@
+package database
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"lan-relay/internal/models"
+	"lan-relay/internal/vault"
+)
+
+func testVault(t *testing.T, fill byte) *vault.Vault {
+	t.Helper()
+	v, err := vault.New(bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// rawSetting reads a settings column as stored
+func rawSetting(t *testing.T, db *DB, column string) string {
+	t.Helper()
+	var value string
+	if err := db.conn.QueryRow(`SELECT COALESCE(` + column + `, '') FROM settings WHERE id = 1`).Scan(&value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestSecretSettingsAreSealed(t *testing.T) {
+	db := newTestDB(t)
+	settings, err := db.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.NgrokToken = "ngrok-secret"
+	if err := db.UpdateSettings(settings); err == nil {
+		t.Fatal("UpdateSettings() saved a secret without the vault key")
+	}
+
+	db.UseVault(testVault(t, 1))
+	if err := db.UpdateSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	if raw := rawSetting(t, db, "ngrok_token"); !strings.HasPrefix(raw, encryptedPrefix) || strings.Contains(raw, "ngrok-secret") {
+		t.Errorf("ngrok_token is stored as %q", raw)
+	}
+	if got, err := db.GetSettings(); err != nil || got.NgrokToken != "ngrok-secret" {
+		t.Fatalf("GetSettings() token = %v, %v", got, err)
+	}
+
+	db.UseVault(nil)
+	if _, err := db.GetSettings(); err == nil {
+		t.Error("GetSettings() read an encrypted secret without the vault key")
+	}
+}
+
+func TestRotateVaultKey(t *testing.T) {
+	db := newTestDB(t)
+	oldVault := testVault(t, 1)
+	newVault := testVault(t, 2)
+	db.UseVault(oldVault)
+
+	settings, err := db.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.NgrokToken = "ngrok-secret"
+	settings.OIDC.ClientSecret = "oidc-secret"
+	if err := db.UpdateSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	// A secret written before settings were encrypted
+	if _, err := db.conn.Exec(`UPDATE settings SET frp_token = 'frp-secret' WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := oldVault.Encrypt([]byte("upstream-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential := &models.Credential{Name: "nas", Type: "bearer", EncryptedSecret: sealed}
+	if err := db.InsertCredential(credential); err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: "alice", PasswordHash: "x", Role: "viewer"}
+	if err := db.InsertUser(user); err != nil {
+		t.Fatal(err)
+	}
+	totpSecret, err := oldVault.Encrypt([]byte("TOTPSECRET"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTOTPSecret(user.ID, totpSecret); err != nil {
+		t.Fatal(err)
+	}
+
+	// A wrong old key fails without changing anything
+	if _, err := db.RotateVaultKey(testVault(t, 3), newVault); err == nil {
+		t.Fatal("RotateVaultKey() with the wrong old key succeeded")
+	}
+	if got, err := db.GetSettings(); err != nil || got.NgrokToken != "ngrok-secret" {
+		t.Fatalf("GetSettings() after a failed rotation = %v, %v", got, err)
+	}
+
+	counts, err := db.RotateVaultKey(oldVault, newVault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RotationCounts{Settings: 3, Credentials: 1, TOTPSecrets: 1}); counts != want {
+		t.Errorf("RotateVaultKey() = %+v, want %+v", counts, want)
+	}
+
+	got, err := db.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.NgrokToken != "ngrok-secret" || got.OIDC.ClientSecret != "oidc-secret" || got.Tunnel.FRPToken != "frp-secret" {
+		t.Errorf("GetSettings() after rotation = %+v", got)
+	}
+	if raw := rawSetting(t, db, "frp_token"); !strings.HasPrefix(raw, encryptedPrefix) {
+		t.Errorf("the plaintext frp_token is still stored as %q", raw)
+	}
+
+	tests := []struct {
+		name string
+		data func() []byte
+		want string
+	}{
+		{"credential", func() []byte {
+			stored, err := db.GetCredential(credential.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return stored.EncryptedSecret
+		}, "upstream-secret"},
+		{"TOTP secret", func() []byte {
+			stored, err := db.GetUser(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return stored.TOTPSecret
+		}, "TOTPSECRET"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data()
+			if plaintext, err := newVault.Decrypt(data); err != nil || string(plaintext) != tt.want {
+				t.Errorf("new key opens %q, %v, want %q", plaintext, err, tt.want)
+			}
+			if _, err := oldVault.Decrypt(data); err == nil {
+				t.Error("the old key still opens the secret")
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+const shareLinkColumns = `share_links.id, share_links.name, share_links.target, COALESCE(share_links.path_prefix, ''),
+	COALESCE(share_links.methods, ''), COALESCE(share_links.max_uses, 0), COALESCE(share_links.uses, 0),
+	COALESCE(share_links.password_hash, ''), COALESCE(share_links.created_by, 0), COALESCE(users.username, ''),
+	share_links.created_at, share_links.expires_at, share_links.revoked_at, share_links.last_used_at,
+	COALESCE(share_links.last_used_ip, '')`
+
+const shareLinkFrom = ` FROM share_links LEFT JOIN users ON users.id = share_links.created_by`
+
+func scanShareLink(row rowScanner) (models.ShareLink, error) {
+	var link models.ShareLink
+	var methods string
+	var revokedAt, lastUsedAt sql.NullTime
+	err := row.Scan(
+		&link.ID,
+		&link.Name,
+		&link.Target,
+		&link.PathPrefix,
+		&methods,
+		&link.MaxUses,
+		&link.Uses,
+		&link.PasswordHash,
+		&link.CreatedBy,
+		&link.CreatorName,
+		&link.CreatedAt,
+		&link.ExpiresAt,
+		&revokedAt,
+		&lastUsedAt,
+		&link.LastUsedIP,
+	)
+	link.Methods = splitList(methods)
+	link.HasPassword = link.PasswordHash != ""
+	if revokedAt.Valid {
+		link.RevokedAt = &revokedAt.Time
+	}
+	if lastUsedAt.Valid {
+		link.LastUsedAt = &lastUsedAt.Time
+	}
+	return link, err
+}
+
+// GetShareLinks returns share links, newest first. Unless all is set only
+// active links are returned: not revoked, expired or used up.
+func (db *DB) GetShareLinks(all bool) ([]models.ShareLink, error) {
+	query := `SELECT ` + shareLinkColumns + shareLinkFrom
+	args := make([]interface{}, 0)
+	if !all {
+		query += ` WHERE share_links.revoked_at IS NULL AND share_links.expires_at > ?
+		AND (share_links.max_uses = 0 OR share_links.uses < share_links.max_uses)`
+		args = append(args, time.Now())
+	}
+	query += ` ORDER BY share_links.id DESC`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]models.ShareLink, 0)
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+// GetShareLink returns a share link by ID, or nil
+func (db *DB) GetShareLink(id int) (*models.ShareLink, error) {
+	link, err := scanShareLink(db.conn.QueryRow(`SELECT `+shareLinkColumns+shareLinkFrom+` WHERE share_links.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// InsertShareLink stores a new share link and sets its ID
+func (db *DB) InsertShareLink(link *models.ShareLink) error {
+	link.CreatedAt = time.Now()
+	link.ExpiresAt = localTime(link.ExpiresAt)
+	result, err := db.conn.Exec(`
+	INSERT INTO share_links (name, target, path_prefix, methods, max_uses, password_hash, created_by, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, link.Name, link.Target, link.PathPrefix, strings.Join(link.Methods, ","), link.MaxUses, link.PasswordHash,
+		nullInt(link.CreatedBy), link.CreatedAt, link.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	link.ID = int(id)
+	link.HasPassword = link.PasswordHash != ""
+	return nil
+}
+
+// UseShareLink counts a use of a share link and stores the visit it
+// starts, under the hash of the visit's token. It returns false, counting
+// nothing, if the link was revoked or has no uses left, so concurrent
+// visitors can't exceed the limit.
+func (db *DB) UseShareLink(id int, ip, visitHash string, expiresAt time.Time) (bool, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`
+	UPDATE share_links SET uses = uses + 1, last_used_at = ?, last_used_ip = ?
+	WHERE id = ? AND revoked_at IS NULL AND (max_uses = 0 OR uses < max_uses)
+	`, now, ip, id)
+	if err != nil {
+		return false, err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows != 1 {
+		return false, err
+	}
+
+	if _, err := tx.Exec(`
+	INSERT INTO share_visits (token_hash, link_id, ip, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?)
+	`, visitHash, id, ip, now, localTime(expiresAt)); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ShareVisitValid reports whether a visit token hash belongs to an unexpired
+// visit of a share link
+func (db *DB) ShareVisitValid(id int, visitHash string) (bool, error) {
+	var count int
+	err := db.conn.QueryRow(`
+	SELECT COUNT(*) FROM share_visits WHERE token_hash = ? AND link_id = ? AND expires_at > ?
+	`, visitHash, id, time.Now()).Scan(&count)
+	return count > 0, err
+}
+
+// DeleteExpiredShareVisits removes visits past their expiry
+func (db *DB) DeleteExpiredShareVisits() error {
+	_, err := db.conn.Exec(`DELETE FROM share_visits WHERE expires_at <= ?`, time.Now())
+	return err
+}
+
+// RevokeShareLink stops a share link from working
+func (db *DB) RevokeShareLink(id int) error {
+	_, err := db.conn.Exec(`UPDATE share_links SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now(), id)
+	return err
+}
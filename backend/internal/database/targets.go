@@ -0,0 +1,97 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+const targetColumns = `id, name, host, port, COALESCE(source, 'manual'), created_at`
+
+func scanTarget(row rowScanner) (models.Target, error) {
+	var target models.Target
+	err := row.Scan(
+		&target.ID,
+		&target.Name,
+		&target.Host,
+		&target.Port,
+		&target.Source,
+		&target.CreatedAt,
+	)
+	return target, err
+}
+
+// GetTargets returns all registered targets
+func (db *DB) GetTargets() ([]models.Target, error) {
+	rows, err := db.conn.Query(`SELECT ` + targetColumns + ` FROM targets ORDER BY name, host, port`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := make([]models.Target, 0)
+	for rows.Next() {
+		target, err := scanTarget(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, rows.Err()
+}
+
+// GetTarget returns a single target, or nil if it doesn't exist
+func (db *DB) GetTarget(id int) (*models.Target, error) {
+	row := db.conn.QueryRow(`SELECT `+targetColumns+` FROM targets WHERE id = ?`, id)
+	return scanOptionalTarget(row)
+}
+
+// GetTargetByAddress returns the target registered for host and port, or nil
+func (db *DB) GetTargetByAddress(host string, port int) (*models.Target, error) {
+	row := db.conn.QueryRow(`SELECT `+targetColumns+` FROM targets WHERE host = ? AND port = ?`, host, port)
+	return scanOptionalTarget(row)
+}
+
+func scanOptionalTarget(row *sql.Row) (*models.Target, error) {
+	target, err := scanTarget(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+// InsertTarget stores a new target and sets its ID
+func (db *DB) InsertTarget(target *models.Target) error {
+	target.CreatedAt = time.Now()
+	result, err := db.conn.Exec(`
+	INSERT INTO targets (name, host, port, source, created_at) VALUES (?, ?, ?, ?, ?)
+	`, target.Name, target.Host, target.Port, target.Source, target.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	target.ID = int(id)
+	return nil
+}
+
+// UpdateTarget updates a target's name and address
+func (db *DB) UpdateTarget(target *models.Target) error {
+	_, err := db.conn.Exec(`UPDATE targets SET name = ?, host = ?, port = ? WHERE id = ?`,
+		target.Name, target.Host, target.Port, target.ID)
+	return err
+}
+
+// DeleteTarget removes a target
+func (db *DB) DeleteTarget(id int) error {
+	_, err := db.conn.Exec(`DELETE FROM targets WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+const apiTokenColumns = `api_tokens.id, api_tokens.name, api_tokens.prefix, api_tokens.token_hash, api_tokens.user_id,
+	COALESCE(users.username, ''), api_tokens.scopes, COALESCE(api_tokens.targets, ''), api_tokens.created_at,
+	api_tokens.expires_at, api_tokens.last_used_at, COALESCE(api_tokens.last_used_ip, '')`
+
+const apiTokenFrom = ` FROM api_tokens LEFT JOIN users ON users.id = api_tokens.user_id`
+
+func scanAPIToken(row rowScanner) (models.APIToken, error) {
+	var token models.APIToken
+	var scopes, targets string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(
+		&token.ID,
+		&token.Name,
+		&token.Prefix,
+		&token.TokenHash,
+		&token.UserID,
+		&token.Username,
+		&scopes,
+		&targets,
+		&token.CreatedAt,
+		&expiresAt,
+		&lastUsedAt,
+		&token.LastUsedIP,
+	)
+	token.Scopes = splitList(scopes)
+	token.Targets = splitList(targets)
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return token, err
+}
+
+func scanOptionalAPIToken(row *sql.Row) (*models.APIToken, error) {
+	token, err := scanAPIToken(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// splitList reads a comma-separated column
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// GetAPITokens returns all API tokens, or only a user's when userID isn't 0
+func (db *DB) GetAPITokens(userID int) ([]models.APIToken, error) {
+	query := `SELECT ` + apiTokenColumns + apiTokenFrom
+	args := make([]interface{}, 0)
+	if userID != 0 {
+		query += ` WHERE api_tokens.user_id = ?`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY api_tokens.created_at DESC`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]models.APIToken, 0)
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// GetAPIToken returns a single API token, or nil if it doesn't exist
+func (db *DB) GetAPIToken(id int) (*models.APIToken, error) {
+	return scanOptionalAPIToken(db.conn.QueryRow(`SELECT `+apiTokenColumns+apiTokenFrom+` WHERE api_tokens.id = ?`, id))
+}
+
+// GetAPITokenByHash returns the token stored under a hash, or nil. Expired
+// tokens are returned too, callers check the expiry.
+func (db *DB) GetAPITokenByHash(tokenHash string) (*models.APIToken, error) {
+	return scanOptionalAPIToken(db.conn.QueryRow(`SELECT `+apiTokenColumns+apiTokenFrom+` WHERE api_tokens.token_hash = ?`, tokenHash))
+}
+
+// InsertAPIToken stores a new API token and sets its ID
+func (db *DB) InsertAPIToken(token *models.APIToken) error {
+	token.CreatedAt = time.Now()
+	result, err := db.conn.Exec(`
+	INSERT INTO api_tokens (name, prefix, token_hash, user_id, scopes, targets, created_at, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, token.Name, token.Prefix, token.TokenHash, token.UserID, strings.Join(token.Scopes, ","),
+		strings.Join(token.Targets, ","), token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	token.ID = int(id)
+	return nil
+}
+
+// TouchAPIToken records that a token was just used, and from where
+func (db *DB) TouchAPIToken(id int, ip string) error {
+	_, err := db.conn.Exec(`UPDATE api_tokens SET last_used_at = ?, last_used_ip = ? WHERE id = ?`, time.Now(), ip, id)
+	return err
+}
+
+// DeleteAPIToken revokes an API token
+func (db *DB) DeleteAPIToken(id int) error {
+	_, err := db.conn.Exec(`DELETE FROM api_tokens WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SetTOTPSecret stores a new, not yet enabled, TOTP secret for a user
+func (db *DB) SetTOTPSecret(userID int, encryptedSecret []byte) error {
+	_, err := db.conn.Exec(`UPDATE users SET totp_secret = ?, totp_enabled = 0, totp_last_step = 0 WHERE id = ?`,
+		encryptedSecret, userID)
+	return err
+}
+
+// EnableTOTP turns on two-factor sign-in with the stored secret and replaces
+// the user's recovery codes
+func (db *DB) EnableTOTP(userID int, lastStep int64, recoveryCodeHashes []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ?`, lastStep, userID); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP removes a user's TOTP secret and recovery codes
+func (db *DB) DisableTOTP(userID int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records the time step of an accepted code. It fails to
+// update, returning false, if the step isn't newer than the last one, so
+// two requests racing with the same code can't both succeed.
+func (db *DB) UseTOTPStep(userID int, step int64) (bool, error) {
+	result, err := db.conn.Exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// ReplaceRecoveryCodes replaces a user's recovery codes
+func (db *DB) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(`INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used, returning false if
+// the user has no such code
+func (db *DB) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	result, err := db.conn.Exec(`UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (db *DB) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
+
+// GetLoginLock returns when sign-in stops being blocked for a key (an
+// account or source IP), or the zero time if it isn't blocked
+func (db *DB) GetLoginLock(key string) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := db.conn.QueryRow(`SELECT locked_until FROM login_failures WHERE key = ?`, key).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !lockedUntil.Valid || !lockedUntil.Time.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return lockedUntil.Time, nil
+}
+
+// RecordLoginFailure counts a failed sign-in for a key and returns the
+// number of consecutive failures. Failures older than window are forgotten.
+func (db *DB) RecordLoginFailure(key string, window time.Duration) (int, error) {
+	now := time.Now()
+	_, err := db.conn.Exec(`
+	INSERT INTO login_failures (key, failures, last_failure) VALUES (?, 1, ?)
+	ON CONFLICT(key) DO UPDATE SET
+		failures = CASE WHEN last_failure < ? THEN 1 ELSE failures + 1 END,
+		last_failure = excluded.last_failure
+	`, key, now, now.Add(-window))
+	if err != nil {
+		return 0, err
+	}
+
+	var failures int
+	err = db.conn.QueryRow(`SELECT failures FROM login_failures WHERE key = ?`, key).Scan(&failures)
+	return failures, err
+}
+
+// LockLogin blocks sign-in for a key until a time
+func (db *DB) LockLogin(key string, until time.Time) error {
+	_, err := db.conn.Exec(`UPDATE login_failures SET locked_until = ? WHERE key = ?`, until, key)
+	return err
+}
+
+// ClearLoginFailures forgets the failures and lockout of a key
+func (db *DB) ClearLoginFailures(key string) error {
+	_, err := db.conn.Exec(`DELETE FROM login_failures WHERE key = ?`, key)
+	return err
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+const userColumns = `id, username, password_hash, role, COALESCE(oidc_subject, ''), totp_secret, COALESCE(totp_enabled, 0),
+	COALESCE(totp_last_step, 0), created_at, last_login_at`
+
+func scanUser(row rowScanner) (models.User, error) {
+	var user models.User
+	var lastLoginAt sql.NullTime
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.PasswordHash,
+		&user.Role,
+		&user.OIDCSubject,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.TOTPLastStep,
+		&user.CreatedAt,
+		&lastLoginAt,
+	)
+	if lastLoginAt.Valid {
+		user.LastLoginAt = &lastLoginAt.Time
+	}
+	return user, err
+}
+
+func scanOptionalUser(row *sql.Row) (*models.User, error) {
+	user, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUsers returns all user accounts
+func (db *DB) GetUsers() ([]models.User, error) {
+	rows, err := db.conn.Query(`SELECT ` + userColumns + ` FROM users ORDER BY username`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]models.User, 0)
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// GetUser returns a single user, or nil if it doesn't exist
+func (db *DB) GetUser(id int) (*models.User, error) {
+	return scanOptionalUser(db.conn.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// GetUserByUsername returns a user by (case-insensitive) username, or nil
+func (db *DB) GetUserByUsername(username string) (*models.User, error) {
+	return scanOptionalUser(db.conn.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, username))
+}
+
+// GetUserByOIDCSubject returns the user signed in through SSO with an
+// issuer and subject, or nil
+func (db *DB) GetUserByOIDCSubject(subject string) (*models.User, error) {
+	return scanOptionalUser(db.conn.QueryRow(`SELECT `+userColumns+` FROM users WHERE oidc_subject = ?`, subject))
+}
+
+// CountUsers returns the number of user accounts
+func (db *DB) CountUsers() (int, error) {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count)
+	return count, err
+}
+
+// InsertUser stores a new user and sets its ID
+func (db *DB) InsertUser(user *models.User) error {
+	user.CreatedAt = time.Now()
+	result, err := db.conn.Exec(`INSERT INTO users (username, password_hash, role, oidc_subject, created_at) VALUES (?, ?, ?, ?, ?)`,
+		user.Username, user.PasswordHash, user.Role, sql.NullString{String: user.OIDCSubject, Valid: user.OIDCSubject != ""}, user.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	user.ID = int(id)
+	return nil
+}
+
+// UpdateUserPassword replaces a user's password hash and signs out all of
+// the user's sessions
+func (db *DB) UpdateUserPassword(id int, passwordHash string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateUserRole changes a user's role
+func (db *DB) UpdateUserRole(id int, role string) error {
+	_, err := db.conn.Exec(`UPDATE users SET role = ? WHERE id = ?`, role, id)
+	return err
+}
+
+// CountUsersWithRole returns the number of users with a role
+func (db *DB) CountUsersWithRole(role string) (int, error) {
+	var count int
+	err := db.conn.QueryRow(`SELECT COUNT(*) FROM users WHERE role = ?`, role).Scan(&count)
+	return count, err
+}
+
+// TouchUserLogin records a successful sign-in
+func (db *DB) TouchUserLogin(id int) error {
+	_, err := db.conn.Exec(`UPDATE users SET last_login_at = ? WHERE id = ?`, time.Now(), id)
+	return err
+}
+
+// DeleteUser removes a user with its sessions, API tokens, group memberships and grants
+func (db *DB) DeleteUser(id int) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM api_tokens WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	// Client certificates stay on record so the CRL can still list them
+	if _, err := tx.Exec(`UPDATE client_certs SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`, time.Now(), id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM group_members WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM target_grants WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertSession stores a new session
+func (db *DB) InsertSession(session *models.Session) error {
+	_, err := db.conn.Exec(`
+	INSERT INTO sessions (token_hash, user_id, created_at, expires_at, last_seen_at, ip, user_agent)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, session.TokenHash, session.UserID, session.CreatedAt, session.ExpiresAt, session.LastSeenAt, session.IP, session.UserAgent)
+	return err
+}
+
+// GetSession returns an unexpired session with its user, or nil if there is none
+func (db *DB) GetSession(tokenHash string) (*models.Session, *models.User, error) {
+	var session models.Session
+	err := db.conn.QueryRow(`
+	SELECT token_hash, user_id, created_at, expires_at, last_seen_at, COALESCE(ip, ''), COALESCE(user_agent, '')
+	FROM sessions WHERE token_hash = ? AND expires_at > ?
+	`, tokenHash, time.Now()).Scan(
+		&session.TokenHash,
+		&session.UserID,
+		&session.CreatedAt,
+		&session.ExpiresAt,
+		&session.LastSeenAt,
+		&session.IP,
+		&session.UserAgent,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := db.GetUser(session.UserID)
+	if err != nil || user == nil {
+		return nil, nil, err
+	}
+	return &session, user, nil
+}
+
+// TouchSession records that a session was just used
+func (db *DB) TouchSession(tokenHash string) error {
+	_, err := db.conn.Exec(`UPDATE sessions SET last_seen_at = ? WHERE token_hash = ?`, time.Now(), tokenHash)
+	return err
+}
+
+// DeleteSession signs out a single session
+func (db *DB) DeleteSession(tokenHash string) error {
+	_, err := db.conn.Exec(`DELETE FROM sessions WHERE token_hash = ?`, tokenHash)
+	return err
+}
+
+// DeleteExpiredSessions removes sessions past their expiry
+func (db *DB) DeleteExpiredSessions() error {
+	_, err := db.conn.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, time.Now())
+	return err
+}
@@ -0,0 +1,254 @@
+// Package discovery finds web services on the local network through mDNS/DNS-SD,
+// SSDP/UPnP and optional TCP probing of private subnets.
+package discovery
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+const (
+	SourceMDNS = "mdns"
+	SourceSSDP = "ssdp"
+	SourceScan = "scan"
+)
+
+// cacheTTL is how long a discovery run is reused before the network is queried again
+const cacheTTL = time.Minute
+
+// Options selects the discovery methods and their limits
+type Options struct {
+	Timeout time.Duration // how long to wait for mDNS and SSDP replies
+	MDNS    bool
+	SSDP    bool
+	Subnets []string // CIDRs to probe, empty to disable scanning
+	Ports   []int    // TCP ports probed on each subnet address
+}
+
+// Discoverer runs discovery and caches the latest result
+type Discoverer struct {
+	opts Options
+
+	mu   sync.Mutex
+	last *models.DiscoveryResult
+}
+
+// New creates a discoverer with the given options
+func New(opts Options) *Discoverer {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 3 * time.Second
+	}
+	return &Discoverer{opts: opts}
+}
+
+// Discover returns the cached result, running discovery first if the cache is
+// empty, stale or refresh is set. Concurrent callers share a single run.
+func (d *Discoverer) Discover(ctx context.Context, refresh bool) models.DiscoveryResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.last == nil || refresh || time.Since(d.last.ScannedAt) > cacheTTL {
+		result := d.run(ctx)
+		d.last = &result
+	}
+	return *d.last
+}
+
+// Lookup finds a service from the latest run by its ID
+func (d *Discoverer) Lookup(id string) (models.DiscoveredService, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.last != nil {
+		for _, service := range d.last.Services {
+			if service.ID == id {
+				return service, true
+			}
+		}
+	}
+	return models.DiscoveredService{}, false
+}
+
+func (d *Discoverer) run(ctx context.Context) models.DiscoveryResult {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		services []models.DiscoveredService
+		warnings = make([]string, 0)
+	)
+
+	collect := func(source string, find func(context.Context) ([]models.DiscoveredService, error)) {
+		defer wg.Done()
+		found, err := find(ctx)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: %v", source, err))
+		}
+		services = append(services, found...)
+	}
+
+	if d.opts.MDNS {
+		wg.Add(1)
+		go collect(SourceMDNS, func(ctx context.Context) ([]models.DiscoveredService, error) {
+			return browseMDNS(ctx, d.opts.Timeout)
+		})
+	}
+	if d.opts.SSDP {
+		wg.Add(1)
+		go collect(SourceSSDP, func(ctx context.Context) ([]models.DiscoveredService, error) {
+			return searchSSDP(ctx, d.opts.Timeout)
+		})
+	}
+	if len(d.opts.Subnets) > 0 {
+		wg.Add(1)
+		go collect(SourceScan, func(ctx context.Context) ([]models.DiscoveredService, error) {
+			return scanSubnets(ctx, d.opts.Subnets, d.opts.Ports)
+		})
+	}
+	wg.Wait()
+
+	services = dedupe(services)
+	guessTitles(ctx, services)
+
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Host != services[j].Host {
+			return compareIPs(services[i].Host, services[j].Host) < 0
+		}
+		if services[i].Port != services[j].Port {
+			return services[i].Port < services[j].Port
+		}
+		return services[i].Source < services[j].Source
+	})
+
+	return models.DiscoveryResult{
+		Services:  services,
+		Warnings:  warnings,
+		ScannedAt: time.Now(),
+	}
+}
+
+// newService fills in the derived fields of a discovered service
+func newService(source, name, serviceType, host string, port int) models.DiscoveredService {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	sum := sha1.Sum([]byte(source + "|" + serviceType + "|" + address))
+	return models.DiscoveredService{
+		ID:        hex.EncodeToString(sum[:6]),
+		Source:    source,
+		Name:      name,
+		Type:      serviceType,
+		Host:      host,
+		Port:      port,
+		ProxyPath: "/proxy/" + address + "/",
+	}
+}
+
+// dedupe drops repeated answers for the same service
+func dedupe(services []models.DiscoveredService) []models.DiscoveredService {
+	seen := make(map[string]bool)
+	unique := make([]models.DiscoveredService, 0, len(services))
+	for _, service := range services {
+		if !seen[service.ID] {
+			seen[service.ID] = true
+			unique = append(unique, service)
+		}
+	}
+	return unique
+}
+
+func compareIPs(a, b string) int {
+	ipA, ipB := net.ParseIP(a).To16(), net.ParseIP(b).To16()
+	if ipA == nil || ipB == nil {
+		return strings.Compare(a, b)
+	}
+	for i := range ipA {
+		if ipA[i] != ipB[i] {
+			return int(ipA[i]) - int(ipB[i])
+		}
+	}
+	return 0
+}
+
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// titleClient fetches landing pages; LAN devices rarely have trusted certificates
+var titleClient = &http.Client{
+	Timeout: 2 * time.Second,
+	Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	},
+}
+
+// guessTitles fills in missing titles from each service's landing page
+func guessTitles(ctx context.Context, services []models.DiscoveredService) {
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, 16)
+
+	for i := range services {
+		if services[i].Title != "" {
+			continue
+		}
+		wg.Add(1)
+		go func(service *models.DiscoveredService) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			service.Title = pageTitle(ctx, service.Host, service.Port)
+		}(&services[i])
+	}
+	wg.Wait()
+}
+
+// pageTitle returns the <title> of the page served at host:port, falling back
+// to its Server header, or "" if it doesn't speak HTTP
+func pageTitle(ctx context.Context, host string, port int) string {
+	schemes := []string{"http", "https"}
+	if port == 443 || port == 8443 {
+		schemes = []string{"https", "http"}
+	}
+
+	for _, scheme := range schemes {
+		url := fmt.Sprintf("%s://%s/", scheme, net.JoinHostPort(host, strconv.Itoa(port)))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return ""
+		}
+
+		resp, err := titleClient.Do(req)
+		if err != nil {
+			continue
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		resp.Body.Close()
+
+		if match := titlePattern.FindSubmatch(body); match != nil {
+			if title := strings.Join(strings.Fields(html.UnescapeString(string(match[1]))), " "); title != "" {
+				return title
+			}
+		}
+		return resp.Header.Get("Server")
+	}
+	return ""
+}
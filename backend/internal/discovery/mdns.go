@@ -0,0 +1,231 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"lan-relay/internal/models"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const serviceEnumeration = "_services._dns-sd._udp.local."
+
+// mdnsServiceTypes are browsed directly, in addition to any types the
+// DNS-SD service enumeration reports
+var mdnsServiceTypes = []string{
+	"_http._tcp.local.",
+	"_https._tcp.local.",
+	"_home-assistant._tcp.local.",
+	"_hap._tcp.local.",
+	"_googlecast._tcp.local.",
+	"_ipp._tcp.local.",
+	"_octoprint._tcp.local.",
+	"_grpc._tcp.local.",
+}
+
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsInstance collects the records describing one service instance
+type mdnsInstance struct {
+	serviceType string
+	target      string
+	port        int
+	source      net.IP
+}
+
+// browseMDNS sends DNS-SD queries asking for unicast replies, so no multicast
+// group has to be joined, and collects the answers until the timeout
+func browseMDNS(ctx context.Context, timeout time.Duration) ([]models.DiscoveredService, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetReadDeadline(deadline)
+
+	queried := make(map[string]bool)
+	query := func(names ...string) error {
+		fresh := make([]string, 0, len(names))
+		for _, name := range names {
+			if !queried[name] {
+				queried[name] = true
+				fresh = append(fresh, name)
+			}
+		}
+		if len(fresh) == 0 {
+			return nil
+		}
+		packet, err := mdnsQuery(fresh)
+		if err != nil {
+			return err
+		}
+		_, err = conn.WriteToUDP(packet, mdnsAddr)
+		return err
+	}
+
+	if err := query(append([]string{serviceEnumeration}, mdnsServiceTypes...)...); err != nil {
+		return nil, err
+	}
+
+	instances := make(map[string]*mdnsInstance)
+	addresses := make(map[string]net.IP)
+	buf := make([]byte, 9000)
+
+	for ctx.Err() == nil {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			return nil, err
+		}
+
+		records, err := parseMDNSResponse(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		newTypes := make([]string, 0)
+		for _, record := range records {
+			newTypes = append(newTypes, readMDNSRecord(record, from.IP, instances, addresses)...)
+		}
+
+		if err := query(newTypes...); err != nil {
+			return nil, err
+		}
+	}
+
+	services := make([]models.DiscoveredService, 0, len(instances))
+	for name, instance := range instances {
+		if instance.port == 0 {
+			continue
+		}
+		ip := addresses[strings.ToLower(instance.target)]
+		if ip == nil {
+			ip = instance.source
+		}
+		label := strings.TrimSuffix(name, "."+instance.serviceType)
+		services = append(services, newService(SourceMDNS, unescapeLabel(label),
+			strings.TrimSuffix(instance.serviceType, ".local."), ip.String(), instance.port))
+	}
+	return services, nil
+}
+
+// parseMDNSResponse returns the answer and additional records of a response;
+// responders usually put the SRV and address records in the additional section
+func parseMDNSResponse(packet []byte) ([]dnsmessage.Resource, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(packet)
+	if err != nil {
+		return nil, err
+	}
+	if !header.Response {
+		return nil, nil
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+
+	answers, err := parser.AllAnswers()
+	if err != nil {
+		return nil, err
+	}
+	if err := parser.SkipAllAuthorities(); err != nil {
+		return answers, nil
+	}
+	additionals, err := parser.AllAdditionals()
+	if err != nil {
+		return answers, nil
+	}
+	return append(answers, additionals...), nil
+}
+
+// readMDNSRecord adds a record to the instances and addresses seen so far,
+// returning any service types announced by the DNS-SD enumeration
+func readMDNSRecord(record dnsmessage.Resource, from net.IP, instances map[string]*mdnsInstance, addresses map[string]net.IP) []string {
+	name := record.Header.Name.String()
+
+	switch body := record.Body.(type) {
+	case *dnsmessage.PTRResource:
+		target := body.PTR.String()
+		if strings.EqualFold(name, serviceEnumeration) {
+			return []string{target}
+		}
+		if _, ok := instances[target]; !ok {
+			instances[target] = &mdnsInstance{serviceType: name, source: from}
+		}
+	case *dnsmessage.SRVResource:
+		instance, ok := instances[name]
+		if !ok {
+			// SRV records can arrive without a PTR when answering a follow-up query
+			serviceType := name
+			if i := strings.Index(name, "._"); i >= 0 {
+				serviceType = name[i+1:]
+			}
+			instance = &mdnsInstance{serviceType: serviceType, source: from}
+			instances[name] = instance
+		}
+		instance.target = body.Target.String()
+		instance.port = int(body.Port)
+	case *dnsmessage.AResource:
+		addresses[strings.ToLower(name)] = net.IP(body.A[:])
+	}
+	return nil
+}
+
+// mdnsQuery builds a PTR query for the given names with the unicast-response bit set
+func mdnsQuery(names []string) ([]byte, error) {
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		dnsName, err := dnsmessage.NewName(name)
+		if err != nil {
+			return nil, err
+		}
+		err = builder.Question(dnsmessage.Question{
+			Name:  dnsName,
+			Type:  dnsmessage.TypePTR,
+			Class: dnsmessage.ClassINET | 1<<15,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// unescapeLabel decodes the \DDD and \x escapes used in DNS-SD instance names
+func unescapeLabel(label string) string {
+	var out strings.Builder
+	for i := 0; i < len(label); i++ {
+		if label[i] != '\\' || i+1 >= len(label) {
+			out.WriteByte(label[i])
+			continue
+		}
+		if i+3 < len(label) && isDigit(label[i+1]) && isDigit(label[i+2]) && isDigit(label[i+3]) {
+			out.WriteByte((label[i+1]-'0')*100 + (label[i+2]-'0')*10 + (label[i+3] - '0'))
+			i += 3
+			continue
+		}
+		out.WriteByte(label[i+1])
+		i++
+	}
+	return out.String()
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
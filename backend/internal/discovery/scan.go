@@ -0,0 +1,118 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+const (
+	// maxScanHosts caps the addresses probed per subnet (a /20)
+	maxScanHosts = 4096
+	scanWorkers  = 128
+	scanTimeout  = 500 * time.Millisecond
+)
+
+// scanSubnets probes every address in the given private subnets for open TCP ports
+func scanSubnets(ctx context.Context, subnets []string, ports []int) ([]models.DiscoveredService, error) {
+	hosts := make([]net.IP, 0)
+	problems := make([]string, 0)
+	for _, subnet := range subnets {
+		subnetHosts, err := subnetAddresses(subnet)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		hosts = append(hosts, subnetHosts...)
+	}
+
+	type probe struct {
+		host net.IP
+		port int
+	}
+	probes := make(chan probe)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		services = make([]models.DiscoveredService, 0)
+	)
+	for i := 0; i < scanWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dialer := net.Dialer{Timeout: scanTimeout}
+			for p := range probes {
+				address := net.JoinHostPort(p.host.String(), strconv.Itoa(p.port))
+				conn, err := dialer.DialContext(ctx, "tcp", address)
+				if err != nil {
+					continue
+				}
+				conn.Close()
+
+				mu.Lock()
+				services = append(services, newService(SourceScan, address, "", p.host.String(), p.port))
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, host := range hosts {
+		for _, port := range ports {
+			select {
+			case probes <- probe{host, port}:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+	}
+	close(probes)
+	wg.Wait()
+
+	if len(problems) > 0 {
+		return services, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return services, nil
+}
+
+// subnetAddresses lists the host addresses of a private IPv4 subnet
+func subnetAddresses(cidr string) ([]net.IP, error) {
+	_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid subnet %q", cidr)
+	}
+
+	base := network.IP.To4()
+	if base == nil {
+		return nil, fmt.Errorf("subnet %s is not IPv4", cidr)
+	}
+	// The same ranges the proxy accepts as targets
+	if !base.IsPrivate() && !base.IsLoopback() && !base.IsLinkLocalUnicast() {
+		return nil, fmt.Errorf("subnet %s is not a private range", cidr)
+	}
+
+	ones, bits := network.Mask.Size()
+	size := 1 << (bits - ones)
+	if size > maxScanHosts {
+		return nil, fmt.Errorf("subnet %s is larger than /20", cidr)
+	}
+
+	start := uint32(base[0])<<24 | uint32(base[1])<<16 | uint32(base[2])<<8 | uint32(base[3])
+	hosts := make([]net.IP, 0, size)
+	for i := 0; i < size; i++ {
+		// Skip the network and broadcast addresses of subnets that have them
+		if size > 2 && (i == 0 || i == size-1) {
+			continue
+		}
+		n := start + uint32(i)
+		hosts = append(hosts, net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)))
+	}
+	return hosts, nil
+}
@@ -0,0 +1,155 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lan-relay/internal/models"
+)
+
+var ssdpAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+const ssdpSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n" +
+	"ST: ssdp:all\r\n" +
+	"\r\n"
+
+// upnpDescription is the part of a UPnP device description used to name a device
+type upnpDescription struct {
+	URLBase string `xml:"URLBase"`
+	Device  struct {
+		DeviceType      string `xml:"deviceType"`
+		FriendlyName    string `xml:"friendlyName"`
+		Manufacturer    string `xml:"manufacturer"`
+		ModelName       string `xml:"modelName"`
+		PresentationURL string `xml:"presentationURL"`
+	} `xml:"device"`
+}
+
+// searchSSDP multicasts an M-SEARCH and turns each responding device's
+// description into a service, preferring its presentation (web UI) URL
+func searchSSDP(ctx context.Context, timeout time.Duration) ([]models.DiscoveredService, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetReadDeadline(deadline)
+
+	// Send twice, UDP multicast is easily dropped
+	for i := 0; i < 2; i++ {
+		if _, err := conn.WriteToUDP([]byte(ssdpSearch), ssdpAddr); err != nil {
+			return nil, err
+		}
+	}
+
+	locations := make(map[string]bool)
+	buf := make([]byte, 4096)
+	for ctx.Err() == nil {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			return nil, err
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if location := resp.Header.Get("Location"); location != "" {
+			locations[location] = true
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		services = make([]models.DiscoveredService, 0)
+	)
+	for location := range locations {
+		wg.Add(1)
+		go func(location string) {
+			defer wg.Done()
+			if service, ok := describeUPnPDevice(ctx, location); ok {
+				mu.Lock()
+				services = append(services, service)
+				mu.Unlock()
+			}
+		}(location)
+	}
+	wg.Wait()
+
+	return services, nil
+}
+
+// describeUPnPDevice fetches a device description from an SSDP location
+func describeUPnPDevice(ctx context.Context, location string) (models.DiscoveredService, bool) {
+	locationURL, err := url.Parse(location)
+	if err != nil || locationURL.Hostname() == "" {
+		return models.DiscoveredService{}, false
+	}
+
+	var description upnpDescription
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err == nil {
+		if resp, err := titleClient.Do(req); err == nil {
+			xml.NewDecoder(io.LimitReader(resp.Body, 256*1024)).Decode(&description)
+			resp.Body.Close()
+		}
+	}
+
+	// The presentation URL is the device's web UI, if it has one
+	serviceURL := locationURL
+	if description.Device.PresentationURL != "" {
+		base := locationURL
+		if description.URLBase != "" {
+			if parsed, err := url.Parse(description.URLBase); err == nil {
+				base = parsed
+			}
+		}
+		if presentation, err := base.Parse(description.Device.PresentationURL); err == nil && presentation.Hostname() != "" {
+			serviceURL = presentation
+		}
+	}
+
+	port, err := strconv.Atoi(serviceURL.Port())
+	if err != nil {
+		port = 80
+		if serviceURL.Scheme == "https" {
+			port = 443
+		}
+	}
+
+	name := description.Device.FriendlyName
+	if name == "" {
+		name = serviceURL.Hostname()
+	}
+	service := newService(SourceSSDP, name, description.Device.DeviceType, serviceURL.Hostname(), port)
+	if serviceURL == locationURL {
+		// The description server isn't a web UI, so name it from the description
+		service.Title = strings.TrimSpace(description.Device.Manufacturer + " " + description.Device.ModelName)
+	}
+	return service, true
+}
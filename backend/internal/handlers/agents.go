@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"lan-relay/internal/hub"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/http2"
+)
+
+// agentKey holds the agent a /proxy request asked for with an @AGENT prefix
+const agentKey = "agent"
+
+// agentTransports reach targets through one agent's connection
+type agentTransports struct {
+	http    *http.Transport
+	grpcH2C *http2.Transport
+	grpcTLS *http2.Transport
+}
+
+// agentState keeps the transports of the connected agents
+type agentState struct {
+	mu         sync.Mutex
+	transports map[*hub.Session]*agentTransports
+}
+
+// UseHub makes the relay a hub, reaching targets through the agents that
+// connect to it rather than directly
+func (h *Handler) UseHub(agents *hub.Hub) {
+	h.hub = agents
+	h.agents.transports = make(map[*hub.Session]*agentTransports)
+}
+
+// ConnectAgent accepts an agent's connection and keeps it until it ends
+func (h *Handler) ConnectAgent(c *gin.Context) {
+	if h.hub == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This relay isn't a hub", "hint": "Start it with: lan-relay hub"})
+		return
+	}
+	if !hub.IsUpgrade(c.Request) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Agents must upgrade the connection to " + hub.UpgradeProtocol})
+		return
+	}
+
+	name, signature, err := h.hub.Authenticate(c.Request)
+	if err != nil {
+		requestLogger(c).Warn(fmt.Sprintf("Refused agent connection from %s: %v", c.ClientIP(), err))
+		h.recordAuditFor(c, nil, "agent.denied", c.GetHeader(hub.NameHeader), err.Error())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Agent authentication failed"})
+		return
+	}
+
+	conn, err := hub.Upgrade(c.Writer, signature)
+	if err != nil {
+		requestLogger(c).Error(fmt.Sprintf("Failed to upgrade the connection of agent %q: %v", name, err))
+		return
+	}
+	session, err := hub.NewSession(name, conn, c.ClientIP())
+	if err != nil {
+		requestLogger(c).Error(fmt.Sprintf("Failed to start the session of agent %q: %v", name, err))
+		return
+	}
+
+	h.hub.Add(session)
+	h.addAgentTransports(session)
+	requestLogger(c).Info(fmt.Sprintf("🔗 Agent %q connected from %s", name, c.ClientIP()))
+	h.recordAuditFor(c, nil, "agent.connect", name, c.ClientIP())
+
+	<-session.Done()
+
+	h.hub.Remove(session)
+	h.removeAgentTransports(session)
+	requestLogger(c).Info(fmt.Sprintf("Agent %q disconnected after %v", name, time.Since(*session.Info().ConnectedAt).Round(time.Second)))
+}
+
+// GetAgents lists the hub's agents and their connections
+func (h *Handler) GetAgents(c *gin.Context) {
+	if h.hub == nil {
+		c.JSON(http.StatusOK, gin.H{"hub": false, "agents": []hub.AgentInfo{}})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"hub": true, "agents": h.hub.Agents()})
+}
+
+// agentFor returns the agent a proxied request goes through, the one named
+// in its path or the default agent, with its transports. It responds and
+// returns nil when that agent isn't connected.
+func (h *Handler) agentFor(c *gin.Context) (*hub.Session, *agentTransports) {
+	name := c.GetString(agentKey)
+	if session := h.hub.Get(name); session != nil {
+		if transports := h.agentTransport(session); transports != nil {
+			return session, transports
+		}
+	}
+
+	switch {
+	case name != "" && !h.hub.Known(name):
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Unknown agent %q", name)})
+	case name != "":
+		c.JSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("Agent %q isn't connected", name)})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": "No default agent is connected",
+			"hint":  "Pick an agent with /proxy/@AGENT/HOST:PORT/path, or set HUB_DEFAULT_AGENT",
+		})
+	}
+	return nil, nil
+}
+
+// splitAgentPath splits "/proxy/@AGENT/rest" into the agent and a URL for
+// "/proxy/rest"
+func splitAgentPath(u *url.URL) (string, *url.URL, bool) {
+	escaped := u.EscapedPath()
+	if !strings.HasPrefix(escaped, "/proxy/@") {
+		return "", u, false
+	}
+
+	rest := strings.TrimPrefix(escaped, "/proxy/@")
+	name, path, _ := strings.Cut(rest, "/")
+	name, err := url.PathUnescape(name)
+	if err != nil || name == "" {
+		return "", u, false
+	}
+
+	stripped := *u
+	stripped.RawPath = "/proxy/" + path
+	stripped.Path, err = url.PathUnescape(stripped.RawPath)
+	if err != nil {
+		return "", u, false
+	}
+	return name, &stripped, true
+}
+
+// agentTransport returns the transports of a connected agent
+func (h *Handler) agentTransport(session *hub.Session) *agentTransports {
+	h.agents.mu.Lock()
+	defer h.agents.mu.Unlock()
+	return h.agents.transports[session]
+}
+
+func (h *Handler) addAgentTransports(session *hub.Session) {
+	grpcH2C, grpcTLS := newGRPCTransports(h.cfg.GRPCTLSSkipVerify, session.DialContext)
+	transports := &agentTransports{
+		http: &http.Transport{
+			DialContext:         session.DialContext,
+			MaxIdleConnsPerHost: 16,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		grpcH2C: grpcH2C,
+		grpcTLS: grpcTLS,
+	}
+
+	h.agents.mu.Lock()
+	h.agents.transports[session] = transports
+	h.agents.mu.Unlock()
+}
+
+func (h *Handler) removeAgentTransports(session *hub.Session) {
+	h.agents.mu.Lock()
+	transports := h.agents.transports[session]
+	delete(h.agents.transports, session)
+	h.agents.mu.Unlock()
+
+	if transports != nil {
+		transports.http.CloseIdleConnections()
+		transports.grpcH2C.CloseIdleConnections()
+		transports.grpcTLS.CloseIdleConnections()
+	}
+}
+
+// hubUnavailable refuses features that act on the relay's own network,
+// which on a hub isn't the network its targets are on
+func (h *Handler) hubUnavailable(c *gin.Context, feature string) bool {
+	if h.hub == nil {
+		return false
+	}
+	c.JSON(http.StatusNotImplemented, gin.H{
+		"error": fmt.Sprintf("%s isn't available on a hub", feature),
+		"hint":  "It only works on the network the relay runs on; run lan-relay start on the home network to use it",
+	})
+	return true
+}
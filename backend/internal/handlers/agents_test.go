@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/config"
+	"lan-relay/internal/database"
+	"lan-relay/internal/hub"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testAgentKey = "0123456789abcdef0123"
+
+// startHub serves a hub with one agent, "home", connected over loopback
+func startHub(t *testing.T) (*Handler, *httptest.Server) {
+	t.Helper()
+	h := newTestHandler(t, &config.Config{})
+	agents := hub.New(map[string]string{"home": testAgentKey, "cabin": "abcdefabcdefabcdef12"}, "")
+	h.UseHub(agents)
+
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET(hub.ConnectPath, h.ConnectAgent)
+	r.GET("/api/agents", h.GetAgents)
+	r.Any("/proxy/*path", h.ProxyRequest)
+	srv := httptest.NewServer(r)
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	agent, err := hub.NewAgent(hub.AgentConfig{HubURL: srv.URL, Name: "home", Key: testAgentKey, Allowed: []*net.IPNet{loopback}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		agent.Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		agents.Close()
+		<-stopped
+		srv.Close()
+	})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for agents.Get("home") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the agent didn't connect")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return h, srv
+}
+
+func get(t *testing.T, rawURL string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(rawURL)
+	if err != nil {
+		t.Fatalf("GET %s: %v", rawURL, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestHubProxiesThroughAgent(t *testing.T) {
+	h, srv := startHub(t)
+	target := targetServer(t).Listener.Addr().String()
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/proxy/@home/" + target + "/a", http.StatusOK, "hello /a"},
+		// The only connected agent is the default
+		{"/proxy/" + target + "/b", http.StatusOK, "hello /b"},
+		{"/proxy/@cabin/" + target + "/c", http.StatusBadGateway, ""},
+		{"/proxy/@nobody/" + target + "/d", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		status, body := get(t, srv.URL+tt.path)
+		if status != tt.status || (tt.body != "" && body != tt.body) {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, status, body, tt.status, tt.body)
+		}
+	}
+
+	logs, err := h.db.GetLogs(database.LogFilter{Agent: "home"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 {
+		t.Errorf("got %d log entries for agent home, want 2", len(logs))
+	}
+
+	_, body := get(t, srv.URL+"/api/agents")
+	var listed struct {
+		Hub    bool            `json:"hub"`
+		Agents []hub.AgentInfo `json:"agents"`
+	}
+	if err := json.Unmarshal([]byte(body), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if !listed.Hub || len(listed.Agents) != 2 || !listed.Agents[1].Connected || listed.Agents[0].Connected {
+		t.Errorf("GET /api/agents = %s, want cabin disconnected and home connected", body)
+	}
+}
+
+func TestDecideChecksGrantsBehindAgents(t *testing.T) {
+	h := newTestHandler(t, &config.Config{AuthEnabled: true})
+	user := createUser(t, h, "alice", auth.RoleProxyOnly)
+	if err := h.db.InsertTargetGrant(&models.TargetGrant{UserID: user.ID, Target: "10.0.0.5:80"}); err != nil {
+		t.Fatal(err)
+	}
+	ungranted := createUser(t, h, "bob", auth.RoleOperator)
+	everywhere := createUser(t, h, "carol", auth.RoleViewer)
+	if err := h.db.InsertTargetGrant(&models.TargetGrant{UserID: everywhere.ID, Target: auth.AnyTarget}); err != nil {
+		t.Fatal(err)
+	}
+	admin := createUser(t, h, "root", auth.RoleAdmin)
+	token := &models.APIToken{ID: 1, Scopes: []string{auth.ScopeProxyRead}, Targets: []string{"10.0.0.5"}}
+
+	tests := []struct {
+		name    string
+		user    *models.User
+		token   *models.APIToken
+		path    string
+		allowed bool
+	}{
+		{"granted target", user, nil, "/proxy/@home/10.0.0.5:80/", true},
+		{"granted target without agent", user, nil, "/proxy/10.0.0.5:80/", true},
+		{"target without grant", user, nil, "/proxy/@home/10.0.0.6:80/", false},
+		{"escaped agent name", user, nil, "/proxy/@ho%6De/10.0.0.6:80/", false},
+		{"agent without target", user, nil, "/proxy/@home/", false},
+		{"bare agent", user, nil, "/proxy/@home", false},
+		{"malformed target", user, nil, "/proxy/@home/nonsense/", false},
+		{"empty agent", user, nil, "/proxy/@/10.0.0.6:80/", false},
+		{"user without grants", ungranted, nil, "/proxy/10.0.0.5:80/", false},
+		{"grant to every target", everywhere, nil, "/proxy/@home/10.0.0.6:80/", true},
+		{"admin without grants", admin, nil, "/proxy/10.0.0.6:80/", true},
+		{"token allowlist", admin, token, "/proxy/@home/10.0.0.5:80/", true},
+		{"token allowlist denies", admin, token, "/proxy/@home/10.0.0.6:80/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decision, err := h.decide(tt.user, tt.token, http.MethodGet, u, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decision.Allowed != tt.allowed {
+				t.Errorf("decide(%s) allowed = %v (%s), want %v", tt.path, decision.Allowed, decision.Reason, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestRouteGRPCChecksGrantsBehindAgents(t *testing.T) {
+	h := newTestHandler(t, &config.Config{AuthEnabled: true})
+	user := createUser(t, h, "alice", auth.RoleProxyOnly)
+	if err := h.db.InsertTargetGrant(&models.TargetGrant{UserID: user.ID, Target: "10.0.0.5:50051"}); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := auth.IssueAPIToken(h.db, &models.APIToken{Name: "grpc", UserID: user.ID, Scopes: []string{auth.ScopeProxyWrite}}, user.Role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(h.RouteGRPC())
+	for _, target := range []string{"@home/10.0.0.6:50051", "@home", "@home/"} {
+		req := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", nil)
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Authorization", "Bearer "+secret)
+		req.Header.Set(GRPCTargetHeader, target)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("gRPC call to %s = %d, want %d", target, w.Code, http.StatusForbidden)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"lan-relay/internal/database"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultAuditLimit = 100
+
+// redactedValue replaces secrets in audit changes
+const redactedValue = "[redacted]"
+
+// sensitiveFields are substrings of field names whose values are never
+// written to the audit log
+var sensitiveFields = []string{"token", "secret", "password", "key"}
+
+// recordAudit stores an audit event for the current request. Failures are
+// logged but don't fail the request.
+func (h *Handler) recordAudit(c *gin.Context, action, resource, detail string) {
+	h.recordAuditFor(c, currentUser(c), action, resource, detail)
+}
+
+// recordAuditChange stores an audit event with the fields that differ
+// between before and after, which are any JSON-encodable values. Either may
+// be nil for something created or deleted. Fields named in redact are
+// treated as secrets along with the usual ones.
+func (h *Handler) recordAuditChange(c *gin.Context, action, resource string, before, after interface{}, redact ...string) {
+	h.storeAudit(c, currentUser(c), action, resource, "", auditChanges(before, after, redact...))
+}
+
+// recordAuditFor stores an audit event acting for a user that isn't signed in
+// yet, such as during sign-in. user may be nil.
+func (h *Handler) recordAuditFor(c *gin.Context, user *models.User, action, resource, detail string) {
+	h.storeAudit(c, user, action, resource, detail, nil)
+}
+
+func (h *Handler) storeAudit(c *gin.Context, user *models.User, action, resource, detail string, changes []models.AuditChange) {
+	event := &models.AuditEvent{
+		Timestamp: time.Now(),
+		Action:    action,
+		Resource:  resource,
+		Detail:    detail,
+		Changes:   changes,
+		SourceIP:  c.ClientIP(),
+		RequestID: requestIDFrom(c),
+	}
+	if user != nil {
+		event.UserID = user.ID
+		event.Username = user.Username
+	}
+
+	if err := h.db.InsertAuditEvent(event); err != nil {
+		requestLogger(c).Error("Failed to record audit event:", err)
+	}
+}
+
+// auditChanges lists the fields that differ between two values, flattening
+// nested objects into dotted field names and redacting secrets
+func auditChanges(before, after interface{}, redact ...string) []models.AuditChange {
+	from := flattenForAudit(before)
+	to := flattenForAudit(after)
+
+	fields := make([]string, 0, len(from)+len(to))
+	for field := range from {
+		fields = append(fields, field)
+	}
+	for field := range to {
+		if _, ok := from[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := make([]models.AuditChange, 0)
+	for _, field := range fields {
+		// updated_at changes on every save and says nothing about what changed
+		if field == "updated_at" || strings.HasSuffix(field, ".updated_at") {
+			continue
+		}
+		oldValue, newValue := from[field], to[field]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		if sensitiveField(field) || containsString(redact, field) {
+			oldValue, newValue = redactSecret(oldValue), redactSecret(newValue)
+		}
+		changes = append(changes, models.AuditChange{Field: field, From: oldValue, To: newValue})
+	}
+	return changes
+}
+
+// flattenForAudit encodes a value as JSON and flattens its objects into a
+// map of dotted field names
+func flattenForAudit(value interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if value == nil {
+		return fields
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return fields
+	}
+
+	var flatten func(prefix string, v interface{})
+	flatten = func(prefix string, v interface{}) {
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			fields[prefix] = v
+			return
+		}
+		for key, nested := range object {
+			name := key
+			if prefix != "" {
+				name = prefix + "." + key
+			}
+			flatten(name, nested)
+		}
+	}
+	flatten("", decoded)
+	return fields
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sensitiveField(field string) bool {
+	lower := strings.ToLower(field)
+	for _, s := range sensitiveFields {
+		if strings.Contains(lower, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactSecret hides a secret while still showing whether it was set
+func redactSecret(value interface{}) interface{} {
+	if value == nil || value == "" {
+		return value
+	}
+	return redactedValue
+}
+
+// GetAuditEvents lists audit events, newest first. Filters by action,
+// username, resource and time range.
+func (h *Handler) GetAuditEvents(c *gin.Context) {
+	filter := database.AuditFilter{
+		Action:   c.Query("action"),
+		Username: c.Query("username"),
+		Resource: c.Query("resource"),
+		Limit:    defaultAuditLimit,
+	}
+
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+			return
+		}
+		filter.Limit = parsed
+	}
+	if value := c.Query("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+			return
+		}
+		filter.Offset = parsed
+	}
+	for name, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := c.Query(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid '%s' time, expected RFC3339", name)})
+				return
+			}
+			*dest = t
+		}
+	}
+
+	events, err := h.db.GetAuditEvents(filter)
+	if err != nil {
+		requestLogger(c).Error("Error fetching audit events:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// VerifyAuditLog checks the audit hash chain for events that were changed
+// or removed
+func (h *Handler) VerifyAuditLog(c *gin.Context) {
+	result, err := h.db.VerifyAuditChain()
+	if err != nil {
+		requestLogger(c).Error("Error verifying audit log:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit log"})
+		return
+	}
+	if !result.Valid {
+		requestLogger(c).Warn(fmt.Sprintf("Audit log chain broken at event %d: %s", result.BrokenAt, result.Reason))
+	}
+
+	c.JSON(http.StatusOK, result)
+}
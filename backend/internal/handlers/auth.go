@@ -0,0 +1,490 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// SessionCookie holds the session token of a signed-in user
+	SessionCookie = "lr_session"
+	userKey       = "user"
+)
+
+// sessionTouchInterval limits how often a session's last-seen time is written
+const sessionTouchInterval = time.Minute
+
+// RequireAuth rejects requests without a valid session, API token or client
+// certificate, or that the user isn't allowed to make
+func (h *Handler) RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.authenticate(c) || !h.authorize(c) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// authenticate loads the session's user into the context, aborting
+// the request if there's no valid session
+func (h *Handler) authenticate(c *gin.Context) bool {
+	if !h.cfg.AuthEnabled || h.proxyAuthExempt(c) {
+		return true
+	}
+
+	// On the mTLS listener the certificate decides who the client is
+	if peer := peerCertificate(c.Request); peer != nil {
+		return h.authenticateCert(c, peer)
+	}
+
+	if token := apiTokenFrom(c.Request); token != "" {
+		return h.authenticateToken(c, token)
+	}
+
+	token, err := c.Cookie(SessionCookie)
+	if err != nil || token == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return false
+	}
+
+	tokenHash := auth.HashToken(token)
+	session, user, err := h.db.GetSession(tokenHash)
+	if err != nil {
+		requestLogger(c).Error("Error fetching session:", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session"})
+		return false
+	}
+	if session == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Session expired, sign in again"})
+		return false
+	}
+
+	if time.Since(session.LastSeenAt) > sessionTouchInterval {
+		if err := h.db.TouchSession(tokenHash); err != nil {
+			requestLogger(c).Warn("Failed to record session use:", err)
+		}
+	}
+
+	c.Set(userKey, user)
+
+	// Browsers attach the session cookie whichever site makes the request
+	return h.checkCSRF(c)
+}
+
+// currentUser returns the signed-in user, or nil when auth is disabled
+func currentUser(c *gin.Context) *models.User {
+	if value, ok := c.Get(userKey); ok {
+		if user, ok := value.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// Login checks a username and password and starts a session. Users with
+// two-factor sign-in get a challenge to complete with LoginTwoFactor instead.
+func (h *Handler) Login(c *gin.Context) {
+	var request struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username and password are required"})
+		return
+	}
+
+	username := strings.TrimSpace(request.Username)
+	if h.loginBlocked(c, username) {
+		return
+	}
+
+	user, err := h.db.GetUserByUsername(username)
+	if err != nil {
+		requestLogger(c).Error("Error fetching user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+
+	passwordHash := ""
+	if user != nil {
+		passwordHash = user.PasswordHash
+	}
+	if !auth.CheckPassword(passwordHash, request.Password) {
+		h.loginFailed(c, username, user, "auth.login_failed", "wrong username or password")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+		return
+	}
+
+	if user.TOTPEnabled {
+		challenge, expires, err := h.startTwoFactor(user)
+		if err != nil {
+			requestLogger(c).Error("Error starting two-factor sign-in:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "challenge": challenge, "expires_at": expires})
+		return
+	}
+
+	h.finishLogin(c, user, "password")
+}
+
+// startSession signs a user in and sets the session cookie, writing an
+// error response if it can't
+func (h *Handler) startSession(c *gin.Context, user *models.User) (*models.Session, bool) {
+	token, err := auth.NewToken()
+	if err != nil {
+		requestLogger(c).Error("Error generating session token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return nil, false
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		TokenHash:  auth.HashToken(token),
+		UserID:     user.ID,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(time.Duration(h.cfg.SessionTTLHours) * time.Hour),
+		LastSeenAt: now,
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}
+	if err := h.db.InsertSession(session); err != nil {
+		requestLogger(c).Error("Error creating session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return nil, false
+	}
+	if err := h.db.TouchUserLogin(user.ID); err != nil {
+		requestLogger(c).Warn("Failed to record sign-in:", err)
+	}
+	if err := h.db.DeleteExpiredSessions(); err != nil {
+		requestLogger(c).Warn("Failed to remove expired sessions:", err)
+	}
+
+	h.setSessionCookie(c, token, int(time.Until(session.ExpiresAt).Seconds()))
+	return session, true
+}
+
+// Logout ends the current session
+func (h *Handler) Logout(c *gin.Context) {
+	if token, err := c.Cookie(SessionCookie); err == nil && token != "" {
+		if err := h.db.DeleteSession(auth.HashToken(token)); err != nil {
+			requestLogger(c).Error("Error deleting session:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out"})
+			return
+		}
+	}
+
+	h.setSessionCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{"message": "Signed out"})
+}
+
+// GetCurrentUser returns the signed-in user with their permissions and groups
+func (h *Handler) GetCurrentUser(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusOK, gin.H{"user": nil, "auth_enabled": h.cfg.AuthEnabled})
+		return
+	}
+
+	groups, err := h.db.GetUserGroups(user.ID)
+	if err != nil {
+		requestLogger(c).Error("Error fetching groups:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch groups"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":         user,
+		"auth_enabled": h.cfg.AuthEnabled,
+		"permissions":  auth.RolePermissions(user.Role),
+		"groups":       groups,
+	})
+}
+
+// ChangePassword changes the signed-in user's password, signing out all
+// of their sessions
+func (h *Handler) ChangePassword(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Authentication is disabled"})
+		return
+	}
+
+	var request struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Current and new password are required"})
+		return
+	}
+
+	if !auth.CheckPassword(user.PasswordHash, request.CurrentPassword) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+	if !h.setPassword(c, user, request.NewPassword) {
+		return
+	}
+
+	h.setSessionCookie(c, "", -1)
+	requestLogger(c).Info(fmt.Sprintf("User %q changed their password", user.Username))
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, sign in again"})
+}
+
+// GetUsers lists the user accounts
+func (h *Handler) GetUsers(c *gin.Context) {
+	users, err := h.db.GetUsers()
+	if err != nil {
+		requestLogger(c).Error("Error fetching users:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// CreateUser adds a user account. New users are viewers unless a role is given.
+func (h *Handler) CreateUser(c *gin.Context) {
+	var request struct {
+		Username string `json:"username" binding:"required"`
+		Password string `json:"password" binding:"required"`
+		Role     string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username and password are required"})
+		return
+	}
+
+	user := &models.User{Username: strings.TrimSpace(request.Username), Role: request.Role}
+	if user.Role == "" {
+		user.Role = auth.RoleViewer
+	}
+	if err := auth.ValidateUsername(user.Username); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := auth.ValidateRole(user.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := auth.ValidatePassword(request.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := h.db.GetUserByUsername(user.Username)
+	if err != nil {
+		requestLogger(c).Error("Error fetching user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+	if existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+		return
+	}
+
+	hash, err := auth.HashPassword(request.Password)
+	if err != nil {
+		requestLogger(c).Error("Error hashing password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+	user.PasswordHash = hash
+
+	if err := h.db.InsertUser(user); err != nil {
+		requestLogger(c).Error("Error creating user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	h.recordAudit(c, "user.create", "user:"+user.Username, "role "+user.Role)
+	requestLogger(c).Info(fmt.Sprintf("User %q created with role %s", user.Username, user.Role))
+	c.JSON(http.StatusCreated, user)
+}
+
+// ResetUserPassword sets another user's password and signs out their sessions
+func (h *Handler) ResetUserPassword(c *gin.Context) {
+	user, ok := h.userFromParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is required"})
+		return
+	}
+	if !h.setPassword(c, user, request.Password) {
+		return
+	}
+
+	h.recordAudit(c, "user.password", "user:"+user.Username, "password reset")
+	requestLogger(c).Info(fmt.Sprintf("Password reset for user %q", user.Username))
+	c.JSON(http.StatusOK, gin.H{"message": "Password updated"})
+}
+
+// SetUserRole changes a user's role. The last admin can't be demoted.
+func (h *Handler) SetUserRole(c *gin.Context) {
+	user, ok := h.userFromParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role is required"})
+		return
+	}
+	if err := auth.ValidateRole(request.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if user.Role == auth.RoleAdmin && request.Role != auth.RoleAdmin && !h.otherAdminsExist(c) {
+		return
+	}
+
+	if err := h.db.UpdateUserRole(user.ID, request.Role); err != nil {
+		requestLogger(c).Error("Error updating role:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update role"})
+		return
+	}
+
+	h.recordAudit(c, "user.role", "user:"+user.Username, user.Role+" -> "+request.Role)
+	requestLogger(c).Info(fmt.Sprintf("User %q is now %s", user.Username, request.Role))
+	user.Role = request.Role
+	c.JSON(http.StatusOK, user)
+}
+
+// otherAdminsExist checks there's more than one admin, writing an error
+// response if there isn't
+func (h *Handler) otherAdminsExist(c *gin.Context) bool {
+	count, err := h.db.CountUsersWithRole(auth.RoleAdmin)
+	if err != nil {
+		requestLogger(c).Error("Error counting admins:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count admins"})
+		return false
+	}
+	if count <= 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "The last admin can't be removed or demoted"})
+		return false
+	}
+	return true
+}
+
+// DeleteUser removes a user account. Users can't delete themselves or the
+// last admin.
+func (h *Handler) DeleteUser(c *gin.Context) {
+	user, ok := h.userFromParam(c)
+	if !ok {
+		return
+	}
+	if current := currentUser(c); current != nil && current.ID == user.ID {
+		c.JSON(http.StatusConflict, gin.H{"error": "You can't delete your own account"})
+		return
+	}
+
+	if user.Role == auth.RoleAdmin && !h.otherAdminsExist(c) {
+		return
+	}
+
+	if err := h.db.DeleteUser(user.ID); err != nil {
+		requestLogger(c).Error("Error deleting user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	h.recordAudit(c, "user.delete", "user:"+user.Username, "role "+user.Role)
+	requestLogger(c).Info(fmt.Sprintf("User %q deleted", user.Username))
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+}
+
+// userFromParam loads the user named by the :id route parameter,
+// writing an error response if it can't
+func (h *Handler) userFromParam(c *gin.Context) (*models.User, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, false
+	}
+
+	user, err := h.db.GetUser(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+		return nil, false
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+
+	return user, true
+}
+
+// setPassword validates and stores a new password, writing an error response if it can't
+func (h *Handler) setPassword(c *gin.Context, user *models.User, password string) bool {
+	if err := auth.ValidatePassword(password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		requestLogger(c).Error("Error hashing password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return false
+	}
+	if err := h.db.UpdateUserPassword(user.ID, hash); err != nil {
+		requestLogger(c).Error("Error updating password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return false
+	}
+	return true
+}
+
+// setSessionCookie writes the session cookie, marking it Secure when the
+// client reached the relay over HTTPS (directly or through the tunnel). In
+// CSRF token mode a new CSRF token goes with each session.
+func (h *Handler) setSessionCookie(c *gin.Context, token string, maxAge int) {
+	c.SetSameSite(h.sessionSameSite())
+	c.SetCookie(SessionCookie, token, maxAge, "/", "", h.isHTTPS(c), true)
+	if h.cfg.CSRFMode == "token" {
+		h.setCSRFCookie(c, maxAge)
+	}
+}
+
+// removeCookie drops a cookie from a request's Cookie headers, leaving the others as sent
+func removeCookie(header http.Header, name string) {
+	values := header.Values("Cookie")
+	if len(values) == 0 {
+		return
+	}
+
+	header.Del("Cookie")
+	for _, value := range values {
+		kept := make([]string, 0)
+		for _, part := range strings.Split(value, ";") {
+			cookieName, _, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if cookieName != name && strings.TrimSpace(part) != "" {
+				kept = append(kept, strings.TrimSpace(part))
+			}
+		}
+		if len(kept) > 0 {
+			header.Add("Cookie", strings.Join(kept, "; "))
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/config"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authRouter serves sign-in and one signed-in endpoint
+func authRouter(h *Handler) *gin.Engine {
+	r := gin.New()
+	r.POST("/api/auth/login", h.Login)
+	r.POST("/api/auth/login/2fa", h.LoginTwoFactor)
+	r.POST("/api/auth/logout", h.Logout)
+	r.GET("/api/auth/me", h.RequireAuth(), h.GetCurrentUser)
+	return r
+}
+
+// createUserWithPassword adds a user who can sign in with a password
+func createUserWithPassword(t *testing.T, h *Handler, username, password, role string) *models.User {
+	t.Helper()
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &models.User{Username: username, PasswordHash: hash, Role: role}
+	if err := h.db.InsertUser(user); err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	return user
+}
+
+// login posts a username and password, returning the response
+func login(r http.Handler, username, password string) *httptest.ResponseRecorder {
+	body := `{"username": "` + username + `", "password": "` + password + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// sessionCookie returns the session cookie a response sets
+func sessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == SessionCookie {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// getWithCookie requests a path with an optional cookie
+func getWithCookie(r http.Handler, path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestSessions(t *testing.T) {
+	h := newTestHandler(t, &config.Config{AuthEnabled: true, SessionTTLHours: 1})
+	user := createUserWithPassword(t, h, "alice", "correct horse", auth.RoleViewer)
+	r := authRouter(h)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantCode int
+	}{
+		{"wrong password", "alice", "wrong horse", http.StatusUnauthorized},
+		{"unknown user", "mallory", "correct horse", http.StatusUnauthorized},
+		{"right password", "alice", "correct horse", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := login(r, tt.username, tt.password)
+		if w.Code != tt.wantCode {
+			t.Fatalf("%s: login = %d %s, want %d", tt.name, w.Code, w.Body, tt.wantCode)
+		}
+		if cookie := sessionCookie(w); (cookie != nil) != (tt.wantCode == http.StatusOK) {
+			t.Fatalf("%s: session cookie = %v", tt.name, cookie)
+		}
+	}
+
+	cookie := sessionCookie(login(r, "alice", "correct horse"))
+	if !cookie.HttpOnly {
+		t.Error("the session cookie is readable by scripts")
+	}
+	if w := getWithCookie(r, "/api/auth/me", cookie); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"alice"`) {
+		t.Fatalf("GET /api/auth/me with a session = %d %s", w.Code, w.Body)
+	}
+	if w := getWithCookie(r, "/api/auth/me", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/auth/me without a session = %d, want 401", w.Code)
+	}
+	if w := getWithCookie(r, "/api/auth/me", &http.Cookie{Name: SessionCookie, Value: "made-up"}); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/auth/me with a made-up session = %d, want 401", w.Code)
+	}
+
+	// Signing out ends the session on the server, not just in the browser
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)
+	req.AddCookie(cookie)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if w := getWithCookie(r, "/api/auth/me", cookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/auth/me after signing out = %d, want 401", w.Code)
+	}
+
+	// Expired sessions are refused, and only their hash is stored
+	token, _ := auth.NewToken()
+	expired := &models.Session{TokenHash: auth.HashToken(token), UserID: user.ID, CreatedAt: time.Now().Add(-2 * time.Hour), ExpiresAt: time.Now().Add(-time.Hour), LastSeenAt: time.Now().Add(-time.Hour)}
+	if err := h.db.InsertSession(expired); err != nil {
+		t.Fatal(err)
+	}
+	if w := getWithCookie(r, "/api/auth/me", &http.Cookie{Name: SessionCookie, Value: token}); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/auth/me with an expired session = %d, want 401", w.Code)
+	}
+	if w := getWithCookie(r, "/api/auth/me", &http.Cookie{Name: SessionCookie, Value: expired.TokenHash}); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /api/auth/me with a stored hash as the cookie = %d, want 401", w.Code)
+	}
+}
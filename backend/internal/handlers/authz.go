@@ -0,0 +1,637 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routePermissions maps each protected API route to the permission it
+// needs. Routes whose permission is also a token scope can be used with API
+// tokens. The proxy needs proxy:read for GET, HEAD and OPTIONS and
+// proxy:write for everything else.
+var routePermissions = map[string]string{
+	"GET /api/status":                   auth.ScopeLogsRead,
+	"GET /api/logs":                     auth.ScopeLogsRead,
+	"GET /api/logs/har":                 auth.ScopeLogsRead,
+	"GET /api/logs/:id":                 auth.ScopeLogsRead,
+	"POST /api/logs/:id/replay":         auth.PermLogsWrite,
+	"POST /api/logs/clear":              auth.PermLogsWrite,
+	"GET /api/rules":                    auth.ScopeSettingsWrite,
+	"POST /api/rules":                   auth.ScopeSettingsWrite,
+	"POST /api/rules/dry-run":           auth.ScopeSettingsWrite,
+	"PUT /api/rules/:id":                auth.ScopeSettingsWrite,
+	"DELETE /api/rules/:id":             auth.ScopeSettingsWrite,
+	"GET /api/credentials":              auth.ScopeSettingsWrite,
+	"POST /api/credentials":             auth.ScopeSettingsWrite,
+	"PUT /api/credentials/:id":          auth.ScopeSettingsWrite,
+	"POST /api/credentials/:id/rotate":  auth.ScopeSettingsWrite,
+	"DELETE /api/credentials/:id":       auth.ScopeSettingsWrite,
+	"GET /api/targets":                  auth.PermTargetsRead,
+	"POST /api/targets":                 auth.PermTargetsWrite,
+	"PUT /api/targets/:id":              auth.PermTargetsWrite,
+	"DELETE /api/targets/:id":           auth.PermTargetsWrite,
+	"GET /api/discovery":                auth.PermTargetsRead,
+	"POST /api/discovery/:id/register":  auth.PermTargetsWrite,
+	"GET /api/devices":                  auth.PermTargetsRead,
+	"GET /api/devices/:id":              auth.PermTargetsRead,
+	"PUT /api/devices/:id":              auth.PermTargetsWrite,
+	"DELETE /api/devices/:id":           auth.PermTargetsWrite,
+	"POST /api/devices/:id/wake":        auth.PermTargetsWrite,
+	"GET /api/auth/me":                  auth.PermAccount,
+	"POST /api/auth/password":           auth.PermAccount,
+	"GET /api/auth/2fa":                 auth.PermAccount,
+	"POST /api/auth/2fa/setup":          auth.PermAccount,
+	"POST /api/auth/2fa/enable":         auth.PermAccount,
+	"POST /api/auth/2fa/disable":        auth.PermAccount,
+	"POST /api/auth/2fa/recovery-codes": auth.PermAccount,
+	"GET /api/roles":                    auth.PermAccount,
+	"GET /api/authz/explain":            auth.PermAccount,
+	"GET /api/tokens":                   auth.PermAccount,
+	"POST /api/tokens":                  auth.PermAccount,
+	"DELETE /api/tokens/:id":            auth.PermAccount,
+	"GET /api/users":                    auth.PermUsersManage,
+	"POST /api/users":                   auth.PermUsersManage,
+	"PUT /api/users/:id/password":       auth.PermUsersManage,
+	"PUT /api/users/:id/role":           auth.PermUsersManage,
+	"DELETE /api/users/:id":             auth.PermUsersManage,
+	"GET /api/groups":                   auth.PermUsersManage,
+	"POST /api/groups":                  auth.PermUsersManage,
+	"PUT /api/groups/:id/members":       auth.PermUsersManage,
+	"DELETE /api/groups/:id":            auth.PermUsersManage,
+	"GET /api/grants":                   auth.PermUsersManage,
+	"POST /api/grants":                  auth.PermUsersManage,
+	"DELETE /api/grants/:id":            auth.PermUsersManage,
+	"GET /api/audit":                    auth.PermUsersManage,
+	"GET /api/audit/verify":             auth.PermUsersManage,
+	"GET /api/shares":                   auth.PermTargetsRead,
+	"POST /api/shares":                  auth.PermTargetsWrite,
+	"DELETE /api/shares/:id":            auth.PermTargetsWrite,
+	"GET /api/ip-rules":                 auth.ScopeSettingsWrite,
+	"POST /api/ip-rules":                auth.ScopeSettingsWrite,
+	"DELETE /api/ip-rules/:id":          auth.ScopeSettingsWrite,
+	"GET /api/settings":                 auth.ScopeSettingsWrite,
+	"POST /api/settings":                auth.ScopeSettingsWrite,
+	"GET /api/tunnel":                   auth.ScopeTunnelManage,
+	"POST /api/tunnel/start":            auth.ScopeTunnelManage,
+	"POST /api/tunnel/stop":             auth.ScopeTunnelManage,
+	"POST /api/ngrok/start":             auth.ScopeTunnelManage,
+	"POST /api/ngrok/stop":              auth.ScopeTunnelManage,
+	"POST /api/ngrok/test":              auth.ScopeTunnelManage,
+	"GET /api/agents":                   auth.ScopeTunnelManage,
+}
+
+// publicRoutes need no sign-in
+var publicRoutes = map[string]bool{
+	"GET /api/health":             true,
+	"POST /api/auth/login":        true,
+	"POST /api/auth/login/2fa":    true,
+	"POST /api/auth/logout":       true,
+	"GET /api/auth/sso":           true,
+	"GET /api/auth/oidc/login":    true,
+	"GET /api/auth/oidc/callback": true,
+	"GET /api/agents/connect":     true,
+}
+
+// UnmappedRoutes returns the API routes without a permission. They're
+// denied to everyone, so this is checked at startup.
+func UnmappedRoutes(routes gin.RoutesInfo) []string {
+	missing := make([]string, 0)
+	for _, route := range routes {
+		key := route.Method + " " + route.Path
+		if strings.HasPrefix(route.Path, "/api/") && !publicRoutes[key] && routePermissions[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// authorize checks the request against the user's role, target grants and
+// API token, auditing and aborting the request if it's denied
+func (h *Handler) authorize(c *gin.Context) bool {
+	user := currentUser(c)
+	if !h.cfg.AuthEnabled || user == nil {
+		return true
+	}
+
+	token := currentAPIToken(c)
+	decision, err := h.decide(user, token, c.Request.Method, c.Request.URL, c.FullPath())
+	return h.enforce(c, decision, err)
+}
+
+// authorizeTarget checks a request the relay makes to a target on the
+// caller's behalf, such as a replay, as if it came through /proxy
+func (h *Handler) authorizeTarget(c *gin.Context, method, agent, hostPort string) bool {
+	user := currentUser(c)
+	if !h.cfg.AuthEnabled || user == nil {
+		return true
+	}
+
+	path := "/proxy/" + hostPort + "/"
+	if agent != "" {
+		path = "/proxy/@" + agent + "/" + hostPort + "/"
+	}
+	decision, err := h.decide(user, currentAPIToken(c), method, &url.URL{Path: path}, "")
+	return h.enforce(c, decision, err)
+}
+
+// enforce audits and aborts a request that was denied
+func (h *Handler) enforce(c *gin.Context, decision models.AuthzDecision, err error) bool {
+	if err != nil {
+		requestLogger(c).Error("Error checking permissions:", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return false
+	}
+	if !decision.Allowed {
+		h.recordAudit(c, "authz.denied", decision.Method+" "+decision.Path, decision.Reason)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": decision.Reason})
+		return false
+	}
+	return true
+}
+
+// decide works out whether a user (optionally through an API token) may make
+// a request. route is the matched route pattern, if already known.
+func (h *Handler) decide(user *models.User, token *models.APIToken, method string, u *url.URL, route string) (models.AuthzDecision, error) {
+	decision := models.AuthzDecision{
+		Username: user.Username,
+		Role:     user.Role,
+		Method:   strings.ToUpper(method),
+		Path:     u.Path,
+	}
+	if token != nil {
+		decision.APITokenID = token.ID
+	}
+
+	// Targets behind a hub agent are checked like any other, on their HOST:PORT
+	proxyURL := u
+	if agent, stripped, ok := splitAgentPath(u); ok {
+		decision.Agent, proxyURL = agent, stripped
+	}
+	hostPort, _, isProxy := splitProxyPath(proxyURL)
+	switch {
+	case isProxy:
+		decision.Route = "/proxy/*path"
+		decision.Target = hostPort
+		decision.Permission = auth.ScopeProxyWrite
+		if decision.Method == http.MethodGet || decision.Method == http.MethodHead || decision.Method == http.MethodOptions {
+			decision.Permission = auth.ScopeProxyRead
+		}
+	case route != "":
+		decision.Route = route
+		decision.Permission = routePermissions[decision.Method+" "+route]
+	default:
+		decision.Route, decision.Permission = matchRoute(decision.Method, u.Path)
+	}
+
+	if decision.Permission == "" {
+		decision.Reason = "No permission is defined for this endpoint"
+		return decision, nil
+	}
+	if !auth.RoleAllows(user.Role, decision.Permission) {
+		decision.Reason = fmt.Sprintf("Role %s doesn't have the %s permission", user.Role, decision.Permission)
+		return decision, nil
+	}
+	if token != nil {
+		if !auth.HasScope(auth.Scopes, decision.Permission) {
+			decision.Reason = "API tokens can't be used for this endpoint"
+			return decision, nil
+		}
+		if !auth.HasScope(token.Scopes, decision.Permission) {
+			decision.Reason = fmt.Sprintf("API token is missing the %s scope", decision.Permission)
+			return decision, nil
+		}
+	}
+	decision.Reason = fmt.Sprintf("Role %s has the %s permission", user.Role, decision.Permission)
+
+	if !isProxy {
+		decision.Allowed = true
+		return decision, nil
+	}
+
+	// Grants and allowlists can't be checked without a target, so anything
+	// malformed is denied rather than left to the proxy
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil || host == "" || port == "" {
+		decision.Reason = "Invalid proxy target, use /proxy/HOST:PORT/path or /proxy/@AGENT/HOST:PORT/path"
+		return decision, nil
+	}
+	if token != nil && !auth.TargetAllowed(token.Targets, host, port) {
+		decision.Reason = "API token is not allowed to reach this target"
+		return decision, nil
+	}
+	if user.Role == auth.RoleAdmin {
+		decision.Allowed = true
+		decision.Reason += ", and admins reach every target"
+		return decision, nil
+	}
+
+	grants, err := h.db.GetUserTargetGrants(user.ID)
+	if err != nil {
+		return decision, err
+	}
+	// Users other than admins reach nothing until they're granted targets
+	for _, grant := range grants {
+		if auth.TargetAllowed([]string{grant.Target}, host, port) {
+			decision.Grants = append(decision.Grants, fmt.Sprintf("%s (grant %d to %s)", grant.Target, grant.ID, grant.Subject))
+		}
+	}
+	if len(grants) == 0 {
+		decision.Reason = fmt.Sprintf("Target %s is denied, as the user has no target grants", hostPort)
+		return decision, nil
+	}
+	if len(decision.Grants) == 0 {
+		decision.Reason = fmt.Sprintf("Target %s isn't covered by the user's target grants", hostPort)
+		return decision, nil
+	}
+	decision.Allowed = true
+	decision.Reason += ", and a target grant covers " + hostPort
+	return decision, nil
+}
+
+// targetVisibility returns a check for which targets the caller may see in
+// logs and share links: those their target grants and API token reach. It's
+// nil when the caller sees every target. Like the proxy, it shows users other
+// than admins nothing until they're granted targets.
+func (h *Handler) targetVisibility(c *gin.Context) (func(host, port string) bool, error) {
+	user := currentUser(c)
+	if !h.cfg.AuthEnabled || user == nil {
+		return nil, nil
+	}
+
+	var tokenTargets []string
+	if token := currentAPIToken(c); token != nil {
+		tokenTargets = token.Targets
+	}
+	// Admins aren't limited by grants, so nil stands for every target
+	var granted []string
+	if user.Role != auth.RoleAdmin {
+		grants, err := h.db.GetUserTargetGrants(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		granted = make([]string, 0, len(grants))
+		for _, grant := range grants {
+			granted = append(granted, grant.Target)
+		}
+	}
+	if len(tokenTargets) == 0 && granted == nil {
+		return nil, nil
+	}
+	return func(host, port string) bool {
+		if granted != nil && (len(granted) == 0 || !auth.TargetAllowed(granted, host, port)) {
+			return false
+		}
+		return auth.TargetAllowed(tokenTargets, host, port)
+	}, nil
+}
+
+// matchRoute finds the route pattern and permission for a method and path,
+// preferring static segments the way the router does (/api/logs/har over
+// /api/logs/:id)
+func matchRoute(method, path string) (string, string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	bestRoute, bestPermission, bestParams := "", "", len(segments)+1
+	for key, permission := range routePermissions {
+		routeMethod, pattern, _ := strings.Cut(key, " ")
+		patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+		if routeMethod != method || len(patternSegments) != len(segments) {
+			continue
+		}
+
+		params := 0
+		for i, segment := range patternSegments {
+			if strings.HasPrefix(segment, ":") {
+				params++
+			} else if segment != segments[i] {
+				params = -1
+				break
+			}
+		}
+		if params >= 0 && params < bestParams {
+			bestRoute, bestPermission, bestParams = pattern, permission, params
+		}
+	}
+	return bestRoute, bestPermission
+}
+
+// GetRoles lists the roles and their permissions
+func (h *Handler) GetRoles(c *gin.Context) {
+	roles := make([]gin.H, 0, len(auth.Roles))
+	for _, role := range auth.Roles {
+		roles = append(roles, gin.H{"name": role, "permissions": auth.RolePermissions(role)})
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// ExplainAccess reports whether a user may make a request, and why. Users
+// can explain their own access; explaining anyone else's needs users:manage.
+func (h *Handler) ExplainAccess(c *gin.Context) {
+	if !h.cfg.AuthEnabled {
+		c.JSON(http.StatusOK, models.AuthzDecision{Allowed: true, Reason: "Authentication is disabled"})
+		return
+	}
+
+	path := c.Query("path")
+	if !strings.HasPrefix(path, "/") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A path starting with / is required"})
+		return
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid path"})
+		return
+	}
+	method := c.DefaultQuery("method", http.MethodGet)
+
+	current := currentUser(c)
+	user := current
+	if username := c.Query("username"); username != "" && !strings.EqualFold(username, current.Username) {
+		if !auth.RoleAllows(current.Role, auth.PermUsersManage) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Explaining another user's access needs the users:manage permission"})
+			return
+		}
+		if user, err = h.db.GetUserByUsername(username); err != nil {
+			requestLogger(c).Error("Error fetching user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return
+		}
+		if user == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+	}
+
+	var token *models.APIToken
+	if tokenID := c.Query("api_token_id"); tokenID != "" {
+		id, err := strconv.Atoi(tokenID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API token ID"})
+			return
+		}
+		if token, err = h.db.GetAPIToken(id); err != nil {
+			requestLogger(c).Error("Error fetching API token:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API token"})
+			return
+		}
+		if token == nil || token.UserID != user.ID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API token not found for this user"})
+			return
+		}
+	}
+
+	decision, err := h.decide(user, token, method, u, "")
+	if err != nil {
+		requestLogger(c).Error("Error checking permissions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return
+	}
+	c.JSON(http.StatusOK, decision)
+}
+
+// GetGroups lists the groups with their members
+func (h *Handler) GetGroups(c *gin.Context) {
+	groups, err := h.db.GetGroups()
+	if err != nil {
+		requestLogger(c).Error("Error fetching groups:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch groups"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"groups": groups})
+}
+
+// CreateGroup adds a group, optionally with members
+func (h *Handler) CreateGroup(c *gin.Context) {
+	var request struct {
+		Name    string `json:"name" binding:"required"`
+		UserIDs []int  `json:"user_ids"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Group name is required"})
+		return
+	}
+
+	group := &models.Group{Name: strings.TrimSpace(request.Name)}
+	if err := auth.ValidateUsername(group.Name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Group name must be 1-64 letters, digits or . _ @ -"})
+		return
+	}
+	if !h.usersExist(c, request.UserIDs) {
+		return
+	}
+
+	if err := h.db.InsertGroup(group); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			c.JSON(http.StatusConflict, gin.H{"error": "A group with this name already exists"})
+			return
+		}
+		requestLogger(c).Error("Error creating group:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
+		return
+	}
+	if err := h.db.SetGroupMembers(group.ID, request.UserIDs); err != nil {
+		requestLogger(c).Error("Error setting group members:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set group members"})
+		return
+	}
+
+	h.recordAudit(c, "group.create", "group:"+group.Name, fmt.Sprintf("members %v", request.UserIDs))
+	h.respondWithGroup(c, http.StatusCreated, group.ID)
+}
+
+// SetGroupMembers replaces the members of a group
+func (h *Handler) SetGroupMembers(c *gin.Context) {
+	group, ok := h.groupFromParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		UserIDs []int `json:"user_ids"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if !h.usersExist(c, request.UserIDs) {
+		return
+	}
+
+	if err := h.db.SetGroupMembers(group.ID, request.UserIDs); err != nil {
+		requestLogger(c).Error("Error setting group members:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set group members"})
+		return
+	}
+
+	h.recordAudit(c, "group.members", "group:"+group.Name, fmt.Sprintf("%v -> user IDs %v", group.Members, request.UserIDs))
+	h.respondWithGroup(c, http.StatusOK, group.ID)
+}
+
+// DeleteGroup removes a group and its target grants
+func (h *Handler) DeleteGroup(c *gin.Context) {
+	group, ok := h.groupFromParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeleteGroup(group.ID); err != nil {
+		requestLogger(c).Error("Error deleting group:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete group"})
+		return
+	}
+
+	h.recordAudit(c, "group.delete", "group:"+group.Name, "")
+	c.JSON(http.StatusOK, gin.H{"message": "Group deleted successfully"})
+}
+
+// groupFromParam loads the group named by the :id route parameter,
+// writing an error response if it can't
+func (h *Handler) groupFromParam(c *gin.Context) (*models.Group, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return nil, false
+	}
+
+	group, err := h.db.GetGroup(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching group:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+		return nil, false
+	}
+	if group == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return nil, false
+	}
+
+	return group, true
+}
+
+func (h *Handler) respondWithGroup(c *gin.Context, status, id int) {
+	group, err := h.db.GetGroup(id)
+	if err != nil || group == nil {
+		requestLogger(c).Error("Error fetching group:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+		return
+	}
+	c.JSON(status, group)
+}
+
+// usersExist checks that every user ID exists, writing an error response if one doesn't
+func (h *Handler) usersExist(c *gin.Context, ids []int) bool {
+	for _, id := range ids {
+		user, err := h.db.GetUser(id)
+		if err != nil {
+			requestLogger(c).Error("Error fetching user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user"})
+			return false
+		}
+		if user == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("User %d not found", id)})
+			return false
+		}
+	}
+	return true
+}
+
+// GetTargetGrants lists the target grants
+func (h *Handler) GetTargetGrants(c *gin.Context) {
+	grants, err := h.db.GetTargetGrants()
+	if err != nil {
+		requestLogger(c).Error("Error fetching target grants:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch target grants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"grants": grants})
+}
+
+// CreateTargetGrant grants a user or group access to a HOST, HOST:PORT, CIDR
+// range or, with *, every target. Users other than admins can only reach
+// granted targets.
+func (h *Handler) CreateTargetGrant(c *gin.Context) {
+	var grant models.TargetGrant
+	if err := c.ShouldBindJSON(&grant); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	grant.Target = strings.TrimSpace(grant.Target)
+	if err := auth.ValidateTargetPattern(grant.Target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (grant.UserID == 0) == (grant.GroupID == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Either user_id or group_id is required"})
+		return
+	}
+	if grant.UserID != 0 && !h.usersExist(c, []int{grant.UserID}) {
+		return
+	}
+	if grant.GroupID != 0 {
+		group, err := h.db.GetGroup(grant.GroupID)
+		if err != nil {
+			requestLogger(c).Error("Error fetching group:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+			return
+		}
+		if group == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Group not found"})
+			return
+		}
+	}
+
+	if err := h.db.InsertTargetGrant(&grant); err != nil {
+		requestLogger(c).Error("Error creating target grant:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create target grant"})
+		return
+	}
+
+	created, err := h.db.GetTargetGrant(grant.ID)
+	if err != nil || created == nil {
+		requestLogger(c).Error("Error fetching target grant:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch target grant"})
+		return
+	}
+
+	h.recordAudit(c, "grant.create", "grant:"+strconv.Itoa(created.ID), fmt.Sprintf("%s to %s", created.Target, created.Subject))
+	c.JSON(http.StatusCreated, created)
+}
+
+// DeleteTargetGrant removes a target grant
+func (h *Handler) DeleteTargetGrant(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid grant ID"})
+		return
+	}
+
+	grant, err := h.db.GetTargetGrant(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching target grant:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch target grant"})
+		return
+	}
+	if grant == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target grant not found"})
+		return
+	}
+
+	if err := h.db.DeleteTargetGrant(id); err != nil {
+		requestLogger(c).Error("Error deleting target grant:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete target grant"})
+		return
+	}
+
+	h.recordAudit(c, "grant.delete", "grant:"+strconv.Itoa(id), fmt.Sprintf("%s to %s", grant.Target, grant.Subject))
+	c.JSON(http.StatusOK, gin.H{"message": "Target grant deleted successfully"})
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"lan-relay/internal/models"
+)
+
+// captureBuffer counts every byte written to it and keeps up to limit bytes
+// when keep is set. Anything not kept marks the buffer as truncated.
+type captureBuffer struct {
+	data      []byte
+	limit     int
+	keep      bool
+	size      int64
+	truncated bool
+}
+
+func newCaptureBuffer(limit int, keep bool) *captureBuffer {
+	return &captureBuffer{limit: limit, keep: keep}
+}
+
+func (b *captureBuffer) Write(p []byte) (int, error) {
+	b.size += int64(len(p))
+	if !b.keep {
+		b.truncated = b.truncated || len(p) > 0
+		return len(p), nil
+	}
+
+	remaining := b.limit - len(b.data)
+	if remaining <= 0 {
+		b.truncated = b.truncated || len(p) > 0
+		return len(p), nil
+	}
+	if len(p) > remaining {
+		b.data = append(b.data, p[:remaining]...)
+		b.truncated = true
+		return len(p), nil
+	}
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+// teeReadCloser copies everything read from a body into a capture buffer
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func newTeeReadCloser(body io.ReadCloser, buf *captureBuffer) io.ReadCloser {
+	return teeReadCloser{Reader: io.TeeReader(body, buf), Closer: body}
+}
+
+// capturedSecretHeaders carry credentials and sessions. Captures keep that
+// they were sent but not their values, as anyone who can read logs can see
+// them.
+var capturedSecretHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+// redactHeaders copies headers with the values of secret headers redacted
+func redactHeaders(header http.Header) http.Header {
+	redacted := header.Clone()
+	for _, name := range capturedSecretHeaders {
+		values := redacted[http.CanonicalHeaderKey(name)]
+		for i := range values {
+			values[i] = redactedValue
+		}
+	}
+	return redacted
+}
+
+// proxyCapture records the request and response of a single proxied call
+type proxyCapture struct {
+	record       models.LogCapture
+	requestBody  *captureBuffer
+	responseBody *captureBuffer
+	maxBody      int
+	contentTypes []string
+}
+
+// newProxyCapture snapshots the incoming request and starts teeing its body
+func newProxyCapture(req *http.Request, maxBody int, contentTypes []string) *proxyCapture {
+	pc := &proxyCapture{
+		record: models.LogCapture{
+			RequestProto:   req.Proto,
+			RequestQuery:   req.URL.RawQuery,
+			RequestHeaders: redactHeaders(req.Header),
+		},
+		maxBody:      maxBody,
+		contentTypes: contentTypes,
+	}
+
+	pc.requestBody = newCaptureBuffer(maxBody, isCapturableType(req.Header.Get("Content-Type"), contentTypes))
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = newTeeReadCloser(req.Body, pc.requestBody)
+	}
+
+	return pc
+}
+
+// captureResponse snapshots the upstream response and starts teeing its body
+func (pc *proxyCapture) captureResponse(resp *http.Response) {
+	pc.record.ResponseProto = resp.Proto
+	pc.record.ResponseHeaders = redactHeaders(resp.Header)
+
+	pc.responseBody = newCaptureBuffer(pc.maxBody, isCapturableType(resp.Header.Get("Content-Type"), pc.contentTypes))
+	if resp.Body != nil && resp.Body != http.NoBody {
+		resp.Body = newTeeReadCloser(resp.Body, pc.responseBody)
+	}
+}
+
+// finish returns the completed capture for the given log entry
+func (pc *proxyCapture) finish(logID int) *models.LogCapture {
+	record := pc.record
+	record.LogID = logID
+
+	record.RequestBody = pc.requestBody.data
+	record.RequestBodySize = pc.requestBody.size
+	record.RequestBodyTruncated = pc.requestBody.truncated
+
+	if pc.responseBody != nil {
+		record.ResponseBody = pc.responseBody.data
+		record.ResponseBodySize = pc.responseBody.size
+		record.ResponseBodyTruncated = pc.responseBody.truncated
+	}
+
+	return &record
+}
+
+// isCapturableType reports whether a content type matches one of the
+// configured prefixes (e.g. "text/" or "application/json")
+func isCapturableType(contentType string, allowed []string) bool {
+	if contentType == "" {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+
+	for _, prefix := range allowed {
+		if strings.HasPrefix(mediaType, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"lan-relay/internal/config"
+	"lan-relay/internal/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCapturesRedactSecretHeaders(t *testing.T) {
+	var replayed http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replayed = r.Header.Clone()
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "upstream-session-secret"})
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(upstream.Close)
+
+	h := newTestHandler(t, &config.Config{CaptureEnabled: true, CaptureMaxBodyBytes: 1024, CaptureContentTypes: []string{"text/"}})
+	r := gin.New()
+	r.Use(RequestID())
+	r.Any("/proxy/*path", h.ProxyRequest)
+	r.GET("/api/logs/har", h.ExportHAR)
+	r.GET("/api/logs/:id", h.GetLogDetail)
+	r.POST("/api/logs/:id/replay", h.ReplayLog)
+	relay := httptest.NewServer(r)
+	t.Cleanup(relay.Close)
+
+	secrets := []string{"Bearer lrt_client-secret", "relay_session=cookie-secret", "key-secret", "upstream-session-secret"}
+	req, _ := http.NewRequest(http.MethodGet, relay.URL+"/proxy/"+upstream.Listener.Addr().String()+"/", nil)
+	req.Header.Set("Authorization", secrets[0])
+	req.Header.Set("Cookie", secrets[1])
+	req.Header.Set("X-Api-Key", secrets[2])
+	req.Header.Set("X-Trace", "kept")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBody(resp)
+
+	logs, err := h.db.GetLogs(database.LogFilter{})
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("GetLogs() = %d entries, %v", len(logs), err)
+	}
+	capture, err := h.db.GetLogCapture(logs[0].ID)
+	if err != nil || capture == nil {
+		t.Fatalf("GetLogCapture() = %v, %v", capture, err)
+	}
+	for name, want := range map[string]string{"Authorization": redactedValue, "Cookie": redactedValue, "X-Api-Key": redactedValue, "X-Trace": "kept"} {
+		if got := capture.RequestHeaders.Get(name); got != want {
+			t.Errorf("captured request header %s = %q, want %q", name, got, want)
+		}
+	}
+	if got := capture.ResponseHeaders.Get("Set-Cookie"); got != redactedValue {
+		t.Errorf("captured Set-Cookie = %q, want %q", got, redactedValue)
+	}
+
+	id := strconv.Itoa(logs[0].ID)
+	for _, path := range []string{"/api/logs/" + id, "/api/logs/har"} {
+		status, body := get(t, relay.URL+path)
+		if status != http.StatusOK {
+			t.Fatalf("GET %s = %d", path, status)
+		}
+		for _, secret := range secrets {
+			if strings.Contains(body, secret) {
+				t.Errorf("GET %s shows %q", path, secret)
+			}
+		}
+	}
+
+	// Replays leave the redacted headers out rather than sending the placeholder
+	resp, err = http.Post(relay.URL+"/api/logs/"+id+"/replay", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := readBody(resp); resp.StatusCode != http.StatusOK {
+		t.Fatalf("replay = %d %s", resp.StatusCode, body)
+	}
+	for _, name := range []string{"Authorization", "Cookie", "X-Api-Key"} {
+		if values := replayed.Values(name); len(values) != 0 {
+			t.Errorf("replay sent %s: %q", name, values)
+		}
+	}
+	if got := replayed.Get("X-Trace"); got != "kept" {
+		t.Errorf("replay sent X-Trace %q, want %q", got, "kept")
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"crypto/x509"
+	"net/http"
+	"strings"
+
+	"lan-relay/internal/models"
+	"lan-relay/internal/pki"
+
+	"github.com/gin-gonic/gin"
+)
+
+const clientCertKey = "client_cert"
+
+// peerCertificate returns the client certificate verified during the TLS
+// handshake, or nil for plain HTTP and TLS without client auth
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// authenticateCert maps a client certificate to the user it was issued for,
+// aborting the request if it doesn't belong to one. The handshake already
+// checked the signature and the CRL; the database is checked too so a
+// revocation applies to connections that were open before it.
+func (h *Handler) authenticateCert(c *gin.Context, peer *x509.Certificate) bool {
+	cert, err := h.db.GetClientCert(pki.FormatSerial(peer.SerialNumber))
+	if err != nil {
+		requestLogger(c).Error("Error fetching client certificate:", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check client certificate"})
+		return false
+	}
+	if cert == nil || cert.RevokedAt != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Client certificate is not valid"})
+		return false
+	}
+
+	user, err := h.db.GetUser(cert.UserID)
+	if err != nil {
+		requestLogger(c).Error("Error fetching user:", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check client certificate"})
+		return false
+	}
+	// The subject names the user, which guards against a record pointing at
+	// an account that was deleted and whose ID was reused
+	if user == nil || !strings.EqualFold(user.Username, peer.Subject.CommonName) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Client certificate is not valid"})
+		return false
+	}
+
+	// A relay token sent alongside is never forwarded
+	c.Request.Header.Del(APITokenHeader)
+
+	c.Set(userKey, user)
+	c.Set(clientCertKey, cert)
+	return true
+}
+
+// currentClientCert returns the client certificate the request was
+// authenticated with, if any
+func currentClientCert(c *gin.Context) *models.ClientCert {
+	if value, ok := c.Get(clientCertKey); ok {
+		if cert, ok := value.(*models.ClientCert); ok {
+			return cert
+		}
+	}
+	return nil
+}
+
+// requestIdentity describes who made a request for the logs: the username,
+// and the API token or client certificate used, or the share link. With auth
+// disabled a client certificate's subject is still recorded.
+func requestIdentity(c *gin.Context) string {
+	if link := currentShareLink(c); link != nil {
+		return "share:" + link.Name
+	}
+
+	user := currentUser(c)
+	if user == nil {
+		if peer := peerCertificate(c.Request); peer != nil {
+			return "cert:" + peer.Subject.CommonName
+		}
+		return ""
+	}
+
+	if cert := currentClientCert(c); cert != nil {
+		return user.Username + " (cert " + cert.Name + ")"
+	}
+	if token := currentAPIToken(c); token != nil {
+		return user.Username + " (token " + token.Name + ")"
+	}
+	return user.Username
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/config"
+	"lan-relay/internal/models"
+	"lan-relay/internal/pki"
+)
+
+func TestClientCertificatesFollowTheDatabase(t *testing.T) {
+	h := newTestHandler(t, &config.Config{AuthEnabled: true})
+	alice := createUser(t, h, "alice", auth.RoleViewer)
+	ca, err := pki.Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// issue signs a certificate for username and records it for user
+	issue := func(username string, user *models.User) *x509.Certificate {
+		cert, _, _, err := ca.IssueClient(username, "laptop", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != nil {
+			record := &models.ClientCert{Serial: pki.FormatSerial(cert.SerialNumber), Name: "laptop", UserID: user.ID, ExpiresAt: cert.NotAfter}
+			if err := h.db.InsertClientCert(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return cert
+	}
+	valid := issue("alice", alice)
+	revoked := issue("alice", alice)
+	if err := h.db.RevokeClientCert(pki.FormatSerial(revoked.SerialNumber)); err != nil {
+		t.Fatal(err)
+	}
+	unrecorded := issue("alice", nil)
+	otherName := issue("bob", alice)
+
+	r := authRouter(h)
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		wantCode int
+	}{
+		{"recorded certificate", valid, http.StatusOK},
+		// The handshake only rereads the CRL for new connections
+		{"revoked on an open connection", revoked, http.StatusUnauthorized},
+		{"certificate the database doesn't know", unrecorded, http.StatusUnauthorized},
+		{"subject naming another user", otherName, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/auth/me", nil)
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.cert}}}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("GET /api/auth/me = %d %s, want %d", w.Code, w.Body, tt.wantCode)
+			}
+		})
+	}
+}
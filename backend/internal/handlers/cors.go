@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// CSRFCookie holds the token that browsers echo in CSRFHeader when
+	// CSRF_MODE is "token". Unlike the session cookie, scripts can read it.
+	CSRFCookie = "lr_csrf"
+	CSRFHeader = "X-CSRF-Token"
+
+	// devServerOrigin is the dashboard's development server, allowed to call
+	// the API outside production
+	devServerOrigin = "http://localhost:3000"
+)
+
+// corsAllowHeaders are the request headers browsers may send cross-origin
+var corsAllowHeaders = []string{"Origin", "Content-Length", "Content-Type", "Authorization", RequestIDHeader,
+	APITokenHeader, GRPCTargetHeader, CSRFHeader, "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout"}
+
+// CORS answers cross-origin requests: /api for the dashboard's own origin,
+// the tunnel URL and CORS_ALLOWED_ORIGINS, with cookies; /proxy only for
+// PROXY_CORS_ALLOWED_ORIGINS. Requests from other origins are refused.
+func (h *Handler) CORS() gin.HandlerFunc {
+	api := cors.New(cors.Config{
+		AllowOriginWithContextFunc: func(c *gin.Context, origin string) bool {
+			return h.apiOriginAllowed(origin)
+		},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS"},
+		AllowHeaders:     corsAllowHeaders,
+		ExposeHeaders:    []string{RequestIDHeader},
+		AllowCredentials: true,
+	})
+
+	var proxy gin.HandlerFunc
+	if origins := h.cfg.ProxyCORSAllowedOrigins; len(origins) > 0 {
+		anyOrigin := containsString(origins, "*")
+		proxy = cors.New(cors.Config{
+			AllowOrigins:  origins,
+			AllowMethods:  []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+			AllowHeaders:  corsAllowHeaders,
+			ExposeHeaders: []string{RequestIDHeader, "Grpc-Status", "Grpc-Message"},
+			// Any website may read responses, but never with the user's session
+			AllowCredentials: !anyOrigin,
+		})
+	}
+
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		switch {
+		case strings.HasPrefix(path, "/proxy/"):
+			if proxy != nil {
+				proxy(c)
+			}
+		case strings.HasPrefix(path, "/api/"):
+			api(c)
+		}
+	}
+}
+
+// apiOriginAllowed reports whether a browser origin other than the relay's
+// own may call the API
+func (h *Handler) apiOriginAllowed(origin string) bool {
+	origin = normalizeOrigin(origin)
+	if origin == "" || origin == "null" {
+		return false
+	}
+	for _, allowed := range h.cfg.CORSAllowedOrigins {
+		if normalizeOrigin(allowed) == origin {
+			return true
+		}
+	}
+	if tunnel, _ := h.tunnelURL.Load().(string); tunnel != "" && normalizeOrigin(tunnel) == origin {
+		return true
+	}
+	return h.cfg.Environment != "production" && origin == devServerOrigin
+}
+
+// proxyOriginAllowed reports whether a browser origin other than the relay's
+// own may send cookie-authenticated requests to /proxy. "*" allows reading
+// responses but not this.
+func (h *Handler) proxyOriginAllowed(origin string) bool {
+	origin = normalizeOrigin(origin)
+	if origin == "" || origin == "null" {
+		return false
+	}
+	for _, allowed := range h.cfg.ProxyCORSAllowedOrigins {
+		if normalizeOrigin(allowed) == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether an origin is the relay itself, as addressed by
+// the request
+func sameOrigin(c *gin.Context, origin string) bool {
+	origin = normalizeOrigin(origin)
+	host := strings.ToLower(c.Request.Host)
+	return origin == "http://"+host || origin == "https://"+host
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
+
+// checkCSRF guards state-changing requests authenticated by the session
+// cookie, answering 403 if one came from another site, or (for the API in
+// token mode) without the CSRF token. It reports whether the request may
+// continue.
+func (h *Handler) checkCSRF(c *gin.Context) bool {
+	proxied := strings.HasPrefix(c.Request.URL.Path, "/proxy/")
+	tokenMode := h.cfg.CSRFMode == "token" && !proxied
+
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		if tokenMode {
+			h.ensureCSRFCookie(c)
+		}
+		return true
+	}
+
+	allowed := h.apiOriginAllowed
+	if proxied {
+		allowed = h.proxyOriginAllowed
+	}
+
+	// Browsers send Origin on cross-origin writes, and Sec-Fetch-Site on all
+	// of them. Clients that send neither, like curl, aren't browsers that a
+	// website could drive.
+	origin := c.GetHeader("Origin")
+	fetchSite := c.GetHeader("Sec-Fetch-Site")
+	crossSite := fetchSite != "" && fetchSite != "same-origin" && fetchSite != "none"
+	if (origin != "" && !sameOrigin(c, origin)) || (origin == "" && crossSite) {
+		if !allowed(origin) {
+			requestLogger(c).Warn(fmt.Sprintf("Blocked cross-site %s %s from origin %q", c.Request.Method, c.Request.URL.Path, origin))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Cross-site request blocked"})
+			return false
+		}
+	}
+
+	if !tokenMode {
+		return true
+	}
+	cookie, err := c.Cookie(CSRFCookie)
+	header := c.GetHeader(CSRFHeader)
+	if err != nil || cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) != 1 {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "Missing or invalid CSRF token",
+			"hint":  "Send the " + CSRFCookie + " cookie's value in the " + CSRFHeader + " header",
+		})
+		return false
+	}
+	return true
+}
+
+// ensureCSRFCookie issues a CSRF token to sessions that don't have one yet
+func (h *Handler) ensureCSRFCookie(c *gin.Context) {
+	if value, err := c.Cookie(CSRFCookie); err == nil && value != "" {
+		return
+	}
+	h.setCSRFCookie(c, 0)
+}
+
+// setCSRFCookie writes a new CSRF token, or removes it when maxAge is
+// negative. A maxAge of 0 lasts for the browser session.
+func (h *Handler) setCSRFCookie(c *gin.Context, maxAge int) {
+	token := ""
+	if maxAge >= 0 {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			requestLogger(c).Error("Error generating CSRF token:", err)
+			return
+		}
+		token = hex.EncodeToString(buf)
+	}
+	c.SetSameSite(h.sessionSameSite())
+	c.SetCookie(CSRFCookie, token, maxAge, "/", "", h.isHTTPS(c), false)
+}
+
+// sessionSameSite is the SameSite mode of the session and CSRF cookies
+func (h *Handler) sessionSameSite() http.SameSite {
+	if h.cfg.SessionCookieSameSite == "strict" {
+		return http.SameSiteStrictMode
+	}
+	return http.SameSiteLaxMode
+}
+
+// stripUpstreamCORS removes an upstream's CORS headers from a proxied
+// response when the relay answers CORS for /proxy itself, so browsers don't
+// see two conflicting sets
+func (h *Handler) stripUpstreamCORS(header http.Header) {
+	if len(h.cfg.ProxyCORSAllowedOrigins) == 0 {
+		return
+	}
+	for name := range header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(name), "Access-Control-") {
+			header.Del(name)
+		}
+	}
+}
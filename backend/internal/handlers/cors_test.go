@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// csrfRouter answers every request that passes checkCSRF with 200
+func csrfRouter(h *Handler) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if h.checkCSRF(c) {
+			c.Status(http.StatusOK)
+		}
+	})
+	return r
+}
+
+func TestCheckCSRFOrigins(t *testing.T) {
+	h := newTestHandler(t, &config.Config{
+		AuthEnabled:             true,
+		Environment:             "production",
+		CORSAllowedOrigins:      []string{"https://dash.example.org/"},
+		ProxyCORSAllowedOrigins: []string{"https://app.example.org", "*"},
+	})
+	h.tunnelURL.Store("https://relay.ngrok.app")
+	r := csrfRouter(h)
+
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		origin    string
+		fetchSite string
+		wantCode  int
+	}{
+		{"read from another site", http.MethodGet, "/api/targets", "https://evil.example", "cross-site", http.StatusOK},
+		{"same origin", http.MethodPost, "/api/targets", "http://relay.lan", "same-origin", http.StatusOK},
+		{"same origin, other case", http.MethodPost, "/api/targets", "HTTP://Relay.LAN/", "", http.StatusOK},
+		{"no browser headers", http.MethodPost, "/api/targets", "", "", http.StatusOK},
+		{"typed into the address bar", http.MethodPost, "/api/targets", "", "none", http.StatusOK},
+		{"another site", http.MethodPost, "/api/targets", "https://evil.example", "cross-site", http.StatusForbidden},
+		{"another site without Origin", http.MethodDelete, "/api/targets/1", "", "cross-site", http.StatusForbidden},
+		{"same site but another origin", http.MethodPut, "/api/targets/1", "http://printer.relay.lan", "same-site", http.StatusForbidden},
+		{"opaque origin", http.MethodPost, "/api/targets", "null", "cross-site", http.StatusForbidden},
+		{"allowed origin", http.MethodPost, "/api/targets", "https://dash.example.org", "cross-site", http.StatusOK},
+		{"tunnel URL", http.MethodPost, "/api/targets", "https://relay.ngrok.app", "cross-site", http.StatusOK},
+		{"dev server in production", http.MethodPost, "/api/targets", devServerOrigin, "same-site", http.StatusForbidden},
+		{"proxy origin on the API", http.MethodPost, "/api/targets", "https://app.example.org", "cross-site", http.StatusForbidden},
+		{"proxy origin on the proxy", http.MethodPost, "/proxy/10.0.0.5/80/", "https://app.example.org", "cross-site", http.StatusOK},
+		{"API origin on the proxy", http.MethodPost, "/proxy/10.0.0.5/80/", "https://dash.example.org", "cross-site", http.StatusForbidden},
+		{"any origin doesn't allow writes", http.MethodPost, "/proxy/10.0.0.5/80/", "https://evil.example", "cross-site", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "http://relay.lan"+tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.fetchSite != "" {
+				req.Header.Set("Sec-Fetch-Site", tt.fetchSite)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("%s %s from %q = %d %s, want %d", tt.method, tt.path, tt.origin, w.Code, w.Body, tt.wantCode)
+			}
+		})
+	}
+
+	// Outside production the dashboard's dev server may call the API
+	dev := csrfRouter(newTestHandler(t, &config.Config{AuthEnabled: true}))
+	req := httptest.NewRequest(http.MethodPost, "http://relay.lan/api/targets", nil)
+	req.Header.Set("Origin", devServerOrigin)
+	w := httptest.NewRecorder()
+	dev.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("POST from the dev server in development = %d, want 200", w.Code)
+	}
+}
+
+func TestCheckCSRFTokens(t *testing.T) {
+	h := newTestHandler(t, &config.Config{AuthEnabled: true, CSRFMode: "token", SessionCookieSameSite: "strict"})
+	r := csrfRouter(h)
+
+	// A read issues the token cookie, once
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+	var token *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == CSRFCookie {
+			token = cookie
+		}
+	}
+	if token == nil || len(token.Value) != 64 || token.HttpOnly || token.SameSite != http.SameSiteStrictMode {
+		t.Fatalf("GET /api/status set CSRF cookie %+v", token)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	req.AddCookie(token)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Header().Get("Set-Cookie") != "" {
+		t.Errorf("GET /api/status with a CSRF cookie replaced it: %s", w.Header().Get("Set-Cookie"))
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		cookie   string
+		header   string
+		wantCode int
+	}{
+		{"matching token", "/api/targets", token.Value, token.Value, http.StatusOK},
+		{"no header", "/api/targets", token.Value, "", http.StatusForbidden},
+		{"no cookie", "/api/targets", "", token.Value, http.StatusForbidden},
+		{"different token", "/api/targets", token.Value, "made-up", http.StatusForbidden},
+		{"both empty", "/api/targets", "", "", http.StatusForbidden},
+		// Proxied sites can't send the header, so /proxy relies on origins
+		{"proxy without a token", "/proxy/10.0.0.5/80/", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookie, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("POST %s = %d %s, want %d", tt.path, w.Code, w.Body, tt.wantCode)
+			}
+		})
+	}
+}
+
+// Only cookie sessions are checked, API tokens aren't sent by browsers on
+// their own
+func TestRequireAuthChecksCSRFForSessions(t *testing.T) {
+	h := newTestHandler(t, &config.Config{AuthEnabled: true, SessionTTLHours: 1})
+	admin := createUserWithPassword(t, h, "root", "correct horse", auth.RoleAdmin)
+	session := sessionCookie(login(authRouter(h), "root", "correct horse"))
+	token := issueToken(t, h, admin, auth.Scopes...)
+
+	r := gin.New()
+	r.Use(h.RequireAuth())
+	r.POST("/api/tunnel/start", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name     string
+		cookie   *http.Cookie
+		bearer   string
+		origin   string
+		wantCode int
+	}{
+		{"session from the relay", session, "", "http://relay.lan", http.StatusOK},
+		{"session from another site", session, "", "https://evil.example", http.StatusForbidden},
+		{"token from another site", nil, token, "https://evil.example", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://relay.lan/api/tunnel/start", nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("POST /api/tunnel/start = %d %s, want %d", w.Code, w.Body, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	CredentialBasic  = "basic"
+	CredentialBearer = "bearer"
+	CredentialHeader = "header"
+	CredentialQuery  = "query"
+)
+
+// credentialRequest is the body accepted when creating or updating a credential
+type credentialRequest struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Username string   `json:"username"`
+	Param    string   `json:"param"`
+	Secret   string   `json:"secret"`
+	Targets  []string `json:"targets"`
+}
+
+// upstreamCredential is a decrypted credential ready to be injected into a request
+type upstreamCredential struct {
+	id       int
+	credType string
+	username string
+	param    string
+	secret   string
+}
+
+// GetCredentials lists stored credentials without their secrets
+func (h *Handler) GetCredentials(c *gin.Context) {
+	credentials, err := h.db.GetCredentials()
+	if err != nil {
+		requestLogger(c).Error("Error fetching credentials:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credentials"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"credentials": credentials})
+}
+
+// CreateCredential encrypts and stores a new upstream credential
+func (h *Handler) CreateCredential(c *gin.Context) {
+	if !h.requireVault(c) {
+		return
+	}
+
+	var request credentialRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	credential := models.Credential{
+		Name:     strings.TrimSpace(request.Name),
+		Type:     strings.ToLower(strings.TrimSpace(request.Type)),
+		Username: request.Username,
+		Param:    strings.TrimSpace(request.Param),
+		Targets:  request.Targets,
+	}
+	if err := validateCredential(&credential); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Secret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Secret is required"})
+		return
+	}
+
+	encrypted, err := h.vault.Encrypt([]byte(request.Secret))
+	if err != nil {
+		requestLogger(c).Error("Error encrypting credential:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt credential"})
+		return
+	}
+	credential.EncryptedSecret = encrypted
+
+	if err := h.db.InsertCredential(&credential); err != nil {
+		requestLogger(c).Error("Error creating credential:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create credential"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Credential %q created", credential.Name))
+	h.recordAuditChange(c, "credential.create", "credential:"+credential.Name, nil, credential)
+	c.JSON(http.StatusCreated, credential)
+}
+
+// UpdateCredential changes a credential's metadata and target bindings.
+// Secrets can only be changed through RotateCredential.
+func (h *Handler) UpdateCredential(c *gin.Context) {
+	credential, ok := h.credentialFromParam(c)
+	if !ok {
+		return
+	}
+
+	before := *credential
+
+	var request credentialRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if request.Secret != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Use the rotate endpoint to change a secret"})
+		return
+	}
+
+	if request.Name != "" {
+		credential.Name = strings.TrimSpace(request.Name)
+	}
+	if request.Type != "" {
+		credential.Type = strings.ToLower(strings.TrimSpace(request.Type))
+	}
+	if request.Username != "" {
+		credential.Username = request.Username
+	}
+	if request.Param != "" {
+		credential.Param = strings.TrimSpace(request.Param)
+	}
+	if request.Targets != nil {
+		credential.Targets = request.Targets
+	}
+
+	if err := validateCredential(credential); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.db.UpdateCredential(credential); err != nil {
+		requestLogger(c).Error("Error updating credential:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update credential"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Credential %q updated", credential.Name))
+	h.recordAuditChange(c, "credential.update", "credential:"+before.Name, before, credential)
+	c.JSON(http.StatusOK, credential)
+}
+
+// RotateCredential replaces a credential's secret
+func (h *Handler) RotateCredential(c *gin.Context) {
+	if !h.requireVault(c) {
+		return
+	}
+
+	credential, ok := h.credentialFromParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Secret string `json:"secret" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Secret is required"})
+		return
+	}
+
+	encrypted, err := h.vault.Encrypt([]byte(request.Secret))
+	if err != nil {
+		requestLogger(c).Error("Error encrypting credential:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt credential"})
+		return
+	}
+
+	if err := h.db.RotateCredential(credential.ID, encrypted); err != nil {
+		requestLogger(c).Error("Error rotating credential:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate credential"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Credential %q rotated", credential.Name))
+	h.recordAudit(c, "credential.rotate", "credential:"+credential.Name, "secret replaced")
+	c.JSON(http.StatusOK, gin.H{"message": "Credential rotated successfully"})
+}
+
+// DeleteCredential removes a credential and its bindings
+func (h *Handler) DeleteCredential(c *gin.Context) {
+	credential, ok := h.credentialFromParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeleteCredential(credential.ID); err != nil {
+		requestLogger(c).Error("Error deleting credential:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete credential"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Credential %q deleted", credential.Name))
+	h.recordAuditChange(c, "credential.delete", "credential:"+credential.Name, credential, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "Credential deleted successfully"})
+}
+
+// credentialFromParam loads the credential named by the :id route parameter,
+// writing an error response if it can't
+func (h *Handler) credentialFromParam(c *gin.Context) (*models.Credential, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid credential ID"})
+		return nil, false
+	}
+
+	credential, err := h.db.GetCredential(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching credential:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credential"})
+		return nil, false
+	}
+	if credential == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Credential not found"})
+		return nil, false
+	}
+
+	return credential, true
+}
+
+func (h *Handler) requireVault(c *gin.Context) bool {
+	if h.vault == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Credential vault is not available",
+			"hint":  "Set VAULT_KEY or VAULT_KEY_FILE and restart the relay",
+		})
+		return false
+	}
+	return true
+}
+
+func validateCredential(credential *models.Credential) error {
+	if credential.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	switch credential.Type {
+	case CredentialBasic:
+		if credential.Username == "" {
+			return fmt.Errorf("username is required for basic credentials")
+		}
+		credential.Param = ""
+	case CredentialBearer:
+		credential.Username = ""
+		credential.Param = ""
+	case CredentialHeader, CredentialQuery:
+		if credential.Param == "" {
+			return fmt.Errorf("param is required for %s credentials", credential.Type)
+		}
+		if strings.ContainsAny(credential.Param, " \t\r\n:=&") {
+			return fmt.Errorf("invalid param name %q", credential.Param)
+		}
+		credential.Username = ""
+	default:
+		return fmt.Errorf("type must be one of basic, bearer, header or query")
+	}
+
+	for i, target := range credential.Targets {
+		host, port, err := net.SplitHostPort(strings.TrimSpace(target))
+		if err != nil || host == "" {
+			return fmt.Errorf("target %q must be in HOST:PORT format", target)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("target %q has an invalid port", target)
+		}
+		credential.Targets[i] = net.JoinHostPort(host, port)
+	}
+	if credential.Targets == nil {
+		credential.Targets = make([]string, 0)
+	}
+
+	return nil
+}
+
+// loadUpstreamCredentials decrypts the credentials bound to a target
+func (h *Handler) loadUpstreamCredentials(target string) ([]upstreamCredential, error) {
+	credentials, err := h.db.GetCredentialsForTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) == 0 {
+		return nil, nil
+	}
+	if h.vault == nil {
+		return nil, fmt.Errorf("credential vault is not available")
+	}
+
+	upstream := make([]upstreamCredential, 0, len(credentials))
+	for _, credential := range credentials {
+		secret, err := h.vault.Decrypt(credential.EncryptedSecret)
+		if err != nil {
+			return nil, fmt.Errorf("credential %q: %v", credential.Name, err)
+		}
+		upstream = append(upstream, upstreamCredential{
+			id:       credential.ID,
+			credType: credential.Type,
+			username: credential.Username,
+			param:    credential.Param,
+			secret:   string(secret),
+		})
+	}
+
+	return upstream, nil
+}
+
+// injectCredentials adds the credentials to an outgoing upstream request
+func (h *Handler) injectCredentials(c *gin.Context, req *http.Request, credentials []upstreamCredential) {
+	for _, credential := range credentials {
+		switch credential.credType {
+		case CredentialBasic:
+			req.SetBasicAuth(credential.username, credential.secret)
+		case CredentialBearer:
+			req.Header.Set("Authorization", "Bearer "+credential.secret)
+		case CredentialHeader:
+			req.Header.Set(credential.param, credential.secret)
+		case CredentialQuery:
+			req.URL.RawQuery = setQueryParam(req.URL.RawQuery, credential.param, credential.secret)
+		}
+
+		if err := h.db.TouchCredential(credential.id); err != nil {
+			requestLogger(c).Warn("Failed to record credential use:", err)
+		}
+	}
+}
+
+// setQueryParam replaces a parameter in a raw query while leaving the
+// encoding and order of every other parameter untouched
+func setQueryParam(rawQuery, name, value string) string {
+	parts := make([]string, 0)
+	for _, part := range strings.Split(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		key := part
+		if i := strings.Index(part, "="); i >= 0 {
+			key = part[:i]
+		}
+		if unescaped, err := url.QueryUnescape(key); err == nil && unescaped == name {
+			continue
+		}
+		parts = append(parts, part)
+	}
+
+	parts = append(parts, url.QueryEscape(name)+"="+url.QueryEscape(value))
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lan-relay/internal/database"
+	"lan-relay/internal/inventory"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDevices lists the device inventory. Filters: q (name, hostname, IP, MAC
+// or vendor), vendor, online, seen_since (RFC3339) and limit.
+func (h *Handler) GetDevices(c *gin.Context) {
+	filter := database.DeviceFilter{
+		Query:       strings.TrimSpace(c.Query("q")),
+		Vendor:      strings.TrimSpace(c.Query("vendor")),
+		OnlineSince: h.onlineSince(),
+	}
+
+	if since := c.Query("seen_since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid seen_since, expected RFC3339"})
+			return
+		}
+		filter.SeenSince = t
+	}
+	if online := c.Query("online"); online != "" {
+		value, err := strconv.ParseBool(online)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid online value"})
+			return
+		}
+		filter.Online = &value
+	}
+	if limit := c.Query("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil || value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		filter.Limit = value
+	}
+
+	devices, err := h.db.GetDevices(filter)
+	if err != nil {
+		requestLogger(c).Error("Error fetching devices:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch devices"})
+		return
+	}
+
+	targets, err := h.targetsByHost()
+	if err != nil {
+		requestLogger(c).Error("Error fetching targets:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch targets"})
+		return
+	}
+	for i := range devices {
+		devices[i].Online = !devices[i].LastSeen.Before(filter.OnlineSince)
+		devices[i].Targets = targets[devices[i].IP]
+	}
+
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// GetDevice returns a device with its address history and linked targets
+func (h *Handler) GetDevice(c *gin.Context) {
+	device, ok := h.deviceFromParam(c)
+	if !ok {
+		return
+	}
+
+	addresses, err := h.db.GetDeviceAddresses(device.ID)
+	if err != nil {
+		requestLogger(c).Error("Error fetching device addresses:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch device addresses"})
+		return
+	}
+	targets, err := h.targetsByHost()
+	if err != nil {
+		requestLogger(c).Error("Error fetching targets:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch targets"})
+		return
+	}
+
+	device.Addresses = addresses
+	device.Targets = targets[device.IP]
+	device.Online = !device.LastSeen.Before(h.onlineSince())
+	c.JSON(http.StatusOK, device)
+}
+
+// UpdateDevice sets a device's friendly name
+func (h *Handler) UpdateDevice(c *gin.Context) {
+	device, ok := h.deviceFromParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	before := device.Name
+	device.Name = strings.TrimSpace(request.Name)
+	if err := h.db.UpdateDeviceName(device.ID, device.Name); err != nil {
+		requestLogger(c).Error("Error updating device:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Device %s renamed to %q", device.MAC, device.Name))
+	h.recordAuditChange(c, "device.update", "device:"+device.MAC, gin.H{"name": before}, gin.H{"name": device.Name})
+	device.Online = !device.LastSeen.Before(h.onlineSince())
+	c.JSON(http.StatusOK, device)
+}
+
+// DeleteDevice forgets a device and its history
+func (h *Handler) DeleteDevice(c *gin.Context) {
+	device, ok := h.deviceFromParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeleteDevice(device.ID); err != nil {
+		requestLogger(c).Error("Error deleting device:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete device"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Device %s deleted", device.MAC))
+	h.recordAudit(c, "device.delete", "device:"+device.MAC, device.Name)
+	c.JSON(http.StatusOK, gin.H{"message": "Device deleted successfully"})
+}
+
+// WakeDevice sends a Wake-on-LAN magic packet to a device
+func (h *Handler) WakeDevice(c *gin.Context) {
+	if h.hubUnavailable(c, "Wake-on-LAN") {
+		return
+	}
+	device, ok := h.deviceFromParam(c)
+	if !ok {
+		return
+	}
+
+	if err := inventory.Wake(device.MAC, h.cfg.WOLBroadcast); err != nil {
+		requestLogger(c).Error("Error sending Wake-on-LAN packet:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send Wake-on-LAN packet"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Wake-on-LAN packet sent to %s", device.MAC))
+	h.recordAudit(c, "device.wake", "device:"+device.MAC, "")
+	c.JSON(http.StatusOK, gin.H{"message": "Wake-on-LAN packet sent"})
+}
+
+// deviceFromParam loads the device named by the :id route parameter,
+// writing an error response if it can't
+func (h *Handler) deviceFromParam(c *gin.Context) (*models.Device, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		return nil, false
+	}
+
+	device, err := h.db.GetDevice(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching device:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch device"})
+		return nil, false
+	}
+	if device == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return nil, false
+	}
+
+	return device, true
+}
+
+// onlineSince is the last-seen time after which a device counts as online:
+// it was present in one of the last two neighbor table scans
+func (h *Handler) onlineSince() time.Time {
+	return time.Now().Add(-2 * time.Duration(h.cfg.InventoryIntervalSeconds) * time.Second)
+}
+
+// targetsByHost groups the registered targets by host so devices can be linked to them
+func (h *Handler) targetsByHost() (map[string][]models.Target, error) {
+	targets, err := h.db.GetTargets()
+	if err != nil {
+		return nil, err
+	}
+
+	byHost := make(map[string][]models.Target)
+	for _, target := range targets {
+		setProxyPath(&target)
+		byHost[target.Host] = append(byHost[target.Host], target)
+	}
+	return byHost, nil
+}
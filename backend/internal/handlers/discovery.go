@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"lan-relay/internal/discovery"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDiscovery lists the services found on the local network. Results are
+// cached for a minute unless refresh=true is given.
+func (h *Handler) GetDiscovery(c *gin.Context) {
+	if h.hubUnavailable(c, "LAN discovery") {
+		return
+	}
+	result := h.discoverer.Discover(c.Request.Context(), c.Query("refresh") == "true")
+
+	targets, err := h.db.GetTargets()
+	if err != nil {
+		requestLogger(c).Error("Error fetching targets:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch targets"})
+		return
+	}
+	registered := make(map[string]int, len(targets))
+	for _, target := range targets {
+		registered[net.JoinHostPort(target.Host, strconv.Itoa(target.Port))] = target.ID
+	}
+
+	// Only services the proxy can reach are listed
+	services := make([]models.DiscoveredService, 0, len(result.Services))
+	for _, service := range result.Services {
+		if !isPrivateIP(service.Host) {
+			continue
+		}
+		service.TargetID = registered[net.JoinHostPort(service.Host, strconv.Itoa(service.Port))]
+		services = append(services, service)
+	}
+	result.Services = services
+
+	c.JSON(http.StatusOK, result)
+}
+
+// RegisterDiscovered registers a discovered service as a proxy target
+func (h *Handler) RegisterDiscovered(c *gin.Context) {
+	if h.hubUnavailable(c, "LAN discovery") {
+		return
+	}
+	service, ok := h.discoverer.Lookup(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Discovered service not found",
+			"hint":  "Run discovery again to refresh the results",
+		})
+		return
+	}
+
+	var request struct {
+		Name string `json:"name"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+	}
+
+	// Scanned services are only named by address, so their page title reads better
+	name := request.Name
+	if name == "" {
+		name = service.Name
+		if service.Source == discovery.SourceScan && service.Title != "" {
+			name = service.Title
+		}
+	}
+
+	h.registerTarget(c, &models.Target{
+		Name:   name,
+		Host:   service.Host,
+		Port:   service.Port,
+		Source: service.Source,
+	})
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/http2"
+)
+
+// GRPCTargetHeader names the HOST:PORT target for native gRPC clients, which
+// can't add the /proxy/HOST:PORT prefix to their method paths
+const GRPCTargetHeader = "X-Relay-Target"
+
+type grpcMode int
+
+const (
+	grpcNone grpcMode = iota
+	grpcNative
+	grpcWeb
+	grpcWebText
+)
+
+// grpcModeOf classifies a request by its content type
+func grpcModeOf(contentType string) grpcMode {
+	contentType = strings.ToLower(contentType)
+	switch {
+	case strings.HasPrefix(contentType, "application/grpc-web-text"):
+		return grpcWebText
+	case strings.HasPrefix(contentType, "application/grpc-web"):
+		return grpcWeb
+	case strings.HasPrefix(contentType, "application/grpc"):
+		return grpcNative
+	}
+	return grpcNone
+}
+
+// RouteGRPC sends native gRPC calls carrying an X-Relay-Target header to the
+// proxy, as if they had been made to /proxy/HOST:PORT/package.Service/Method
+func (h *Handler) RouteGRPC() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target := c.GetHeader(GRPCTargetHeader)
+		if target == "" || grpcModeOf(c.GetHeader("Content-Type")) == grpcNone ||
+			strings.HasPrefix(c.Request.URL.Path, "/proxy/") {
+			c.Next()
+			return
+		}
+
+		c.Request.URL.Path = "/proxy/" + target + c.Request.URL.Path
+		c.Request.URL.RawPath = ""
+
+		// Runs ahead of the route groups, so it checks access itself
+		if !h.authenticate(c) || !h.authorize(c) {
+			return
+		}
+		h.ProxyRequest(c)
+		c.Abort()
+	}
+}
+
+// grpcTransport returns the HTTP/2 transport for a gRPC target, reached
+// through an agent's transports when they're given
+func (h *Handler) grpcTransport(target string, agent *agentTransports) (http.RoundTripper, bool) {
+	h2c, withTLS := h.grpcH2C, h.grpcTLS
+	if agent != nil {
+		h2c, withTLS = agent.grpcH2C, agent.grpcTLS
+	}
+	for _, tlsTarget := range h.cfg.GRPCTLSTargets {
+		if tlsTarget == target {
+			return withTLS, true
+		}
+	}
+	return h2c, false
+}
+
+// newGRPCTransports creates the HTTP/2 transports used for h2c and TLS gRPC
+// targets. They connect with dial, or directly when it's nil.
+func newGRPCTransports(skipVerify bool, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (h2c, withTLS *http2.Transport) {
+	direct := dial == nil
+	if direct {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+
+	h2c = &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		},
+	}
+	withTLS = &http2.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: skipVerify,
+			NextProtos:         []string{"h2"},
+		},
+	}
+	if !direct {
+		withTLS.DialTLSContext = func(ctx context.Context, network, addr string, config *tls.Config) (net.Conn, error) {
+			conn, err := dial(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsConn := tls.Client(conn, config)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		}
+	}
+	return h2c, withTLS
+}
+
+// translateGRPCWebRequest turns a gRPC-Web request into a native gRPC one
+func translateGRPCWebRequest(req *http.Request, mode grpcMode) {
+	contentType := req.Header.Get("Content-Type")
+	prefix := "application/grpc-web"
+	if mode == grpcWebText {
+		prefix = "application/grpc-web-text"
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{base64.NewDecoder(base64.StdEncoding, req.Body), req.Body}
+	}
+	req.Header.Set("Content-Type", "application/grpc"+contentType[len(prefix):])
+	req.Header.Set("Te", "trailers")
+	req.Header.Del("Content-Length")
+	req.Header.Del("X-Grpc-Web")
+	req.ContentLength = -1
+}
+
+// translateGRPCWebResponse turns a native gRPC response into a gRPC-Web one,
+// moving the HTTP/2 trailers into a trailer frame at the end of the body
+func translateGRPCWebResponse(resp *http.Response, mode grpcMode) *grpcWebBody {
+	contentType := resp.Header.Get("Content-Type")
+	prefix := "application/grpc-web"
+	if mode == grpcWebText {
+		prefix = "application/grpc-web-text"
+	}
+	if strings.HasPrefix(strings.ToLower(contentType), "application/grpc") {
+		resp.Header.Set("Content-Type", prefix+contentType[len("application/grpc"):])
+	}
+
+	body := &grpcWebBody{body: resp.Body, resp: resp, text: mode == grpcWebText}
+	resp.Body = body
+	resp.Trailer = nil
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	resp.Header.Del("Trailer")
+	return body
+}
+
+// grpcWebBody appends the gRPC trailers to the response body as a gRPC-Web
+// trailer frame, base64-encoding each chunk for grpc-web-text clients
+type grpcWebBody struct {
+	body    io.ReadCloser
+	resp    *http.Response
+	trailer http.Header // set once the upstream body is drained
+	text    bool
+	pending []byte
+	done    bool
+}
+
+func (b *grpcWebBody) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+
+		buf := make([]byte, 32*1024)
+		n, err := b.body.Read(buf)
+		chunk := buf[:n]
+		if err == io.EOF {
+			// The transport stores the trailers on the response when the body ends.
+			// They're taken back off so ReverseProxy doesn't also send them as HTTP trailers.
+			b.trailer, b.resp.Trailer = b.resp.Trailer, nil
+			chunk = append(chunk, grpcWebTrailerFrame(b.trailer)...)
+			b.done = true
+		} else if err != nil {
+			return 0, err
+		}
+
+		if b.text && len(chunk) > 0 {
+			chunk = []byte(base64.StdEncoding.EncodeToString(chunk))
+		}
+		b.pending = chunk
+	}
+
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+func (b *grpcWebBody) Close() error {
+	return b.body.Close()
+}
+
+// grpcWebTrailerFrame encodes trailers as a gRPC-Web trailer frame, or nothing
+// for trailers-only responses whose status was already sent in the headers
+func grpcWebTrailerFrame(trailer http.Header) []byte {
+	if len(trailer) == 0 {
+		return nil
+	}
+
+	var block strings.Builder
+	for name, values := range trailer {
+		for _, value := range values {
+			block.WriteString(strings.ToLower(name) + ": " + value + "\r\n")
+		}
+	}
+
+	size := block.Len()
+	frame := []byte{0x80, byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size)}
+	return append(frame, block.String()...)
+}
+
+// grpcStatus reads the gRPC status from a response's headers (trailers-only
+// responses) or its trailers, returning nil if none was sent
+func grpcStatus(header, trailer http.Header) (*int, string) {
+	value, message := header.Get("Grpc-Status"), header.Get("Grpc-Message")
+	if value == "" {
+		value, message = trailer.Get("Grpc-Status"), trailer.Get("Grpc-Message")
+	}
+
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, ""
+	}
+	if decoded, err := url.PathUnescape(message); err == nil {
+		message = decoded
+	}
+	return &code, message
+}
+
+// grpcError describes a non-OK gRPC status for the log entry
+func grpcError(code *int, message string) string {
+	if code == nil || *code == 0 {
+		return ""
+	}
+	if message == "" {
+		return fmt.Sprintf("grpc status %d", *code)
+	}
+	return fmt.Sprintf("grpc status %d: %s", *code, message)
+}
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
@@ -9,56 +11,120 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"lan-relay/internal/config"
 	"lan-relay/internal/database"
-	"lan-relay/internal/logger"
+	"lan-relay/internal/discovery"
+	"lan-relay/internal/har"
+	"lan-relay/internal/hub"
 	"lan-relay/internal/models"
 	"lan-relay/internal/ngrok"
+	"lan-relay/internal/rules"
+	"lan-relay/internal/share"
+	"lan-relay/internal/tunnel"
+	"lan-relay/internal/vault"
 
 	"github.com/gin-gonic/gin"
 )
 
+const version = "1.0.0"
+
 type Handler struct {
-	db           *database.DB
-	startTime    time.Time
-	ngrokManager *ngrok.NgrokManager
-	ngrokMutex   sync.Mutex
+	db          *database.DB
+	cfg         *config.Config
+	vault       *vault.Vault
+	startTime   time.Time
+	tunnel      tunnel.Provider
+	tunnelMutex sync.Mutex
+	tunnelURL   atomic.Value // public URL of the running tunnel, allowed by CORS
+	tunnelConns *tunnelListener
+	grpcH2C     http.RoundTripper
+	grpcTLS     http.RoundTripper
+	discoverer  *discovery.Discoverer
+	sso         ssoState
+	twoFactor   twoFactorState
+	shareSigner *share.Signer
+	ipRules     ipRuleState
+	// trustedProxies may say who the client is in forwarding headers
+	trustedProxies []*net.IPNet
+	// hub is set when targets are reached through connected agents
+	hub    *hub.Hub
+	agents agentState
 }
 
-func New(db *database.DB) *Handler {
+// New creates the handler set. credentialVault may be nil, in which case
+// upstream credentials can't be stored or injected.
+func New(db *database.DB, cfg *config.Config, credentialVault *vault.Vault) *Handler {
+	grpcH2C, grpcTLS := newGRPCTransports(cfg.GRPCTLSSkipVerify, nil)
 	return &Handler{
-		db:        db,
-		startTime: time.Now(),
+		db:          db,
+		cfg:         cfg,
+		vault:       credentialVault,
+		startTime:   time.Now(),
+		tunnelConns: newTunnelListener(),
+		grpcH2C:     grpcH2C,
+		grpcTLS:     grpcTLS,
+		discoverer: discovery.New(discovery.Options{
+			Timeout: time.Duration(cfg.DiscoveryTimeoutMs) * time.Millisecond,
+			MDNS:    cfg.DiscoveryMDNS,
+			SSDP:    cfg.DiscoverySSDP,
+			Subnets: cfg.DiscoverySubnets,
+			Ports:   cfg.DiscoveryPorts,
+		}),
+		trustedProxies: parseTrustedProxies(cfg.TrustedProxies),
 	}
 }
 
 // ProxyRequest handles proxying HTTP requests to internal network targets
 func (h *Handler) ProxyRequest(c *gin.Context) {
-	start := time.Now()
+	// A hub may be asked for a particular agent: /proxy/@AGENT/HOST:PORT/path
+	proxyURL, prefix := c.Request.URL, "/proxy/"
+	if h.hub != nil {
+		if name, stripped, ok := splitAgentPath(proxyURL); ok {
+			c.Set(agentKey, name)
+			proxyURL, prefix = stripped, "/proxy/@"+url.PathEscape(name)+"/"
+		}
+	}
 
 	// Extract target from path: /proxy/HOST:PORT/path
-	fullPath := c.Param("path")
-	if fullPath == "" || !strings.HasPrefix(fullPath, "/") {
+	hostPort, targetPath, ok := splitProxyPath(proxyURL)
+	if !ok {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proxy path format. Use: /proxy/HOST:PORT/path"})
 		return
 	}
 
-	// Remove leading slash and split
-	cleanPath := strings.TrimPrefix(fullPath, "/")
-	parts := strings.SplitN(cleanPath, "/", 2)
-	if len(parts) < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proxy path format. Use: /proxy/HOST:PORT/path"})
-		return
+	h.proxyTo(c, hostPort, targetPath, prefix+hostPort)
+}
+
+// proxyTo forwards a request to a target and logs it. targetPath is the
+// escaped path on the target, and proxyPrefix the path the target is served
+// under, which links in its HTML pages are rewritten to.
+func (h *Handler) proxyTo(c *gin.Context, hostPort, targetPath, proxyPrefix string) {
+	start := time.Now()
+
+	// The relay's session cookie is never forwarded or captured
+	if h.cfg.AuthEnabled {
+		removeCookie(c.Request.Header, SessionCookie)
 	}
 
-	hostPort := parts[0]
-	targetPath := "/"
-	if len(parts) > 1 {
-		targetPath = "/" + parts[1]
+	// The bare proxy prefix is treated as the target's root directory, so
+	// relative links in the returned page resolve under it
+	if targetPath == "" {
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			location := c.Request.URL.EscapedPath() + "/"
+			if c.Request.URL.RawQuery != "" {
+				location += "?" + c.Request.URL.RawQuery
+			}
+			c.Redirect(http.StatusMovedPermanently, location)
+			return
+		}
+		targetPath = "/"
 	}
 
 	// Parse host and port
@@ -80,21 +146,136 @@ func (h *Handler) ProxyRequest(c *gin.Context) {
 		return
 	}
 
-	// Create target URL
-	target := fmt.Sprintf("http://%s:%d%s", host, port, targetPath)
+	// The target may only accept some clients
+	if !h.targetIPAllowed(c, net.JoinHostPort(host, portStr)) {
+		return
+	}
+
+	// A hub reaches the target through an agent
+	var (
+		agentName string
+		agent     *agentTransports
+	)
+	if h.hub != nil {
+		var session *hub.Session
+		if session, agent = h.agentFor(c); session == nil {
+			return
+		}
+		agentName = session.Name()
+	}
+
+	// Create target URL, keeping the client's path encoding and query string as sent
+	targetURL, err := buildTargetURL(host, portStr, targetPath, c.Request.URL)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proxy path encoding"})
+		return
+	}
+
+	// gRPC calls need HTTP/2 to the target. gRPC-Web is translated to gRPC when
+	// enabled, otherwise it's passed through for targets that serve it natively.
+	mode := grpcModeOf(c.GetHeader("Content-Type"))
+	if (mode == grpcWeb || mode == grpcWebText) && !h.cfg.GRPCWebEnabled {
+		mode = grpcNone
+	}
+	var transport http.RoundTripper
+	if agent != nil {
+		transport = agent.http
+	}
+	if mode != grpcNone {
+		var useTLS bool
+		transport, useTLS = h.grpcTransport(net.JoinHostPort(host, portStr), agent)
+		if useTLS {
+			targetURL.Scheme = "https"
+		}
+	}
+	target := targetURL.String()
+
+	// Load the header rewrite rules for this target
+	headerRules, err := h.db.GetHeaderRules(net.JoinHostPort(host, portStr))
+	if err != nil {
+		requestLogger(c).Error("Error fetching header rules:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load header rules"})
+		return
+	}
+
+	// Load the upstream credentials bound to this target
+	credentials, err := h.loadUpstreamCredentials(net.JoinHostPort(host, portStr))
+	if err != nil {
+		requestLogger(c).Error("Error loading upstream credentials:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load upstream credentials"})
+		return
+	}
+
+	ruleVars := ruleVariables(c.ClientIP(), requestIDFrom(c), c.Request.Method, targetPath, host, portStr)
 
-	// Create reverse proxy with custom response modifier for HTML rewriting
+	// Snapshot the request before the proxy modifies it
+	var capture *proxyCapture
+	if h.cfg.CaptureEnabled {
+		capture = newProxyCapture(c.Request, h.cfg.CaptureMaxBodyBytes, h.cfg.CaptureContentTypes)
+	}
+
+	var (
+		streamed        bool
+		proxyErr        error
+		upstream        *http.Response
+		upstreamWebBody *grpcWebBody
+	)
+
+	// Create reverse proxy with custom response modifier for HTML rewriting.
+	// FlushInterval keeps long-poll and chunked responses moving; event streams
+	// and unknown-length bodies are flushed immediately by ReverseProxy itself.
 	proxy := &httputil.ReverseProxy{
+		FlushInterval: 100 * time.Millisecond,
+		Transport:     transport,
 		Director: func(req *http.Request) {
-			targetURL, _ := url.Parse(target)
-			req.URL = targetURL
+			outURL := *targetURL
+			req.URL = &outURL
 			req.Host = targetURL.Host
-			req.Header.Set("X-Forwarded-For", c.ClientIP())
+			// ReverseProxy appends the connecting address to X-Forwarded-For, so
+			// only a chain from a trusted proxy is kept; anything else is the
+			// client's word. An empty entry marks the header as not removed.
+			if !h.trustedPeer(c) || len(req.Header.Values("X-Forwarded-For")) == 0 {
+				req.Header["X-Forwarded-For"] = []string{}
+			}
 			req.Header.Set("X-Forwarded-Proto", "http")
+			req.Header.Set(RequestIDHeader, requestIDFrom(c))
+			req.Header.Del(GRPCTargetHeader)
+			if mode == grpcWeb || mode == grpcWebText {
+				translateGRPCWebRequest(req, mode)
+			}
+
+			if hostHeader := rules.Apply(req.Header, headerRules, rules.DirectionRequest, ruleVars); hostHeader != "" {
+				req.Host = hostHeader
+			}
+			// Credentials are injected last so rules can't rename or strip them
+			h.injectCredentials(c, req, credentials)
+
+			// A nil entry stops ReverseProxy from re-adding a header removed by a
+			// rule, while a missing one lets it start the chain
+			if values, ok := req.Header["X-Forwarded-For"]; !ok {
+				req.Header["X-Forwarded-For"] = nil
+			} else if len(values) == 0 {
+				delete(req.Header, "X-Forwarded-For")
+			}
 		},
 		ModifyResponse: func(resp *http.Response) error {
+			c.Set(upstreamKey, true)
+			h.stripUpstreamCORS(resp.Header)
+			rules.Apply(resp.Header, headerRules, rules.DirectionResponse, ruleVars)
+
+			// Streams are passed through untouched, rewriting would require buffering them
+			streamed = isStreamingResponse(resp)
+
+			// Trailers arrive after the body, so the gRPC status is read once the response is complete
+			if mode != grpcNone {
+				upstream = resp
+			}
+			if mode == grpcWeb || mode == grpcWebText {
+				upstreamWebBody = translateGRPCWebResponse(resp, mode)
+			}
+
 			// Only modify HTML responses
-			if isHTMLResponse(resp) {
+			if isHTMLResponse(resp) && !streamed {
 				// Read the response body
 				body, err := io.ReadAll(resp.Body)
 				if err != nil {
@@ -103,7 +284,6 @@ func (h *Handler) ProxyRequest(c *gin.Context) {
 				resp.Body.Close()
 
 				// Create HTML rewriter
-				proxyPrefix := fmt.Sprintf("/proxy/%s:%d", host, port)
 				rewriter := NewHTMLRewriter(proxyPrefix, host)
 
 				// Rewrite HTML content
@@ -117,22 +297,84 @@ func (h *Handler) ProxyRequest(c *gin.Context) {
 				// Add custom header to indicate the response was modified
 				resp.Header.Set("X-Proxy-Modified", "true")
 
-				logger.Debug(fmt.Sprintf("Rewrote HTML response for %s (original: %d bytes, modified: %d bytes)",
+				requestLogger(c).Debug(fmt.Sprintf("Rewrote HTML response for %s (original: %d bytes, modified: %d bytes)",
 					target, len(body), len(modifiedBody)))
 			}
+
+			if capture != nil {
+				capture.captureResponse(resp)
+			}
 			return nil
 		},
+		ErrorHandler: func(rw http.ResponseWriter, req *http.Request, err error) {
+			proxyErr = err
+			requestLogger(c).Warn(fmt.Sprintf("Upstream request to %s failed: %v", target, err))
+			rw.WriteHeader(http.StatusBadGateway)
+		},
 	}
 
+	// Log once the response has been fully written. ReverseProxy aborts the handler
+	// with http.ErrAbortHandler when a stream breaks mid-copy; that is recovered here
+	// so the entry is still recorded, and AbortBrokenStreams closes the connection.
+	defer func() {
+		aborted := recover()
+		if aborted != nil && aborted != http.ErrAbortHandler {
+			panic(aborted)
+		}
+
+		status := c.Writer.Status()
+		if status == 0 {
+			status = 200 // Default status if not set
+		}
+		errorMsg := ""
+		if proxyErr != nil {
+			errorMsg = proxyErr.Error()
+		}
+		if aborted != nil {
+			errorMsg = "response stream aborted"
+		}
+		var grpcCode *int
+		if upstream != nil {
+			trailer := upstream.Trailer
+			if upstreamWebBody != nil {
+				trailer = upstreamWebBody.trailer
+			}
+			var grpcMessage string
+			grpcCode, grpcMessage = grpcStatus(upstream.Header, trailer)
+			if errorMsg == "" {
+				errorMsg = grpcError(grpcCode, grpcMessage)
+			}
+		}
+
+		duration := time.Since(start)
+		logID := h.logRequest(c, &models.LogEntry{
+			TargetHost: host,
+			TargetPort: portStr,
+			Path:       targetPath,
+			StatusCode: status,
+			Duration:   duration.Milliseconds(),
+			Error:      errorMsg,
+			Streamed:   streamed,
+			GRPCStatus: grpcCode,
+			Agent:      agentName,
+		})
+		if capture != nil && logID != 0 {
+			if err := h.db.InsertLogCapture(capture.finish(logID)); err != nil {
+				requestLogger(c).Error("Failed to store request capture:", err)
+			}
+		}
+		if streamed {
+			requestLogger(c).Info(fmt.Sprintf("Stream from %s ended after %v (status %d, %d bytes)",
+				target, duration.Round(time.Millisecond), status, responseBytes(c)))
+		}
+
+		if aborted != nil {
+			abortStream(c)
+		}
+	}()
+
 	// Perform the proxy request
 	proxy.ServeHTTP(c.Writer, c.Request)
-
-	// Log the request
-	status := c.Writer.Status()
-	if status == 0 {
-		status = 200 // Default status if not set
-	}
-	h.logRequest(c, host, portStr, targetPath, status, time.Since(start), "")
 }
 
 // HealthCheck returns the health status of the service
@@ -140,7 +382,7 @@ func (h *Handler) HealthCheck(c *gin.Context) {
 	response := models.HealthResponse{
 		Status:    "ok",
 		Timestamp: time.Now(),
-		Version:   "1.0.0",
+		Version:   version,
 	}
 	c.JSON(http.StatusOK, response)
 }
@@ -150,18 +392,20 @@ func (h *Handler) GetStatus(c *gin.Context) {
 	totalRequests, _ := h.db.GetLogCount()
 	uptime := time.Since(h.startTime)
 
-	// Check ngrok status
+	// Check tunnel status
 	ngrokStatus := "disconnected"
 	ngrokURL := ""
+	tunnelName := ""
 
-	h.ngrokMutex.Lock()
-	if h.ngrokManager != nil {
-		if url, isActive := h.ngrokManager.GetStatus(); isActive {
+	h.tunnelMutex.Lock()
+	if h.tunnel != nil {
+		tunnelName = h.tunnel.Name()
+		if status := h.tunnel.Status(); status.State == tunnel.StateRunning {
 			ngrokStatus = "connected"
-			ngrokURL = url
+			ngrokURL = status.URL
 		}
 	}
-	h.ngrokMutex.Unlock()
+	h.tunnelMutex.Unlock()
 
 	status := models.SystemStatus{
 		Online:        true,
@@ -170,6 +414,7 @@ func (h *Handler) GetStatus(c *gin.Context) {
 		Uptime:        uptime.String(),
 		NgrokStatus:   ngrokStatus,
 		NgrokURL:      ngrokURL,
+		TunnelName:    tunnelName,
 	}
 
 	c.JSON(http.StatusOK, status)
@@ -193,9 +438,20 @@ func (h *Handler) GetLogs(c *gin.Context) {
 		offset = 0
 	}
 
-	logs, err := h.db.GetLogs(limit, offset)
+	filter, err := parseLogFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.Limit = limit
+	filter.Offset = offset
+	if !h.limitLogTargets(c, &filter) {
+		return
+	}
+
+	logs, err := h.db.GetLogs(filter)
 	if err != nil {
-		logger.Error("Error fetching logs:", err)
+		requestLogger(c).Error("Error fetching logs:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch logs"})
 		return
 	}
@@ -207,15 +463,131 @@ func (h *Handler) GetLogs(c *gin.Context) {
 	})
 }
 
+// GetLogDetail returns a single log entry with its captured request/response
+func (h *Handler) GetLogDetail(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid log ID"})
+		return
+	}
+
+	entry, err := h.db.GetLogEntry(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching log entry:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch log entry"})
+		return
+	}
+	if entry == nil || !h.logEntryVisible(c, *entry) {
+		if !c.IsAborted() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Log entry not found"})
+		}
+		return
+	}
+
+	capture, err := h.db.GetLogCapture(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching log capture:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch log capture"})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.LogDetail{LogEntry: *entry, Capture: capture})
+}
+
+// logEntryVisible reports whether the caller may see a log entry, answering
+// 500 if that can't be checked
+func (h *Handler) logEntryVisible(c *gin.Context, entry models.LogEntry) bool {
+	visible, err := h.targetVisibility(c)
+	if err != nil {
+		requestLogger(c).Error("Error fetching target grants:", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return false
+	}
+	return visible == nil || visible(entry.TargetHost, entry.TargetPort)
+}
+
+// limitLogTargets narrows a log filter to the targets the caller may see,
+// answering 500 if that can't be checked. Filtering in the query rather than
+// afterwards keeps limits and offsets right.
+func (h *Handler) limitLogTargets(c *gin.Context, filter *database.LogFilter) bool {
+	visible, err := h.targetVisibility(c)
+	if err != nil {
+		requestLogger(c).Error("Error fetching target grants:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return false
+	}
+	if visible == nil {
+		return true
+	}
+
+	targets, err := h.db.GetLogTargets()
+	if err != nil {
+		requestLogger(c).Error("Error fetching log targets:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch logs"})
+		return false
+	}
+	filter.Targets = make([]database.LogTarget, 0, len(targets))
+	for _, target := range targets {
+		if visible(target.Host, target.Port) {
+			filter.Targets = append(filter.Targets, target)
+		}
+	}
+	return true
+}
+
+// ExportHAR exports the logs matching the query filters as a HAR 1.2 file
+func (h *Handler) ExportHAR(c *gin.Context) {
+	filter, err := parseLogFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter.Limit = 5000
+	if limit, err := strconv.Atoi(c.Query("limit")); err == nil && limit > 0 && limit < filter.Limit {
+		filter.Limit = limit
+	}
+	// Only targets the caller can reach are exported, with their captures
+	if !h.limitLogTargets(c, &filter) {
+		return
+	}
+
+	logs, err := h.db.GetLogs(filter)
+	if err != nil {
+		requestLogger(c).Error("Error fetching logs:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch logs"})
+		return
+	}
+
+	captures := make(map[int]*models.LogCapture)
+	for _, log := range logs {
+		if !log.HasCapture {
+			continue
+		}
+		capture, err := h.db.GetLogCapture(log.ID)
+		if err != nil {
+			requestLogger(c).Error("Error fetching log capture:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch log capture"})
+			return
+		}
+		captures[log.ID] = capture
+	}
+
+	filename := fmt.Sprintf("lan-relay-%s.har", time.Now().Format("20060102-150405"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.JSON(http.StatusOK, har.Build(version, logs, captures))
+}
+
 // ClearLogs clears all proxy logs
 func (h *Handler) ClearLogs(c *gin.Context) {
 	if err := h.db.ClearLogs(); err != nil {
-		logger.Error("Error clearing logs:", err)
+		requestLogger(c).Error("Error clearing logs:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear logs"})
 		return
 	}
 
-	logger.Info("Logs cleared by user")
+	requestLogger(c).Info("Logs cleared by user")
+	h.recordAudit(c, "logs.clear", "logs", "")
 	c.JSON(http.StatusOK, gin.H{"message": "Logs cleared successfully"})
 }
 
@@ -223,30 +595,50 @@ func (h *Handler) ClearLogs(c *gin.Context) {
 func (h *Handler) GetSettings(c *gin.Context) {
 	settings, err := h.db.GetSettings()
 	if err != nil {
-		logger.Error("Error fetching settings:", err)
+		requestLogger(c).Error("Error fetching settings:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
 		return
 	}
 
-	// Don't expose the full token in the response for security
-	tokenMasked := ""
-	if len(settings.NgrokToken) > 8 {
-		tokenMasked = settings.NgrokToken[:4] + "****" + settings.NgrokToken[len(settings.NgrokToken)-4:]
-	} else if len(settings.NgrokToken) > 0 {
-		tokenMasked = "****"
-	}
+	// Don't expose the full token or client secret in the response for security
+	oidc := settings.OIDC
+	oidc.ClientSecret = maskSecret(oidc.ClientSecret)
+	tunnelSettings := settings.Tunnel
+	tunnelSettings.Provider = tunnelProviderName(settings)
+	tunnelSettings.CloudflaredToken = maskSecret(tunnelSettings.CloudflaredToken)
+	tunnelSettings.FRPToken = maskSecret(tunnelSettings.FRPToken)
+	tunnelSettings.SSHPrivateKey = maskSecret(strings.TrimSpace(tunnelSettings.SSHPrivateKey))
 
 	c.JSON(http.StatusOK, gin.H{
-		"ngrok_token":  tokenMasked,
+		"ngrok_token":  maskSecret(settings.NgrokToken),
 		"ngrok_domain": settings.NgrokDomain,
+		"oidc":         oidc,
+		"tunnel":       tunnelSettings,
 	})
 }
 
+// maskSecret hides all but the ends of a secret
+func maskSecret(secret string) string {
+	if len(secret) > 8 {
+		return secret[:4] + "****" + secret[len(secret)-4:]
+	} else if len(secret) > 0 {
+		return "****"
+	}
+	return ""
+}
+
+// isMaskedSecret reports whether a value is a secret masked by maskSecret
+func isMaskedSecret(value string) bool {
+	return strings.Contains(value, "****")
+}
+
 // UpdateSettings updates application settings
 func (h *Handler) UpdateSettings(c *gin.Context) {
 	var request struct {
-		NgrokToken  string `json:"ngrok_token"`
-		NgrokDomain string `json:"ngrok_domain"`
+		NgrokToken  string                 `json:"ngrok_token"`
+		NgrokDomain string                 `json:"ngrok_domain"`
+		OIDC        *models.OIDCSettings   `json:"oidc"`
+		Tunnel      *models.TunnelSettings `json:"tunnel"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -254,113 +646,70 @@ func (h *Handler) UpdateSettings(c *gin.Context) {
 		return
 	}
 
-	settings := &models.Settings{
-		NgrokToken:  request.NgrokToken,
-		NgrokDomain: request.NgrokDomain,
-	}
-
-	if err := h.db.UpdateSettings(settings); err != nil {
-		logger.Error("Error updating settings:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
-		return
-	}
-
-	logger.Info("Settings updated by user")
-	c.JSON(http.StatusOK, gin.H{"message": "Settings updated successfully"})
-}
-
-// StartNgrokTunnel starts an ngrok tunnel
-func (h *Handler) StartNgrokTunnel(c *gin.Context) {
-	h.ngrokMutex.Lock()
-	defer h.ngrokMutex.Unlock()
-
 	settings, err := h.db.GetSettings()
 	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to get settings: %v", err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
+		requestLogger(c).Error("Error fetching settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
 		return
 	}
+	before := *settings
 
-	if settings.NgrokToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Ngrok token not configured. Please set your token in settings first.",
-			"hint":  "Get your token from: https://dashboard.ngrok.com/get-started/your-authtoken",
-		})
-		return
+	// The token is sent back masked when it wasn't changed
+	if !isMaskedSecret(request.NgrokToken) {
+		settings.NgrokToken = request.NgrokToken
 	}
-
-	// Stop any existing tunnel
-	if h.ngrokManager != nil {
-		h.ngrokManager.StopTunnel()
+	settings.NgrokDomain = request.NgrokDomain
+
+	// SSO settings are only replaced when sent. An empty or masked client
+	// secret keeps the stored one.
+	if request.OIDC != nil {
+		oidc := *request.OIDC
+		if oidc.ClientSecret == "" || isMaskedSecret(oidc.ClientSecret) {
+			oidc.ClientSecret = settings.OIDC.ClientSecret
+		}
+		if err := validateOIDCSettings(oidc); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		settings.OIDC = oidc
 	}
 
-	h.ngrokManager = ngrok.NewManager(settings.NgrokToken, settings.NgrokDomain, 8080)
-
-	// Test connection first
-	logger.Info("Testing ngrok authentication...")
-	if err := h.ngrokManager.TestConnection(); err != nil {
-		logger.Error(fmt.Sprintf("Ngrok authentication failed: %v", err))
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Invalid ngrok token. Please check your token in settings.",
-			"hint":    "Get your token from: https://dashboard.ngrok.com/get-started/your-authtoken",
-			"details": err.Error(),
-		})
-		return
+	// Tunnel settings are also only replaced when sent, keeping the stored
+	// tokens when they come back masked
+	if request.Tunnel != nil {
+		tunnelSettings := *request.Tunnel
+		tunnelSettings.Provider = strings.ToLower(strings.TrimSpace(tunnelSettings.Provider))
+		if tunnelSettings.Provider == "" {
+			tunnelSettings.Provider = "ngrok"
+		}
+		if !slices.Contains(tunnelProviders, tunnelSettings.Provider) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Unknown tunnel provider %q", tunnelSettings.Provider),
+				"hint":  "Use one of: " + strings.Join(tunnelProviders, ", "),
+			})
+			return
+		}
+		if isMaskedSecret(tunnelSettings.CloudflaredToken) {
+			tunnelSettings.CloudflaredToken = settings.Tunnel.CloudflaredToken
+		}
+		if isMaskedSecret(tunnelSettings.FRPToken) {
+			tunnelSettings.FRPToken = settings.Tunnel.FRPToken
+		}
+		if isMaskedSecret(tunnelSettings.SSHPrivateKey) {
+			tunnelSettings.SSHPrivateKey = settings.Tunnel.SSHPrivateKey
+		}
+		settings.Tunnel = tunnelSettings
 	}
 
-	logger.Info("Starting ngrok tunnel...")
-	url, err := h.ngrokManager.StartTunnel()
-	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to start ngrok tunnel: %v", err))
-
-		// Provide specific error messages based on the error
-		errorMsg := "Failed to start tunnel"
-		hint := "Please check your internet connection and try again."
-
-		if strings.Contains(err.Error(), "token") {
-			errorMsg = "Authentication failed"
-			hint = "Please verify your ngrok token in settings."
-		} else if strings.Contains(err.Error(), "timeout") {
-			errorMsg = "Tunnel startup timeout"
-			hint = "Ngrok is taking longer than expected. Please try again."
-		} else if strings.Contains(err.Error(), "connect: connection refused") {
-			errorMsg = "Ngrok API connection failed"
-			hint = "Ngrok process may not be starting properly. Check if port 4040 is available."
-		}
-
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error":   errorMsg,
-			"hint":    hint,
-			"details": err.Error(),
-		})
+	if err := h.db.UpdateSettings(settings); err != nil {
+		requestLogger(c).Error("Error updating settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
 		return
 	}
 
-	logger.Info(fmt.Sprintf("Ngrok tunnel started successfully: %s", url))
-	c.JSON(http.StatusOK, gin.H{
-		"url":     url,
-		"message": "🎉 Tunnel started successfully! You can now access your relay from anywhere using this URL.",
-		"usage":   fmt.Sprintf("Try: curl %s/api/health", url),
-	})
-}
-
-// StopNgrokTunnel stops the ngrok tunnel
-func (h *Handler) StopNgrokTunnel(c *gin.Context) {
-	h.ngrokMutex.Lock()
-	defer h.ngrokMutex.Unlock()
-
-	if h.ngrokManager != nil {
-		if err := h.ngrokManager.StopTunnel(); err != nil {
-			logger.Error(fmt.Sprintf("Failed to stop ngrok tunnel: %v", err))
-		} else {
-			logger.Info("Ngrok tunnel stopped")
-		}
-		h.ngrokManager = nil
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Tunnel stopped successfully",
-	})
+	requestLogger(c).Info("Settings updated by user")
+	h.recordAuditChange(c, "settings.update", "settings", before, settings)
+	c.JSON(http.StatusOK, gin.H{"message": "Settings updated successfully"})
 }
 
 // TestNgrokToken tests if the provided ngrok token is valid
@@ -374,8 +723,9 @@ func (h *Handler) TestNgrokToken(c *gin.Context) {
 		return
 	}
 
-	manager := ngrok.NewManager(request.Token, "", 8080)
+	manager := ngrok.NewManager(request.Token, "", tunnel.Options{})
 	if err := manager.TestConnection(); err != nil {
+		h.recordAudit(c, "ngrok.token_test", "ngrok", "invalid")
 		c.JSON(http.StatusBadRequest, gin.H{
 			"valid":   false,
 			"error":   "Invalid token",
@@ -385,6 +735,7 @@ func (h *Handler) TestNgrokToken(c *gin.Context) {
 		return
 	}
 
+	h.recordAudit(c, "ngrok.token_test", "ngrok", "valid")
 	c.JSON(http.StatusOK, gin.H{
 		"valid":   true,
 		"message": "✅ Token is valid!",
@@ -513,22 +864,136 @@ func isHTMLResponse(resp *http.Response) bool {
 
 // Helper functions
 
-func (h *Handler) logRequest(c *gin.Context, host, port, path string, statusCode int, duration time.Duration, errorMsg string) {
-	entry := &models.LogEntry{
-		Timestamp:  time.Now(),
-		SourceIP:   c.ClientIP(),
-		Method:     c.Request.Method,
-		TargetHost: host,
-		TargetPort: port,
-		Path:       path,
-		StatusCode: statusCode,
-		Duration:   duration.Milliseconds(),
-		Error:      errorMsg,
+// logRequest fills in the request details of a log entry, stores it and
+// returns its ID, or 0 on failure
+func (h *Handler) logRequest(c *gin.Context, entry *models.LogEntry) int {
+	entry.Timestamp = time.Now()
+	entry.SourceIP = c.ClientIP()
+	entry.Origin = requestOrigin(c)
+	entry.Method = c.Request.Method
+	entry.RequestID = requestIDFrom(c)
+	entry.ResponseBytes = responseBytes(c)
+	if token := currentAPIToken(c); token != nil {
+		entry.APITokenID = token.ID
+	}
+	if link := currentShareLink(c); link != nil {
+		entry.ShareID = link.ID
 	}
+	entry.Identity = requestIdentity(c)
 
 	if err := h.db.InsertLogEntry(entry); err != nil {
-		logger.Error("Failed to log request:", err)
+		requestLogger(c).Error("Failed to log request:", err)
+		return 0
+	}
+	return entry.ID
+}
+
+// parseLogFilter reads the log filters shared by the log list and export endpoints
+func parseLogFilter(c *gin.Context) (database.LogFilter, error) {
+	filter := database.LogFilter{
+		TargetHost: c.Query("target_host"),
+		Method:     c.Query("method"),
+		RequestID:  c.Query("request_id"),
+		Identity:   c.Query("identity"),
+		Origin:     c.Query("origin"),
+		Agent:      c.Query("agent"),
+	}
+
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter, fmt.Errorf("invalid 'since' time, expected RFC3339")
+		}
+		filter.Since = t
+	}
+
+	if until := c.Query("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return filter, fmt.Errorf("invalid 'until' time, expected RFC3339")
+		}
+		filter.Until = t
+	}
+
+	if status := c.Query("status"); status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			return filter, fmt.Errorf("invalid status code")
+		}
+		filter.StatusCode = code
+	}
+
+	if tokenID := c.Query("api_token_id"); tokenID != "" {
+		id, err := strconv.Atoi(tokenID)
+		if err != nil {
+			return filter, fmt.Errorf("invalid API token ID")
+		}
+		filter.APITokenID = id
+	}
+
+	if shareID := c.Query("share_id"); shareID != "" {
+		id, err := strconv.Atoi(shareID)
+		if err != nil {
+			return filter, fmt.Errorf("invalid share link ID")
+		}
+		filter.ShareID = id
+	}
+
+	return filter, nil
+}
+
+// splitProxyPath splits "/proxy/HOST:PORT/rest" into the target and the
+// still-escaped remainder of the path. The remainder is "" when the URL has
+// no slash after the target.
+func splitProxyPath(u *url.URL) (string, string, bool) {
+	escaped := u.EscapedPath()
+	if !strings.HasPrefix(escaped, "/proxy/") {
+		return "", "", false
+	}
+
+	rest := strings.TrimPrefix(escaped, "/proxy/")
+	hostPort, path := rest, ""
+	if i := strings.Index(rest, "/"); i >= 0 {
+		hostPort, path = rest[:i], rest[i:]
+	}
+
+	hostPort, err := url.PathUnescape(hostPort)
+	if err != nil || hostPort == "" {
+		return "", "", false
+	}
+
+	return hostPort, path, true
+}
+
+// buildTargetURL creates the upstream URL for an escaped path, preserving its
+// exact encoding (e.g. %2F), and the client's raw query string
+func buildTargetURL(host, port, escapedPath string, client *url.URL) (*url.URL, error) {
+	path, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &url.URL{
+		Scheme:     "http",
+		Host:       net.JoinHostPort(host, port),
+		Path:       path,
+		RawQuery:   client.RawQuery,
+		ForceQuery: client.ForceQuery,
+	}
+	if escapedPath != target.EscapedPath() {
+		target.RawPath = escapedPath
+	}
+
+	return target, nil
+}
+
+// newRequestID returns a random identifier for a single request
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
 	}
+	return hex.EncodeToString(b)
 }
 
 func isPrivateIP(ip string) bool {
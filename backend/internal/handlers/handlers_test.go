@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"lan-relay/internal/config"
+	"lan-relay/internal/database"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestHandler creates a handler on a fresh database
+func newTestHandler(t *testing.T, cfg *config.Config) *Handler {
+	t.Helper()
+	db, err := database.Init(filepath.Join(t.TempDir(), "relay.db"))
+	if err != nil {
+		t.Fatalf("database.Init: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return New(db, cfg, nil)
+}
+
+// createUser adds a user with a role
+func createUser(t *testing.T, h *Handler, username, role string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, PasswordHash: "x", Role: role}
+	if err := h.db.InsertUser(user); err != nil {
+		t.Fatalf("InsertUser: %v", err)
+	}
+	return user
+}
+
+// targetServer answers with the path it was asked for
+func targetServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
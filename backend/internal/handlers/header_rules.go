@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"lan-relay/internal/models"
+	"lan-relay/internal/rules"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetHeaderRules lists header rules, optionally for a single target
+func (h *Handler) GetHeaderRules(c *gin.Context) {
+	headerRules, err := h.db.GetHeaderRules(c.Query("target"))
+	if err != nil {
+		requestLogger(c).Error("Error fetching header rules:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch header rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": headerRules})
+}
+
+// headerRuleSecretFields are redacted from audited header rules, as values
+// often carry credentials such as an Authorization header
+var headerRuleSecretFields = []string{"value"}
+
+// CreateHeaderRule validates and stores a new header rule
+func (h *Handler) CreateHeaderRule(c *gin.Context) {
+	rule := models.HeaderRule{Enabled: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	if err := rules.Validate(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.db.InsertHeaderRule(&rule); err != nil {
+		requestLogger(c).Error("Error creating header rule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create header rule"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Header rule %d created for %s", rule.ID, rule.Target))
+	h.recordAuditChange(c, "header_rule.create", fmt.Sprintf("header_rule:%d", rule.ID), nil, rule, headerRuleSecretFields...)
+	c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateHeaderRule validates and replaces an existing header rule
+func (h *Handler) UpdateHeaderRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	existing, err := h.db.GetHeaderRule(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching header rule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch header rule"})
+		return
+	}
+	if existing == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Header rule not found"})
+		return
+	}
+
+	rule := *existing
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	rule.ID = id
+
+	if err := rules.Validate(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.db.UpdateHeaderRule(&rule); err != nil {
+		requestLogger(c).Error("Error updating header rule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update header rule"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Header rule %d updated", id))
+	h.recordAuditChange(c, "header_rule.update", fmt.Sprintf("header_rule:%d", id), existing, rule, headerRuleSecretFields...)
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteHeaderRule removes a header rule
+func (h *Handler) DeleteHeaderRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	existing, err := h.db.GetHeaderRule(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching header rule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch header rule"})
+		return
+	}
+
+	if err := h.db.DeleteHeaderRule(id); err != nil {
+		requestLogger(c).Error("Error deleting header rule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete header rule"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Header rule %d deleted", id))
+	if existing != nil {
+		h.recordAuditChange(c, "header_rule.delete", fmt.Sprintf("header_rule:%d", id), existing, nil, headerRuleSecretFields...)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Header rule deleted successfully"})
+}
+
+// DryRunHeaderRules shows how a target's rules (or the supplied unsaved rules)
+// would rewrite the given request and response headers
+func (h *Handler) DryRunHeaderRules(c *gin.Context) {
+	var request struct {
+		Target          string              `json:"target" binding:"required"`
+		ClientIP        string              `json:"client_ip"`
+		Method          string              `json:"method"`
+		Path            string              `json:"path"`
+		RequestHeaders  http.Header         `json:"request_headers"`
+		ResponseHeaders http.Header         `json:"response_headers"`
+		Rules           []models.HeaderRule `json:"rules"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Target is required"})
+		return
+	}
+
+	host, port, err := net.SplitHostPort(request.Target)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid host:port format"})
+		return
+	}
+
+	headerRules := request.Rules
+	if headerRules == nil {
+		headerRules, err = h.db.GetHeaderRules(request.Target)
+		if err != nil {
+			requestLogger(c).Error("Error fetching header rules:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch header rules"})
+			return
+		}
+	} else {
+		for i := range headerRules {
+			headerRules[i].Target = request.Target
+			headerRules[i].Enabled = true
+			if err := rules.Validate(&headerRules[i]); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("rule %d: %v", i, err)})
+				return
+			}
+		}
+	}
+
+	if request.ClientIP == "" {
+		request.ClientIP = c.ClientIP()
+	}
+	if request.Method == "" {
+		request.Method = http.MethodGet
+	}
+	if request.Path == "" {
+		request.Path = "/"
+	}
+
+	vars := ruleVariables(request.ClientIP, "dry-run", request.Method, request.Path, host, port)
+
+	requestHeaders := cloneHeader(request.RequestHeaders)
+	hostHeader := rules.Apply(requestHeaders, headerRules, rules.DirectionRequest, vars)
+	if hostHeader == "" {
+		hostHeader = request.Target
+	}
+
+	responseHeaders := cloneHeader(request.ResponseHeaders)
+	rules.Apply(responseHeaders, headerRules, rules.DirectionResponse, vars)
+
+	c.JSON(http.StatusOK, gin.H{
+		"rules":            headerRules,
+		"host":             hostHeader,
+		"request_headers":  requestHeaders,
+		"response_headers": responseHeaders,
+	})
+}
+
+// ruleVariables builds the template variables available to header rule values
+func ruleVariables(clientIP, requestID, method, path, host, port string) map[string]string {
+	return map[string]string{
+		"client_ip":   clientIP,
+		"request_id":  requestID,
+		"method":      method,
+		"path":        path,
+		"target":      net.JoinHostPort(host, port),
+		"target_host": host,
+		"target_port": port,
+	}
+}
+
+// cloneHeader canonicalizes header names so rules match regardless of input casing
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header)
+	for name, values := range header {
+		for _, value := range values {
+			clone.Add(name, value)
+		}
+	}
+	return clone
+}
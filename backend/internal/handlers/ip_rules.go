@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ipRuleAllow = "allow"
+	ipRuleDeny  = "deny"
+
+	// ipRuleCacheTTL is how long the active rules are cached, so expired
+	// rules stop applying without a change
+	ipRuleCacheTTL = 30 * time.Second
+
+	// upstreamKey marks requests answered by the target rather than the relay
+	upstreamKey = "upstream_responded"
+)
+
+// ipRuleState caches the active client IP rules, parsed
+type ipRuleState struct {
+	mu     sync.RWMutex
+	rules  []ipRule
+	loaded time.Time
+}
+
+type ipRule struct {
+	models.IPRule
+	network *net.IPNet
+}
+
+// parseCIDR accepts a CIDR or a single address, returning the network
+func parseCIDR(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address or CIDR %q", value)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP address or CIDR %q", value)
+	}
+	return network, nil
+}
+
+// activeIPRules returns the cached rules, reloading them when stale
+func (h *Handler) activeIPRules(c *gin.Context) []ipRule {
+	h.ipRules.mu.RLock()
+	rules, loaded := h.ipRules.rules, h.ipRules.loaded
+	h.ipRules.mu.RUnlock()
+	if time.Since(loaded) < ipRuleCacheTTL {
+		return rules
+	}
+
+	stored, err := h.db.GetIPRules(false)
+	if err != nil {
+		// Keep using the rules we have rather than failing every request
+		requestLogger(c).Error("Error fetching IP rules:", err)
+		return rules
+	}
+	rules = make([]ipRule, 0, len(stored))
+	for _, rule := range stored {
+		network, err := parseCIDR(rule.CIDR)
+		if err != nil {
+			requestLogger(c).Warn(fmt.Sprintf("Skipping IP rule %d: %v", rule.ID, err))
+			continue
+		}
+		rules = append(rules, ipRule{IPRule: rule, network: network})
+	}
+
+	h.ipRules.mu.Lock()
+	h.ipRules.rules, h.ipRules.loaded = rules, time.Now()
+	h.ipRules.mu.Unlock()
+	return rules
+}
+
+// reloadIPRules makes the next request read the rules again
+func (h *Handler) reloadIPRules() {
+	h.ipRules.mu.Lock()
+	h.ipRules.loaded = time.Time{}
+	h.ipRules.mu.Unlock()
+}
+
+// ipAllowed applies the rules for a target ("" for the whole relay) to the
+// client. Deny rules win; if there are allow rules the client must match
+// one, unless it's local and IP_ALLOWLIST_LOCAL is off.
+func (h *Handler) ipAllowed(c *gin.Context, target string) (bool, string) {
+	ip := net.ParseIP(c.ClientIP())
+	if ip == nil {
+		return false, "unknown client address"
+	}
+
+	allowlisted, hasAllowlist := false, false
+	for _, rule := range h.activeIPRules(c) {
+		if rule.Target != target || !rule.Active() {
+			continue
+		}
+		switch rule.Action {
+		case ipRuleDeny:
+			if rule.network.Contains(ip) {
+				return false, fmt.Sprintf("denied by IP rule %d", rule.ID)
+			}
+		case ipRuleAllow:
+			hasAllowlist = true
+			allowlisted = allowlisted || rule.network.Contains(ip)
+		}
+	}
+
+	if !hasAllowlist || allowlisted {
+		return true, ""
+	}
+	if requestOrigin(c) == OriginLocal && !h.cfg.IPAllowlistLocal {
+		return true, ""
+	}
+	return false, "not on the allowlist"
+}
+
+// IPFilter refuses clients the relay-wide IP rules don't allow, and bans
+// clients that keep getting refused
+func (h *Handler) IPFilter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if allowed, reason := h.ipAllowed(c, ""); !allowed {
+			requestLogger(c).Warn(fmt.Sprintf("Refused %s %s from %s: %s", c.Request.Method, c.Request.URL.Path, c.ClientIP(), reason))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Your address isn't allowed to use the relay"})
+			return
+		}
+
+		c.Next()
+		h.countRefusal(c)
+	}
+}
+
+// targetIPAllowed applies a target's own IP rules, answering 403 if the
+// client may not reach it
+func (h *Handler) targetIPAllowed(c *gin.Context, target string) bool {
+	if allowed, reason := h.ipAllowed(c, target); !allowed {
+		requestLogger(c).Warn(fmt.Sprintf("Refused %s from %s for %s: %s", c.Request.URL.Path, c.ClientIP(), target, reason))
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your address isn't allowed to reach this target"})
+		return false
+	}
+	return true
+}
+
+// countRefusal counts 401, 403 and 429 responses from the relay itself
+// against the client and bans it for a while once it passes the limit.
+// Responses from targets don't count, nor do clients on this host (but not
+// the tunnel's) or on a relay-wide allow rule. Counts share the sign-in
+// failure table, under "ban:" keys.
+func (h *Handler) countRefusal(c *gin.Context) {
+	if h.cfg.AutoBanThreshold <= 0 || c.GetBool(upstreamKey) {
+		return
+	}
+	switch c.Writer.Status() {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+	default:
+		return
+	}
+
+	address := c.ClientIP()
+	ip := net.ParseIP(address)
+	if ip == nil || (ip.IsLoopback() && !fromTunnelConn(c)) {
+		return
+	}
+	for _, rule := range h.activeIPRules(c) {
+		if rule.Target == "" && rule.Action == ipRuleAllow && rule.network.Contains(ip) {
+			return
+		}
+	}
+
+	key := "ban:" + address
+	window := time.Duration(h.cfg.AutoBanWindowSeconds) * time.Second
+	refusals, err := h.db.RecordLoginFailure(key, window)
+	if err != nil {
+		requestLogger(c).Error("Failed to count refused request:", err)
+		return
+	}
+	if refusals < h.cfg.AutoBanThreshold {
+		return
+	}
+
+	network, _ := parseCIDR(address)
+	expires := time.Now().Add(time.Duration(h.cfg.AutoBanSeconds) * time.Second)
+	rule := &models.IPRule{
+		CIDR:      network.String(),
+		Action:    ipRuleDeny,
+		Reason:    fmt.Sprintf("%d refused requests within %s", refusals, window),
+		Automatic: true,
+		ExpiresAt: &expires,
+	}
+	if err := h.db.InsertIPRule(rule); err != nil {
+		requestLogger(c).Error("Failed to ban client:", err)
+		return
+	}
+	if err := h.db.ClearLoginFailures(key); err != nil {
+		requestLogger(c).Warn("Failed to clear refused request count:", err)
+	}
+	h.reloadIPRules()
+
+	h.recordAuditFor(c, nil, "ip_rule.auto_ban", "ip_rule:"+strconv.Itoa(rule.ID), fmt.Sprintf("%s until %s: %s", rule.CIDR, expires.Format(time.RFC3339), rule.Reason))
+	requestLogger(c).Warn(fmt.Sprintf("🚫 Banned %s until %s after %s", address, expires.Format(time.RFC3339), rule.Reason))
+}
+
+// GetIPRules lists the active client IP rules, or all of them with ?all=true
+func (h *Handler) GetIPRules(c *gin.Context) {
+	all, _ := strconv.ParseBool(c.Query("all"))
+	rules, err := h.db.GetIPRules(all)
+	if err != nil {
+		requestLogger(c).Error("Error fetching IP rules:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch IP rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules, "client_ip": c.ClientIP()})
+}
+
+// CreateIPRule adds an allow or deny rule for the whole relay or a target,
+// optionally expiring
+func (h *Handler) CreateIPRule(c *gin.Context) {
+	var request struct {
+		CIDR             string     `json:"cidr" binding:"required"`
+		Action           string     `json:"action" binding:"required"`
+		Target           string     `json:"target"`
+		Reason           string     `json:"reason"`
+		ExpiresAt        *time.Time `json:"expires_at"`
+		ExpiresInMinutes int        `json:"expires_in_minutes"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CIDR and action are required"})
+		return
+	}
+
+	network, err := parseCIDR(request.CIDR)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule := &models.IPRule{
+		CIDR:   network.String(),
+		Action: strings.ToLower(strings.TrimSpace(request.Action)),
+		Target: strings.TrimSpace(request.Target),
+		Reason: strings.TrimSpace(request.Reason),
+	}
+	if rule.Action != ipRuleAllow && rule.Action != ipRuleDeny {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Action must be allow or deny"})
+		return
+	}
+	if rule.Target != "" {
+		if _, port, err := net.SplitHostPort(rule.Target); err != nil || port == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Target must be HOST:PORT"})
+			return
+		}
+	}
+
+	switch {
+	case request.ExpiresAt != nil:
+		rule.ExpiresAt = request.ExpiresAt
+	case request.ExpiresInMinutes > 0:
+		expires := time.Now().Add(time.Duration(request.ExpiresInMinutes) * time.Minute)
+		rule.ExpiresAt = &expires
+	}
+	if rule.ExpiresAt != nil && !rule.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+
+	if user := currentUser(c); user != nil {
+		rule.CreatedBy = user.ID
+		rule.CreatorName = user.Username
+	}
+	if err := h.db.InsertIPRule(rule); err != nil {
+		requestLogger(c).Error("Error creating IP rule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create IP rule"})
+		return
+	}
+	h.reloadIPRules()
+
+	h.recordAuditChange(c, "ip_rule.create", "ip_rule:"+strconv.Itoa(rule.ID), nil, rule)
+	c.JSON(http.StatusCreated, rule)
+}
+
+// DeleteIPRule removes a client IP rule, such as lifting a ban early
+func (h *Handler) DeleteIPRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP rule ID"})
+		return
+	}
+
+	rule, err := h.db.GetIPRule(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching IP rule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch IP rule"})
+		return
+	}
+	if rule == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "IP rule not found"})
+		return
+	}
+
+	if err := h.db.DeleteIPRule(id); err != nil {
+		requestLogger(c).Error("Error deleting IP rule:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete IP rule"})
+		return
+	}
+	h.reloadIPRules()
+
+	h.recordAuditChange(c, "ip_rule.delete", "ip_rule:"+strconv.Itoa(id), rule, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "IP rule deleted"})
+}
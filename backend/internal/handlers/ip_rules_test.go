@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"lan-relay/internal/config"
+	"lan-relay/internal/database"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ipRouter applies origins and relay-wide IP rules the way the server does.
+// /api/refused is refused by the relay, /upstream by a target, and
+// /target/:target applies that target's rules.
+func ipRouter(t *testing.T, h *Handler) *gin.Engine {
+	t.Helper()
+	r := gin.New()
+	r.RemoteIPHeaders = h.cfg.RemoteIPHeaders
+	if err := r.SetTrustedProxies(h.TrustedProxies()); err != nil {
+		t.Fatal(err)
+	}
+	r.Use(h.OriginPolicy())
+	r.Use(h.IPFilter())
+	r.GET("/api/status", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/api/refused", func(c *gin.Context) { c.Status(http.StatusUnauthorized) })
+	r.GET("/upstream", func(c *gin.Context) {
+		c.Set(upstreamKey, true)
+		c.Status(http.StatusForbidden)
+	})
+	r.GET("/target/:target", func(c *gin.Context) {
+		if h.targetIPAllowed(c, c.Param("target")) {
+			c.Status(http.StatusOK)
+		}
+	})
+	return r
+}
+
+func insertIPRules(t *testing.T, h *Handler, rules []models.IPRule) {
+	t.Helper()
+	for i := range rules {
+		if err := h.db.InsertIPRule(&rules[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIPRules(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	deny := func(cidr string) models.IPRule { return models.IPRule{CIDR: cidr, Action: ipRuleDeny} }
+	allow := func(cidr string) models.IPRule { return models.IPRule{CIDR: cidr, Action: ipRuleAllow} }
+	forTarget := func(rule models.IPRule, target string) models.IPRule {
+		rule.Target = target
+		return rule
+	}
+
+	tests := []struct {
+		name           string
+		rules          []models.IPRule
+		allowlistLocal bool
+		remoteAddr     string
+		path           string
+		wantCode       int
+	}{
+		{"no rules", nil, false, "203.0.113.5:5000", "/api/status", http.StatusOK},
+		{"denied range", []models.IPRule{deny("203.0.113.0/24")}, false, "203.0.113.5:5000", "/api/status", http.StatusForbidden},
+		{"outside a denied range", []models.IPRule{deny("203.0.113.0/24")}, false, "198.51.100.7:5000", "/api/status", http.StatusOK},
+		{"deny beats allow", []models.IPRule{allow("198.51.100.7"), deny("198.51.100.0/24")}, false, "198.51.100.7:5000", "/api/status", http.StatusForbidden},
+		{"on the allowlist", []models.IPRule{allow("198.51.100.7")}, false, "198.51.100.7:5000", "/api/status", http.StatusOK},
+		{"off the allowlist", []models.IPRule{allow("198.51.100.7")}, false, "203.0.113.5:5000", "/api/status", http.StatusForbidden},
+		{"LAN client off the allowlist", []models.IPRule{allow("198.51.100.7")}, false, "192.168.1.10:5000", "/api/status", http.StatusOK},
+		{"LAN client with IP_ALLOWLIST_LOCAL", []models.IPRule{allow("198.51.100.7")}, true, "192.168.1.10:5000", "/api/status", http.StatusForbidden},
+		{"IPv6 range", []models.IPRule{deny("2001:db8::/32")}, false, "[2001:db8::1]:5000", "/api/status", http.StatusForbidden},
+		{"expired deny", []models.IPRule{{CIDR: "203.0.113.5", Action: ipRuleDeny, ExpiresAt: &past}}, false, "203.0.113.5:5000", "/api/status", http.StatusOK},
+		{"target rule elsewhere", []models.IPRule{forTarget(deny("203.0.113.5"), "10.0.0.5:80")}, false, "203.0.113.5:5000", "/api/status", http.StatusOK},
+		{"target rule on its target", []models.IPRule{forTarget(deny("203.0.113.5"), "10.0.0.5:80")}, false, "203.0.113.5:5000", "/target/10.0.0.5:80", http.StatusForbidden},
+		{"target rule on another target", []models.IPRule{forTarget(deny("203.0.113.5"), "10.0.0.5:80")}, false, "203.0.113.5:5000", "/target/10.0.0.6:80", http.StatusOK},
+		{"target allowlist", []models.IPRule{forTarget(allow("198.51.100.7"), "10.0.0.5:80")}, false, "203.0.113.5:5000", "/target/10.0.0.5:80", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, &config.Config{IPAllowlistLocal: tt.allowlistLocal})
+			insertIPRules(t, h, tt.rules)
+			w := originRequest{method: http.MethodGet, path: tt.path, remoteAddr: tt.remoteAddr}.serve(ipRouter(t, h))
+			if w.Code != tt.wantCode {
+				t.Fatalf("GET %s from %s = %d %s, want %d", tt.path, tt.remoteAddr, w.Code, w.Body, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAutoBan(t *testing.T) {
+	tests := []struct {
+		name       string
+		threshold  int
+		rules      []models.IPRule
+		request    originRequest
+		refusals   int
+		wantBanned bool
+	}{
+		{"refused up to the threshold", 3, nil, originRequest{path: "/api/refused", remoteAddr: "203.0.113.5:5000"}, 3, true},
+		{"refused below the threshold", 3, nil, originRequest{path: "/api/refused", remoteAddr: "203.0.113.5:5000"}, 2, false},
+		{"refused by a target", 3, nil, originRequest{path: "/upstream", remoteAddr: "203.0.113.5:5000"}, 5, false},
+		{"not refused", 3, nil, originRequest{path: "/api/status", remoteAddr: "203.0.113.5:5000"}, 5, false},
+		{"LAN client", 3, nil, originRequest{path: "/api/refused", remoteAddr: "192.168.1.10:5000"}, 3, true},
+		{"this host", 3, nil, originRequest{path: "/api/refused", remoteAddr: "127.0.0.1:5000"}, 5, false},
+		{"through the tunnel", 3, nil, originRequest{path: "/api/refused", remoteAddr: "127.0.0.1:5000", tunnelConn: true}, 3, true},
+		{"on a relay-wide allow rule", 3, []models.IPRule{{CIDR: "203.0.113.0/24", Action: ipRuleAllow}}, originRequest{path: "/api/refused", remoteAddr: "203.0.113.5:5000"}, 5, false},
+		{"bans off", 0, nil, originRequest{path: "/api/refused", remoteAddr: "203.0.113.5:5000"}, 5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, &config.Config{AutoBanThreshold: tt.threshold, AutoBanWindowSeconds: 60, AutoBanSeconds: 900})
+			insertIPRules(t, h, tt.rules)
+			r := ipRouter(t, h)
+
+			tt.request.method = http.MethodGet
+			for i := 0; i < tt.refusals; i++ {
+				tt.request.serve(r)
+			}
+			status := originRequest{method: http.MethodGet, path: "/api/status", remoteAddr: tt.request.remoteAddr, tunnelConn: tt.request.tunnelConn}
+			banned := status.serve(r).Code == http.StatusForbidden
+			if banned != tt.wantBanned {
+				t.Fatalf("banned after %d requests = %v, want %v", tt.refusals, banned, tt.wantBanned)
+			}
+			if !banned {
+				return
+			}
+
+			rules, err := h.db.GetIPRules(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ban := rules[len(rules)-1]
+			if !ban.Automatic || ban.Action != ipRuleDeny || ban.ExpiresAt == nil || time.Until(*ban.ExpiresAt) > 900*time.Second {
+				t.Errorf("ban rule = %+v", ban)
+			}
+			events, err := h.db.GetAuditEvents(database.AuditFilter{Action: "ip_rule.auto_ban"})
+			if err != nil || len(events) != 1 {
+				t.Errorf("auto_ban audit events = %v, %v", events, err)
+			}
+		})
+	}
+}
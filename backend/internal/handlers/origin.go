@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Where a request came from
+const (
+	OriginLocal  = "local"  // a private address on the LAN, or this host
+	OriginTunnel = "tunnel" // through the tunnel, or a trusted proxy, from outside
+	OriginOther  = "other"  // straight from a public address
+
+	originKey = "origin"
+)
+
+// TrustedProxies returns the proxies allowed to set the client IP, for
+// gin.Engine.SetTrustedProxies. "none" trusts no proxy.
+func (h *Handler) TrustedProxies() []string {
+	proxies := make([]string, 0, len(h.cfg.TrustedProxies))
+	for _, proxy := range h.cfg.TrustedProxies {
+		if !strings.EqualFold(proxy, "none") {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// parseTrustedProxies turns trusted proxy IPs and CIDRs into networks,
+// skipping invalid entries (which SetTrustedProxies refuses at startup)
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if network, err := parseCIDR(proxy); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// OriginPolicy tags each request with its origin and refuses routes listed
+// in LOCAL_ONLY_ROUTES unless the request is local
+func (h *Handler) OriginPolicy() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The tunnel's client is whoever connected to it, whatever the
+		// request claims
+		if fromTunnelConn(c) {
+			for _, header := range h.cfg.RemoteIPHeaders {
+				c.Request.Header.Del(header)
+			}
+		}
+
+		origin := h.classifyOrigin(c)
+		c.Set(originKey, origin)
+
+		if origin != OriginLocal && h.localOnly(c) {
+			requestLogger(c).Warn(fmt.Sprintf("Refused %s %s from %s (%s), it's only allowed from the LAN",
+				c.Request.Method, c.Request.URL.Path, c.ClientIP(), origin))
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This is only available from the local network"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestOrigin returns the origin OriginPolicy tagged a request with
+func requestOrigin(c *gin.Context) string {
+	return c.GetString(originKey)
+}
+
+// classifyOrigin works out where a request came from
+func (h *Handler) classifyOrigin(c *gin.Context) string {
+	if fromTunnelConn(c) || h.tunnelHost(c.Request.Host) {
+		return OriginTunnel
+	}
+
+	local := isLocalAddress(c.ClientIP())
+	if h.forwarded(c) && !local {
+		return OriginTunnel
+	}
+	if local {
+		return OriginLocal
+	}
+	return OriginOther
+}
+
+// tunnelHost reports whether a request addressed the running tunnel or one
+// of TUNNEL_HOSTS
+func (h *Handler) tunnelHost(host string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.ToLower(host)
+	if host == "" {
+		return false
+	}
+
+	if tunnel, _ := h.tunnelURL.Load().(string); tunnel != "" {
+		if u, err := url.Parse(tunnel); err == nil && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	for _, name := range h.cfg.TunnelHosts {
+		if strings.EqualFold(name, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedPeer reports whether the connection itself came from a trusted proxy
+func (h *Handler) trustedPeer(c *gin.Context) bool {
+	if fromTunnelConn(c) {
+		return false
+	}
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, network := range h.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded reports whether a trusted proxy forwarded the request on behalf
+// of another client
+func (h *Handler) forwarded(c *gin.Context) bool {
+	if !h.trustedPeer(c) {
+		return false
+	}
+	for _, header := range h.cfg.RemoteIPHeaders {
+		if c.GetHeader(header) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// localOnly reports whether a request's route is in LOCAL_ONLY_ROUTES
+func (h *Handler) localOnly(c *gin.Context) bool {
+	route := c.FullPath()
+	if route == "" && c.GetHeader(GRPCTargetHeader) != "" {
+		// Native gRPC requests are routed to /proxy by RouteGRPC later on
+		route = "/proxy/*path"
+	}
+	if route == "" {
+		return false
+	}
+
+	for _, entry := range h.cfg.LocalOnlyRoutes {
+		method, path, hasMethod := strings.Cut(entry, " ")
+		if !hasMethod {
+			method, path = "", entry
+		}
+		path = strings.TrimSpace(path)
+		if path == route && (method == "" || strings.EqualFold(method, c.Request.Method)) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyAuthExempt reports whether a proxied request may skip sign-in
+// because of where it came from, as set by PROXY_AUTH_ORIGINS
+func (h *Handler) proxyAuthExempt(c *gin.Context) bool {
+	if !strings.HasPrefix(c.Request.URL.Path, "/proxy/") {
+		return false
+	}
+	origin := requestOrigin(c)
+	return origin != "" && !containsString(h.cfg.ProxyAuthOrigins, origin)
+}
+
+// isLocalAddress reports whether an IP is on a private network, link-local
+// or this host
+func isLocalAddress(address string) bool {
+	ip := net.ParseIP(address)
+	return ip != nil && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast())
+}
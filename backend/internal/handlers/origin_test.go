@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lan-relay/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// originRouter sets up client IPs and OriginPolicy the way the server does,
+// answering with the request's origin, whether its peer is a trusted proxy
+// and its client IP
+func originRouter(t *testing.T, h *Handler) *gin.Engine {
+	t.Helper()
+	r := gin.New()
+	r.RemoteIPHeaders = h.cfg.RemoteIPHeaders
+	if err := r.SetTrustedProxies(h.TrustedProxies()); err != nil {
+		t.Fatal(err)
+	}
+	r.Use(h.OriginPolicy())
+	answer := func(c *gin.Context) {
+		c.String(http.StatusOK, fmt.Sprintf("%s %v %s", requestOrigin(c), h.trustedPeer(c), c.ClientIP()))
+	}
+	r.GET("/api/status", answer)
+	r.POST("/api/status", answer)
+	r.GET("/api/settings", answer)
+	return r
+}
+
+type originRequest struct {
+	method     string
+	path       string
+	remoteAddr string
+	host       string
+	forwarded  string
+	tunnelConn bool
+}
+
+func (o originRequest) serve(r http.Handler) *httptest.ResponseRecorder {
+	method, path := o.method, o.path
+	if method == "" {
+		method, path = http.MethodGet, "/api/status"
+	}
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = o.remoteAddr
+	if o.host != "" {
+		req.Host = o.host
+	}
+	if o.forwarded != "" {
+		req.Header.Set("X-Forwarded-For", o.forwarded)
+	}
+	if o.tunnelConn {
+		req = req.WithContext(TunnelConnContext(req.Context(), nil))
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestClassifyOrigin(t *testing.T) {
+	h := newTestHandler(t, &config.Config{
+		TrustedProxies:  []string{"127.0.0.1", "10.0.0.2/32"},
+		RemoteIPHeaders: []string{"X-Forwarded-For"},
+		TunnelHosts:     []string{"relay.example.com"},
+	})
+	h.tunnelURL.Store("https://abc.ngrok.app")
+	r := originRouter(t, h)
+
+	tests := []struct {
+		name    string
+		request originRequest
+		want    string
+	}{
+		{"LAN client", originRequest{remoteAddr: "192.168.1.10:5000"}, "local false 192.168.1.10"},
+		{"this host", originRequest{remoteAddr: "127.0.0.1:5000"}, "local true 127.0.0.1"},
+		{"IPv6 link-local", originRequest{remoteAddr: "[fe80::1]:5000"}, "local false fe80::1"},
+		{"IPv6 unique local", originRequest{remoteAddr: "[fd00::5]:5000"}, "local false fd00::5"},
+		{"public address", originRequest{remoteAddr: "203.0.113.5:5000"}, "other false 203.0.113.5"},
+		{"public IPv6 address", originRequest{remoteAddr: "[2001:db8::1]:5000"}, "other false 2001:db8::1"},
+		{"public address claiming to be local", originRequest{remoteAddr: "203.0.113.5:5000", forwarded: "192.168.1.10"}, "other false 203.0.113.5"},
+		{"LAN client claiming another address", originRequest{remoteAddr: "192.168.1.10:5000", forwarded: "192.168.1.11"}, "local false 192.168.1.10"},
+		{"trusted proxy forwarding from outside", originRequest{remoteAddr: "10.0.0.2:5000", forwarded: "203.0.113.5"}, "tunnel true 203.0.113.5"},
+		{"trusted proxy forwarding from the LAN", originRequest{remoteAddr: "10.0.0.2:5000", forwarded: "192.168.1.10"}, "local true 192.168.1.10"},
+		{"trusted proxy's own request", originRequest{remoteAddr: "10.0.0.2:5000"}, "local true 10.0.0.2"},
+		{"tunnel host from the LAN", originRequest{remoteAddr: "192.168.1.10:5000", host: "Relay.Example.com"}, "tunnel false 192.168.1.10"},
+		{"running tunnel's host with a port", originRequest{remoteAddr: "127.0.0.1:5000", host: "abc.ngrok.app:443"}, "tunnel true 127.0.0.1"},
+		{"other host name", originRequest{remoteAddr: "192.168.1.10:5000", host: "example.com"}, "local false 192.168.1.10"},
+		// In-process tunnels connect from loopback, but no header from them is trusted
+		{"tunnel connection", originRequest{remoteAddr: "127.0.0.1:5000", forwarded: "192.168.1.10", tunnelConn: true}, "tunnel false 127.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tt.request.serve(r)
+			if w.Code != http.StatusOK || w.Body.String() != tt.want {
+				t.Fatalf("GET /api/status = %d %q, want %q", w.Code, w.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesNone(t *testing.T) {
+	h := newTestHandler(t, &config.Config{
+		TrustedProxies:  []string{"none"},
+		RemoteIPHeaders: []string{"X-Forwarded-For"},
+	})
+	if proxies := h.TrustedProxies(); len(proxies) != 0 {
+		t.Fatalf("TrustedProxies() = %q, want none", proxies)
+	}
+	w := originRequest{remoteAddr: "127.0.0.1:5000", forwarded: "203.0.113.5"}.serve(originRouter(t, h))
+	if want := "local false 127.0.0.1"; w.Body.String() != want {
+		t.Errorf("GET /api/status through an untrusted proxy = %q, want %q", w.Body, want)
+	}
+}
+
+func TestLocalOnlyRoutes(t *testing.T) {
+	h := newTestHandler(t, &config.Config{
+		TrustedProxies:  []string{"127.0.0.1"},
+		RemoteIPHeaders: []string{"X-Forwarded-For"},
+		LocalOnlyRoutes: []string{"/api/settings", "post /api/status"},
+	})
+	r := originRouter(t, h)
+
+	tests := []struct {
+		name     string
+		request  originRequest
+		wantCode int
+	}{
+		{"route from the LAN", originRequest{method: http.MethodGet, path: "/api/settings", remoteAddr: "192.168.1.10:5000"}, http.StatusOK},
+		{"route from outside", originRequest{method: http.MethodGet, path: "/api/settings", remoteAddr: "203.0.113.5:5000"}, http.StatusForbidden},
+		{"route through the tunnel", originRequest{method: http.MethodGet, path: "/api/settings", remoteAddr: "127.0.0.1:5000", tunnelConn: true}, http.StatusForbidden},
+		{"route forwarded from outside", originRequest{method: http.MethodGet, path: "/api/settings", remoteAddr: "127.0.0.1:5000", forwarded: "203.0.113.5"}, http.StatusForbidden},
+		{"method from outside", originRequest{method: http.MethodPost, path: "/api/status", remoteAddr: "203.0.113.5:5000"}, http.StatusForbidden},
+		{"other method from outside", originRequest{method: http.MethodGet, path: "/api/status", remoteAddr: "203.0.113.5:5000"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := tt.request.serve(r); w.Code != tt.wantCode {
+				t.Fatalf("%s %s = %d %s, want %d", tt.request.method, tt.request.path, w.Code, w.Body, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"lan-relay/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Characters and escapes the generated paths and queries are built from.
+// Queries leave out ";" and malformed escapes, which the reverse proxy
+// re-encodes on purpose.
+var (
+	pathChars    = []string{"a", "B", "z", "0", "9", "-", ".", "_", "~", "!", "$", "&", "'", "(", ")", "*", "+", ",", ";", "=", ":", "@"}
+	pathEscapes  = []string{"%2F", "%2f", "%20", "%25", "%3F", "%23", "%2B", "%C3%A9", "%E2%9C%93"}
+	queryChars   = []string{"a", "Q", "7", "-", ".", "_", "~", "!", "$", "'", "(", ")", "*", "+", ",", ":", "@", "/", "?"}
+	queryEscapes = []string{"%26", "%3D", "%20", "%2B", "%25", "%C3%A9", "%2F"}
+)
+
+// proxiedURL is a random escaped path and raw query as a client sends them
+type proxiedURL struct {
+	Path       string // escaped, starting with "/"
+	RawQuery   string
+	ForceQuery bool // a "?" with nothing after it
+}
+
+func randomPart(r *rand.Rand, chars, escapes []string, max int) string {
+	var b strings.Builder
+	for n := r.Intn(max + 1); n > 0; n-- {
+		if r.Intn(4) == 0 {
+			b.WriteString(escapes[r.Intn(len(escapes))])
+		} else {
+			b.WriteString(chars[r.Intn(len(chars))])
+		}
+	}
+	return b.String()
+}
+
+// Generate builds paths with any number of segments (including empty ones
+// and a trailing slash) and queries with repeated, empty and bare keys
+func (proxiedURL) Generate(r *rand.Rand, size int) reflect.Value {
+	var u proxiedURL
+	for n := r.Intn(5); n >= 0; n-- {
+		segment := randomPart(r, pathChars, pathEscapes, 8)
+		if segment == "." || segment == ".." {
+			segment += "x"
+		}
+		u.Path += "/" + segment
+	}
+	if r.Intn(3) == 0 && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	pairs := make([]string, 0)
+	for n := r.Intn(4); n > 0; n-- {
+		key := randomPart(r, queryChars, queryEscapes, 6)
+		switch r.Intn(3) {
+		case 0:
+			pairs = append(pairs, key)
+		default:
+			pairs = append(pairs, key+"="+randomPart(r, queryChars, queryEscapes, 6))
+		}
+	}
+	if len(pairs) > 1 && r.Intn(4) == 0 {
+		pairs = append(pairs, pairs[0])
+	}
+	u.RawQuery = strings.Join(pairs, "&")
+	u.ForceQuery = u.RawQuery == "" && r.Intn(4) == 0
+	return reflect.ValueOf(u)
+}
+
+// RequestURI is the request target as sent on the wire
+func (u proxiedURL) RequestURI() string {
+	if u.RawQuery != "" || u.ForceQuery {
+		return u.Path + "?" + u.RawQuery
+	}
+	return u.Path
+}
+
+// startProxy serves /proxy in front of an upstream that answers with the
+// request target it received
+func startProxy(t *testing.T) (string, string) {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, r.RequestURI)
+	}))
+	t.Cleanup(upstream.Close)
+
+	h := newTestHandler(t, &config.Config{})
+	r := gin.New()
+	r.Use(RequestID())
+	r.Any("/proxy/*path", h.ProxyRequest)
+	relay := httptest.NewServer(r)
+	t.Cleanup(relay.Close)
+	return relay.Listener.Addr().String(), upstream.Listener.Addr().String()
+}
+
+// rawRequest sends a request target exactly as given, which http.Client
+// would normalize
+func rawRequest(t *testing.T, addr, method, target string) *http.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "%s %s HTTP/1.1\r\nHost: relay.test\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", method, target)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	return resp
+}
+
+func readBody(resp *http.Response) string {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// The upstream receives exactly the path and query the client sent
+func TestProxyForwardsURLsVerbatim(t *testing.T) {
+	relay, upstream := startProxy(t)
+
+	forwarded := func(u proxiedURL) bool {
+		resp := rawRequest(t, relay, http.MethodGet, "/proxy/"+upstream+u.RequestURI())
+		body := readBody(resp)
+		if resp.StatusCode != http.StatusOK || body != u.RequestURI() {
+			t.Logf("sent %q, upstream got %q (%d)", u.RequestURI(), body, resp.StatusCode)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(forwarded, &quick.Config{MaxCount: 300}); err != nil {
+		t.Error(err)
+	}
+}
+
+// A bare /proxy/HOST:PORT is the target's root: GETs are redirected to the
+// trailing slash keeping the query, other methods go to "/"
+func TestProxyBareTargetIsRoot(t *testing.T) {
+	relay, upstream := startProxy(t)
+
+	bare := func(u proxiedURL) bool {
+		query := strings.TrimPrefix(u.RequestURI(), u.Path)
+		resp := rawRequest(t, relay, http.MethodGet, "/proxy/"+upstream+query)
+		readBody(resp)
+		wantLocation := "/proxy/" + upstream + "/" + query
+		if query == "?" {
+			wantLocation = "/proxy/" + upstream + "/"
+		}
+		if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != wantLocation {
+			t.Logf("GET %q = %d to %q, want a redirect to %q", query, resp.StatusCode, resp.Header.Get("Location"), wantLocation)
+			return false
+		}
+
+		resp = rawRequest(t, relay, http.MethodPost, "/proxy/"+upstream+query)
+		if body := readBody(resp); body != "/"+query {
+			t.Logf("POST %q reached %q, want %q", query, body, "/"+query)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(bare, &quick.Config{MaxCount: 100}); err != nil {
+		t.Error(err)
+	}
+}
+
+// Splitting a proxy URL and building the target URL round-trips the path
+// and query without touching their encoding
+func TestBuildTargetURLRoundTrips(t *testing.T) {
+	roundTrip := func(u proxiedURL) bool {
+		client, err := url.ParseRequestURI("/proxy/10.0.0.5:8080" + u.RequestURI())
+		if err != nil {
+			return false
+		}
+		hostPort, path, ok := splitProxyPath(client)
+		if !ok || hostPort != "10.0.0.5:8080" || path != u.Path {
+			t.Logf("splitProxyPath(%q) = %q, %q, %v", u.RequestURI(), hostPort, path, ok)
+			return false
+		}
+		target, err := buildTargetURL("10.0.0.5", "8080", path, client)
+		if err != nil || target.RequestURI() != u.RequestURI() {
+			t.Logf("buildTargetURL(%q) = %v, %v", u.RequestURI(), target, err)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(roundTrip, &quick.Config{MaxCount: 1000}); err != nil {
+		t.Error(err)
+	}
+}
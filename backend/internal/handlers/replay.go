@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReplayRequest holds the optional overrides for a replay
+type ReplayRequest struct {
+	Target        string            `json:"target"` // HOST:PORT, defaults to the original target
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Query         *string           `json:"query"`
+	Headers       map[string]string `json:"headers"`
+	RemoveHeaders []string          `json:"remove_headers"`
+	Body          *string           `json:"body"`
+}
+
+// ReplayLog re-issues a captured request to its original (or an overridden) target
+// and returns the new response next to the original one
+func (h *Handler) ReplayLog(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid log ID"})
+		return
+	}
+
+	var request ReplayRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+	}
+
+	entry, err := h.db.GetLogEntry(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching log entry:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch log entry"})
+		return
+	}
+	if entry == nil || !h.logEntryVisible(c, *entry) {
+		if !c.IsAborted() {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Log entry not found"})
+		}
+		return
+	}
+
+	capture, err := h.db.GetLogCapture(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching log capture:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch log capture"})
+		return
+	}
+	if capture == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "No captured request data for this log entry",
+			"hint":  "Enable CAPTURE_ENABLED to record requests for replay",
+		})
+		return
+	}
+	if capture.RequestBodyTruncated && request.Body == nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "The captured request body was truncated or not captured",
+			"hint":  "Provide a replacement 'body' to replay this request",
+		})
+		return
+	}
+
+	// Resolve the target, validating overrides the same way as proxied requests
+	host, port := entry.TargetHost, entry.TargetPort
+	if request.Target != "" {
+		host, port, err = net.SplitHostPort(request.Target)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid host:port format"})
+			return
+		}
+		if portNum, err := strconv.Atoi(port); err != nil || portNum < 1 || portNum > 65535 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid port number"})
+			return
+		}
+	}
+	if !isPrivateIP(host) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only private IP addresses are allowed"})
+		return
+	}
+
+	method := entry.Method
+	if request.Method != "" {
+		method = strings.ToUpper(request.Method)
+	}
+
+	// A replay reaches its target like a proxied request, with the stored
+	// credentials, so the caller needs the same access the proxy would ask
+	// for, both to the recorded target and to the one replayed to
+	original := net.JoinHostPort(entry.TargetHost, entry.TargetPort)
+	target := net.JoinHostPort(host, port)
+	if !h.authorizeTarget(c, entry.Method, entry.Agent, original) || !h.authorizeTarget(c, method, entry.Agent, target) {
+		return
+	}
+	if !h.targetIPAllowed(c, original) || (target != original && !h.targetIPAllowed(c, target)) {
+		return
+	}
+
+	// A hub replays through the agent the request originally went through
+	client, agentName := replayClient, ""
+	if h.hub != nil {
+		c.Set(agentKey, entry.Agent)
+		session, agent := h.agentFor(c)
+		if session == nil {
+			return
+		}
+		agentClient := *replayClient
+		agentClient.Transport = agent.http
+		client, agentName = &agentClient, session.Name()
+	}
+
+	path := entry.Path
+	if request.Path != "" {
+		path = "/" + strings.TrimPrefix(request.Path, "/")
+	}
+
+	query := capture.RequestQuery
+	if request.Query != nil {
+		query = strings.TrimPrefix(*request.Query, "?")
+	}
+
+	body := capture.RequestBody
+	if request.Body != nil {
+		body = []byte(*request.Body)
+	}
+
+	targetURL := fmt.Sprintf("http://%s%s", target, path)
+	if query != "" {
+		targetURL += "?" + query
+	}
+
+	credentials, err := h.loadUpstreamCredentials(target)
+	if err != nil {
+		requestLogger(c).Error("Error loading upstream credentials:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load upstream credentials"})
+		return
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), method, targetURL, bytes.NewReader(body))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid replay request", "details": err.Error()})
+		return
+	}
+
+	// Secret headers were redacted when captured, so they're left for the
+	// caller to set again
+	for name, values := range capture.RequestHeaders {
+		if isHopByHopHeader(name) {
+			continue
+		}
+		for _, value := range values {
+			if value != redactedValue {
+				req.Header.Add(name, value)
+			}
+		}
+	}
+	for _, name := range request.RemoveHeaders {
+		req.Header.Del(name)
+	}
+	for name, value := range request.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Del("Content-Length")
+	req.Header.Set(RequestIDHeader, requestIDFrom(c))
+	req.ContentLength = int64(len(body))
+
+	// Keep the replay's own capture so it can be inspected and replayed again
+	replayCapture := newProxyCapture(req, h.cfg.CaptureMaxBodyBytes, h.cfg.CaptureContentTypes)
+	replayCapture.requestBody.keep = true
+
+	// Inject after capturing so the stored replay never contains secrets
+	h.injectCredentials(c, req, credentials)
+
+	start := time.Now()
+	result := models.ReplayResult{
+		ReplayOf: id,
+		Target:   targetURL,
+		Original: models.ReplayResponse{
+			StatusCode:    entry.StatusCode,
+			Headers:       capture.ResponseHeaders,
+			Body:          capture.ResponseBody,
+			BodySize:      capture.ResponseBodySize,
+			BodyTruncated: capture.ResponseBodyTruncated,
+			Duration:      entry.Duration,
+			Error:         entry.Error,
+		},
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Replay.StatusCode = http.StatusBadGateway
+		result.Replay.Error = err.Error()
+	} else {
+		replayCapture.captureResponse(resp)
+		replayCapture.responseBody.keep = true
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		finished := replayCapture.finish(0)
+		result.Replay = models.ReplayResponse{
+			StatusCode:    resp.StatusCode,
+			Headers:       finished.ResponseHeaders,
+			Body:          finished.ResponseBody,
+			BodySize:      finished.ResponseBodySize,
+			BodyTruncated: finished.ResponseBodyTruncated,
+		}
+		if err != nil {
+			result.Replay.Error = fmt.Sprintf("failed to read response body: %v", err)
+		}
+	}
+	result.Replay.Duration = time.Since(start).Milliseconds()
+
+	replayEntry := &models.LogEntry{
+		Timestamp:  time.Now(),
+		SourceIP:   c.ClientIP(),
+		Origin:     requestOrigin(c),
+		Method:     method,
+		TargetHost: host,
+		TargetPort: port,
+		Path:       path,
+		StatusCode: result.Replay.StatusCode,
+		Duration:   result.Replay.Duration,
+		Error:      result.Replay.Error,
+		ReplayOf:   id,
+		RequestID:  requestIDFrom(c),
+		Agent:      agentName,
+	}
+	if err := h.db.InsertLogEntry(replayEntry); err != nil {
+		requestLogger(c).Error("Failed to log replay:", err)
+	} else {
+		result.LogID = replayEntry.ID
+		if err := h.db.InsertLogCapture(replayCapture.finish(replayEntry.ID)); err != nil {
+			requestLogger(c).Error("Failed to store replay capture:", err)
+		}
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Replayed log entry %d against %s (status %d)", id, targetURL, result.Replay.StatusCode))
+	c.JSON(http.StatusOK, result)
+}
+
+// replayClient does not follow redirects so replays can be compared with the original
+var replayClient = &http.Client{
+	Timeout: 30 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/config"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordLog stores a captured GET to a target, as the proxy would
+func recordLog(t *testing.T, h *Handler, target string) int {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(target)
+	entry := &models.LogEntry{Timestamp: time.Now(), Method: http.MethodGet, TargetHost: host, TargetPort: port, Path: "/secret", StatusCode: http.StatusOK}
+	if err := h.db.InsertLogEntry(entry); err != nil {
+		t.Fatalf("InsertLogEntry: %v", err)
+	}
+	capture := &models.LogCapture{LogID: entry.ID, RequestHeaders: http.Header{}, ResponseHeaders: http.Header{}, ResponseBody: []byte("captured")}
+	if err := h.db.InsertLogCapture(capture); err != nil {
+		t.Fatalf("InsertLogCapture: %v", err)
+	}
+	return entry.ID
+}
+
+// logRouter serves the log endpoints to a signed-in user, optionally
+// through an API token
+func logRouter(h *Handler, user *models.User, token *models.APIToken) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set(userKey, user)
+		if token != nil {
+			c.Set(apiTokenKey, token)
+		}
+	})
+	r.GET("/api/logs", h.GetLogs)
+	r.GET("/api/logs/har", h.ExportHAR)
+	r.GET("/api/logs/:id", h.GetLogDetail)
+	r.POST("/api/logs/:id/replay", h.ReplayLog)
+	return r
+}
+
+func TestLogsFollowTargetGrants(t *testing.T) {
+	h := newTestHandler(t, &config.Config{AuthEnabled: true})
+	granted := targetServer(t).Listener.Addr().String()
+	other := targetServer(t).Listener.Addr().String()
+	grantedID := recordLog(t, h, granted)
+	otherID := recordLog(t, h, other)
+
+	operator := createUser(t, h, "olive", auth.RoleOperator)
+	if err := h.db.InsertTargetGrant(&models.TargetGrant{UserID: operator.ID, Target: granted}); err != nil {
+		t.Fatal(err)
+	}
+	admin := createUser(t, h, "root", auth.RoleAdmin)
+	limitedToken := &models.APIToken{ID: 1, Scopes: []string{auth.ScopeLogsRead}, Targets: []string{granted}}
+
+	replay := func(id int) string {
+		return "/api/logs/" + strconv.Itoa(id) + "/replay"
+	}
+	tests := []struct {
+		name     string
+		user     *models.User
+		token    *models.APIToken
+		method   string
+		path     string
+		body     string
+		wantCode int
+	}{
+		{"detail of granted target", operator, nil, http.MethodGet, "/api/logs/" + strconv.Itoa(grantedID), "", http.StatusOK},
+		{"detail of other target", operator, nil, http.MethodGet, "/api/logs/" + strconv.Itoa(otherID), "", http.StatusNotFound},
+		{"replay to granted target", operator, nil, http.MethodPost, replay(grantedID), "", http.StatusOK},
+		{"replay of other target", operator, nil, http.MethodPost, replay(otherID), "", http.StatusNotFound},
+		{"replay redirected to other target", operator, nil, http.MethodPost, replay(grantedID), `{"target": "` + other + `"}`, http.StatusForbidden},
+		{"admin detail of other target", admin, nil, http.MethodGet, "/api/logs/" + strconv.Itoa(otherID), "", http.StatusOK},
+		{"admin replay redirected", admin, nil, http.MethodPost, replay(grantedID), `{"target": "` + other + `"}`, http.StatusOK},
+		{"token outside its allowlist", admin, limitedToken, http.MethodGet, "/api/logs/" + strconv.Itoa(otherID), "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			} else {
+				req.ContentLength = 0
+			}
+			w := httptest.NewRecorder()
+			logRouter(h, tt.user, tt.token).ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("%s %s = %d %s, want %d", tt.method, tt.path, w.Code, w.Body, tt.wantCode)
+			}
+		})
+	}
+
+	// Exports leave out entries for targets the caller can't reach
+	for _, caller := range []struct {
+		user  *models.User
+		token *models.APIToken
+	}{{operator, nil}, {admin, limitedToken}} {
+		w := httptest.NewRecorder()
+		logRouter(h, caller.user, caller.token).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/logs/har", nil))
+		var export struct {
+			Log struct {
+				Entries []struct {
+					Request struct {
+						URL string `json:"url"`
+					} `json:"request"`
+				} `json:"entries"`
+			} `json:"log"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &export); err != nil {
+			t.Fatalf("HAR export: %v", err)
+		}
+		if len(export.Log.Entries) == 0 {
+			t.Errorf("HAR export for %s is empty", caller.user.Username)
+		}
+		for _, entry := range export.Log.Entries {
+			if !strings.Contains(entry.Request.URL, granted) {
+				t.Errorf("HAR export for %s includes %s", caller.user.Username, entry.Request.URL)
+			}
+		}
+	}
+}
+
+func TestLogListFollowsTargetGrants(t *testing.T) {
+	h := newTestHandler(t, &config.Config{AuthEnabled: true})
+	recordLog(t, h, "10.0.0.5:80")
+	// Newer entries for other targets come first in the list
+	for _, target := range []string{"10.0.0.6:80", "10.0.0.5:443", "10.0.0.7:22"} {
+		recordLog(t, h, target)
+	}
+
+	viewer := createUser(t, h, "vera", auth.RoleViewer)
+	if err := h.db.InsertTargetGrant(&models.TargetGrant{UserID: viewer.ID, Target: "10.0.0.5:80"}); err != nil {
+		t.Fatal(err)
+	}
+	ungranted := createUser(t, h, "otto", auth.RoleOperator)
+	admin := createUser(t, h, "root", auth.RoleAdmin)
+	rangeToken := &models.APIToken{ID: 1, Scopes: []string{auth.ScopeLogsRead}, Targets: []string{"10.0.0.0/29"}}
+
+	tests := []struct {
+		name  string
+		user  *models.User
+		token *models.APIToken
+		query string
+		want  []string
+	}{
+		{"granted target only", viewer, nil, "", []string{"10.0.0.5:80"}},
+		{"paging counts visible entries", viewer, nil, "?limit=1", []string{"10.0.0.5:80"}},
+		{"past the visible entries", viewer, nil, "?limit=1&offset=1", []string{}},
+		{"user without grants", ungranted, nil, "", []string{}},
+		{"admin", admin, nil, "?limit=2", []string{"10.0.0.7:22", "10.0.0.5:443"}},
+		{"token allowlist", admin, rangeToken, "", []string{"10.0.0.7:22", "10.0.0.5:443", "10.0.0.6:80", "10.0.0.5:80"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			logRouter(h, tt.user, tt.token).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/logs"+tt.query, nil))
+			var listed struct {
+				Logs []models.LogEntry `json:"logs"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &listed); err != nil {
+				t.Fatalf("GET /api/logs%s = %d %s", tt.query, w.Code, w.Body)
+			}
+			got := make([]string, 0, len(listed.Logs))
+			for _, entry := range listed.Logs {
+				got = append(got, net.JoinHostPort(entry.TargetHost, entry.TargetPort))
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("GET /api/logs%s listed %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
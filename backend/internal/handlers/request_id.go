@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"regexp"
+
+	"lan-relay/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+)
+
+// Incoming IDs are only trusted if they are short and free of anything that
+// could break a header or a log line
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID assigns every request an ID, reusing a valid incoming X-Request-ID,
+// and echoes it back to the client
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+
+		c.Set(requestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// requestIDFrom returns the ID assigned to the current request
+func requestIDFrom(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// requestLogger returns a logger that tags lines with the current request ID
+func requestLogger(c *gin.Context) *logger.RequestLogger {
+	return logger.WithRequestID(requestIDFrom(c))
+}
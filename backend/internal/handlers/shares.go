@@ -0,0 +1,567 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/models"
+	"lan-relay/internal/share"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ShareCookie marks a browser that already opened (and, for
+	// password-protected links, unlocked) a share link. It holds a random
+	// token for that one visit and is scoped to the link's path.
+	ShareCookie = "lr_share"
+	// SharePasswordHeader lets non-browser clients send a share link's password
+	SharePasswordHeader = "X-Share-Password"
+
+	shareKey = "share_link"
+
+	defaultShareLifetime = 24 * time.Hour
+)
+
+// shareMethods are the methods a share link may allow
+var shareMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// UseShareSigner sets the key share links are signed with. Without one,
+// share links can't be created or used.
+func (h *Handler) UseShareSigner(signer *share.Signer) {
+	h.shareSigner = signer
+}
+
+// requireShareSigner answers 503 when share links aren't available
+func (h *Handler) requireShareSigner(c *gin.Context) bool {
+	if h.shareSigner == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Share links are unavailable",
+			"hint":  "Set SHARE_KEY or make SHARE_KEY_FILE writable",
+		})
+		return false
+	}
+	return true
+}
+
+// currentShareLink returns the share link a request was made through, if any
+func currentShareLink(c *gin.Context) *models.ShareLink {
+	if value, ok := c.Get(shareKey); ok {
+		if link, ok := value.(*models.ShareLink); ok {
+			return link
+		}
+	}
+	return nil
+}
+
+// GetShareLinks lists active share links, or all of them with ?all=true,
+// leaving out links to targets the caller can't reach
+func (h *Handler) GetShareLinks(c *gin.Context) {
+	all, _ := strconv.ParseBool(c.Query("all"))
+	links, err := h.db.GetShareLinks(all)
+	if err != nil {
+		requestLogger(c).Error("Error fetching share links:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch share links"})
+		return
+	}
+
+	visible, err := h.targetVisibility(c)
+	if err != nil {
+		requestLogger(c).Error("Error fetching target grants:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return
+	}
+	if visible != nil {
+		shown := make([]models.ShareLink, 0, len(links))
+		for _, link := range links {
+			if host, port, err := net.SplitHostPort(link.Target); err == nil && visible(host, port) {
+				shown = append(shown, link)
+			}
+		}
+		links = shown
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": links})
+}
+
+// CreateShareLink mints a signed link to one target. The link is only
+// returned in this response.
+func (h *Handler) CreateShareLink(c *gin.Context) {
+	if !h.requireShareSigner(c) {
+		return
+	}
+
+	var request struct {
+		Name           string     `json:"name" binding:"required"`
+		Target         string     `json:"target" binding:"required"`
+		PathPrefix     string     `json:"path_prefix"`
+		Methods        []string   `json:"methods"`
+		ExpiresAt      *time.Time `json:"expires_at"`
+		ExpiresInHours int        `json:"expires_in_hours"`
+		MaxUses        int        `json:"max_uses"`
+		Password       string     `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name and target are required"})
+		return
+	}
+
+	link := &models.ShareLink{
+		Name:       strings.TrimSpace(request.Name),
+		Target:     strings.TrimSpace(request.Target),
+		PathPrefix: strings.TrimSpace(request.PathPrefix),
+		MaxUses:    request.MaxUses,
+		ExpiresAt:  time.Now().Add(defaultShareLifetime),
+	}
+	for _, method := range request.Methods {
+		link.Methods = append(link.Methods, strings.ToUpper(strings.TrimSpace(method)))
+	}
+	if len(link.Methods) == 0 {
+		link.Methods = append(link.Methods, share.DefaultMethods...)
+	}
+	switch {
+	case request.ExpiresAt != nil:
+		link.ExpiresAt = *request.ExpiresAt
+	case request.ExpiresInHours > 0:
+		link.ExpiresAt = time.Now().Add(time.Duration(request.ExpiresInHours) * time.Hour)
+	}
+	if err := h.validateShareLink(link); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Nobody can share more than they can reach themselves
+	user := currentUser(c)
+	if user != nil {
+		for _, method := range link.Methods {
+			u := &url.URL{Path: "/proxy/" + link.Target + link.PathPrefix}
+			decision, err := h.decide(user, currentAPIToken(c), method, u, "")
+			if err != nil {
+				requestLogger(c).Error("Error checking permissions:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+				return
+			}
+			if !decision.Allowed {
+				c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("You can't share %s access: %s", method, decision.Reason)})
+				return
+			}
+		}
+		link.CreatedBy = user.ID
+		link.CreatorName = user.Username
+	}
+
+	if request.Password != "" {
+		hash, err := auth.HashPassword(request.Password)
+		if err != nil {
+			requestLogger(c).Error("Error hashing share password:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+			return
+		}
+		link.PasswordHash = hash
+	}
+
+	if err := h.db.InsertShareLink(link); err != nil {
+		requestLogger(c).Error("Error creating share link:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
+	}
+
+	token, err := h.shareSigner.Sign(share.Claims{
+		ID:         link.ID,
+		Target:     link.Target,
+		PathPrefix: link.PathPrefix,
+		Methods:    link.Methods,
+		ExpiresAt:  link.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		requestLogger(c).Error("Error signing share link:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create share link"})
+		return
+	}
+	path := "/share/" + token + "/" + strings.TrimPrefix(link.PathPrefix, "/")
+
+	h.recordAuditChange(c, "share.create", "share:"+strconv.Itoa(link.ID), nil, link)
+	requestLogger(c).Info(fmt.Sprintf("Share link %q created for %s until %s", link.Name, link.Target, link.ExpiresAt.Format(time.RFC3339)))
+	c.JSON(http.StatusCreated, gin.H{"url": h.shareBaseURL(c) + path, "path": path, "share": link})
+}
+
+// validateShareLink checks a share link before it's created
+func (h *Handler) validateShareLink(link *models.ShareLink) error {
+	if link.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	host, port, err := net.SplitHostPort(link.Target)
+	if err != nil {
+		return fmt.Errorf("target must be HOST:PORT")
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return fmt.Errorf("invalid target port")
+	}
+	if !isPrivateIP(host) {
+		return fmt.Errorf("only private IP addresses can be shared")
+	}
+
+	if link.PathPrefix != "" && !strings.HasPrefix(link.PathPrefix, "/") {
+		return fmt.Errorf("path prefix must start with /")
+	}
+	for _, method := range link.Methods {
+		if !containsString(shareMethods, method) {
+			return fmt.Errorf("unsupported method %q", method)
+		}
+	}
+
+	if !link.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expiry must be in the future")
+	}
+	if maxLifetime := time.Duration(h.cfg.ShareMaxHours) * time.Hour; time.Until(link.ExpiresAt) > maxLifetime {
+		return fmt.Errorf("share links can last at most %d hours", h.cfg.ShareMaxHours)
+	}
+	if link.MaxUses < 0 {
+		return fmt.Errorf("max uses can't be negative")
+	}
+	return nil
+}
+
+// shareBaseURL is where share links point: the tunnel when it's running, as
+// that's how people outside the LAN reach the relay, or else the relay as
+// the request addressed it
+func (h *Handler) shareBaseURL(c *gin.Context) string {
+	if tunnel, _ := h.tunnelURL.Load().(string); tunnel != "" {
+		return strings.TrimSuffix(tunnel, "/")
+	}
+	scheme := "http"
+	if h.isHTTPS(c) {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host
+}
+
+// RevokeShareLink stops a share link from working. Only its creator or an
+// admin can revoke it.
+func (h *Handler) RevokeShareLink(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share link ID"})
+		return
+	}
+
+	link, err := h.db.GetShareLink(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching share link:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch share link"})
+		return
+	}
+	if link == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return
+	}
+	if user := currentUser(c); h.cfg.AuthEnabled && user != nil && user.Role != auth.RoleAdmin && user.ID != link.CreatedBy {
+		h.recordAudit(c, "authz.denied", "share:"+strconv.Itoa(id), "Only the link's creator or an admin can revoke it")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the link's creator or an admin can revoke it"})
+		return
+	}
+
+	if err := h.db.RevokeShareLink(id); err != nil {
+		requestLogger(c).Error("Error revoking share link:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share link"})
+		return
+	}
+
+	h.recordAudit(c, "share.revoke", "share:"+strconv.Itoa(id), fmt.Sprintf("%q for %s", link.Name, link.Target))
+	requestLogger(c).Info(fmt.Sprintf("Share link %q revoked", link.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked"})
+}
+
+// ShareProxy proxies a request made through a share link:
+// /share/TOKEN/path. The link's signature, expiry and scope are checked
+// first, then its revocation, password and remaining uses.
+func (h *Handler) ShareProxy(c *gin.Context) {
+	token, targetPath := splitSharePath(c.Request.URL)
+	link, claims, ok := h.loadShareLink(c, token)
+	if !ok {
+		return
+	}
+
+	checked, err := cleanSharePath(targetPath)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !claims.Allows(c.Request.Method, checked) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This share link doesn't allow this request"})
+		return
+	}
+
+	if !h.shareVisitor(c, link) {
+		if !link.HasPassword {
+			if !h.countShareUse(c, token, link) {
+				return
+			}
+		} else if password := c.GetHeader(SharePasswordHeader); password != "" {
+			if !h.checkSharePassword(c, link, password) {
+				if !c.IsAborted() {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": "Wrong share link password"})
+				}
+				return
+			}
+			if !h.countShareUse(c, token, link) {
+				return
+			}
+		} else {
+			h.askSharePassword(c, token, link, "")
+			return
+		}
+	}
+
+	// Neither the share cookie nor its password reach the target
+	removeCookie(c.Request.Header, ShareCookie)
+	c.Request.Header.Del(SharePasswordHeader)
+
+	// The target gets exactly the path that was checked
+	if targetPath != "" {
+		targetPath = (&url.URL{Path: checked}).EscapedPath()
+	}
+	c.Set(shareKey, link)
+	h.proxyTo(c, claims.Target, targetPath, "/share/"+token)
+}
+
+// cleanSharePath decodes the escaped path of a share request and resolves
+// its dot segments, so /public/../admin is checked as /admin. A trailing
+// slash is kept.
+func cleanSharePath(escaped string) (string, error) {
+	decoded, err := url.PathUnescape(escaped)
+	if err != nil {
+		return "", fmt.Errorf("Invalid path encoding")
+	}
+	cleaned := path.Clean("/" + decoded)
+	if strings.HasSuffix(decoded, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	for _, segment := range strings.Split(cleaned, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("Invalid path")
+		}
+	}
+	return cleaned, nil
+}
+
+// UnlockShareLink checks the password posted from the password form of a
+// share link, then sends the browser on to the shared page
+func (h *Handler) UnlockShareLink(c *gin.Context) {
+	token := c.Param("token")
+	link, claims, ok := h.loadShareLink(c, token)
+	if !ok {
+		return
+	}
+	if !link.HasPassword {
+		c.Redirect(http.StatusSeeOther, "/share/"+token+"/")
+		return
+	}
+
+	password := c.PostForm("password")
+	if password == "" {
+		h.askSharePassword(c, token, link, "Enter the password")
+		return
+	}
+	if !h.checkSharePassword(c, link, password) {
+		if !c.IsAborted() {
+			h.askSharePassword(c, token, link, "Wrong password")
+		}
+		return
+	}
+	if !h.shareVisitor(c, link) && !h.countShareUse(c, token, link) {
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/share/"+token+"/"+strings.TrimPrefix(claims.PathPrefix, "/"))
+}
+
+// splitSharePath splits /share/TOKEN/path into the token and escaped path
+func splitSharePath(u *url.URL) (string, string) {
+	rest := strings.TrimPrefix(u.EscapedPath(), "/share/")
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[:i], rest[i:]
+	}
+	return rest, ""
+}
+
+// loadShareLink verifies a share token and loads its link, answering 404 or
+// 410 if it can't be used
+func (h *Handler) loadShareLink(c *gin.Context, token string) (*models.ShareLink, *share.Claims, bool) {
+	if h.shareSigner == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return nil, nil, false
+	}
+
+	claims, err := h.shareSigner.Verify(token, time.Now())
+	if err != nil {
+		status := http.StatusNotFound
+		if strings.Contains(err.Error(), "expired") {
+			status = http.StatusGone
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+
+	link, err := h.db.GetShareLink(claims.ID)
+	if err != nil {
+		requestLogger(c).Error("Error fetching share link:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check share link"})
+		return nil, nil, false
+	}
+	if link == nil || link.Target != claims.Target {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		return nil, nil, false
+	}
+	if link.RevokedAt != nil {
+		c.JSON(http.StatusGone, gin.H{"error": "Share link has been revoked"})
+		return nil, nil, false
+	}
+	return link, claims, true
+}
+
+// shareVisitor reports whether the browser already opened this share link.
+// Its cookie holds the random token of the visit that was counted for it.
+func (h *Handler) shareVisitor(c *gin.Context, link *models.ShareLink) bool {
+	value, err := c.Cookie(ShareCookie)
+	if err != nil || value == "" {
+		return false
+	}
+	valid, err := h.db.ShareVisitValid(link.ID, auth.HashToken(value))
+	if err != nil {
+		requestLogger(c).Error("Error checking share visit:", err)
+		return false
+	}
+	return valid
+}
+
+// countShareUse counts a new visit to a share link and gives the browser a
+// token for it, so its further requests aren't counted. It answers 410 when
+// the link has no uses left.
+func (h *Handler) countShareUse(c *gin.Context, token string, link *models.ShareLink) bool {
+	visit, err := auth.NewToken()
+	if err != nil {
+		requestLogger(c).Error("Error creating share visit:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check share link"})
+		return false
+	}
+	counted, err := h.db.UseShareLink(link.ID, c.ClientIP(), auth.HashToken(visit), link.ExpiresAt)
+	if err != nil {
+		requestLogger(c).Error("Error recording share link use:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check share link"})
+		return false
+	}
+	if !counted {
+		c.JSON(http.StatusGone, gin.H{"error": "Share link has no uses left"})
+		return false
+	}
+	link.Uses++
+
+	maxAge := int(time.Until(link.ExpiresAt).Seconds())
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ShareCookie, visit, maxAge, "/share/"+token, "", h.isHTTPS(c), true)
+	requestLogger(c).Info(fmt.Sprintf("Share link %q opened from %s (use %d)", link.Name, c.ClientIP(), link.Uses))
+	return true
+}
+
+// checkSharePassword checks a share link's password. Failures count like
+// failed sign-ins, against the link and the source IP; while either is
+// locked out it answers 429.
+func (h *Handler) checkSharePassword(c *gin.Context, link *models.ShareLink, password string) bool {
+	linkKey := "share:" + strconv.Itoa(link.ID)
+	ipKey := auth.IPLockoutKey(c.ClientIP())
+	for _, key := range []string{linkKey, ipKey} {
+		until, err := h.db.GetLoginLock(key)
+		if err != nil {
+			requestLogger(c).Error("Error checking share lockout:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check share link"})
+			return false
+		}
+		if !until.IsZero() {
+			retryAfter := int(time.Until(until).Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":       fmt.Sprintf("Too many wrong passwords, try again in %d seconds", retryAfter),
+				"retry_after": retryAfter,
+			})
+			return false
+		}
+	}
+
+	if auth.CheckPassword(link.PasswordHash, password) {
+		if err := h.db.ClearLoginFailures(linkKey); err != nil {
+			requestLogger(c).Warn("Failed to clear share password failures:", err)
+		}
+		return true
+	}
+
+	requestLogger(c).Warn(fmt.Sprintf("Wrong password for share link %q from %s", link.Name, c.ClientIP()))
+	limits := map[string]int{linkKey: h.cfg.LoginMaxFailures, ipKey: h.cfg.LoginMaxFailuresPerIP}
+	for key, limit := range limits {
+		failures, err := h.db.RecordLoginFailure(key, loginFailureWindow)
+		if err != nil {
+			requestLogger(c).Error("Failed to record share password failure:", err)
+			continue
+		}
+		policy := auth.LockoutPolicy{
+			MaxFailures: limit,
+			Base:        time.Duration(h.cfg.LoginLockoutSeconds) * time.Second,
+			Max:         time.Duration(h.cfg.LoginLockoutMaxSeconds) * time.Second,
+		}
+		if lock := policy.LockFor(failures); lock > 0 {
+			if err := h.db.LockLogin(key, time.Now().Add(lock)); err != nil {
+				requestLogger(c).Error("Failed to lock share link:", err)
+			}
+		}
+	}
+	return false
+}
+
+var sharePasswordPage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>{{.Name}}</title></head>
+<body style="font-family: sans-serif; max-width: 24rem; margin: 4rem auto;">
+<h2>{{.Name}}</h2>
+<p>This shared link is protected by a password.</p>
+{{if .Message}}<p style="color: #b00020;">{{.Message}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<input type="password" name="password" autofocus required style="width: 100%; padding: .5rem; box-sizing: border-box;">
+<button type="submit" style="margin-top: .75rem; padding: .5rem 1rem;">Open</button>
+</form>
+</body>
+</html>
+`))
+
+// askSharePassword answers 401, with a password form for browsers
+func (h *Handler) askSharePassword(c *gin.Context, token string, link *models.ShareLink, message string) {
+	if !strings.Contains(c.GetHeader("Accept"), "text/html") {
+		if message == "" {
+			message = "This share link needs a password"
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": message,
+			"hint":  "Send it in the " + SharePasswordHeader + " header",
+		})
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusUnauthorized)
+	err := sharePasswordPage.Execute(c.Writer, map[string]string{
+		"Name":    link.Name,
+		"Message": message,
+		"Action":  "/share/" + token,
+	})
+	if err != nil {
+		requestLogger(c).Error("Error rendering share password page:", err)
+	}
+}
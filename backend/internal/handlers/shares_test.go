@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/config"
+	"lan-relay/internal/models"
+	"lan-relay/internal/share"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newShareRouter serves share links signed with a fresh key
+func newShareRouter(t *testing.T) (*Handler, *gin.Engine) {
+	t.Helper()
+	h := newTestHandler(t, &config.Config{})
+	signer, err := share.NewSigner([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.UseShareSigner(signer)
+
+	r := gin.New()
+	r.POST("/share/:token", h.UnlockShareLink)
+	r.Any("/share/:token/*path", h.ShareProxy)
+	return h, r
+}
+
+// createShareLink stores a share link and returns its token
+func createShareLink(t *testing.T, h *Handler, link *models.ShareLink) string {
+	t.Helper()
+	link.ExpiresAt = time.Now().Add(time.Hour)
+	if err := h.db.InsertShareLink(link); err != nil {
+		t.Fatalf("InsertShareLink: %v", err)
+	}
+	token, err := h.shareSigner.Sign(share.Claims{
+		ID:         link.ID,
+		Target:     link.Target,
+		PathPrefix: link.PathPrefix,
+		Methods:    link.Methods,
+		ExpiresAt:  link.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestShareProxyCleansPaths(t *testing.T) {
+	h, r := newShareRouter(t)
+	target := targetServer(t).Listener.Addr().String()
+	token := createShareLink(t, h, &models.ShareLink{Name: "docs", Target: target, PathPrefix: "/public"})
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	tests := []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"/public/a.txt", http.StatusOK, "hello /public/a.txt"},
+		{"/public/docs/", http.StatusOK, "hello /public/docs/"},
+		{"/public/./x/../a.txt", http.StatusOK, "hello /public/a.txt"},
+		{"/public/../admin", http.StatusForbidden, ""},
+		{"/public/%2e%2e/admin", http.StatusForbidden, ""},
+		{"/public/%2E%2E%2Fadmin", http.StatusForbidden, ""},
+		{"/public/..%2f..%2fadmin", http.StatusForbidden, ""},
+		{"/public%2f..%2fadmin", http.StatusForbidden, ""},
+		{"/publicity", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			code, body := get(t, srv.URL+"/share/"+token+tt.path)
+			if code != tt.wantCode {
+				t.Fatalf("GET %s = %d %s, want %d", tt.path, code, body, tt.wantCode)
+			}
+			if tt.wantBody != "" && body != tt.wantBody {
+				t.Fatalf("GET %s reached %q, want %q", tt.path, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// unlockShare posts a share link's password form and returns the visit
+// cookie it sets
+func unlockShare(t *testing.T, srv *httptest.Server, token, password string) (int, *http.Cookie) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.PostForm(srv.URL+"/share/"+token, url.Values{"password": {password}})
+	if err != nil {
+		t.Fatalf("unlocking share: %v", err)
+	}
+	defer resp.Body.Close()
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == ShareCookie {
+			return resp.StatusCode, cookie
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// getShare fetches a share path with a visit cookie
+func getShare(t *testing.T, srv *httptest.Server, path string, cookie *http.Cookie) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestShareVisitsAreCountedPerVisit(t *testing.T) {
+	h, r := newShareRouter(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	target := targetServer(t).Listener.Addr().String()
+	hash, err := auth.HashPassword("open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := &models.ShareLink{Name: "nas", Target: target, MaxUses: 2, PasswordHash: hash}
+	token := createShareLink(t, h, link)
+	path := "/share/" + token + "/photos"
+
+	if code, _ := getShare(t, srv, path, nil); code != http.StatusUnauthorized {
+		t.Fatalf("GET without unlocking = %d, want 401", code)
+	}
+
+	// Each unlock is its own visit with its own token
+	code, first := unlockShare(t, srv, token, "open sesame")
+	if code != http.StatusSeeOther || first == nil {
+		t.Fatalf("first unlock = %d, cookie %v", code, first)
+	}
+	_, second := unlockShare(t, srv, token, "open sesame")
+	if second == nil || second.Value == first.Value {
+		t.Fatalf("second unlock cookie = %v, want a new token", second)
+	}
+	for _, cookie := range []*http.Cookie{first, second} {
+		if code, body := getShare(t, srv, path, cookie); code != http.StatusOK || body != "hello /photos" {
+			t.Fatalf("GET with a visit cookie = %d %q", code, body)
+		}
+	}
+
+	// Further requests of a visit aren't counted, and there are no uses left
+	// for another one
+	stored, err := h.db.GetShareLink(link.ID)
+	if err != nil || stored.Uses != 2 {
+		t.Fatalf("share link uses = %v, %v, want 2", stored, err)
+	}
+	if code, _ := unlockShare(t, srv, token, "open sesame"); code != http.StatusGone {
+		t.Fatalf("third unlock = %d, want 410", code)
+	}
+
+	// Tokens that weren't issued, or were issued for another link, don't
+	// unlock anything
+	other := createShareLink(t, h, &models.ShareLink{Name: "other", Target: target, PasswordHash: hash})
+	_, otherCookie := unlockShare(t, srv, other, "open sesame")
+	for _, cookie := range []*http.Cookie{
+		{Name: ShareCookie, Value: "forged"},
+		{Name: ShareCookie, Value: otherCookie.Value},
+	} {
+		if code, _ := getShare(t, srv, path, cookie); code != http.StatusUnauthorized {
+			t.Errorf("GET with cookie %q = %d, want 401", cookie.Value, code)
+		}
+	}
+}
+
+func TestCleanSharePath(t *testing.T) {
+	tests := []struct {
+		escaped string
+		want    string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/a/b", "/a/b"},
+		{"/a/b/", "/a/b/"},
+		{"//a//b", "/a/b"},
+		{"/a/%2e%2e/b", "/b"},
+		{"/../../etc", "/etc"},
+		{"/a%2Fb", "/a/b"},
+	}
+	for _, tt := range tests {
+		if got, err := cleanSharePath(tt.escaped); err != nil || got != tt.want {
+			t.Errorf("cleanSharePath(%q) = %q, %v, want %q", tt.escaped, got, err, tt.want)
+		}
+	}
+	if _, err := cleanSharePath("/%zz"); err == nil {
+		t.Error("cleanSharePath accepted a bad escape")
+	}
+}
+
+func TestShareLinksFollowTargetGrants(t *testing.T) {
+	h := newTestHandler(t, &config.Config{AuthEnabled: true})
+	olive := createUser(t, h, "olive", auth.RoleOperator)
+	otto := createUser(t, h, "otto", auth.RoleOperator)
+	admin := createUser(t, h, "root", auth.RoleAdmin)
+	for _, user := range []*models.User{olive, otto} {
+		if err := h.db.InsertTargetGrant(&models.TargetGrant{UserID: user.ID, Target: "10.0.0.5"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]*models.ShareLink{
+		"olive's": {Name: "olive's", Target: "10.0.0.5:80", Methods: []string{"GET"}, CreatedBy: olive.ID},
+		"otto's":  {Name: "otto's", Target: "10.0.0.5:443", Methods: []string{"GET"}, CreatedBy: otto.ID},
+		"admin's": {Name: "admin's", Target: "10.0.0.9:80", Methods: []string{"GET"}, CreatedBy: admin.ID},
+	}
+	for _, link := range links {
+		link.ExpiresAt = time.Now().Add(time.Hour)
+		if err := h.db.InsertShareLink(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := func(user *models.User) *gin.Engine {
+		r := gin.New()
+		r.Use(func(c *gin.Context) { c.Set(userKey, user) })
+		r.GET("/api/shares", h.GetShareLinks)
+		r.DELETE("/api/shares/:id", h.RevokeShareLink)
+		return r
+	}
+
+	listed := func(user *models.User) map[string]bool {
+		w := httptest.NewRecorder()
+		router(user).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/shares", nil))
+		var response struct {
+			Shares []models.ShareLink `json:"shares"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("GET /api/shares = %d %s", w.Code, w.Body)
+		}
+		names := make(map[string]bool)
+		for _, link := range response.Shares {
+			names[link.Name] = true
+		}
+		return names
+	}
+	if names := listed(olive); len(names) != 2 || !names["olive's"] || !names["otto's"] {
+		t.Errorf("olive's share list = %v, want the links to 10.0.0.5 only", names)
+	}
+	if names := listed(admin); len(names) != 3 {
+		t.Errorf("admin's share list = %v, want every link", names)
+	}
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		link     string
+		wantCode int
+	}{
+		{"another user's link", olive, "otto's", http.StatusForbidden},
+		{"an admin's link", olive, "admin's", http.StatusForbidden},
+		{"own link", olive, "olive's", http.StatusOK},
+		{"admin revoking another user's link", admin, "otto's", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			path := "/api/shares/" + strconv.Itoa(links[tt.link].ID)
+			router(tt.user).ServeHTTP(w, httptest.NewRequest(http.MethodDelete, path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("DELETE %s as %s = %d %s, want %d", path, tt.user.Username, w.Code, w.Body, tt.wantCode)
+			}
+			revoked, err := h.db.GetShareLink(links[tt.link].ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wantRevoked := tt.wantCode == http.StatusOK; (revoked.RevokedAt != nil) != wantRevoked {
+				t.Errorf("link revoked = %v, want %v", revoked.RevokedAt != nil, wantRevoked)
+			}
+		})
+	}
+}
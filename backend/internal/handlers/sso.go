@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/models"
+	"lan-relay/internal/sso"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCStateCookie ties a provider callback to the browser that started the login
+const OIDCStateCookie = "lr_oidc_state"
+
+const oidcCallbackPath = "/api/auth/oidc/callback"
+
+// ssoState caches the discovered provider and the logins in progress
+type ssoState struct {
+	mu       sync.Mutex
+	key      string
+	provider *sso.Provider
+	pending  map[string]sso.Pending
+}
+
+var oidcHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProvider returns the provider for the current settings, discovering it
+// again when the settings change
+func (h *Handler) oidcProvider(ctx context.Context, settings models.OIDCSettings) (*sso.Provider, error) {
+	mapping, err := sso.ParseRoleMapping(settings.RoleMapping)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%+v", settings)
+
+	h.sso.mu.Lock()
+	defer h.sso.mu.Unlock()
+	if h.sso.provider != nil && h.sso.key == key {
+		return h.sso.provider, nil
+	}
+
+	provider, err := sso.New(ctx, sso.Config{
+		Issuer:        settings.Issuer,
+		ClientID:      settings.ClientID,
+		ClientSecret:  settings.ClientSecret,
+		Scopes:        strings.Fields(settings.Scopes),
+		UsernameClaim: settings.UsernameClaim,
+		GroupsClaim:   settings.GroupsClaim,
+		RoleMapping:   mapping,
+		DefaultRole:   settings.DefaultRole,
+	}, oidcHTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	h.sso.key, h.sso.provider = key, provider
+	return provider, nil
+}
+
+// GetSSOConfig tells the login page whether single sign-on is available
+func (h *Handler) GetSSOConfig(c *gin.Context) {
+	settings, err := h.db.GetSettings()
+	if err != nil {
+		requestLogger(c).Error("Error fetching settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
+	}
+
+	enabled := h.cfg.AuthEnabled && settings.OIDC.Enabled
+	response := gin.H{"oidc_enabled": enabled}
+	if enabled {
+		response["login_url"] = "/api/auth/oidc/login"
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// OIDCLogin sends the browser to the identity provider. ?return_to= is the
+// relay path to come back to afterwards.
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	settings, err := h.db.GetSettings()
+	if err != nil {
+		requestLogger(c).Error("Error fetching settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
+	}
+	if !h.cfg.AuthEnabled || !settings.OIDC.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+
+	provider, err := h.oidcProvider(c.Request.Context(), settings.OIDC)
+	if err != nil {
+		requestLogger(c).Error("Error loading OIDC provider:", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable: " + err.Error()})
+		return
+	}
+
+	redirectURL := settings.OIDC.RedirectURL
+	if redirectURL == "" {
+		redirectURL = h.externalURL(c, oidcCallbackPath)
+	}
+	authURL, pending, err := provider.Start(redirectURL, safeReturnPath(c.Query("return_to")))
+	if err != nil {
+		requestLogger(c).Error("Error starting OIDC login:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+
+	h.sso.mu.Lock()
+	if h.sso.pending == nil {
+		h.sso.pending = make(map[string]sso.Pending)
+	}
+	for state, p := range h.sso.pending {
+		if time.Now().After(p.Expires) {
+			delete(h.sso.pending, state)
+		}
+	}
+	h.sso.pending[pending.State] = pending
+	h.sso.mu.Unlock()
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(OIDCStateCookie, pending.State, int(time.Until(pending.Expires).Seconds()), oidcCallbackPath, "", h.isHTTPS(c), true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback completes a login at the identity provider, creating the
+// user on first sign-in and updating their role from their groups
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	if message := c.Query("error"); message != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider refused sign-in: " + message + " " + c.Query("error_description")})
+		return
+	}
+
+	state := c.Query("state")
+	cookieState, _ := c.Cookie(OIDCStateCookie)
+	c.SetCookie(OIDCStateCookie, "", -1, oidcCallbackPath, "", h.isHTTPS(c), true)
+	if state == "" || state != cookieState {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sign-in state doesn't match, start again"})
+		return
+	}
+
+	h.sso.mu.Lock()
+	pending, ok := h.sso.pending[state]
+	delete(h.sso.pending, state)
+	provider := h.sso.provider
+	h.sso.mu.Unlock()
+	if !ok || provider == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sign-in expired, start again"})
+		return
+	}
+
+	identity, err := provider.Finish(c.Request.Context(), c.Query("code"), pending)
+	if err != nil {
+		requestLogger(c).Warn("OIDC sign-in failed:", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in failed: " + err.Error()})
+		return
+	}
+
+	role, ok := provider.RoleFor(identity.Groups)
+	if !ok {
+		h.recordAudit(c, "auth.sso_denied", "user:"+identity.Username, fmt.Sprintf("no role for groups %v", identity.Groups))
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your account isn't in a group that can use the relay"})
+		return
+	}
+
+	user, ok := h.ssoUser(c, identity, role)
+	if !ok {
+		return
+	}
+	if _, ok := h.startSession(c, user); !ok {
+		return
+	}
+
+	h.recordAuditFor(c, user, "auth.login", "user:"+user.Username, "sso")
+	requestLogger(c).Info(fmt.Sprintf("User %q signed in through SSO from %s", user.Username, c.ClientIP()))
+	c.Redirect(http.StatusFound, pending.ReturnTo)
+}
+
+// ssoUser finds or creates the local user for an SSO identity and applies
+// its role, writing an error response if it can't
+func (h *Handler) ssoUser(c *gin.Context, identity *sso.Identity, role string) (*models.User, bool) {
+	subject := identity.Issuer + "|" + identity.Subject
+	user, err := h.db.GetUserByOIDCSubject(subject)
+	if err != nil {
+		requestLogger(c).Error("Error fetching user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return nil, false
+	}
+
+	if user == nil {
+		existing, err := h.db.GetUserByUsername(identity.Username)
+		if err != nil {
+			requestLogger(c).Error("Error fetching user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return nil, false
+		}
+		// Local accounts are never taken over by a provider account with the same name
+		if existing != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Username %q is used by a local account", identity.Username)})
+			return nil, false
+		}
+
+		user = &models.User{Username: identity.Username, Role: role, OIDCSubject: subject}
+		if err := h.db.InsertUser(user); err != nil {
+			requestLogger(c).Error("Error creating user:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return nil, false
+		}
+		h.recordAudit(c, "user.create", "user:"+user.Username, "role "+role+" from SSO")
+		return user, true
+	}
+
+	if user.Role != role {
+		if err := h.db.UpdateUserRole(user.ID, role); err != nil {
+			requestLogger(c).Error("Error updating role:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return nil, false
+		}
+		h.recordAudit(c, "user.role", "user:"+user.Username, user.Role+" -> "+role+" from SSO groups")
+		user.Role = role
+	}
+	return user, true
+}
+
+// externalURL builds an absolute URL for a relay path as the client sees it,
+// so redirects work through the tunnel as well as on the LAN. X-Forwarded-Host
+// only counts when a trusted proxy sent it.
+func (h *Handler) externalURL(c *gin.Context, path string) string {
+	scheme := "http"
+	if h.isHTTPS(c) {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if forwarded := c.GetHeader("X-Forwarded-Host"); forwarded != "" && h.trustedPeer(c) {
+		host = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return scheme + "://" + host + path
+}
+
+// isHTTPS reports whether the client reached the relay over HTTPS, directly
+// or through a trusted proxy such as the tunnel agent
+func (h *Handler) isHTTPS(c *gin.Context) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+	return h.trustedPeer(c) && strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+}
+
+// safeReturnPath only allows returning to a path on the relay itself
+func safeReturnPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
+
+// validateOIDCSettings checks SSO settings before they're saved
+func validateOIDCSettings(settings models.OIDCSettings) error {
+	if _, err := sso.ParseRoleMapping(settings.RoleMapping); err != nil {
+		return err
+	}
+	if settings.DefaultRole != "" {
+		if err := auth.ValidateRole(settings.DefaultRole); err != nil {
+			return err
+		}
+	}
+	if settings.Enabled && (settings.Issuer == "" || settings.ClientID == "") {
+		return fmt.Errorf("an issuer and client ID are required to enable single sign-on")
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/config"
+	"lan-relay/internal/models"
+	"lan-relay/internal/sso/ssotest"
+	"lan-relay/internal/vault"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newSSOHandler serves single sign-on against a test identity provider
+func newSSOHandler(t *testing.T) (*Handler, *gin.Engine, *ssotest.Provider) {
+	t.Helper()
+	idp := ssotest.NewProvider()
+	t.Cleanup(idp.Close)
+
+	h := newTestHandler(t, &config.Config{AuthEnabled: true, TrustedProxies: []string{"127.0.0.1"}})
+	// The client secret is sealed with the vault
+	v, err := vault.New([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.db.UseVault(v)
+	settings, err := h.db.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings.OIDC = models.OIDCSettings{
+		Enabled:      true,
+		Issuer:       idp.URL,
+		ClientID:     ssotest.ClientID,
+		ClientSecret: ssotest.ClientSecret,
+		RoleMapping:  "admins=admin,staff=viewer",
+	}
+	if err := h.db.UpdateSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.GET("/api/auth/oidc/login", h.OIDCLogin)
+	r.GET(oidcCallbackPath, h.OIDCCallback)
+	return h, r, idp
+}
+
+// startSSO begins a login from remoteAddr and returns the provider URL and
+// the state cookie
+func startSSO(t *testing.T, r http.Handler, remoteAddr string, headers map[string]string) (*url.URL, *http.Cookie) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "http://relay.test/api/auth/oidc/login?return_to=/logs", nil)
+	req.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("login = %d %s", w.Code, w.Body)
+	}
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == OIDCStateCookie {
+			return authURL, cookie
+		}
+	}
+	t.Fatal("login didn't set the state cookie")
+	return nil, nil
+}
+
+// callbackQuery signs in at the provider and returns the callback's query
+func callbackQuery(t *testing.T, authURL *url.URL) url.Values {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL.String())
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return back.Query()
+}
+
+// finishSSO calls the relay's callback with a state cookie
+func finishSSO(r http.Handler, query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, oidcCallbackPath+"?"+query.Encode(), nil)
+	req.RemoteAddr = "127.0.0.1:5000"
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// signInWithSSO runs a whole login as the identity in claims
+func signInWithSSO(t *testing.T, r http.Handler, idp *ssotest.Provider, claims map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	idp.SignInAs(claims)
+	authURL, cookie := startSSO(t, r, "127.0.0.1:5000", nil)
+	return finishSSO(r, callbackQuery(t, authURL), cookie)
+}
+
+func TestSSOLinksUsersBySubject(t *testing.T) {
+	h, r, idp := newSSOHandler(t)
+
+	w := signInWithSSO(t, r, idp, map[string]interface{}{"sub": "u-1", "preferred_username": "alice", "groups": []string{"admins"}})
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/logs" {
+		t.Fatalf("first sign-in = %d %s", w.Code, w.Body)
+	}
+	user, err := h.db.GetUserByOIDCSubject(idp.URL + "|u-1")
+	if err != nil || user == nil || user.Username != "alice" || user.Role != auth.RoleAdmin {
+		t.Fatalf("linked user = %+v, %v", user, err)
+	}
+
+	// The subject decides who signs in, whatever the username claim says,
+	// and the role follows the groups
+	w = signInWithSSO(t, r, idp, map[string]interface{}{"sub": "u-1", "preferred_username": "alice2", "groups": []string{"staff"}})
+	if w.Code != http.StatusFound {
+		t.Fatalf("second sign-in = %d %s", w.Code, w.Body)
+	}
+	again, err := h.db.GetUserByOIDCSubject(idp.URL + "|u-1")
+	if err != nil || again.ID != user.ID || again.Role != auth.RoleViewer {
+		t.Fatalf("user after second sign-in = %+v, %v, want user %d as viewer", again, err, user.ID)
+	}
+	if renamed, _ := h.db.GetUserByUsername("alice2"); renamed != nil {
+		t.Fatal("a second user was created for the same subject")
+	}
+
+	// Local accounts aren't taken over by a provider account of the same name
+	createUser(t, h, "carol", auth.RoleAdmin)
+	w = signInWithSSO(t, r, idp, map[string]interface{}{"sub": "u-3", "preferred_username": "carol", "groups": []string{"staff"}})
+	if w.Code != http.StatusConflict {
+		t.Fatalf("sign-in as a local username = %d, want 409", w.Code)
+	}
+
+	// Users in no mapped group are refused without a default role
+	w = signInWithSSO(t, r, idp, map[string]interface{}{"sub": "u-4", "preferred_username": "dave", "groups": []string{"guests"}})
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("sign-in without a role = %d, want 403", w.Code)
+	}
+}
+
+func TestSSOCallbackChecksStateAndNonce(t *testing.T) {
+	_, r, idp := newSSOHandler(t)
+	idp.SignInAs(map[string]interface{}{"sub": "u-1", "preferred_username": "alice", "groups": []string{"admins"}})
+
+	authURL, cookie := startSSO(t, r, "127.0.0.1:5000", nil)
+	query := callbackQuery(t, authURL)
+	otherURL, otherCookie := startSSO(t, r, "127.0.0.1:5000", nil)
+	otherQuery := callbackQuery(t, otherURL)
+
+	// Another browser's state, or none, doesn't complete the login
+	if w := finishSSO(r, query, otherCookie); w.Code != http.StatusBadRequest {
+		t.Errorf("callback with another login's state cookie = %d, want 400", w.Code)
+	}
+	if w := finishSSO(r, query, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("callback without a state cookie = %d, want 400", w.Code)
+	}
+	if w := finishSSO(r, query, cookie); w.Code != http.StatusFound {
+		t.Fatalf("callback = %d %s", w.Code, w.Body)
+	}
+	// Each state is used once
+	if w := finishSSO(r, query, cookie); w.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback = %d, want 400", w.Code)
+	}
+	if w := finishSSO(r, otherQuery, otherCookie); w.Code != http.StatusFound {
+		t.Errorf("second login's callback = %d %s", w.Code, w.Body)
+	}
+
+	// An ID token for another login's nonce is refused
+	idp.ForceNonce("someone-elses-nonce")
+	authURL, cookie = startSSO(t, r, "127.0.0.1:5000", nil)
+	if w := finishSSO(r, callbackQuery(t, authURL), cookie); w.Code != http.StatusUnauthorized {
+		t.Errorf("callback with a wrong nonce = %d, want 401", w.Code)
+	}
+}
+
+func TestSSOTrustsForwardedHeadersOnlyFromProxies(t *testing.T) {
+	_, r, _ := newSSOHandler(t)
+	forwarded := map[string]string{"X-Forwarded-Host": "relay.example.com", "X-Forwarded-Proto": "https"}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		wantRedirect string
+		wantSecure   bool
+	}{
+		{"trusted proxy", "127.0.0.1:5000", "https://relay.example.com" + oidcCallbackPath, true},
+		{"other client", "192.0.2.10:5000", "http://relay.test" + oidcCallbackPath, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authURL, cookie := startSSO(t, r, tt.remoteAddr, forwarded)
+			if got := authURL.Query().Get("redirect_uri"); got != tt.wantRedirect {
+				t.Errorf("redirect_uri = %q, want %q", got, tt.wantRedirect)
+			}
+			if cookie.Secure != tt.wantSecure {
+				t.Errorf("state cookie Secure = %v, want %v", cookie.Secure, tt.wantSecure)
+			}
+		})
+	}
+}
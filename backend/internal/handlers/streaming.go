@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const streamAbortedKey = "stream_aborted"
+
+// AbortBrokenStreams closes the client connection when a proxied stream broke
+// mid-response, so clients see a truncated body rather than a clean end of stream.
+// It must run outside gin.Recovery, which would otherwise report the abort as a panic.
+func AbortBrokenStreams() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if c.GetBool(streamAbortedKey) {
+			// net/http closes the connection quietly for this sentinel
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// abortStream marks the response as broken for AbortBrokenStreams
+func abortStream(c *gin.Context) {
+	c.Set(streamAbortedKey, true)
+	c.Abort()
+}
+
+// isStreamingResponse reports whether a response is an event stream or has no
+// known length (chunked, long-poll) and so must be relayed as it arrives
+func isStreamingResponse(resp *http.Response) bool {
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && mediaType == "text/event-stream" {
+		return true
+	}
+	for _, encoding := range resp.TransferEncoding {
+		if strings.EqualFold(encoding, "chunked") {
+			return true
+		}
+	}
+	return resp.ContentLength < 0
+}
+
+// responseBytes returns the number of body bytes written to the client
+func responseBytes(c *gin.Context) int64 {
+	if size := c.Writer.Size(); size > 0 {
+		return int64(size)
+	}
+	return 0
+}
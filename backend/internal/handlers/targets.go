@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TargetManual marks targets added by hand rather than from discovery
+const TargetManual = "manual"
+
+// GetTargets lists the registered proxy targets
+func (h *Handler) GetTargets(c *gin.Context) {
+	targets, err := h.db.GetTargets()
+	if err != nil {
+		requestLogger(c).Error("Error fetching targets:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch targets"})
+		return
+	}
+
+	for i := range targets {
+		setProxyPath(&targets[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"targets": targets})
+}
+
+// CreateTarget registers a proxy target
+func (h *Handler) CreateTarget(c *gin.Context) {
+	var target models.Target
+	if err := c.ShouldBindJSON(&target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	target.Source = TargetManual
+
+	h.registerTarget(c, &target)
+}
+
+// UpdateTarget renames or re-addresses a target
+func (h *Handler) UpdateTarget(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target ID"})
+		return
+	}
+
+	existing, err := h.db.GetTarget(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching target:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch target"})
+		return
+	}
+	if existing == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target not found"})
+		return
+	}
+
+	target := *existing
+	if err := c.ShouldBindJSON(&target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		return
+	}
+	target.ID = id
+	target.Source = existing.Source
+
+	if err := validateTarget(&target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if other, err := h.db.GetTargetByAddress(target.Host, target.Port); err == nil && other != nil && other.ID != id {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another target already uses this address"})
+		return
+	}
+
+	if err := h.db.UpdateTarget(&target); err != nil {
+		requestLogger(c).Error("Error updating target:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update target"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Target %d updated", id))
+	h.recordAuditChange(c, "target.update", fmt.Sprintf("target:%d", id), existing, target)
+	setProxyPath(&target)
+	c.JSON(http.StatusOK, target)
+}
+
+// DeleteTarget removes a registered target
+func (h *Handler) DeleteTarget(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target ID"})
+		return
+	}
+
+	existing, err := h.db.GetTarget(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching target:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch target"})
+		return
+	}
+
+	if err := h.db.DeleteTarget(id); err != nil {
+		requestLogger(c).Error("Error deleting target:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete target"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Target %d deleted", id))
+	if existing != nil {
+		h.recordAuditChange(c, "target.delete", fmt.Sprintf("target:%d", id), existing, nil)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Target deleted successfully"})
+}
+
+// registerTarget validates and stores a new target, answering 409 with the
+// existing target if the address is already registered
+func (h *Handler) registerTarget(c *gin.Context, target *models.Target) {
+	if err := validateTarget(target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := h.db.GetTargetByAddress(target.Host, target.Port)
+	if err != nil {
+		requestLogger(c).Error("Error fetching target:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch target"})
+		return
+	}
+	if existing != nil {
+		setProxyPath(existing)
+		c.JSON(http.StatusConflict, gin.H{"error": "Target is already registered", "target": existing})
+		return
+	}
+
+	if err := h.db.InsertTarget(target); err != nil {
+		requestLogger(c).Error("Error creating target:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create target"})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Target %q registered at %s:%d", target.Name, target.Host, target.Port))
+	h.recordAuditChange(c, "target.create", fmt.Sprintf("target:%d", target.ID), nil, target)
+	setProxyPath(target)
+	c.JSON(http.StatusCreated, target)
+}
+
+func validateTarget(target *models.Target) error {
+	target.Host = strings.TrimSpace(target.Host)
+	target.Name = strings.TrimSpace(target.Name)
+
+	if target.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	if target.Port < 1 || target.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	if !isPrivateIP(target.Host) {
+		return fmt.Errorf("only private IP addresses are allowed")
+	}
+	if target.Name == "" {
+		target.Name = net.JoinHostPort(target.Host, strconv.Itoa(target.Port))
+	}
+	return nil
+}
+
+// setProxyPath fills in the relay path that reaches a target
+func setProxyPath(target *models.Target) {
+	target.ProxyPath = "/proxy/" + net.JoinHostPort(target.Host, strconv.Itoa(target.Port)) + "/"
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APITokenHeader carries an API token when the Authorization header is
+// needed by the target itself
+const APITokenHeader = "X-Relay-Token"
+
+const apiTokenKey = "api_token"
+
+// apiTokenFrom returns the API token sent with a request, if any. Bearer
+// credentials that aren't relay tokens are left for the target.
+func apiTokenFrom(r *http.Request) string {
+	if token := r.Header.Get(APITokenHeader); token != "" {
+		return token
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if ok && strings.EqualFold(scheme, "Bearer") && strings.HasPrefix(token, auth.APITokenPrefix) {
+		return token
+	}
+	return ""
+}
+
+// authenticateToken checks an API token and loads its owner, aborting the
+// request if the token isn't valid. Its scopes are checked by authorize.
+func (h *Handler) authenticateToken(c *gin.Context, secret string) bool {
+	token, err := h.db.GetAPITokenByHash(auth.HashToken(secret))
+	if err != nil {
+		requestLogger(c).Error("Error fetching API token:", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check API token"})
+		return false
+	}
+	if token == nil || token.Expired() {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API token"})
+		return false
+	}
+
+	user, err := h.db.GetUser(token.UserID)
+	if err != nil {
+		requestLogger(c).Error("Error fetching user:", err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check API token"})
+		return false
+	}
+	if user == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API token"})
+		return false
+	}
+
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) > sessionTouchInterval || token.LastUsedIP != c.ClientIP() {
+		if err := h.db.TouchAPIToken(token.ID, c.ClientIP()); err != nil {
+			requestLogger(c).Warn("Failed to record API token use:", err)
+		}
+	}
+
+	// The token is never forwarded or captured
+	c.Request.Header.Del(APITokenHeader)
+	if _, bearer, _ := strings.Cut(c.Request.Header.Get("Authorization"), " "); bearer == secret {
+		c.Request.Header.Del("Authorization")
+	}
+
+	c.Set(userKey, user)
+	c.Set(apiTokenKey, token)
+	return true
+}
+
+// currentAPIToken returns the API token the request was made with, if any
+func currentAPIToken(c *gin.Context) *models.APIToken {
+	if value, ok := c.Get(apiTokenKey); ok {
+		if token, ok := value.(*models.APIToken); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+// GetAPITokens lists the signed-in user's API tokens, or everyone's for
+// users with the users:manage permission
+func (h *Handler) GetAPITokens(c *gin.Context) {
+	userID := 0
+	if user := currentUser(c); user != nil && !auth.RoleAllows(user.Role, auth.PermUsersManage) {
+		userID = user.ID
+	}
+
+	tokens, err := h.db.GetAPITokens(userID)
+	if err != nil {
+		requestLogger(c).Error("Error fetching API tokens:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens, "scopes": auth.Scopes})
+}
+
+// CreateAPIToken issues an API token for the signed-in user. The token is
+// only returned in this response.
+func (h *Handler) CreateAPIToken(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Authentication is disabled"})
+		return
+	}
+
+	var request struct {
+		Name          string     `json:"name" binding:"required"`
+		Scopes        []string   `json:"scopes"`
+		Targets       []string   `json:"targets"`
+		ExpiresAt     *time.Time `json:"expires_at"`
+		ExpiresInDays int        `json:"expires_in_days"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token name is required"})
+		return
+	}
+
+	token := &models.APIToken{
+		Name:      request.Name,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Scopes:    request.Scopes,
+		Targets:   request.Targets,
+		ExpiresAt: request.ExpiresAt,
+	}
+	if token.Targets == nil {
+		token.Targets = make([]string, 0)
+	}
+	if request.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, request.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := auth.ValidateAPIToken(token, user.Role); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := auth.IssueAPIToken(h.db, token, user.Role)
+	if err != nil {
+		requestLogger(c).Error("Error creating API token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API token"})
+		return
+	}
+
+	h.recordAudit(c, "token.create", "token:"+strconv.Itoa(token.ID), fmt.Sprintf("%q with scopes %s", token.Name, strings.Join(token.Scopes, ",")))
+	requestLogger(c).Info(fmt.Sprintf("API token %q created by %q with scopes %s", token.Name, user.Username, strings.Join(token.Scopes, ",")))
+	c.JSON(http.StatusCreated, gin.H{"token": secret, "api_token": token})
+}
+
+// DeleteAPIToken revokes an API token. Users can revoke their own tokens,
+// and anyone's with the users:manage permission.
+func (h *Handler) DeleteAPIToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	token, err := h.db.GetAPIToken(id)
+	if err != nil {
+		requestLogger(c).Error("Error fetching API token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch API token"})
+		return
+	}
+	user := currentUser(c)
+	if token == nil || user != nil && token.UserID != user.ID && !auth.RoleAllows(user.Role, auth.PermUsersManage) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API token not found"})
+		return
+	}
+
+	if err := h.db.DeleteAPIToken(id); err != nil {
+		requestLogger(c).Error("Error deleting API token:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API token"})
+		return
+	}
+
+	h.recordAudit(c, "token.revoke", "token:"+strconv.Itoa(token.ID), fmt.Sprintf("%q owned by %s", token.Name, token.Username))
+	requestLogger(c).Info(fmt.Sprintf("API token %q revoked", token.Name))
+	c.JSON(http.StatusOK, gin.H{"message": "API token revoked"})
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/config"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// issueToken stores an API token for a user and returns its secret
+func issueToken(t *testing.T, h *Handler, user *models.User, scopes ...string) string {
+	t.Helper()
+	secret, err := auth.IssueAPIToken(h.db, &models.APIToken{Name: "ci", UserID: user.ID, Scopes: scopes}, user.Role)
+	if err != nil {
+		t.Fatalf("IssueAPIToken: %v", err)
+	}
+	return secret
+}
+
+func TestAPITokens(t *testing.T) {
+	h := newTestHandler(t, &config.Config{AuthEnabled: true})
+	viewer := createUser(t, h, "vera", auth.RoleViewer)
+	demoted := createUser(t, h, "olive", auth.RoleOperator)
+	admin := createUser(t, h, "root", auth.RoleAdmin)
+
+	logs := issueToken(t, h, viewer, auth.ScopeLogsRead)
+	proxyOnly := issueToken(t, h, viewer, auth.ScopeProxyRead)
+	tunnel := issueToken(t, h, demoted, auth.ScopeTunnelManage)
+	if err := h.db.UpdateUserRole(demoted.ID, auth.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	everything := issueToken(t, h, admin, auth.Scopes...)
+
+	past := time.Now().Add(-time.Minute)
+	expiredSecret := auth.APITokenPrefix + "expired-token"
+	expired := &models.APIToken{Name: "old", UserID: viewer.ID, Scopes: []string{auth.ScopeLogsRead}, TokenHash: auth.HashToken(expiredSecret), Prefix: "lrt_expire", ExpiresAt: &past}
+	if err := h.db.InsertAPIToken(expired); err != nil {
+		t.Fatal(err)
+	}
+
+	// The endpoints answer with the Authorization header they were left with
+	echo := func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetHeader("Authorization")+c.GetHeader(APITokenHeader))
+	}
+	r := gin.New()
+	r.Use(h.RequireAuth())
+	r.GET("/api/status", echo)
+	r.GET("/api/tunnel", echo)
+	r.GET("/api/users", echo)
+
+	tests := []struct {
+		name     string
+		header   string
+		token    string
+		path     string
+		wantCode int
+	}{
+		{"scope granted", "Authorization", "Bearer " + logs, "/api/status", http.StatusOK},
+		{"token header", APITokenHeader, logs, "/api/status", http.StatusOK},
+		{"scope missing", "Authorization", "Bearer " + proxyOnly, "/api/status", http.StatusForbidden},
+		{"scope beyond the owner's new role", "Authorization", "Bearer " + tunnel, "/api/tunnel", http.StatusForbidden},
+		{"endpoint tokens can't use", "Authorization", "Bearer " + everything, "/api/users", http.StatusForbidden},
+		{"admin token", "Authorization", "Bearer " + everything, "/api/tunnel", http.StatusOK},
+		{"expired token", "Authorization", "Bearer " + expiredSecret, "/api/status", http.StatusUnauthorized},
+		{"unknown token", "Authorization", "Bearer " + auth.APITokenPrefix + "made-up", "/api/status", http.StatusUnauthorized},
+		{"not a relay token", "Authorization", "Bearer something-else", "/api/status", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set(tt.header, tt.token)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("GET %s = %d %s, want %d", tt.path, w.Code, w.Body, tt.wantCode)
+			}
+			// The token is removed before the request goes any further
+			if w.Code == http.StatusOK && w.Body.Len() != 0 {
+				t.Errorf("GET %s passed on the token: %q", tt.path, w.Body)
+			}
+		})
+	}
+
+	// Only a hash and a short prefix of the secret are stored
+	stored, err := h.db.GetAPITokenByHash(auth.HashToken(logs))
+	if err != nil || stored == nil {
+		t.Fatalf("GetAPITokenByHash() = %v, %v", stored, err)
+	}
+	if stored.TokenHash == logs || stored.Prefix != logs[:len(auth.APITokenPrefix)+6] {
+		t.Errorf("stored token hash %q and prefix %q", stored.TokenHash, stored.Prefix)
+	}
+}
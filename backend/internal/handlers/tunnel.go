@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"lan-relay/internal/logger"
+	"lan-relay/internal/models"
+	"lan-relay/internal/ngrok"
+	"lan-relay/internal/tunnel"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tunnelProviders are the providers that can be chosen in settings
+var tunnelProviders = []string{"ngrok", "cloudflared", "frpc", "ssh"}
+
+// tunnelHints point to where each provider's credentials come from
+var tunnelHints = map[string]string{
+	"ngrok":       "Get your token from: https://dashboard.ngrok.com/get-started/your-authtoken",
+	"cloudflared": "Leave the token empty for a quick tunnel, or copy a named tunnel's token and hostname from the Cloudflare Zero Trust dashboard",
+	"frpc":        "Set the frp server (HOST:PORT), its token and the custom domain it routes to the relay",
+	"ssh":         "Set the SSH server, user and private key, and pin the server's host key (ssh-keyscan HOST | ssh-keygen -lf -)",
+}
+
+// newTunnelProvider creates a tunnel provider from the settings
+func (h *Handler) newTunnelProvider(name string, settings *models.Settings) (tunnel.Provider, error) {
+	port, err := strconv.Atoi(h.cfg.Port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay port %q", h.cfg.Port)
+	}
+	opts := tunnel.Options{Port: port, Notify: h.tunnelEvent, Serve: h.tunnelConns.deliver}
+
+	switch name {
+	case "ngrok":
+		if settings.NgrokToken == "" {
+			return nil, fmt.Errorf("Ngrok token not configured. Please set your token in settings first.")
+		}
+		return ngrok.NewManager(settings.NgrokToken, settings.NgrokDomain, opts), nil
+	case "cloudflared":
+		return tunnel.NewCloudflared(settings.Tunnel.CloudflaredToken, settings.Tunnel.CloudflaredHostname, opts), nil
+	case "frpc":
+		return tunnel.NewFRPC(settings.Tunnel.FRPServer, settings.Tunnel.FRPToken, settings.Tunnel.FRPDomain, opts), nil
+	case "ssh":
+		return tunnel.NewSSH(tunnel.SSHConfig{
+			Server:     settings.Tunnel.SSHServer,
+			User:       settings.Tunnel.SSHUser,
+			PrivateKey: settings.Tunnel.SSHPrivateKey,
+			HostKey:    settings.Tunnel.SSHHostKey,
+			RemoteAddr: settings.Tunnel.SSHRemoteAddr,
+			PublicURL:  settings.Tunnel.SSHPublicURL,
+		}, opts), nil
+	}
+	return nil, fmt.Errorf("unknown tunnel provider %q", name)
+}
+
+// tunnelEvent logs what happens to the tunnel, and keeps its URL current
+// when the tunnel drops or reconnects on its own
+func (h *Handler) tunnelEvent(event tunnel.Event) {
+	message := fmt.Sprintf("Tunnel %s %s", event.Provider, event.Type)
+	if event.Message != "" {
+		message += ": " + event.Message
+	}
+
+	switch event.Type {
+	case tunnel.EventOutput:
+		logger.Debug(message)
+	case tunnel.EventExited, tunnel.EventError:
+		logger.Warn(message)
+	default:
+		logger.Info(message)
+	}
+
+	switch event.Type {
+	case tunnel.EventStarted:
+		h.tunnelURL.Store(event.Message)
+	case tunnel.EventExited:
+		h.tunnelURL.Store("")
+	}
+}
+
+// GetTunnel returns the tunnel's status and recent events
+func (h *Handler) GetTunnel(c *gin.Context) {
+	settings, err := h.db.GetSettings()
+	if err != nil {
+		requestLogger(c).Error("Error fetching settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch settings"})
+		return
+	}
+
+	h.tunnelMutex.Lock()
+	provider := h.tunnel
+	h.tunnelMutex.Unlock()
+
+	status := tunnel.Status{Provider: tunnelProviderName(settings), State: tunnel.StateStopped}
+	events := make([]tunnel.Event, 0)
+	if provider != nil {
+		status = provider.Status()
+		events = provider.Events()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    status,
+		"events":    events,
+		"provider":  tunnelProviderName(settings),
+		"providers": tunnelProviders,
+	})
+}
+
+// tunnelProviderName returns the provider chosen in settings
+func tunnelProviderName(settings *models.Settings) string {
+	if settings.Tunnel.Provider == "" {
+		return "ngrok"
+	}
+	return settings.Tunnel.Provider
+}
+
+// StartTunnel starts the tunnel provider chosen in settings, or the one
+// named in the request
+func (h *Handler) StartTunnel(c *gin.Context) {
+	var request struct {
+		Provider string `json:"provider"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+			return
+		}
+	}
+	h.startTunnel(c, strings.ToLower(strings.TrimSpace(request.Provider)))
+}
+
+// StartNgrokTunnel starts an ngrok tunnel, whichever provider is chosen
+func (h *Handler) StartNgrokTunnel(c *gin.Context) {
+	h.startTunnel(c, "ngrok")
+}
+
+func (h *Handler) startTunnel(c *gin.Context, name string) {
+	h.tunnelMutex.Lock()
+	defer h.tunnelMutex.Unlock()
+
+	settings, err := h.db.GetSettings()
+	if err != nil {
+		requestLogger(c).Error(fmt.Sprintf("Failed to get settings: %v", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
+		return
+	}
+	if name == "" {
+		name = tunnelProviderName(settings)
+	}
+
+	provider, err := h.newTunnelProvider(name, settings)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "hint": tunnelHints[name]})
+		return
+	}
+
+	// Stop any existing tunnel
+	h.stopTunnel(c)
+
+	// Test the configuration first
+	if checker, ok := provider.(tunnel.Checker); ok {
+		requestLogger(c).Info(fmt.Sprintf("Checking %s configuration...", name))
+		if err := checker.Check(); err != nil {
+			requestLogger(c).Error(fmt.Sprintf("Tunnel %s configuration check failed: %v", name, err))
+			errorMsg := fmt.Sprintf("Invalid %s configuration. Please check it in settings.", name)
+			if name == "ngrok" {
+				errorMsg = "Invalid ngrok token. Please check your token in settings."
+			}
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   errorMsg,
+				"hint":    tunnelHints[name],
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Starting %s tunnel...", name))
+	h.tunnel = provider
+	url, err := provider.Start()
+	if err != nil {
+		requestLogger(c).Error(fmt.Sprintf("Failed to start %s tunnel: %v", name, err))
+
+		// Provide specific error messages based on the error
+		errorMsg := "Failed to start tunnel"
+		hint := "Please check your internet connection and try again."
+
+		if strings.Contains(err.Error(), "token") {
+			errorMsg = "Authentication failed"
+			hint = fmt.Sprintf("Please verify your %s token in settings.", name)
+		} else if strings.Contains(err.Error(), "timeout") {
+			errorMsg = "Tunnel startup timeout"
+			hint = fmt.Sprintf("%s is taking longer than expected. Please try again.", name)
+		} else if strings.Contains(err.Error(), "connect: connection refused") {
+			errorMsg = "Ngrok API connection failed"
+			hint = "Ngrok process may not be starting properly. Check if port 4040 is available."
+		} else if strings.Contains(err.Error(), "host key") {
+			errorMsg = "SSH server not trusted"
+			hint = "Check the server's host key fingerprint out of band, then pin it in settings."
+		} else if strings.Contains(err.Error(), "not in PATH") {
+			errorMsg = fmt.Sprintf("%s isn't installed", name)
+			hint = fmt.Sprintf("Install %s on the relay host and make sure it's in PATH.", name)
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    errorMsg,
+			"hint":     hint,
+			"details":  err.Error(),
+			"provider": name,
+		})
+		return
+	}
+
+	requestLogger(c).Info(fmt.Sprintf("Tunnel %s started successfully: %s", name, url))
+	h.tunnelURL.Store(url)
+	h.recordAudit(c, "tunnel.start", name, url)
+	c.JSON(http.StatusOK, gin.H{
+		"url":      url,
+		"provider": name,
+		"message":  "🎉 Tunnel started successfully! You can now access your relay from anywhere using this URL.",
+		"usage":    fmt.Sprintf("Try: curl %s/api/health", url),
+	})
+}
+
+// StopTunnel stops the running tunnel, whichever the provider
+func (h *Handler) StopTunnel(c *gin.Context) {
+	h.tunnelMutex.Lock()
+	defer h.tunnelMutex.Unlock()
+
+	if name := h.stopTunnel(c); name != "" {
+		h.recordAudit(c, "tunnel.stop", name, "")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tunnel stopped successfully",
+	})
+}
+
+// StopNgrokTunnel stops the tunnel; kept for the old /api/ngrok/stop route
+func (h *Handler) StopNgrokTunnel(c *gin.Context) {
+	h.StopTunnel(c)
+}
+
+// stopTunnel stops the running tunnel and returns its provider's name, or
+// "" if none was running. The caller holds tunnelMutex.
+func (h *Handler) stopTunnel(c *gin.Context) string {
+	if h.tunnel == nil {
+		return ""
+	}
+
+	name := h.tunnel.Name()
+	if err := h.tunnel.Stop(); err != nil {
+		requestLogger(c).Error(fmt.Sprintf("Failed to stop %s tunnel: %v", name, err))
+	} else {
+		requestLogger(c).Info(fmt.Sprintf("Tunnel %s stopped", name))
+	}
+	h.tunnel = nil
+	h.tunnelURL.Store("")
+	return name
+}
+
+// tunnelListener passes the connections an in-process tunnel accepts to the
+// relay's tunnel server, which marks their requests as coming through the
+// tunnel
+type tunnelListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newTunnelListener() *tunnelListener {
+	return &tunnelListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// deliver waits for the tunnel server to take a connection
+func (l *tunnelListener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *tunnelListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *tunnelListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *tunnelListener) Addr() net.Addr {
+	return tunnelAddr{}
+}
+
+type tunnelAddr struct{}
+
+func (tunnelAddr) Network() string { return "tunnel" }
+func (tunnelAddr) String() string  { return "tunnel" }
+
+// tunnelConnKey marks the context of connections from the tunnel listener
+type tunnelConnKey struct{}
+
+// TunnelListener returns the listener in-process tunnels hand their
+// connections to. Serve it with TunnelConnContext.
+func (h *Handler) TunnelListener() net.Listener {
+	return h.tunnelConns
+}
+
+// TunnelConnContext marks a connection as coming through the tunnel, for
+// http.Server.ConnContext
+func TunnelConnContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, tunnelConnKey{}, true)
+}
+
+// fromTunnelConn reports whether a request arrived on a tunnel connection
+func fromTunnelConn(c *gin.Context) bool {
+	marked, _ := c.Request.Context().Value(tunnelConnKey{}).(bool)
+	return marked
+}
@@ -0,0 +1,488 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/models"
+
+	"github.com/gin-gonic/gin"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	// twoFactorChallengeTTL is how long a sign-in waits for the second factor
+	twoFactorChallengeTTL = 5 * time.Minute
+	// twoFactorMaxAttempts is how many codes can be tried per challenge
+	twoFactorMaxAttempts = 5
+	// loginFailureWindow is how long failed sign-ins are remembered
+	loginFailureWindow = 24 * time.Hour
+)
+
+// twoFactorState holds the sign-ins waiting for a second factor, keyed by a
+// hash of the challenge token
+type twoFactorState struct {
+	mu      sync.Mutex
+	pending map[string]*twoFactorChallenge
+}
+
+type twoFactorChallenge struct {
+	userID   int
+	expires  time.Time
+	attempts int
+}
+
+// startTwoFactor records a sign-in that passed the password check, returning
+// the challenge token the client sends back with the code
+func (h *Handler) startTwoFactor(user *models.User) (string, time.Time, error) {
+	token, err := auth.NewToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expires := time.Now().Add(twoFactorChallengeTTL)
+
+	h.twoFactor.mu.Lock()
+	defer h.twoFactor.mu.Unlock()
+	if h.twoFactor.pending == nil {
+		h.twoFactor.pending = make(map[string]*twoFactorChallenge)
+	}
+	for key, challenge := range h.twoFactor.pending {
+		if time.Now().After(challenge.expires) {
+			delete(h.twoFactor.pending, key)
+		}
+	}
+	h.twoFactor.pending[auth.HashToken(token)] = &twoFactorChallenge{userID: user.ID, expires: expires}
+	return token, expires, nil
+}
+
+// useTwoFactorChallenge counts an attempt at a challenge and returns its
+// user, or false if it's unknown, expired or out of attempts
+func (h *Handler) useTwoFactorChallenge(token string) (int, bool) {
+	key := auth.HashToken(token)
+
+	h.twoFactor.mu.Lock()
+	defer h.twoFactor.mu.Unlock()
+	challenge, ok := h.twoFactor.pending[key]
+	if !ok || time.Now().After(challenge.expires) {
+		delete(h.twoFactor.pending, key)
+		return 0, false
+	}
+	challenge.attempts++
+	if challenge.attempts >= twoFactorMaxAttempts {
+		delete(h.twoFactor.pending, key)
+	}
+	return challenge.userID, true
+}
+
+func (h *Handler) endTwoFactorChallenge(token string) {
+	h.twoFactor.mu.Lock()
+	defer h.twoFactor.mu.Unlock()
+	delete(h.twoFactor.pending, auth.HashToken(token))
+}
+
+// LoginTwoFactor completes a sign-in with a TOTP or recovery code
+func (h *Handler) LoginTwoFactor(c *gin.Context) {
+	var request struct {
+		Challenge string `json:"challenge" binding:"required"`
+		Code      string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Challenge and code are required"})
+		return
+	}
+
+	userID, ok := h.useTwoFactorChallenge(request.Challenge)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in expired, start again"})
+		return
+	}
+	user, err := h.db.GetUser(userID)
+	if err != nil {
+		requestLogger(c).Error("Error fetching user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+	if user == nil || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in expired, start again"})
+		return
+	}
+	if h.loginBlocked(c, user.Username) || !h.requireVault(c) {
+		return
+	}
+
+	method, err := h.verifySecondFactor(user, request.Code)
+	if err != nil {
+		requestLogger(c).Error("Error checking two-factor code:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+	if method == "" {
+		h.loginFailed(c, user.Username, user, "auth.2fa_failed", "wrong two-factor code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid two-factor code"})
+		return
+	}
+
+	h.endTwoFactorChallenge(request.Challenge)
+	if method == "recovery code" {
+		h.recordRecoveryCodeUse(c, user)
+	}
+	h.finishLogin(c, user, "password and "+method)
+}
+
+// verifySecondFactor checks a TOTP or recovery code, returning which one it
+// was, or "" if the code is wrong. Each code is accepted only once.
+func (h *Handler) verifySecondFactor(user *models.User, code string) (string, error) {
+	secret, err := h.vault.Decrypt(user.TOTPSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt TOTP secret: %v", err)
+	}
+
+	if step, ok := auth.VerifyTOTP(string(secret), code, time.Now(), user.TOTPLastStep); ok {
+		fresh, err := h.db.UseTOTPStep(user.ID, step)
+		if err != nil || !fresh {
+			return "", err
+		}
+		return "totp", nil
+	}
+
+	used, err := h.db.UseRecoveryCode(user.ID, auth.HashRecoveryCode(code))
+	if err != nil || !used {
+		return "", err
+	}
+	return "recovery code", nil
+}
+
+// recordRecoveryCodeUse audits a recovery code sign-in, with how many are left
+func (h *Handler) recordRecoveryCodeUse(c *gin.Context, user *models.User) {
+	remaining, err := h.db.CountRecoveryCodes(user.ID)
+	if err != nil {
+		requestLogger(c).Warn("Failed to count recovery codes:", err)
+	}
+	h.recordAuditFor(c, user, "auth.recovery_code_used", "user:"+user.Username, fmt.Sprintf("%d left", remaining))
+}
+
+// finishLogin starts a session once every factor has been checked
+func (h *Handler) finishLogin(c *gin.Context, user *models.User, method string) {
+	if err := h.db.ClearLoginFailures(auth.AccountLockoutKey(user.Username)); err != nil {
+		requestLogger(c).Warn("Failed to clear sign-in failures:", err)
+	}
+
+	session, ok := h.startSession(c, user)
+	if !ok {
+		return
+	}
+
+	h.recordAuditFor(c, user, "auth.login", "user:"+user.Username, method)
+	requestLogger(c).Info(fmt.Sprintf("User %q signed in from %s", user.Username, c.ClientIP()))
+	c.JSON(http.StatusOK, gin.H{"user": user, "expires_at": session.ExpiresAt})
+}
+
+// loginBlocked rejects the sign-in with 429 if the account or the source IP
+// is locked out after too many failures
+func (h *Handler) loginBlocked(c *gin.Context, username string) bool {
+	for _, key := range []string{auth.AccountLockoutKey(username), auth.IPLockoutKey(c.ClientIP())} {
+		until, err := h.db.GetLoginLock(key)
+		if err != nil {
+			requestLogger(c).Error("Error checking sign-in lockout:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return true
+		}
+		if until.IsZero() {
+			continue
+		}
+
+		retryAfter := int(time.Until(until).Seconds()) + 1
+		requestLogger(c).Warn(fmt.Sprintf("Blocked sign-in for %q from %s, %s is locked out", username, c.ClientIP(), key))
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       fmt.Sprintf("Too many failed sign-ins, try again in %d seconds", retryAfter),
+			"retry_after": retryAfter,
+		})
+		return true
+	}
+	return false
+}
+
+// loginFailed audits a failed sign-in and counts it against the account and
+// the source IP, locking them out once they pass their limit. user is nil
+// for unknown usernames.
+func (h *Handler) loginFailed(c *gin.Context, username string, user *models.User, action, detail string) {
+	requestLogger(c).Warn(fmt.Sprintf("Failed sign-in for %q from %s: %s", username, c.ClientIP(), detail))
+	h.recordAuditFor(c, user, action, "user:"+username, detail)
+
+	limits := map[string]int{
+		auth.AccountLockoutKey(username): h.cfg.LoginMaxFailures,
+		auth.IPLockoutKey(c.ClientIP()):  h.cfg.LoginMaxFailuresPerIP,
+	}
+	for key, limit := range limits {
+		failures, err := h.db.RecordLoginFailure(key, loginFailureWindow)
+		if err != nil {
+			requestLogger(c).Error("Failed to record sign-in failure:", err)
+			continue
+		}
+
+		policy := auth.LockoutPolicy{
+			MaxFailures: limit,
+			Base:        time.Duration(h.cfg.LoginLockoutSeconds) * time.Second,
+			Max:         time.Duration(h.cfg.LoginLockoutMaxSeconds) * time.Second,
+		}
+		lock := policy.LockFor(failures)
+		if lock <= 0 {
+			continue
+		}
+		if err := h.db.LockLogin(key, time.Now().Add(lock)); err != nil {
+			requestLogger(c).Error("Failed to lock sign-in:", err)
+			continue
+		}
+		h.recordAuditFor(c, user, "auth.lockout", key, fmt.Sprintf("%d failures, locked for %s", failures, lock))
+	}
+}
+
+// GetTwoFactorStatus reports whether the signed-in user has two-factor
+// sign-in on, and how many recovery codes they have left
+func (h *Handler) GetTwoFactorStatus(c *gin.Context) {
+	user, ok := requireSignedIn(c)
+	if !ok {
+		return
+	}
+
+	remaining, err := h.db.CountRecoveryCodes(user.ID)
+	if err != nil {
+		requestLogger(c).Error("Error counting recovery codes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch two-factor status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  user.TOTPEnabled,
+		"pending":                  !user.TOTPEnabled && len(user.TOTPSecret) > 0,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// SetupTwoFactor creates a new TOTP secret for the signed-in user, to be
+// confirmed with EnableTwoFactor. It needs the current password.
+func (h *Handler) SetupTwoFactor(c *gin.Context) {
+	user, ok := requireSignedIn(c)
+	if !ok || !h.requireVault(c) {
+		return
+	}
+
+	var request struct {
+		Password string `json:"password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password is required"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor sign-in is already enabled, disable it first"})
+		return
+	}
+	if !auth.CheckPassword(user.PasswordHash, request.Password) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password is incorrect"})
+		return
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		requestLogger(c).Error("Error generating TOTP secret:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up two-factor sign-in"})
+		return
+	}
+	encrypted, err := h.vault.Encrypt([]byte(secret))
+	if err != nil {
+		requestLogger(c).Error("Error encrypting TOTP secret:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up two-factor sign-in"})
+		return
+	}
+	if err := h.db.SetTOTPSecret(user.ID, encrypted); err != nil {
+		requestLogger(c).Error("Error storing TOTP secret:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up two-factor sign-in"})
+		return
+	}
+
+	uri := auth.TOTPURI(h.cfg.TOTPIssuer, user.Username, secret)
+	response := gin.H{"secret": secret, "otpauth_uri": uri}
+	if png, err := qrcode.Encode(uri, qrcode.Medium, 256); err == nil {
+		response["qr_code"] = "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+	} else {
+		requestLogger(c).Warn("Failed to render TOTP QR code:", err)
+	}
+
+	h.recordAudit(c, "auth.2fa_setup", "user:"+user.Username, "")
+	c.JSON(http.StatusOK, response)
+}
+
+// EnableTwoFactor turns on two-factor sign-in once the user has shown a
+// code from the new secret, and returns their recovery codes
+func (h *Handler) EnableTwoFactor(c *gin.Context) {
+	user, ok := requireSignedIn(c)
+	if !ok || !h.requireVault(c) {
+		return
+	}
+
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Code is required"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor sign-in is already enabled"})
+		return
+	}
+	if len(user.TOTPSecret) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set up two-factor sign-in first"})
+		return
+	}
+
+	secret, err := h.vault.Decrypt(user.TOTPSecret)
+	if err != nil {
+		requestLogger(c).Error("Error decrypting TOTP secret:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor sign-in"})
+		return
+	}
+	step, ok := auth.VerifyTOTP(string(secret), request.Code, time.Now(), 0)
+	if !ok {
+		h.recordAudit(c, "auth.2fa_failed", "user:"+user.Username, "wrong code while enabling")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code, check the authenticator's clock"})
+		return
+	}
+
+	codes, hashes, ok := newRecoveryCodes(c)
+	if !ok {
+		return
+	}
+	if err := h.db.EnableTOTP(user.ID, step, hashes); err != nil {
+		requestLogger(c).Error("Error enabling two-factor sign-in:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor sign-in"})
+		return
+	}
+
+	h.recordAudit(c, "auth.2fa_enabled", "user:"+user.Username, "")
+	requestLogger(c).Info(fmt.Sprintf("User %q enabled two-factor sign-in", user.Username))
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "recovery_codes": codes})
+}
+
+// DisableTwoFactor turns off two-factor sign-in. It needs the password and
+// a current TOTP or recovery code.
+func (h *Handler) DisableTwoFactor(c *gin.Context) {
+	user, ok := h.confirmSecondFactor(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DisableTOTP(user.ID); err != nil {
+		requestLogger(c).Error("Error disabling two-factor sign-in:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor sign-in"})
+		return
+	}
+
+	h.recordAudit(c, "auth.2fa_disabled", "user:"+user.Username, "")
+	requestLogger(c).Info(fmt.Sprintf("User %q disabled two-factor sign-in", user.Username))
+	c.JSON(http.StatusOK, gin.H{"enabled": false})
+}
+
+// RegenerateRecoveryCodes replaces the signed-in user's recovery codes. It
+// needs the password and a current TOTP or recovery code.
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	user, ok := h.confirmSecondFactor(c)
+	if !ok {
+		return
+	}
+
+	codes, hashes, ok := newRecoveryCodes(c)
+	if !ok {
+		return
+	}
+	if err := h.db.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		requestLogger(c).Error("Error storing recovery codes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes"})
+		return
+	}
+
+	h.recordAudit(c, "auth.recovery_codes", "user:"+user.Username, "regenerated")
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// confirmSecondFactor checks the password and code sent to change an
+// enabled second factor, writing an error response if they're wrong.
+// Failures count towards the lockout like failed sign-ins.
+func (h *Handler) confirmSecondFactor(c *gin.Context) (*models.User, bool) {
+	user, ok := requireSignedIn(c)
+	if !ok || !h.requireVault(c) {
+		return nil, false
+	}
+
+	var request struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password and code are required"})
+		return nil, false
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor sign-in is not enabled"})
+		return nil, false
+	}
+	if h.loginBlocked(c, user.Username) {
+		return nil, false
+	}
+	if !auth.CheckPassword(user.PasswordHash, request.Password) {
+		h.loginFailed(c, user.Username, user, "auth.2fa_failed", "wrong password")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password or code is incorrect"})
+		return nil, false
+	}
+
+	method, err := h.verifySecondFactor(user, request.Code)
+	if err != nil {
+		requestLogger(c).Error("Error checking two-factor code:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check code"})
+		return nil, false
+	}
+	if method == "" {
+		h.loginFailed(c, user.Username, user, "auth.2fa_failed", "wrong two-factor code")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password or code is incorrect"})
+		return nil, false
+	}
+	if method == "recovery code" {
+		h.recordRecoveryCodeUse(c, user)
+	}
+	return user, true
+}
+
+// newRecoveryCodes generates recovery codes and their hashes, writing an
+// error response if it can't
+func newRecoveryCodes(c *gin.Context) ([]string, []string, bool) {
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		requestLogger(c).Error("Error generating recovery codes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recovery codes"})
+		return nil, nil, false
+	}
+
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	return codes, hashes, true
+}
+
+// requireSignedIn returns the signed-in user, writing an error response when
+// auth is disabled
+func requireSignedIn(c *gin.Context) (*models.User, bool) {
+	user := currentUser(c)
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Authentication is disabled"})
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/config"
+	"lan-relay/internal/vault"
+)
+
+// lockoutConfig locks sign-in for a minute, doubling up to ten
+func lockoutConfig(perAccount, perIP int) *config.Config {
+	return &config.Config{
+		AuthEnabled:            true,
+		SessionTTLHours:        1,
+		LoginMaxFailures:       perAccount,
+		LoginMaxFailuresPerIP:  perIP,
+		LoginLockoutSeconds:    60,
+		LoginLockoutMaxSeconds: 600,
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	type attempt struct {
+		username string
+		password string
+		wantCode int
+	}
+	tests := []struct {
+		name       string
+		perAccount int
+		perIP      int
+		attempts   []attempt
+	}{
+		{"account locked after its limit", 2, 100, []attempt{
+			{"alice", "wrong", http.StatusUnauthorized},
+			{"alice", "wrong", http.StatusUnauthorized},
+			{"alice", "correct horse", http.StatusTooManyRequests},
+			{"bob", "battery staple", http.StatusOK},
+		}},
+		{"address locked after its limit", 100, 2, []attempt{
+			{"alice", "wrong", http.StatusUnauthorized},
+			{"mallory", "wrong", http.StatusUnauthorized},
+			{"bob", "battery staple", http.StatusTooManyRequests},
+		}},
+		{"success before the limit resets the count", 2, 100, []attempt{
+			{"alice", "wrong", http.StatusUnauthorized},
+			{"alice", "correct horse", http.StatusOK},
+			{"alice", "wrong", http.StatusUnauthorized},
+			{"alice", "correct horse", http.StatusOK},
+		}},
+		{"lockout off", 0, 0, []attempt{
+			{"alice", "wrong", http.StatusUnauthorized},
+			{"alice", "wrong", http.StatusUnauthorized},
+			{"alice", "wrong", http.StatusUnauthorized},
+			{"alice", "correct horse", http.StatusOK},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, lockoutConfig(tt.perAccount, tt.perIP))
+			createUserWithPassword(t, h, "alice", "correct horse", auth.RoleViewer)
+			createUserWithPassword(t, h, "bob", "battery staple", auth.RoleViewer)
+			r := authRouter(h)
+
+			for i, a := range tt.attempts {
+				w := login(r, a.username, a.password)
+				if w.Code != a.wantCode {
+					t.Fatalf("attempt %d as %s = %d %s, want %d", i+1, a.username, w.Code, w.Body, a.wantCode)
+				}
+				if w.Code != http.StatusTooManyRequests {
+					continue
+				}
+				retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+				if err != nil || retryAfter < 1 || retryAfter > 61 {
+					t.Errorf("Retry-After = %q, want up to the 60 second lockout", w.Header().Get("Retry-After"))
+				}
+			}
+		})
+	}
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+	h := newTestHandler(t, lockoutConfig(3, 100))
+	v, err := vault.New([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.vault = v
+
+	user := createUserWithPassword(t, h, "alice", "correct horse", auth.RoleViewer)
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := v.Encrypt([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.db.SetTOTPSecret(user.ID, sealed); err != nil {
+		t.Fatal(err)
+	}
+	codes, err := auth.NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	if err := h.db.EnableTOTP(user.ID, 0, hashes); err != nil {
+		t.Fatal(err)
+	}
+	r := authRouter(h)
+
+	// challenge signs in with the password and returns the challenge token
+	challenge := func() string {
+		t.Helper()
+		w := login(r, "alice", "correct horse")
+		var body struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			Challenge         string `json:"challenge"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || !body.TwoFactorRequired || body.Challenge == "" {
+			t.Fatalf("login = %d %s, want a two-factor challenge", w.Code, w.Body)
+		}
+		if sessionCookie(w) != nil {
+			t.Fatal("the password alone started a session")
+		}
+		return body.Challenge
+	}
+	secondFactor := func(challenge, code string) *httptest.ResponseRecorder {
+		body := `{"challenge": "` + challenge + `", "code": "` + code + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login/2fa", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := challenge()
+	tests := []struct {
+		name      string
+		challenge string
+		code      string
+		wantCode  int
+	}{
+		{"made-up challenge", "made-up", codes[0], http.StatusUnauthorized},
+		{"wrong code", first, "000000", http.StatusUnauthorized},
+		{"recovery code", first, strings.ToUpper(codes[0]), http.StatusOK},
+		{"challenge already used", first, codes[1], http.StatusUnauthorized},
+		{"recovery code used twice", challenge(), codes[0], http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := secondFactor(tt.challenge, tt.code)
+		if w.Code != tt.wantCode {
+			t.Fatalf("%s: POST /api/auth/login/2fa = %d %s, want %d", tt.name, w.Code, w.Body, tt.wantCode)
+		}
+		if cookie := sessionCookie(w); (cookie != nil) != (tt.wantCode == http.StatusOK) {
+			t.Fatalf("%s: session cookie = %v", tt.name, cookie)
+		}
+	}
+
+	// Wrong codes count towards the account lockout like wrong passwords.
+	// The reused recovery code was the first failure since signing in.
+	third := challenge()
+	for i := 0; i < 2; i++ {
+		if w := secondFactor(third, "000000"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code = %d, want 401", w.Code)
+		}
+	}
+	if w := secondFactor(third, codes[1]); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("recovery code after the lockout = %d %s, want 429", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,232 @@
+package har
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"lan-relay/internal/models"
+)
+
+// HAR is the top-level HAR 1.2 document
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Entry struct {
+	StartedDateTime string   `json:"startedDateTime"`
+	Time            float64  `json:"time"`
+	Request         Request  `json:"request"`
+	Response        Response `json:"response"`
+	Cache           struct{} `json:"cache"`
+	Timings         Timings  `json:"timings"`
+	Comment         string   `json:"comment,omitempty"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// Build converts log entries and their captures into a HAR document.
+// Entries without a capture are exported with method, URL and status only.
+func Build(version string, entries []models.LogEntry, captures map[int]*models.LogCapture) *HAR {
+	doc := &HAR{
+		Log: Log{
+			Version: "1.2",
+			Creator: Creator{Name: "LAN Relay", Version: version},
+			Entries: make([]Entry, 0, len(entries)),
+		},
+	}
+
+	// HAR viewers expect entries in chronological order
+	for i := len(entries) - 1; i >= 0; i-- {
+		doc.Log.Entries = append(doc.Log.Entries, buildEntry(entries[i], captures[entries[i].ID]))
+	}
+
+	return doc
+}
+
+func buildEntry(log models.LogEntry, capture *models.LogCapture) Entry {
+	targetURL := fmt.Sprintf("http://%s:%s%s", log.TargetHost, log.TargetPort, log.Path)
+
+	entry := Entry{
+		StartedDateTime: log.Timestamp.Format(time.RFC3339Nano),
+		Time:            float64(log.Duration),
+		Request: Request{
+			Method:      log.Method,
+			URL:         targetURL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []NameValue{},
+			Headers:     []NameValue{},
+			QueryString: []NameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: Response{
+			Status:      log.StatusCode,
+			StatusText:  http.StatusText(log.StatusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []NameValue{},
+			Headers:     []NameValue{},
+			Content:     Content{Size: -1, MimeType: "x-unknown"},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: Timings{Send: 0, Wait: float64(log.Duration), Receive: 0},
+		Comment: log.Error,
+	}
+
+	if capture == nil {
+		return entry
+	}
+
+	req := &entry.Request
+	if capture.RequestProto != "" {
+		req.HTTPVersion = capture.RequestProto
+	}
+	if capture.RequestQuery != "" {
+		req.URL += "?" + capture.RequestQuery
+		if values, err := url.ParseQuery(capture.RequestQuery); err == nil {
+			req.QueryString = nameValues(values)
+		}
+	}
+	req.Headers = nameValues(capture.RequestHeaders)
+	req.Cookies = requestCookies(capture.RequestHeaders)
+	req.BodySize = capture.RequestBodySize
+	if capture.RequestBodySize > 0 {
+		text, _ := bodyText(capture.RequestBody)
+		req.PostData = &PostData{
+			MimeType: capture.RequestHeaders.Get("Content-Type"),
+			Text:     text,
+		}
+		if capture.RequestBodyTruncated {
+			req.PostData.Comment = "truncated"
+		}
+	}
+
+	resp := &entry.Response
+	if capture.ResponseProto != "" {
+		resp.HTTPVersion = capture.ResponseProto
+	}
+	resp.Headers = nameValues(capture.ResponseHeaders)
+	resp.Cookies = responseCookies(capture.ResponseHeaders)
+	resp.RedirectURL = capture.ResponseHeaders.Get("Location")
+	resp.BodySize = capture.ResponseBodySize
+	resp.Content = Content{
+		Size:     capture.ResponseBodySize,
+		MimeType: capture.ResponseHeaders.Get("Content-Type"),
+	}
+	if resp.Content.MimeType == "" {
+		resp.Content.MimeType = "x-unknown"
+	}
+	if len(capture.ResponseBody) > 0 {
+		resp.Content.Text, resp.Content.Encoding = bodyText(capture.ResponseBody)
+	}
+	if capture.ResponseBodyTruncated {
+		resp.Content.Comment = "truncated"
+	}
+
+	return entry
+}
+
+// bodyText returns the body as text, base64-encoding it if it isn't valid UTF-8
+func bodyText(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func nameValues(values map[string][]string) []NameValue {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]NameValue, 0, len(values))
+	for _, name := range names {
+		for _, value := range values[name] {
+			pairs = append(pairs, NameValue{Name: name, Value: value})
+		}
+	}
+	return pairs
+}
+
+func requestCookies(header http.Header) []NameValue {
+	req := http.Request{Header: header}
+	cookies := make([]NameValue, 0)
+	for _, cookie := range req.Cookies() {
+		cookies = append(cookies, NameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	return cookies
+}
+
+func responseCookies(header http.Header) []NameValue {
+	resp := http.Response{Header: header}
+	cookies := make([]NameValue, 0)
+	for _, cookie := range resp.Cookies() {
+		cookies = append(cookies, NameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	return cookies
+}
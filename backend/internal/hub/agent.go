@@ -0,0 +1,289 @@
+package hub
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"lan-relay/internal/logger"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	// agentDialTimeout bounds connecting to the hub and to targets
+	agentDialTimeout = 15 * time.Second
+	// agentMaxBackoff caps the wait between reconnection attempts
+	agentMaxBackoff = time.Minute
+	// agentStableAfter is how long a connection must last for the backoff
+	// to start over
+	agentStableAfter = time.Minute
+
+	// HTTP/2 flow control windows the agent grants the hub
+	agentStreamWindow     = 1 << 20
+	agentConnectionWindow = 8 << 20
+	// agentMaxStreams is how many connections the hub may have open at once;
+	// more wait for one to finish
+	agentMaxStreams = 256
+)
+
+// AgentConfig configures an agent
+type AgentConfig struct {
+	// HubURL is the hub's base URL, such as https://hub.example.com
+	HubURL string
+	Name   string
+	Key    string
+	// Allowed are the networks the hub may reach through the agent
+	Allowed []*net.IPNet
+}
+
+// Agent connects a home network to a hub, reconnecting with backoff
+type Agent struct {
+	config AgentConfig
+	hub    *url.URL
+}
+
+// NewAgent creates an agent
+func NewAgent(config AgentConfig) (*Agent, error) {
+	hubURL, err := url.Parse(strings.TrimSuffix(config.HubURL, "/"))
+	if err != nil || (hubURL.Scheme != "http" && hubURL.Scheme != "https") || hubURL.Host == "" {
+		return nil, fmt.Errorf("the hub URL must be https://HOST[:PORT]")
+	}
+	// Past the handshake, plain HTTP could be taken over by anyone on the path
+	if hubURL.Scheme == "http" && !isLoopback(hubURL.Hostname()) {
+		return nil, fmt.Errorf("the hub URL must use https unless the hub is on this host")
+	}
+	if config.Name == "" {
+		return nil, fmt.Errorf("the agent needs a name")
+	}
+	if config.Key == "" {
+		return nil, fmt.Errorf("the agent needs its pre-shared key")
+	}
+	if len(config.Allowed) == 0 {
+		return nil, fmt.Errorf("the agent needs at least one allowed network")
+	}
+	return &Agent{config: config, hub: hubURL}, nil
+}
+
+// Run keeps the agent connected to the hub until ctx is done
+func (a *Agent) Run(ctx context.Context) {
+	delay := time.Second
+	for {
+		connectedAt := time.Now()
+		err := a.connectAndServe(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(connectedAt) > agentStableAfter {
+			delay = time.Second
+		}
+		logger.Warn(fmt.Sprintf("Hub connection lost: %v, reconnecting in %v", err, delay))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, agentMaxBackoff)
+	}
+}
+
+// connectAndServe connects to the hub and serves its streams until the
+// connection ends
+func (a *Agent) connectAndServe(ctx context.Context) error {
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Info(fmt.Sprintf("🔗 Connected to hub %s as %q", a.hub.Host, a.config.Name))
+
+	// The connection is closed when the agent stops
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	server := &http2.Server{
+		MaxConcurrentStreams:         agentMaxStreams,
+		MaxUploadBufferPerStream:     agentStreamWindow,
+		MaxUploadBufferPerConnection: agentConnectionWindow,
+		ReadIdleTimeout:              30 * time.Second,
+		PingTimeout:                  15 * time.Second,
+	}
+	server.ServeConn(conn, &http2.ServeConnOpts{
+		Context: ctx,
+		Handler: http.HandlerFunc(a.serveStream),
+	})
+	return fmt.Errorf("connection closed")
+}
+
+// connect dials the hub and upgrades an authenticated request to a raw
+// connection
+func (a *Agent) connect(ctx context.Context) (net.Conn, error) {
+	host := a.hub.Host
+	if a.hub.Port() == "" {
+		if a.hub.Scheme == "https" {
+			host = net.JoinHostPort(a.hub.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(a.hub.Hostname(), "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: agentDialTimeout, KeepAlive: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if a.hub.Scheme == "https" {
+		// HTTP/1.1 is required to upgrade the connection
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: a.hub.Hostname(), NextProtos: []string{"http/1.1"}})
+		handshakeCtx, cancel := context.WithTimeout(ctx, agentDialTimeout)
+		err := tlsConn.HandshakeContext(handshakeCtx)
+		cancel()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req, err := http.NewRequest(http.MethodGet, a.hub.String()+ConnectPath, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", UpgradeProtocol)
+	if err := signRequest(req, a.config.Name, a.config.Key); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(agentDialTimeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		conn.Close()
+		return nil, fmt.Errorf("the hub refused the connection (%s): %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	// Streams are only served to a hub that holds the key
+	if err := verifyHub(req, resp, a.config.Key); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// serveStream connects a stream from the hub to a target on the agent's
+// network
+func (a *Agent) serveStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != dialPath {
+		http.Error(w, "unknown request", http.StatusNotFound)
+		return
+	}
+
+	target := r.Header.Get(TargetHeader)
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		http.Error(w, "invalid target", http.StatusBadRequest)
+		return
+	}
+	if !a.allowed(host) {
+		logger.Warn(fmt.Sprintf("Hub asked for %s, which isn't in an allowed network", target))
+		http.Error(w, "target isn't in an allowed network", http.StatusForbidden)
+		return
+	}
+
+	conn, err := net.DialTimeout("tcp", target, agentDialTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer conn.Close()
+
+	// The stream is reset when the hub closes its end
+	stop := context.AfterFunc(r.Context(), func() { conn.Close() })
+	defer stop()
+
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	go func() {
+		io.Copy(conn, r.Body)
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+	}()
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := conn.Read(buffer)
+		if n > 0 {
+			if _, writeErr := w.Write(buffer[:n]); writeErr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// allowed reports whether a target host is in an allowed network. Only IP
+// addresses are accepted, as the hub already checked them.
+func (a *Agent) allowed(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range a.config.Allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopback reports whether a hub host is this machine
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// bufferedConn reads what was buffered while reading the upgrade response
+// before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,112 @@
+package hub
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxClockSkew is how far an agent's signature time may be from the hub's.
+// Nonces are remembered for twice as long, so a signature can't be replayed.
+const maxClockSkew = 5 * time.Minute
+
+// Signers, so neither end's signature can be passed off as the other's
+const (
+	signedByAgent = "agent"
+	signedByHub   = "hub"
+)
+
+// sign computes a signature with an agent's pre-shared key. The key itself
+// never crosses the wire.
+func sign(key, signer, name, timestamp, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s", UpgradeProtocol, signer, name, timestamp, nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequest adds the authentication headers to an agent's connect request
+func signRequest(req *http.Request, name, key string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(NameHeader, name)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, hex.EncodeToString(nonce))
+	req.Header.Set(SignatureHeader, sign(key, signedByAgent, name, timestamp, hex.EncodeToString(nonce)))
+	return nil
+}
+
+// verifyHub checks the hub's answer to a connect request. The agent's nonce
+// is new for every request, so an old answer can't be replayed.
+func verifyHub(req *http.Request, resp *http.Response, key string) error {
+	expected := sign(key, signedByHub, req.Header.Get(NameHeader), req.Header.Get(TimestampHeader), req.Header.Get(NonceHeader))
+	if !hmac.Equal([]byte(resp.Header.Get(HubSignatureHeader)), []byte(expected)) {
+		return fmt.Errorf("the hub didn't prove it holds the agent's key")
+	}
+	return nil
+}
+
+// Authenticate checks an agent's connect request and returns its name and
+// the hub's signature to answer with
+func (h *Hub) Authenticate(req *http.Request) (string, string, error) {
+	name := req.Header.Get(NameHeader)
+	timestamp := req.Header.Get(TimestampHeader)
+	nonce := req.Header.Get(NonceHeader)
+	signature := req.Header.Get(SignatureHeader)
+	if name == "" || timestamp == "" || nonce == "" || signature == "" {
+		return "", "", fmt.Errorf("missing agent credentials")
+	}
+
+	key, ok := h.keys[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown agent %q", name)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid timestamp")
+	}
+	signedAt := time.Unix(seconds, 0)
+	if skew := time.Since(signedAt); skew > maxClockSkew || skew < -maxClockSkew {
+		return "", "", fmt.Errorf("the agent's clock is off by %v", skew.Round(time.Second))
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(sign(key, signedByAgent, name, timestamp, nonce))) {
+		return "", "", fmt.Errorf("invalid signature for agent %q", name)
+	}
+	if !h.nonces.use(name+":"+nonce, signedAt) {
+		return "", "", fmt.Errorf("replayed signature for agent %q", name)
+	}
+	return name, sign(key, signedByHub, name, timestamp, nonce), nil
+}
+
+// nonceCache remembers the nonces of recent signatures
+type nonceCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// use records a nonce, returning false if it was already used
+func (n *nonceCache) use(nonce string, signedAt time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for seen, at := range n.seen {
+		if time.Since(at) > 2*maxClockSkew {
+			delete(n.seen, seen)
+		}
+	}
+	if _, ok := n.seen[nonce]; ok {
+		return false
+	}
+	n.seen[nonce] = signedAt
+	return true
+}
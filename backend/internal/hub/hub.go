@@ -0,0 +1,169 @@
+// Package hub connects relay agents on home networks to a hub on a public
+// server. An agent dials out to the hub and upgrades an HTTP/1.1 request to
+// a raw connection, over which the hub speaks HTTP/2 as the client and the
+// agent as the server. Every connection the hub makes to a target is one
+// HTTP/2 stream, so streams are multiplexed over the agent's connection and
+// HTTP/2 flow control keeps one busy stream from starving the others.
+package hub
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ConnectPath is where agents connect to the hub
+	ConnectPath = "/api/agents/connect"
+	// UpgradeProtocol is the protocol agents upgrade the connection to
+	UpgradeProtocol = "lan-relay-agent/1"
+
+	// Headers agents authenticate with
+	NameHeader      = "X-Agent-Name"
+	TimestampHeader = "X-Agent-Timestamp"
+	NonceHeader     = "X-Agent-Nonce"
+	SignatureHeader = "X-Agent-Signature"
+	// HubSignatureHeader carries the hub's answer, proving to the agent that
+	// it holds the agent's key too
+	HubSignatureHeader = "X-Hub-Signature"
+
+	// TargetHeader tells the agent which HOST:PORT a stream connects to
+	TargetHeader = "X-Relay-Target"
+	// dialPath is the agent's endpoint for streams
+	dialPath = "/dial"
+)
+
+// AgentInfo describes a known agent and its connection, if any
+type AgentInfo struct {
+	Name          string     `json:"name"`
+	Connected     bool       `json:"connected"`
+	Default       bool       `json:"default"`
+	RemoteAddr    string     `json:"remote_addr,omitempty"`
+	ConnectedAt   *time.Time `json:"connected_at,omitempty"`
+	LatencyMs     int64      `json:"latency_ms,omitempty"`
+	ActiveStreams int64      `json:"active_streams"`
+	TotalStreams  int64      `json:"total_streams"`
+	BytesIn       int64      `json:"bytes_in"`  // from the agent's network
+	BytesOut      int64      `json:"bytes_out"` // to the agent's network
+}
+
+// Hub keeps track of the agents that may connect and the ones that are
+type Hub struct {
+	keys         map[string]string
+	defaultAgent string
+	nonces       nonceCache
+
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+// New creates a hub for agents with the given pre-shared keys, keyed by
+// agent name. Requests that don't pick an agent go to defaultAgent, or to
+// the only connected agent when it's empty.
+func New(keys map[string]string, defaultAgent string) *Hub {
+	return &Hub{
+		keys:         keys,
+		defaultAgent: defaultAgent,
+		nonces:       nonceCache{seen: make(map[string]time.Time)},
+		sessions:     make(map[string]*Session),
+	}
+}
+
+// ParseKeys reads agent keys given as NAME:KEY entries
+func ParseKeys(entries []string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, entry := range entries {
+		name, key, ok := strings.Cut(entry, ":")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("agent keys must be NAME:KEY, got %q", entry)
+		}
+		if len(key) < 16 {
+			return nil, fmt.Errorf("the key of agent %q is too short, use at least 16 characters", name)
+		}
+		keys[name] = key
+	}
+	return keys, nil
+}
+
+// Add registers a connected agent, replacing and closing any earlier
+// connection of the same agent
+func (h *Hub) Add(session *Session) {
+	h.mu.Lock()
+	previous := h.sessions[session.name]
+	h.sessions[session.name] = session
+	h.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+}
+
+// Remove forgets a connection, unless it was already replaced
+func (h *Hub) Remove(session *Session) {
+	h.mu.Lock()
+	if h.sessions[session.name] == session {
+		delete(h.sessions, session.name)
+	}
+	h.mu.Unlock()
+}
+
+// Get returns the connection of an agent, or of the default agent when
+// name is "". It returns nil if that agent isn't connected.
+func (h *Hub) Get(name string) *Session {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if name != "" {
+		return h.sessions[name]
+	}
+	if h.defaultAgent != "" {
+		return h.sessions[h.defaultAgent]
+	}
+	if len(h.sessions) == 1 {
+		for _, session := range h.sessions {
+			return session
+		}
+	}
+	return nil
+}
+
+// Known reports whether an agent has a key
+func (h *Hub) Known(name string) bool {
+	_, ok := h.keys[name]
+	return ok
+}
+
+// Agents lists every agent with a key, connected or not, by name
+func (h *Hub) Agents() []AgentInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	agents := make([]AgentInfo, 0, len(h.keys))
+	for name := range h.keys {
+		info := AgentInfo{Name: name}
+		if session := h.sessions[name]; session != nil {
+			info = session.Info()
+		}
+		info.Default = name == h.defaultAgent || (h.defaultAgent == "" && len(h.sessions) == 1 && info.Connected)
+		agents = append(agents, info)
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Name < agents[j].Name
+	})
+	return agents
+}
+
+// Close disconnects every agent
+func (h *Hub) Close() {
+	h.mu.Lock()
+	sessions := h.sessions
+	h.sessions = make(map[string]*Session)
+	h.mu.Unlock()
+
+	for _, session := range sessions {
+		session.Close()
+	}
+}
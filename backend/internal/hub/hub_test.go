@@ -0,0 +1,315 @@
+package hub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testKey  = "0123456789abcdef0123"
+	otherKey = "fedcba9876543210fedc"
+)
+
+// serveHub answers agent connections the way the relay's handler does
+func serveHub(t *testing.T, h *Hub) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, signature, err := h.Authenticate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		conn, err := Upgrade(w, signature)
+		if err != nil {
+			return
+		}
+		session, err := NewSession(name, conn, r.RemoteAddr)
+		if err != nil {
+			return
+		}
+		h.Add(session)
+		<-session.Done()
+		h.Remove(session)
+	}))
+	t.Cleanup(func() {
+		h.Close()
+		srv.Close()
+	})
+	return srv
+}
+
+// runAgent runs an agent until the test ends
+func runAgent(t *testing.T, hubURL, key string, allowed ...string) *Agent {
+	t.Helper()
+	networks := make([]*net.IPNet, 0, len(allowed))
+	for _, cidr := range allowed {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		networks = append(networks, network)
+	}
+	agent, err := NewAgent(AgentConfig{HubURL: hubURL, Name: "home", Key: key, Allowed: networks})
+	if err != nil {
+		t.Fatalf("NewAgent: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		agent.Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	return agent
+}
+
+// waitForSession waits until an agent other than previous is connected
+func waitForSession(t *testing.T, h *Hub, name string, previous *Session) *Session {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if session := h.Get(name); session != nil && session != previous {
+			return session
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("agent %q didn't connect", name)
+	return nil
+}
+
+// echoServer answers GETs with the path and POSTs with their body
+func echoServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			io.Copy(w, r.Body)
+			return
+		}
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func signedRequest(t *testing.T, name, key string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, ConnectPath, nil)
+	if err := signRequest(req, name, key); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestAuthenticate(t *testing.T) {
+	h := New(map[string]string{"home": testKey}, "")
+
+	stale := httptest.NewRequest(http.MethodGet, ConnectPath, nil)
+	timestamp := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	stale.Header.Set(NameHeader, "home")
+	stale.Header.Set(TimestampHeader, timestamp)
+	stale.Header.Set(NonceHeader, "00112233")
+	stale.Header.Set(SignatureHeader, sign(testKey, signedByAgent, "home", timestamp, "00112233"))
+
+	// A hub's answer can't be passed off as an agent's signature
+	reflected := signedRequest(t, "home", testKey)
+	reflected.Header.Set(SignatureHeader, sign(testKey, signedByHub, "home",
+		reflected.Header.Get(TimestampHeader), reflected.Header.Get(NonceHeader)))
+
+	tests := []struct {
+		name    string
+		req     *http.Request
+		wantErr string
+	}{
+		{"valid", signedRequest(t, "home", testKey), ""},
+		{"wrong key", signedRequest(t, "home", otherKey), "invalid signature"},
+		{"unknown agent", signedRequest(t, "cabin", testKey), "unknown agent"},
+		{"stale timestamp", stale, "clock is off"},
+		{"hub signature", reflected, "invalid signature"},
+		{"no credentials", httptest.NewRequest(http.MethodGet, ConnectPath, nil), "missing agent credentials"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, signature, err := h.Authenticate(tt.req)
+			if tt.wantErr == "" {
+				if err != nil || name != "home" || signature == "" {
+					t.Fatalf("Authenticate() = %q, %q, %v, want home and a signature", name, signature, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRejectsReplayedNonce(t *testing.T) {
+	h := New(map[string]string{"home": testKey}, "")
+	req := signedRequest(t, "home", testKey)
+
+	if _, _, err := h.Authenticate(req); err != nil {
+		t.Fatalf("first Authenticate() error = %v", err)
+	}
+	if _, _, err := h.Authenticate(req); err == nil || !strings.Contains(err.Error(), "replayed") {
+		t.Fatalf("replayed Authenticate() error = %v, want a replay error", err)
+	}
+}
+
+func TestAgentProxiesThroughHub(t *testing.T) {
+	target := echoServer(t)
+	h := New(map[string]string{"home": testKey}, "")
+	srv := serveHub(t, h)
+	runAgent(t, srv.URL, testKey, "127.0.0.0/8")
+	session := waitForSession(t, h, "home", nil)
+
+	client := &http.Client{Transport: &http.Transport{DialContext: session.DialContext}}
+	defer client.CloseIdleConnections()
+
+	// Streams run side by side, including uploads larger than the flow
+	// control windows
+	upload := make([]byte, 4*agentConnectionWindow)
+	rand.Read(upload)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				resp, err := client.Post(target.URL, "application/octet-stream", bytes.NewReader(upload))
+				if err != nil {
+					t.Errorf("POST through agent: %v", err)
+					return
+				}
+				defer resp.Body.Close()
+				if body, _ := io.ReadAll(resp.Body); !bytes.Equal(body, upload) {
+					t.Errorf("POST through agent returned %d bytes, want the %d sent", len(body), len(upload))
+				}
+				return
+			}
+			path := "/item/" + strconv.Itoa(i)
+			resp, err := client.Get(target.URL + path)
+			if err != nil {
+				t.Errorf("GET through agent: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			if body, _ := io.ReadAll(resp.Body); string(body) != "hello "+path {
+				t.Errorf("GET through agent = %q, want %q", body, "hello "+path)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	info := session.Info()
+	if info.TotalStreams == 0 || info.BytesIn < int64(4*len(upload)) || info.BytesOut < int64(4*len(upload)) {
+		t.Errorf("Info() = %+v, want streams and bytes counted", info)
+	}
+}
+
+func TestAgentRefusesTargetsOutsideAllowedNetworks(t *testing.T) {
+	target := echoServer(t)
+	h := New(map[string]string{"home": testKey}, "")
+	srv := serveHub(t, h)
+	runAgent(t, srv.URL, testKey, "10.0.0.0/8")
+	session := waitForSession(t, h, "home", nil)
+
+	_, err := session.DialContext(context.Background(), "tcp", target.Listener.Addr().String())
+	if err == nil || !strings.Contains(err.Error(), "allowed network") {
+		t.Fatalf("DialContext() error = %v, want the target refused", err)
+	}
+}
+
+func TestAgentWithWrongKeyIsRefused(t *testing.T) {
+	h := New(map[string]string{"home": testKey}, "")
+	srv := serveHub(t, h)
+	agent, err := NewAgent(AgentConfig{HubURL: srv.URL, Name: "home", Key: otherKey, Allowed: []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := agent.connect(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("connect() error = %v, want the hub to refuse it", err)
+	}
+	if h.Get("home") != nil {
+		t.Fatal("the agent was connected with the wrong key")
+	}
+}
+
+func TestAgentRequiresHubSignature(t *testing.T) {
+	// An impostor accepts any agent but doesn't hold its key
+	impostor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := r.Header.Get(NonceHeader)
+		conn, err := Upgrade(w, sign(otherKey, signedByHub, "home", r.Header.Get(TimestampHeader), nonce))
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer impostor.Close()
+
+	agent, err := NewAgent(AgentConfig{HubURL: impostor.URL, Name: "home", Key: testKey, Allowed: []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := agent.connect(context.Background()); err == nil || !strings.Contains(err.Error(), "didn't prove") {
+		t.Fatalf("connect() error = %v, want the impostor refused", err)
+	}
+}
+
+func TestAgentReconnects(t *testing.T) {
+	h := New(map[string]string{"home": testKey}, "")
+	srv := serveHub(t, h)
+	runAgent(t, srv.URL, testKey, "127.0.0.0/8")
+
+	first := waitForSession(t, h, "home", nil)
+	first.Close()
+	second := waitForSession(t, h, "home", first)
+	if second.Info().ConnectedAt.Before(*first.Info().ConnectedAt) {
+		t.Fatal("the reconnected session is older than the first")
+	}
+}
+
+func TestNewAgentRequiresHTTPSForRemoteHubs(t *testing.T) {
+	allowed := []*net.IPNet{{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://hub.example.com", true},
+		{"http://127.0.0.1:8080", true},
+		{"http://localhost:8080", true},
+		{"http://hub.example.com", false},
+		{"ftp://hub.example.com", false},
+	}
+	for _, tt := range tests {
+		_, err := NewAgent(AgentConfig{HubURL: tt.url, Name: "home", Key: testKey, Allowed: allowed})
+		if (err == nil) != tt.ok {
+			t.Errorf("NewAgent(%q) error = %v, want ok = %v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys([]string{"home:" + testKey, " cabin : " + otherKey})
+	if err != nil || keys["home"] != testKey || keys["cabin"] != otherKey {
+		t.Fatalf("ParseKeys() = %v, %v", keys, err)
+	}
+	for _, entry := range []string{"home", ":" + testKey, "home:short"} {
+		if _, err := ParseKeys([]string{entry}); err == nil {
+			t.Errorf("ParseKeys(%q) succeeded, want an error", entry)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	// pingInterval is how often the hub checks an agent's connection
+	pingInterval = 15 * time.Second
+	// pingTimeout is how long an agent has to answer a ping
+	pingTimeout = 10 * time.Second
+)
+
+// Session is the hub's end of an agent's connection
+type Session struct {
+	name        string
+	remoteAddr  string
+	connectedAt time.Time
+	conn        *http2.ClientConn
+
+	latency       atomic.Int64
+	activeStreams atomic.Int64
+	totalStreams  atomic.Int64
+	bytesIn       atomic.Int64
+	bytesOut      atomic.Int64
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewSession starts speaking HTTP/2 to an agent over its upgraded connection
+func NewSession(name string, conn net.Conn, remoteAddr string) (*Session, error) {
+	s := &Session{
+		name:        name,
+		remoteAddr:  remoteAddr,
+		connectedAt: time.Now(),
+		done:        make(chan struct{}),
+	}
+
+	transport := &http2.Transport{AllowHTTP: true}
+	clientConn, err := transport.NewClientConn(&watchedConn{Conn: conn, lost: s.Close})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.mu.Lock()
+	s.conn = clientConn
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		clientConn.Close()
+		return nil, fmt.Errorf("agent %s disconnected", name)
+	}
+
+	go s.keepalive()
+	return s, nil
+}
+
+// Name returns the agent's name
+func (s *Session) Name() string {
+	return s.name
+}
+
+// Done is closed once the connection is closed or lost
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close disconnects the agent
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	close(s.done)
+}
+
+// watchedConn closes the session as soon as its connection fails, rather
+// than at the next ping
+type watchedConn struct {
+	net.Conn
+	lost func()
+}
+
+func (c *watchedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		go c.lost()
+	}
+	return n, err
+}
+
+// keepalive pings the agent, closing the session when it stops answering
+func (s *Session) keepalive() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		start := time.Now()
+		err := s.conn.Ping(ctx)
+		cancel()
+		if err != nil {
+			s.Close()
+			return
+		}
+		s.latency.Store(time.Since(start).Milliseconds())
+	}
+}
+
+// Info describes the connection
+func (s *Session) Info() AgentInfo {
+	connectedAt := s.connectedAt
+	return AgentInfo{
+		Name:          s.name,
+		Connected:     true,
+		RemoteAddr:    s.remoteAddr,
+		ConnectedAt:   &connectedAt,
+		LatencyMs:     s.latency.Load(),
+		ActiveStreams: s.activeStreams.Load(),
+		TotalStreams:  s.totalStreams.Load(),
+		BytesIn:       s.bytesIn.Load(),
+		BytesOut:      s.bytesOut.Load(),
+	}
+}
+
+// DialContext connects to a HOST:PORT on the agent's network through a new
+// stream. It can be used as the dialer of an http.Transport.
+func (s *Session) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, fmt.Errorf("agent %s only dials TCP, not %s", s.name, network)
+	}
+
+	// The stream outlives ctx, which only bounds dialing
+	streamCtx, cancel := context.WithCancel(context.Background())
+	upload, uploadWriter := io.Pipe()
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodPost, "http://"+s.name+dialPath, upload)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set(TargetHeader, addr)
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		resp, err := s.conn.RoundTrip(req)
+		opened <- result{resp, err}
+	}()
+
+	var r result
+	select {
+	case r = <-opened:
+	case <-ctx.Done():
+		cancel()
+		uploadWriter.Close()
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		cancel()
+		uploadWriter.Close()
+		return nil, fmt.Errorf("agent %s: %v", s.name, r.err)
+	}
+	if r.resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(r.resp.Body, 1024))
+		r.resp.Body.Close()
+		cancel()
+		uploadWriter.Close()
+		return nil, fmt.Errorf("agent %s can't reach %s: %s", s.name, addr, strings.TrimSpace(string(message)))
+	}
+
+	s.activeStreams.Add(1)
+	s.totalStreams.Add(1)
+	return &streamConn{
+		session:  s,
+		download: r.resp.Body,
+		upload:   uploadWriter,
+		cancel:   cancel,
+		target:   addr,
+	}, nil
+}
+
+// streamConn is a connection to a target carried by one stream. Deadlines
+// aren't supported; callers close the connection instead.
+type streamConn struct {
+	session  *Session
+	download io.ReadCloser
+	upload   *io.PipeWriter
+	cancel   context.CancelFunc
+	target   string
+
+	closeOnce sync.Once
+}
+
+func (c *streamConn) Read(p []byte) (int, error) {
+	n, err := c.download.Read(p)
+	c.session.bytesIn.Add(int64(n))
+	return n, err
+}
+
+func (c *streamConn) Write(p []byte) (int, error) {
+	n, err := c.upload.Write(p)
+	c.session.bytesOut.Add(int64(n))
+	return n, err
+}
+
+// CloseWrite tells the target nothing more will be sent
+func (c *streamConn) CloseWrite() error {
+	return c.upload.Close()
+}
+
+func (c *streamConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.upload.Close()
+		c.download.Close()
+		c.cancel()
+		c.session.activeStreams.Add(-1)
+	})
+	return nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return agentAddr(c.session.name)
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return agentAddr(c.session.name + "/" + c.target)
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// agentAddr names an end of a stream
+type agentAddr string
+
+func (a agentAddr) Network() string { return "agent" }
+func (a agentAddr) String() string  { return string(a) }
+
+// IsUpgrade reports whether a request asks to upgrade to an agent connection
+func IsUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), UpgradeProtocol) &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
+
+// Upgrade takes over an agent's connect request and switches protocols with
+// the hub's signature, returning the raw connection
+func Upgrade(w http.ResponseWriter, signature string) (net.Conn, error) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("the connection can't be upgraded")
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + UpgradeProtocol +
+		"\r\n" + HubSignatureHeader + ": " + signature + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &bufferedConn{Conn: conn, reader: buffered.Reader}, nil
+}
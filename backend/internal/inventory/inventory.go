@@ -0,0 +1,106 @@
+// Package inventory keeps a history of the devices on the LAN by periodically
+// reading the kernel neighbor (ARP) table.
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"lan-relay/internal/database"
+	"lan-relay/internal/logger"
+	"lan-relay/internal/models"
+)
+
+// hostnameTTL is how long a reverse DNS answer is reused
+const hostnameTTL = time.Hour
+
+// Inventory records neighbor table entries as devices
+type Inventory struct {
+	db        *database.DB
+	tablePath string
+	interval  time.Duration
+	oui       OUIDatabase
+	resolver  *net.Resolver
+	hostnames map[string]cachedHostname // only used from the scan loop
+}
+
+type cachedHostname struct {
+	name     string
+	resolved time.Time
+}
+
+// New creates an inventory reading tablePath (usually /proc/net/arp) every interval
+func New(db *database.DB, tablePath string, interval time.Duration, oui OUIDatabase) *Inventory {
+	return &Inventory{
+		db:        db,
+		tablePath: tablePath,
+		interval:  interval,
+		oui:       oui,
+		resolver:  net.DefaultResolver,
+		hostnames: make(map[string]cachedHostname),
+	}
+}
+
+// Run scans immediately and then every interval until ctx is cancelled
+func (inv *Inventory) Run(ctx context.Context) {
+	ticker := time.NewTicker(inv.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := inv.Scan(ctx); err != nil {
+			logger.Warn(fmt.Sprintf("Device inventory scan failed: %v", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan reads the neighbor table once and records every device in it
+func (inv *Inventory) Scan(ctx context.Context) error {
+	neighbors, err := ReadNeighbors(inv.tablePath)
+	if err != nil {
+		return err
+	}
+
+	seenAt := time.Now()
+	for _, neighbor := range neighbors {
+		device := &models.Device{
+			MAC:       neighbor.MAC,
+			IP:        neighbor.IP,
+			Hostname:  inv.reverseLookup(ctx, neighbor.IP),
+			Vendor:    inv.oui.Lookup(neighbor.MAC),
+			Interface: neighbor.Interface,
+			LastSeen:  seenAt,
+		}
+		if err := inv.db.RecordDeviceSighting(device); err != nil {
+			return err
+		}
+	}
+
+	logger.Debug(fmt.Sprintf("Device inventory recorded %d neighbors", len(neighbors)))
+	return nil
+}
+
+// reverseLookup returns the first PTR name for an IP, or "" if there is none
+func (inv *Inventory) reverseLookup(ctx context.Context, ip string) string {
+	if cached, ok := inv.hostnames[ip]; ok && time.Since(cached.resolved) < hostnameTTL {
+		return cached.name
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	name := ""
+	if names, err := inv.resolver.LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	inv.hostnames[ip] = cachedHostname{name: name, resolved: time.Now()}
+	return name
+}
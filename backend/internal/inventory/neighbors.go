@@ -0,0 +1,74 @@
+package inventory
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// arpFlagComplete marks a resolved entry in /proc/net/arp (ATF_COM)
+const arpFlagComplete = 0x2
+
+// Neighbor is one resolved entry of the kernel neighbor table
+type Neighbor struct {
+	IP        string
+	MAC       string
+	Interface string
+}
+
+// ReadNeighbors reads the resolved entries of a Linux /proc/net/arp table
+func ReadNeighbors(path string) ([]Neighbor, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseNeighbors(file)
+}
+
+func parseNeighbors(r io.Reader) ([]Neighbor, error) {
+	neighbors := make([]Neighbor, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // header line
+
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 {
+			continue
+		}
+
+		flags, err := strconv.ParseInt(strings.TrimPrefix(fields[2], "0x"), 16, 64)
+		if err != nil || flags&arpFlagComplete == 0 {
+			continue
+		}
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil || isZeroMAC(mac) {
+			continue
+		}
+		if net.ParseIP(fields[0]) == nil {
+			continue
+		}
+
+		neighbors = append(neighbors, Neighbor{
+			IP:        fields[0],
+			MAC:       mac.String(),
+			Interface: fields[5],
+		})
+	}
+
+	return neighbors, scanner.Err()
+}
+
+func isZeroMAC(mac net.HardwareAddr) bool {
+	for _, b := range mac {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
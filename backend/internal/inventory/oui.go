@@ -0,0 +1,94 @@
+package inventory
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"os"
+	"strings"
+)
+
+// ouiSample is a small sample of vendors common on home and lab networks,
+// so the inventory shows some vendors out of the box. It isn't the IEEE
+// registry, which is supplied through OUI_FILE.
+//
+//go:embed oui_sample.txt
+var ouiSample string
+
+// OUIDatabase maps the first three bytes of a MAC address ("B827EB") to a vendor
+type OUIDatabase map[string]string
+
+// LoadOUI loads the embedded sample, extended by an optional IEEE oui.txt or
+// Wireshark manuf file
+func LoadOUI(path string) (OUIDatabase, error) {
+	db := make(OUIDatabase)
+	db.read(strings.NewReader(ouiSample))
+
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return db, err
+		}
+		defer file.Close()
+		db.read(file)
+	}
+	return db, nil
+}
+
+// read accepts "B8:27:EB Vendor", "B8-27-EB (hex) Vendor" (IEEE) and
+// "B827EB<TAB>Vendor" lines, skipping anything else
+func (db OUIDatabase) read(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		prefix := normalizePrefix(fields[0])
+		if len(prefix) != 6 {
+			continue
+		}
+
+		rest := strings.TrimSpace(line[len(fields[0]):])
+		rest = strings.TrimPrefix(strings.TrimPrefix(rest, "(hex)"), "(base 16)")
+		// Wireshark manuf lines carry a short and a long name, tab separated
+		if i := strings.LastIndex(rest, "\t"); i >= 0 && strings.TrimSpace(rest[i:]) != "" {
+			rest = rest[i:]
+		}
+		if rest = strings.TrimSpace(rest); rest != "" {
+			db[prefix] = rest
+		}
+	}
+}
+
+// Lookup returns the vendor for a MAC address. Locally administered
+// (randomized or virtual) addresses have no vendor.
+func (db OUIDatabase) Lookup(mac string) string {
+	prefix := normalizePrefix(mac)
+	if len(prefix) < 6 {
+		return ""
+	}
+	if vendor, ok := db[prefix[:6]]; ok {
+		return vendor
+	}
+	if second := prefix[1]; strings.ContainsRune("2367ABEF", rune(second)) {
+		return "Locally administered"
+	}
+	return ""
+}
+
+func normalizePrefix(value string) string {
+	value = strings.ToUpper(value)
+	value = strings.NewReplacer(":", "", "-", "", ".", "").Replace(value)
+	for _, r := range value {
+		if !strings.ContainsRune("0123456789ABCDEF", r) {
+			return ""
+		}
+	}
+	return value
+}
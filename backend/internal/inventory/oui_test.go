@@ -0,0 +1,70 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ieeeExcerpt follows the layout of the IEEE oui.txt registry
+const ieeeExcerpt = `OUI/MA-L                                                    Organization
+company_id                                                  Organization
+                                                            Address
+
+FC-EC-DA   (hex)		Ubiquiti Inc
+FCECDA     (base 16)		Ubiquiti Inc
+				685 Third Avenue
+				New York  NY  10017
+				US
+
+3C-22-FB   (hex)		Apple, Inc.
+3C22FB     (base 16)		Apple, Inc.
+`
+
+// manufExcerpt follows the layout of Wireshark's manuf file
+const manufExcerpt = "# Wireshark manuf\n02:42:AC\tDocker\tDocker container\n"
+
+func TestLoadOUIReadsFullRegistries(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		mac     string
+		want    string
+	}{
+		{"ieee", ieeeExcerpt, "fc:ec:da:01:02:03", "Ubiquiti Inc"},
+		{"second ieee entry", ieeeExcerpt, "3c:22:fb:01:02:03", "Apple, Inc."},
+		{"manuf", manufExcerpt, "02-42-ac-11-00-02", "Docker container"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			db, err := LoadOUI(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := db.Lookup(tt.mac); got != tt.want {
+				t.Errorf("Lookup(%s) = %q, want %q", tt.mac, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadOUIFallsBackToSample(t *testing.T) {
+	db, err := LoadOUI(filepath.Join(t.TempDir(), "missing.txt"))
+	if err == nil {
+		t.Fatal("LoadOUI() accepted a missing file")
+	}
+	if got := db.Lookup("00:00:0c:12:34:56"); got != "Cisco Systems" {
+		t.Errorf("Lookup() with the sample = %q, want %q", got, "Cisco Systems")
+	}
+	if got := db.Lookup("12:34:56:78:9a:bc"); got != "Locally administered" {
+		t.Errorf("Lookup() of a local address = %q", got)
+	}
+	if got := db.Lookup("00:11:22:33:44:55"); got != "" {
+		t.Errorf("Lookup() of an unknown vendor = %q, want none", got)
+	}
+}
@@ -0,0 +1,32 @@
+package inventory
+
+import (
+	"bytes"
+	"net"
+)
+
+// Wake sends a Wake-on-LAN magic packet for mac to the given broadcast address
+// (e.g. "255.255.255.255:9")
+func Wake(mac, broadcast string) error {
+	hardwareAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+
+	// Six 0xFF bytes followed by the MAC address repeated sixteen times
+	packet := bytes.Repeat([]byte{0xFF}, 6)
+	packet = append(packet, bytes.Repeat(hardwareAddr, 16)...)
+
+	addr, err := net.ResolveUDPAddr("udp4", broadcast)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(packet)
+	return err
+}
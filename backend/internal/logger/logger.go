@@ -60,4 +60,30 @@ func Error(v ...interface{}) {
 	if currentLevel <= ERROR {
 		errorLogger.Println(v...)
 	}
-} 
\ No newline at end of file
+} 
+
+// RequestLogger prefixes every line with the ID of the request being handled
+type RequestLogger struct {
+	prefix string
+}
+
+// WithRequestID returns a logger scoped to a single request
+func WithRequestID(requestID string) *RequestLogger {
+	return &RequestLogger{prefix: "[" + requestID + "]"}
+}
+
+func (l *RequestLogger) Debug(v ...interface{}) {
+	Debug(append([]interface{}{l.prefix}, v...)...)
+}
+
+func (l *RequestLogger) Info(v ...interface{}) {
+	Info(append([]interface{}{l.prefix}, v...)...)
+}
+
+func (l *RequestLogger) Warn(v ...interface{}) {
+	Warn(append([]interface{}{l.prefix}, v...)...)
+}
+
+func (l *RequestLogger) Error(v ...interface{}) {
+	Error(append([]interface{}{l.prefix}, v...)...)
+}
@@ -1,19 +1,75 @@
 package models
 
-import "time"
+import (
+	"net/http"
+	"time"
+)
 
 // LogEntry represents a proxy request log entry
 type LogEntry struct {
-	ID         int       `json:"id" db:"id"`
-	Timestamp  time.Time `json:"timestamp" db:"timestamp"`
-	SourceIP   string    `json:"source_ip" db:"source_ip"`
-	Method     string    `json:"method" db:"method"`
-	TargetHost string    `json:"target_host" db:"target_host"`
-	TargetPort string    `json:"target_port" db:"target_port"`
-	Path       string    `json:"path" db:"path"`
-	StatusCode int       `json:"status_code" db:"status_code"`
-	Duration   int64     `json:"duration_ms" db:"duration_ms"`
-	Error      string    `json:"error,omitempty" db:"error"`
+	ID            int       `json:"id" db:"id"`
+	Timestamp     time.Time `json:"timestamp" db:"timestamp"`
+	SourceIP      string    `json:"source_ip" db:"source_ip"`
+	Origin        string    `json:"origin,omitempty" db:"origin"` // local, tunnel or other
+	Method        string    `json:"method" db:"method"`
+	TargetHost    string    `json:"target_host" db:"target_host"`
+	TargetPort    string    `json:"target_port" db:"target_port"`
+	Path          string    `json:"path" db:"path"`
+	StatusCode    int       `json:"status_code" db:"status_code"`
+	Duration      int64     `json:"duration_ms" db:"duration_ms"`
+	Error         string    `json:"error,omitempty" db:"error"`
+	ReplayOf      int       `json:"replay_of,omitempty" db:"replay_of"`
+	RequestID     string    `json:"request_id,omitempty" db:"request_id"`
+	ResponseBytes int64     `json:"response_bytes" db:"response_bytes"`
+	Streamed      bool      `json:"streamed" db:"streamed"`
+	GRPCStatus    *int      `json:"grpc_status,omitempty" db:"grpc_status"`
+	APITokenID    int       `json:"api_token_id,omitempty" db:"api_token_id"`
+	Identity      string    `json:"identity,omitempty" db:"identity"` // who made the request: user, token, client certificate or share link
+	ShareID       int       `json:"share_id,omitempty" db:"share_id"`
+	Agent         string    `json:"agent,omitempty" db:"agent"` // the hub agent the request went through
+	HasCapture    bool      `json:"has_capture"`
+}
+
+// LogCapture holds the captured request/response data for a log entry
+type LogCapture struct {
+	LogID                 int         `json:"log_id" db:"log_id"`
+	RequestProto          string      `json:"request_proto" db:"request_proto"`
+	RequestQuery          string      `json:"request_query" db:"request_query"`
+	RequestHeaders        http.Header `json:"request_headers" db:"request_headers"`
+	RequestBody           []byte      `json:"request_body,omitempty" db:"request_body"`
+	RequestBodySize       int64       `json:"request_body_size" db:"request_body_size"`
+	RequestBodyTruncated  bool        `json:"request_body_truncated" db:"request_body_truncated"`
+	ResponseProto         string      `json:"response_proto" db:"response_proto"`
+	ResponseHeaders       http.Header `json:"response_headers" db:"response_headers"`
+	ResponseBody          []byte      `json:"response_body,omitempty" db:"response_body"`
+	ResponseBodySize      int64       `json:"response_body_size" db:"response_body_size"`
+	ResponseBodyTruncated bool        `json:"response_body_truncated" db:"response_body_truncated"`
+}
+
+// LogDetail is a log entry together with its capture, if any
+type LogDetail struct {
+	LogEntry
+	Capture *LogCapture `json:"capture,omitempty"`
+}
+
+// ReplayResponse is one side of a replay comparison
+type ReplayResponse struct {
+	StatusCode    int         `json:"status_code"`
+	Headers       http.Header `json:"headers"`
+	Body          []byte      `json:"body,omitempty"`
+	BodySize      int64       `json:"body_size"`
+	BodyTruncated bool        `json:"body_truncated"`
+	Duration      int64       `json:"duration_ms"`
+	Error         string      `json:"error,omitempty"`
+}
+
+// ReplayResult compares the original response of a logged request with the replayed one
+type ReplayResult struct {
+	LogID    int            `json:"log_id"`
+	ReplayOf int            `json:"replay_of"`
+	Target   string         `json:"target"`
+	Original ReplayResponse `json:"original"`
+	Replay   ReplayResponse `json:"replay"`
 }
 
 // SystemStatus represents the current status of the relay system
@@ -22,8 +78,9 @@ type SystemStatus struct {
 	LastCheck     time.Time `json:"last_check"`
 	TotalRequests int       `json:"total_requests"`
 	Uptime        string    `json:"uptime"`
-	NgrokStatus   string    `json:"ngrok_status"`
+	NgrokStatus   string    `json:"ngrok_status"` // status of the tunnel, whichever the provider
 	NgrokURL      string    `json:"ngrok_url,omitempty"`
+	TunnelName    string    `json:"tunnel_provider,omitempty"`
 }
 
 // HealthResponse represents health check response
@@ -35,10 +92,43 @@ type HealthResponse struct {
 
 // Settings represents application settings
 type Settings struct {
-	ID          int       `json:"id" db:"id"`
-	NgrokToken  string    `json:"ngrok_token" db:"ngrok_token"`
-	NgrokDomain string    `json:"ngrok_domain" db:"ngrok_domain"`
-	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+	ID          int            `json:"id" db:"id"`
+	NgrokToken  string         `json:"ngrok_token" db:"ngrok_token"`
+	NgrokDomain string         `json:"ngrok_domain" db:"ngrok_domain"`
+	Tunnel      TunnelSettings `json:"tunnel"`
+	OIDC        OIDCSettings   `json:"oidc"`
+	UpdatedAt   time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// TunnelSettings choose and configure the tunnel provider. ngrok uses the
+// ngrok token and domain above.
+type TunnelSettings struct {
+	Provider            string `json:"provider" db:"tunnel_provider"`                  // ngrok, cloudflared, frpc or ssh
+	CloudflaredToken    string `json:"cloudflared_token" db:"cloudflared_token"`       // empty for a quick tunnel
+	CloudflaredHostname string `json:"cloudflared_hostname" db:"cloudflared_hostname"` // public hostname of a named tunnel
+	FRPServer           string `json:"frp_server" db:"frp_server"`                     // HOST:PORT
+	FRPToken            string `json:"frp_token" db:"frp_token"`
+	FRPDomain           string `json:"frp_domain" db:"frp_domain"`
+	SSHServer           string `json:"ssh_server" db:"ssh_server"` // HOST:PORT, port 22 by default
+	SSHUser             string `json:"ssh_user" db:"ssh_user"`
+	SSHPrivateKey       string `json:"ssh_private_key" db:"ssh_private_key"` // PEM or OpenSSH private key
+	SSHHostKey          string `json:"ssh_host_key" db:"ssh_host_key"`       // pinned server key or its SHA256 fingerprint
+	SSHRemoteAddr       string `json:"ssh_remote_addr" db:"ssh_remote_addr"` // address the server listens on, e.g. 0.0.0.0:8080
+	SSHPublicURL        string `json:"ssh_public_url" db:"ssh_public_url"`   // URL the forwarded port is reached at
+}
+
+// OIDCSettings configures single sign-on through an OpenID Connect provider
+type OIDCSettings struct {
+	Enabled       bool   `json:"enabled" db:"oidc_enabled"`
+	Issuer        string `json:"issuer" db:"oidc_issuer"`
+	ClientID      string `json:"client_id" db:"oidc_client_id"`
+	ClientSecret  string `json:"client_secret" db:"oidc_client_secret"`
+	RedirectURL   string `json:"redirect_url" db:"oidc_redirect_url"` // derived from the request when empty
+	Scopes        string `json:"scopes" db:"oidc_scopes"`             // space-separated
+	UsernameClaim string `json:"username_claim" db:"oidc_username_claim"`
+	GroupsClaim   string `json:"groups_claim" db:"oidc_groups_claim"`
+	RoleMapping   string `json:"role_mapping" db:"oidc_role_mapping"` // group=role pairs
+	DefaultRole   string `json:"default_role" db:"oidc_default_role"` // empty refuses unmapped users
 }
 
 // NgrokTunnelResponse represents ngrok tunnel start response
@@ -46,3 +136,263 @@ type NgrokTunnelResponse struct {
 	URL     string `json:"url"`
 	Message string `json:"message"`
 }
+
+// HeaderRule rewrites a request or response header for a proxy target
+type HeaderRule struct {
+	ID        int       `json:"id" db:"id"`
+	Target    string    `json:"target" db:"target"`       // HOST:PORT
+	Direction string    `json:"direction" db:"direction"` // request or response
+	Action    string    `json:"action" db:"action"`       // set, append, remove or rename
+	Name      string    `json:"name" db:"name"`
+	Value     string    `json:"value" db:"value"` // template value, or the new name for rename
+	Position  int       `json:"position" db:"position"`
+	Enabled   bool      `json:"enabled" db:"enabled"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Credential is an upstream secret injected into requests for its bound targets.
+// The secret itself is only ever stored encrypted and never returned by the API.
+type Credential struct {
+	ID              int        `json:"id" db:"id"`
+	Name            string     `json:"name" db:"name"`
+	Type            string     `json:"type" db:"type"`                   // basic, bearer, header or query
+	Username        string     `json:"username,omitempty" db:"username"` // basic auth only
+	Param           string     `json:"param,omitempty" db:"param"`       // header or query parameter name
+	EncryptedSecret []byte     `json:"-" db:"secret"`
+	Targets         []string   `json:"targets"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	RotatedAt       *time.Time `json:"rotated_at,omitempty" db:"rotated_at"`
+	LastUsedAt      *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+}
+
+// Target is a registered proxy target
+type Target struct {
+	ID        int       `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	Host      string    `json:"host" db:"host"`
+	Port      int       `json:"port" db:"port"`
+	Source    string    `json:"source" db:"source"` // manual, mdns, ssdp or scan
+	ProxyPath string    `json:"proxy_path"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// DiscoveredService is a service found on the local network
+type DiscoveredService struct {
+	ID        string `json:"id"`
+	Source    string `json:"source"` // mdns, ssdp or scan
+	Name      string `json:"name"`
+	Type      string `json:"type,omitempty"` // DNS-SD service type or UPnP device type
+	Host      string `json:"host"`
+	Port      int    `json:"port"`
+	Title     string `json:"title,omitempty"` // guessed from the service's web page or UPnP description
+	ProxyPath string `json:"proxy_path"`
+	TargetID  int    `json:"target_id,omitempty"` // set once registered as a target
+}
+
+// DiscoveryResult is the outcome of a discovery run
+type DiscoveryResult struct {
+	Services  []DiscoveredService `json:"services"`
+	Warnings  []string            `json:"warnings,omitempty"`
+	ScannedAt time.Time           `json:"scanned_at"`
+}
+
+// Device is a host seen in the LAN neighbor (ARP) table, keyed by MAC address
+type Device struct {
+	ID        int             `json:"id" db:"id"`
+	MAC       string          `json:"mac" db:"mac"` // also used for Wake-on-LAN
+	IP        string          `json:"ip" db:"ip"`
+	Name      string          `json:"name,omitempty" db:"name"` // friendly name set by a user
+	Hostname  string          `json:"hostname,omitempty" db:"hostname"`
+	Vendor    string          `json:"vendor,omitempty" db:"vendor"`
+	Interface string          `json:"interface,omitempty" db:"interface"`
+	FirstSeen time.Time       `json:"first_seen" db:"first_seen"`
+	LastSeen  time.Time       `json:"last_seen" db:"last_seen"`
+	Online    bool            `json:"online"`
+	Targets   []Target        `json:"targets,omitempty"`
+	Addresses []DeviceAddress `json:"addresses,omitempty"`
+}
+
+// DeviceAddress is an IP address a device has used
+type DeviceAddress struct {
+	IP        string    `json:"ip" db:"ip"`
+	FirstSeen time.Time `json:"first_seen" db:"first_seen"`
+	LastSeen  time.Time `json:"last_seen" db:"last_seen"`
+}
+
+// User is a local account that can sign in to the dashboard and API
+type User struct {
+	ID           int        `json:"id" db:"id"`
+	Username     string     `json:"username" db:"username"`
+	PasswordHash string     `json:"-" db:"password_hash"`
+	Role         string     `json:"role" db:"role"`
+	OIDCSubject  string     `json:"oidc_subject,omitempty" db:"oidc_subject"` // issuer and subject of SSO users
+	TOTPSecret   []byte     `json:"-" db:"totp_secret"`                       // encrypted with the vault key
+	TOTPEnabled  bool       `json:"two_factor_enabled" db:"totp_enabled"`     // false while enrollment is pending
+	TOTPLastStep int64      `json:"-" db:"totp_last_step"`                    // last accepted time step, so codes can't be replayed
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+}
+
+// Session is a signed-in browser session. Only a hash of its token is stored.
+type Session struct {
+	TokenHash  string    `json:"-" db:"token_hash"`
+	UserID     int       `json:"user_id" db:"user_id"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at" db:"expires_at"`
+	LastSeenAt time.Time `json:"last_seen_at" db:"last_seen_at"`
+	IP         string    `json:"ip" db:"ip"`
+	UserAgent  string    `json:"user_agent" db:"user_agent"`
+}
+
+// APIToken is a long-lived bearer token for scripts, acting for its owner
+// within its scopes. Only a hash of the token is stored.
+type APIToken struct {
+	ID         int        `json:"id" db:"id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"prefix"` // first characters, to tell tokens apart
+	TokenHash  string     `json:"-" db:"token_hash"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Username   string     `json:"username"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	Targets    []string   `json:"targets" db:"targets"` // allowlist of HOST, HOST:PORT or CIDR; empty allows all
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip,omitempty" db:"last_used_ip"`
+}
+
+// Expired reports whether the token is past its expiry
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && !t.ExpiresAt.After(time.Now())
+}
+
+// ClientCert is a client certificate issued by the relay CA for the mTLS
+// listener. The certificate acts for its user; only its metadata is stored.
+type ClientCert struct {
+	Serial    string     `json:"serial" db:"serial"` // hex, as printed by the CLI
+	Name      string     `json:"name" db:"name"`     // label for the device or service holding it
+	UserID    int        `json:"user_id" db:"user_id"`
+	Username  string     `json:"username"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// ShareLink gives access to one target without an account. The link itself
+// carries the signed target, path prefix, methods and expiry; uses and
+// revocation are tracked here.
+type ShareLink struct {
+	ID           int        `json:"id" db:"id"`
+	Name         string     `json:"name" db:"name"`
+	Target       string     `json:"target" db:"target"` // HOST:PORT
+	PathPrefix   string     `json:"path_prefix,omitempty" db:"path_prefix"`
+	Methods      []string   `json:"methods" db:"methods"`
+	MaxUses      int        `json:"max_uses,omitempty" db:"max_uses"` // 0 for unlimited
+	Uses         int        `json:"uses" db:"uses"`
+	PasswordHash string     `json:"-" db:"password_hash"`
+	HasPassword  bool       `json:"has_password"`
+	CreatedBy    int        `json:"created_by" db:"created_by"`
+	CreatorName  string     `json:"created_by_name"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at" db:"expires_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	LastUsedIP   string     `json:"last_used_ip,omitempty" db:"last_used_ip"`
+}
+
+// Active reports whether the link can still be used
+func (s *ShareLink) Active() bool {
+	return s.RevokedAt == nil && s.ExpiresAt.After(time.Now()) && (s.MaxUses == 0 || s.Uses < s.MaxUses)
+}
+
+// IPRule allows or denies clients by address, for the whole relay or one
+// target. Rules with an expiry, like automatic bans, stop applying once it
+// passes.
+type IPRule struct {
+	ID          int        `json:"id" db:"id"`
+	CIDR        string     `json:"cidr" db:"cidr"`
+	Action      string     `json:"action" db:"action"`           // allow or deny
+	Target      string     `json:"target,omitempty" db:"target"` // HOST:PORT, empty for the whole relay
+	Reason      string     `json:"reason,omitempty" db:"reason"`
+	Automatic   bool       `json:"automatic" db:"automatic"` // an automatic ban
+	CreatedBy   int        `json:"created_by,omitempty" db:"created_by"`
+	CreatorName string     `json:"created_by_name,omitempty"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+}
+
+// Active reports whether the rule still applies
+func (r *IPRule) Active() bool {
+	return r.ExpiresAt == nil || r.ExpiresAt.After(time.Now())
+}
+
+// Group is a named set of users that target grants can be given to
+type Group struct {
+	ID        int       `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	Members   []string  `json:"members"` // usernames
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TargetGrant lets a user, or every member of a group, reach the targets
+// matching a HOST, HOST:PORT or CIDR pattern. Non-admin users with grants
+// can only reach their granted targets.
+type TargetGrant struct {
+	ID        int       `json:"id" db:"id"`
+	UserID    int       `json:"user_id,omitempty" db:"user_id"`
+	GroupID   int       `json:"group_id,omitempty" db:"group_id"`
+	Subject   string    `json:"subject"` // the username or group name
+	Target    string    `json:"target" db:"target"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// AuthzDecision explains whether a user may make a request, and why
+type AuthzDecision struct {
+	Allowed    bool     `json:"allowed"`
+	Username   string   `json:"username"`
+	Role       string   `json:"role"`
+	Method     string   `json:"method"`
+	Path       string   `json:"path"`
+	Route      string   `json:"route,omitempty"`
+	Permission string   `json:"permission,omitempty"`
+	Target     string   `json:"target,omitempty"`
+	Agent      string   `json:"agent,omitempty"`  // the hub agent named with /proxy/@AGENT
+	Grants     []string `json:"grants,omitempty"` // the target grants that applied
+	APITokenID int      `json:"api_token_id,omitempty"`
+	Reason     string   `json:"reason"`
+}
+
+// AuditEvent records a security-relevant action, such as a denied request
+// or an administrative change. Events are append-only and each one's hash
+// covers the previous event's, so editing or removing one breaks the chain.
+type AuditEvent struct {
+	ID        int           `json:"id" db:"id"`
+	Timestamp time.Time     `json:"timestamp" db:"timestamp"`
+	UserID    int           `json:"user_id,omitempty" db:"user_id"`
+	Username  string        `json:"username,omitempty" db:"username"`
+	Action    string        `json:"action" db:"action"`
+	Resource  string        `json:"resource,omitempty" db:"resource"`
+	Detail    string        `json:"detail,omitempty" db:"detail"`
+	Changes   []AuditChange `json:"changes,omitempty" db:"changes"`
+	SourceIP  string        `json:"source_ip,omitempty" db:"source_ip"`
+	RequestID string        `json:"request_id,omitempty" db:"request_id"`
+	PrevHash  string        `json:"prev_hash" db:"prev_hash"`
+	Hash      string        `json:"hash" db:"hash"`
+}
+
+// AuditChange is one field changed by an audited action. Secrets show as
+// "[redacted]".
+type AuditChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// AuditVerification is the result of checking the audit hash chain
+type AuditVerification struct {
+	Valid    bool   `json:"valid"`
+	Events   int    `json:"events"`
+	Head     string `json:"head,omitempty"` // hash of the latest event, to record elsewhere
+	BrokenAt int    `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
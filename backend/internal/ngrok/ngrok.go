@@ -9,7 +9,10 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"lan-relay/internal/tunnel"
 )
 
 type TunnelInfo struct {
@@ -24,29 +27,47 @@ type TunnelsResponse struct {
 	Tunnels []TunnelInfo `json:"tunnels"`
 }
 
+// NgrokManager runs an ngrok tunnel. It implements tunnel.Provider.
 type NgrokManager struct {
-	cmd     *exec.Cmd
 	token   string
 	domain  string
 	port    int
 	apiPort int
+	events  *tunnel.EventLog
+
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	startedAt *time.Time
+	lastError string
 }
 
-func NewManager(token, domain string, port int) *NgrokManager {
+func NewManager(token, domain string, opts tunnel.Options) *NgrokManager {
 	return &NgrokManager{
 		token:   token,
 		domain:  domain,
-		port:    port,
+		port:    opts.Port,
 		apiPort: 4040, // Default ngrok API port
+		events:  tunnel.NewEventLog("ngrok", opts.Notify),
 	}
 }
 
-// StartTunnel starts an ngrok tunnel programmatically
-func (n *NgrokManager) StartTunnel() (string, error) {
+// Name identifies the provider
+func (n *NgrokManager) Name() string {
+	return "ngrok"
+}
+
+// Check verifies the token before starting
+func (n *NgrokManager) Check() error {
+	return n.TestConnection()
+}
+
+// Start starts an ngrok tunnel programmatically
+func (n *NgrokManager) Start() (string, error) {
 	// Validate token first
 	if n.token == "" {
 		return "", fmt.Errorf("ngrok token is required")
 	}
+	n.events.Add(tunnel.EventStarting, "")
 
 	// Kill any existing ngrok processes to avoid conflicts
 	exec.Command("pkill", "-f", "ngrok").Run()
@@ -62,15 +83,19 @@ func (n *NgrokManager) StartTunnel() (string, error) {
 	// Add log configuration for better debugging
 	args = append(args, "--log", "stdout", "--log-level", "info")
 
-	n.cmd = exec.Command("ngrok", args...)
+	cmd := exec.Command("ngrok", args...)
 
 	// Start the process
-	if err := n.cmd.Start(); err != nil {
-		return "", fmt.Errorf("failed to start ngrok process: %v", err)
+	if err := cmd.Start(); err != nil {
+		return "", n.fail(fmt.Errorf("failed to start ngrok process: %v", err))
 	}
+	n.mu.Lock()
+	n.cmd = cmd
+	n.mu.Unlock()
+	go n.wait(cmd)
 
 	// Wait longer for ngrok to fully initialize
-	fmt.Println("Waiting for ngrok to initialize...")
+	n.events.Add(tunnel.EventOutput, "Waiting for ngrok to initialize...")
 
 	// Try to connect to ngrok API with retries
 	maxRetries := 12 // 12 seconds total
@@ -78,37 +103,82 @@ func (n *NgrokManager) StartTunnel() (string, error) {
 		time.Sleep(1 * time.Second)
 
 		// Check if the process is still running
-		if n.cmd.Process == nil {
-			return "", fmt.Errorf("ngrok process died unexpectedly")
+		if !n.running(cmd) {
+			return "", n.fail(fmt.Errorf("ngrok process died unexpectedly"))
 		}
 
 		// Try to get the URL
 		url, err := n.getPublicURL()
 		if err == nil {
-			fmt.Printf("Ngrok tunnel established: %s\n", url)
+			now := time.Now()
+			n.mu.Lock()
+			n.startedAt, n.lastError = &now, ""
+			n.mu.Unlock()
+			n.events.Add(tunnel.EventStarted, url)
 			return url, nil
 		}
 
 		// On last retry, return the error
 		if i == maxRetries-1 {
-			return "", fmt.Errorf("failed to establish ngrok tunnel after %d attempts: %v", maxRetries, err)
+			return "", n.fail(fmt.Errorf("failed to establish ngrok tunnel after %d attempts: %v", maxRetries, err))
 		}
 
-		fmt.Printf("Attempt %d/%d: Waiting for ngrok API... (%v)\n", i+1, maxRetries, err)
+		n.events.Add(tunnel.EventOutput, fmt.Sprintf("Attempt %d/%d: Waiting for ngrok API... (%v)", i+1, maxRetries, err))
+	}
+
+	return "", n.fail(fmt.Errorf("timeout waiting for ngrok to start"))
+}
+
+// wait reaps the ngrok process, recording if it quit on its own
+func (n *NgrokManager) wait(cmd *exec.Cmd) {
+	err := cmd.Wait()
+
+	n.mu.Lock()
+	if n.cmd != cmd {
+		n.mu.Unlock()
+		return
+	}
+	n.cmd, n.startedAt = nil, nil
+	n.lastError = "ngrok exited"
+	if err != nil {
+		n.lastError = fmt.Sprintf("ngrok exited: %v", err)
 	}
+	message := n.lastError
+	n.mu.Unlock()
+
+	n.events.Add(tunnel.EventExited, message)
+}
 
-	return "", fmt.Errorf("timeout waiting for ngrok to start")
+// running reports whether cmd is still the running ngrok process
+func (n *NgrokManager) running(cmd *exec.Cmd) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.cmd == cmd
 }
 
-// StopTunnel stops the ngrok tunnel
-func (n *NgrokManager) StopTunnel() error {
-	if n.cmd != nil && n.cmd.Process != nil {
-		err := n.cmd.Process.Kill()
+// fail records a failed start and returns its error
+func (n *NgrokManager) fail(err error) error {
+	n.mu.Lock()
+	n.lastError = err.Error()
+	n.mu.Unlock()
+	n.events.Add(tunnel.EventError, err.Error())
+	return err
+}
+
+// Stop stops the ngrok tunnel
+func (n *NgrokManager) Stop() error {
+	n.mu.Lock()
+	cmd := n.cmd
+	n.cmd, n.startedAt, n.lastError = nil, nil, ""
+	n.mu.Unlock()
+
+	if cmd != nil && cmd.Process != nil {
+		err := cmd.Process.Kill()
 		if err != nil {
 			// Try pkill as backup
 			exec.Command("pkill", "-f", "ngrok").Run()
 		}
-		n.cmd = nil
+		n.events.Add(tunnel.EventStopped, "")
 		return err
 	}
 	// Also try pkill to ensure cleanup
@@ -164,13 +234,33 @@ func (n *NgrokManager) getPublicURL() (string, error) {
 	return "", fmt.Errorf("no tunnel found for port %d in %d tunnels", n.port, len(tunnels.Tunnels))
 }
 
-// GetStatus checks if ngrok is running and returns tunnel info
-func (n *NgrokManager) GetStatus() (string, bool) {
-	url, err := n.getPublicURL()
-	if err != nil {
-		return "", false
+// Status checks if ngrok is running and returns tunnel info
+func (n *NgrokManager) Status() tunnel.Status {
+	n.mu.Lock()
+	status := tunnel.Status{Provider: n.Name(), State: tunnel.StateStopped, StartedAt: n.startedAt}
+	cmd, lastError := n.cmd, n.lastError
+	n.mu.Unlock()
+
+	switch {
+	case cmd != nil:
+		status.State = tunnel.StateStarting
+		if url, err := n.getPublicURL(); err == nil {
+			status.State, status.URL = tunnel.StateRunning, url
+		}
+	case lastError != "":
+		status.State, status.Error = tunnel.StateFailed, lastError
 	}
-	return url, true
+	return status
+}
+
+// PublicURL returns the tunnel's URL while it's running
+func (n *NgrokManager) PublicURL() string {
+	return n.Status().URL
+}
+
+// Events returns what recently happened to the tunnel
+func (n *NgrokManager) Events() []tunnel.Event {
+	return n.events.Events()
 }
 
 // TestConnection tests if ngrok can connect with the given token
@@ -0,0 +1,447 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files kept in the PKI directory. The directory is shared by the server and
+// the CLI, which is how a revocation made with the CLI reaches the server.
+const (
+	CACertFile     = "ca.crt"
+	CAKeyFile      = "ca.key"
+	ServerCertFile = "server.crt"
+	ServerKeyFile  = "server.key"
+	CRLFile        = "crl.pem"
+)
+
+const (
+	caValidity     = 10 * 365 * 24 * time.Hour
+	serverValidity = 365 * 24 * time.Hour
+	// crlValidity is how long a CRL is valid for; the server only reads the
+	// revoked serials, so this mostly matters to other tools reading the file
+	crlValidity = 30 * 24 * time.Hour
+	// organization is set on every certificate the relay issues
+	organization = "LAN Relay"
+)
+
+// Authority is the relay-managed certificate authority that signs client
+// certificates for the mTLS listener
+type Authority struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+
+	mu         sync.Mutex
+	crlModTime time.Time
+	revoked    map[string]bool
+}
+
+// RevokedCert is a serial to list in the CRL
+type RevokedCert struct {
+	Serial    string
+	RevokedAt time.Time
+}
+
+// Load reads the CA from dir, creating the directory and a new CA on first
+// use
+func Load(dir string) (*Authority, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("no PKI directory configured")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create PKI directory: %v", err)
+	}
+
+	a := &Authority{dir: dir}
+	cert, key, err := readKeyPair(a.path(CACertFile), a.path(CAKeyFile))
+	if os.IsNotExist(err) {
+		if err := a.createCA(); err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA: %v", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", a.path(CACertFile))
+	}
+
+	a.cert, a.key = cert, key
+	return a, nil
+}
+
+// CACertPath returns the CA certificate that clients use to verify the server
+func (a *Authority) CACertPath() string {
+	return a.path(CACertFile)
+}
+
+// CACertPEM returns the CA certificate, PEM-encoded
+func (a *Authority) CACertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.cert.Raw})
+}
+
+// IssueClient signs a client certificate for username. The username is the
+// subject common name, and name, a label for the device or service holding
+// the certificate, is the organizational unit.
+func (a *Authority) IssueClient(username, name string, validity time.Duration) (*x509.Certificate, []byte, []byte, error) {
+	subject := pkix.Name{
+		CommonName:   username,
+		Organization: []string{organization},
+	}
+	if name != "" {
+		subject.OrganizationalUnit = []string{name}
+	}
+
+	template := &x509.Certificate{
+		Subject:     subject,
+		NotBefore:   time.Now().Add(-5 * time.Minute),
+		NotAfter:    time.Now().Add(validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return a.sign(template)
+}
+
+// WriteCRL replaces the CRL file with one listing the revoked serials
+func (a *Authority) WriteCRL(revoked []RevokedCert) error {
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+	for _, cert := range revoked {
+		serial, ok := ParseSerial(cert.Serial)
+		if !ok {
+			return fmt.Errorf("invalid serial %q", cert.Serial)
+		}
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: cert.RevokedAt})
+	}
+
+	now := time.Now()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(crlValidity),
+	}, a.cert, a.key)
+	if err != nil {
+		return fmt.Errorf("failed to create CRL: %v", err)
+	}
+
+	return writeFileAtomic(a.path(CRLFile), pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0644)
+}
+
+// ServerConfig returns the TLS configuration for the mTLS listener: clients
+// must present a certificate signed by the CA that is not in the CRL. The
+// server certificate is read from certFile/keyFile if given, otherwise one
+// is issued by the CA for hosts and renewed when it nears expiry.
+func (a *Authority) ServerConfig(certFile, keyFile string, hosts []string) (*tls.Config, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost"}
+	}
+
+	var certificate tls.Certificate
+	var err error
+	if certFile != "" || keyFile != "" {
+		certificate, err = tls.LoadX509KeyPair(certFile, keyFile)
+	} else {
+		certificate, err = a.serverCertificate(hosts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %v", err)
+	}
+
+	// Fail at startup rather than on the first handshake if the CRL is broken
+	if _, err := a.loadRevoked(); err != nil {
+		return nil, err
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(a.cert)
+
+	return &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		// Runs on every handshake, including resumed sessions, so a
+		// revocation applies to connections made after it
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("client certificate required")
+			}
+			revoked, err := a.loadRevoked()
+			if err != nil {
+				return err
+			}
+			serial := FormatSerial(state.PeerCertificates[0].SerialNumber)
+			if revoked[serial] {
+				return fmt.Errorf("client certificate %s has been revoked", serial)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// FormatSerial returns a serial number as the hex string used in the
+// database and on the command line
+func FormatSerial(serial *big.Int) string {
+	return strings.ToUpper(serial.Text(16))
+}
+
+// ParseSerial parses a serial number written by FormatSerial
+func ParseSerial(serial string) (*big.Int, bool) {
+	return new(big.Int).SetString(strings.TrimPrefix(strings.ToLower(serial), "0x"), 16)
+}
+
+// EncodeKey returns a private key, PEM-encoded
+func EncodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// loadRevoked returns the revoked serials, rereading the CRL only when the
+// file has changed. A missing CRL means nothing is revoked.
+func (a *Authority) loadRevoked() (map[string]bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := os.Stat(a.path(CRLFile))
+	if os.IsNotExist(err) {
+		a.revoked, a.crlModTime = map[string]bool{}, time.Time{}
+		return a.revoked, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL: %v", err)
+	}
+	if a.revoked != nil && info.ModTime().Equal(a.crlModTime) {
+		return a.revoked, nil
+	}
+
+	data, err := os.ReadFile(a.path(CRLFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "X509 CRL" {
+		return nil, fmt.Errorf("%s is not a PEM-encoded CRL", a.path(CRLFile))
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CRL: %v", err)
+	}
+	if err := crl.CheckSignatureFrom(a.cert); err != nil {
+		return nil, fmt.Errorf("CRL is not signed by the relay CA: %v", err)
+	}
+
+	revoked := make(map[string]bool, len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked[FormatSerial(entry.SerialNumber)] = true
+	}
+	a.revoked, a.crlModTime = revoked, info.ModTime()
+	return revoked, nil
+}
+
+func (a *Authority) createCA() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: organization + " CA", Organization: []string{organization}},
+		NotBefore:             time.Now().Add(-5 * time.Minute),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to create CA: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return err
+	}
+
+	keyPEM, err := EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(a.path(CAKeyFile), keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(a.path(CACertFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+
+	a.cert, a.key = cert, key
+	return nil
+}
+
+// serverCertificate returns the CA-issued server certificate, issuing a new
+// one when there is none, it expires within a month, or it doesn't cover
+// all of hosts
+func (a *Authority) serverCertificate(hosts []string) (tls.Certificate, error) {
+	certPath, keyPath := a.path(ServerCertFile), a.path(ServerKeyFile)
+	if cert, _, err := readKeyPair(certPath, keyPath); err == nil && coversHosts(cert, hosts) &&
+		time.Until(cert.NotAfter) > 30*24*time.Hour {
+		return tls.LoadX509KeyPair(certPath, keyPath)
+	}
+
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0], Organization: []string{organization}},
+		NotBefore:   time.Now().Add(-5 * time.Minute),
+		NotAfter:    time.Now().Add(serverValidity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	_, certPEM, keyPEM, err := a.sign(template)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := writeFileAtomic(keyPath, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := writeFileAtomic(certPath, certPEM, 0644); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// sign issues a certificate for template with a new key and serial
+func (a *Authority) sign(template *x509.Certificate) (*x509.Certificate, []byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template.SerialNumber, err = newSerial()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if template.NotAfter.After(a.cert.NotAfter) {
+		template.NotAfter = a.cert.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to sign certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keyPEM, err := EncodeKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+func (a *Authority) path(name string) string {
+	return filepath.Join(a.dir, name)
+}
+
+func coversHosts(cert *x509.Certificate, hosts []string) bool {
+	for _, host := range hosts {
+		if cert.VerifyHostname(host) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// newSerial returns a random 128-bit serial number
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	return serial, nil
+}
+
+func readKeyPair(certPath, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("%s is not PEM-encoded", certPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("%s is not PEM-encoded", keyPath)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not an ECDSA key", keyPath)
+	}
+	return cert, key, nil
+}
+
+// writeFileAtomic writes through a temporary file so a reader never sees a
+// partly written certificate or CRL
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,172 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startMTLS serves an authority's mTLS listener, answering every request
+// with the client certificate's common name
+func startMTLS(t *testing.T, a *Authority) *httptest.Server {
+	t.Helper()
+	config, err := a.ServerConfig("", "", []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("ServerConfig() error = %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = config
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// issue returns a client certificate for username and its serial
+func issue(t *testing.T, a *Authority, username string) (tls.Certificate, string) {
+	t.Helper()
+	cert, certPEM, keyPEM, err := a.IssueClient(username, "laptop", time.Hour)
+	if err != nil {
+		t.Fatalf("IssueClient() error = %v", err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair, FormatSerial(cert.SerialNumber)
+}
+
+// connect makes a request with a client certificate over a new connection,
+// returning the response body or the handshake error
+func connect(a *Authority, srv *httptest.Server, cert *tls.Certificate) (string, error) {
+	roots := x509.NewCertPool()
+	roots.AddCert(a.cert)
+	config := &tls.Config{RootCAs: roots}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestServerConfigChecksClientCertificates(t *testing.T) {
+	a, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := startMTLS(t, a)
+	alice, aliceSerial := issue(t, a, "alice")
+	bob, _ := issue(t, a, "bob")
+
+	foreign, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mallory, _ := issue(t, foreign, "alice")
+
+	tests := []struct {
+		name   string
+		cert   *tls.Certificate
+		revoke []string
+		want   string
+	}{
+		{"issued certificate", &alice, nil, "alice"},
+		{"no certificate", nil, nil, ""},
+		{"another CA's certificate", &mallory, nil, ""},
+		{"revoked certificate", &alice, []string{aliceSerial}, ""},
+		{"certificate that isn't revoked", &bob, []string{aliceSerial}, "bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.revoke != nil {
+				revoked := make([]RevokedCert, 0, len(tt.revoke))
+				for _, serial := range tt.revoke {
+					revoked = append(revoked, RevokedCert{Serial: serial, RevokedAt: time.Now()})
+				}
+				if err := a.WriteCRL(revoked); err != nil {
+					t.Fatalf("WriteCRL() error = %v", err)
+				}
+			}
+			got, err := connect(a, srv, tt.cert)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("the handshake succeeded as %q", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("request = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCRLMustBeSignedByTheCA(t *testing.T) {
+	dir := t.TempDir()
+	a, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, serial := issue(t, a, "alice")
+
+	// A CRL from another CA in the directory is refused rather than ignored
+	foreignDir := t.TempDir()
+	foreign, err := Load(foreignDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := foreign.WriteCRL([]RevokedCert{{Serial: serial, RevokedAt: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(foreign.path(CRLFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(a.path(CRLFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ServerConfig("", "", nil); err == nil || !strings.Contains(err.Error(), "not signed by the relay CA") {
+		t.Fatalf("ServerConfig() with a foreign CRL error = %v", err)
+	}
+
+	if err := os.WriteFile(a.path(CRLFile), []byte("not a CRL"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.ServerConfig("", "", nil); err == nil {
+		t.Fatal("ServerConfig() accepted a corrupt CRL")
+	}
+
+	// The CA is kept across restarts
+	reloaded, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.cert.Equal(a.cert) {
+		t.Error("Load() created a new CA instead of reading the existing one")
+	}
+}
+
+func TestSerials(t *testing.T) {
+	for _, serial := range []string{"1F", "0x1f", "1f"} {
+		parsed, ok := ParseSerial(serial)
+		if !ok || FormatSerial(parsed) != "1F" {
+			t.Errorf("ParseSerial(%q) = %v, %v, want 1F", serial, parsed, ok)
+		}
+	}
+	if _, ok := ParseSerial("not hex"); ok {
+		t.Error("ParseSerial() accepted a serial that isn't hex")
+	}
+}
@@ -0,0 +1,150 @@
+package rules
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"lan-relay/internal/models"
+)
+
+const (
+	DirectionRequest  = "request"
+	DirectionResponse = "response"
+
+	ActionSet    = "set"
+	ActionAppend = "append"
+	ActionRemove = "remove"
+	ActionRename = "rename"
+)
+
+// Variables available to rule values, e.g. "{{client_ip}}"
+var knownVariables = map[string]bool{
+	"client_ip":   true,
+	"request_id":  true,
+	"method":      true,
+	"path":        true,
+	"target":      true,
+	"target_host": true,
+	"target_port": true,
+}
+
+var (
+	variablePattern   = regexp.MustCompile(`\{\{\s*([a-z_]+)\s*\}\}`)
+	headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+)
+
+// Validate checks that a rule is well-formed and normalizes its fields
+func Validate(rule *models.HeaderRule) error {
+	rule.Direction = strings.ToLower(strings.TrimSpace(rule.Direction))
+	rule.Action = strings.ToLower(strings.TrimSpace(rule.Action))
+	rule.Name = strings.TrimSpace(rule.Name)
+
+	host, port, err := net.SplitHostPort(rule.Target)
+	if err != nil || host == "" {
+		return fmt.Errorf("target must be in HOST:PORT format")
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid target port")
+	}
+
+	if rule.Direction != DirectionRequest && rule.Direction != DirectionResponse {
+		return fmt.Errorf("direction must be 'request' or 'response'")
+	}
+
+	if !headerNamePattern.MatchString(rule.Name) {
+		return fmt.Errorf("invalid header name %q", rule.Name)
+	}
+	if isProtectedHeader(rule.Name) {
+		return fmt.Errorf("header %q cannot be rewritten", rule.Name)
+	}
+
+	switch rule.Action {
+	case ActionSet, ActionAppend:
+		if strings.ContainsAny(rule.Value, "\r\n") {
+			return fmt.Errorf("header value must not contain line breaks")
+		}
+		for _, match := range variablePattern.FindAllStringSubmatch(rule.Value, -1) {
+			if !knownVariables[match[1]] {
+				return fmt.Errorf("unknown template variable %q", match[1])
+			}
+		}
+	case ActionRemove:
+		rule.Value = ""
+	case ActionRename:
+		rule.Value = strings.TrimSpace(rule.Value)
+		if !headerNamePattern.MatchString(rule.Value) {
+			return fmt.Errorf("invalid new header name %q", rule.Value)
+		}
+		if isProtectedHeader(rule.Value) {
+			return fmt.Errorf("header %q cannot be rewritten", rule.Value)
+		}
+	default:
+		return fmt.Errorf("action must be one of set, append, remove or rename")
+	}
+
+	if strings.EqualFold(rule.Name, "host") {
+		if rule.Direction != DirectionRequest || rule.Action != ActionSet {
+			return fmt.Errorf("the Host header can only be set on requests")
+		}
+	}
+
+	return nil
+}
+
+// isProtectedHeader reports headers that rules must never touch because the
+// proxy relies on them for framing
+func isProtectedHeader(name string) bool {
+	switch textproto.CanonicalMIMEHeaderKey(name) {
+	case "Content-Length", "Transfer-Encoding", "Connection", "Upgrade", "Te", "Trailer":
+		return true
+	}
+	return false
+}
+
+// Expand replaces template variables in a rule value
+func Expand(value string, vars map[string]string) string {
+	return variablePattern.ReplaceAllStringFunc(value, func(match string) string {
+		name := variablePattern.FindStringSubmatch(match)[1]
+		return vars[name]
+	})
+}
+
+// Apply runs the enabled rules for a direction against a header set, in order.
+// It returns the Host override for request rules, or "" if none was set.
+func Apply(header http.Header, rules []models.HeaderRule, direction string, vars map[string]string) string {
+	host := ""
+	for _, rule := range rules {
+		if !rule.Enabled || rule.Direction != direction {
+			continue
+		}
+
+		switch rule.Action {
+		case ActionSet:
+			value := Expand(rule.Value, vars)
+			if strings.EqualFold(rule.Name, "host") {
+				host = value
+				continue
+			}
+			header.Set(rule.Name, value)
+		case ActionAppend:
+			header.Add(rule.Name, Expand(rule.Value, vars))
+		case ActionRemove:
+			header.Del(rule.Name)
+		case ActionRename:
+			values := header.Values(rule.Name)
+			if len(values) == 0 {
+				continue
+			}
+			header.Del(rule.Name)
+			for _, value := range values {
+				header.Add(rule.Value, value)
+			}
+		}
+	}
+	return host
+}
@@ -0,0 +1,106 @@
+package share
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Claims are what a share link grants. They travel inside the link, signed,
+// so a link can be checked without looking it up.
+type Claims struct {
+	ID         int      `json:"id"`
+	Target     string   `json:"t"`           // HOST:PORT
+	PathPrefix string   `json:"p,omitempty"` // empty allows every path
+	Methods    []string `json:"m,omitempty"` // empty allows GET and HEAD
+	ExpiresAt  int64    `json:"e"`           // Unix seconds
+}
+
+// DefaultMethods are allowed when a share doesn't list any
+var DefaultMethods = []string{"GET", "HEAD"}
+
+// Allows reports whether the claims cover a request method and target path
+func (c *Claims) Allows(method, path string) bool {
+	methods := c.Methods
+	if len(methods) == 0 {
+		methods = DefaultMethods
+	}
+	allowed := false
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			allowed = true
+			break
+		}
+	}
+	return allowed && PathAllowed(c.PathPrefix, path)
+}
+
+// PathAllowed reports whether a path is under a prefix. The prefix only
+// matches whole segments, so /admin doesn't allow /administrator.
+func PathAllowed(prefix, path string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// Signer signs and verifies share links with an HMAC-SHA256 key
+type Signer struct {
+	key []byte
+}
+
+// NewSigner creates a signer from a key of at least 32 bytes
+func NewSigner(key []byte) (*Signer, error) {
+	if len(key) < 32 {
+		return nil, fmt.Errorf("share key must be at least 32 bytes, got %d", len(key))
+	}
+	return &Signer{key: key}, nil
+}
+
+// Sign encodes claims as a URL-safe token: base64url(JSON).base64url(HMAC)
+func (s *Signer) Sign(claims Claims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac("link", encoded)), nil
+}
+
+// Verify checks a token's signature and expiry and returns its claims
+func (s *Signer) Verify(token string, now time.Time) (*Claims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed share link")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, s.mac("link", encoded)) {
+		return nil, fmt.Errorf("invalid share link signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed share link")
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed share link")
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("share link has expired")
+	}
+	return &claims, nil
+}
+
+// mac signs a message under a purpose, so one kind of signature can't be
+// passed off as another
+func (s *Signer) mac(purpose, message string) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(purpose + ":" + message))
+	return h.Sum(nil)
+}
@@ -0,0 +1,249 @@
+// Package sso signs users in through an OpenID Connect identity provider,
+// using the authorization code flow with PKCE.
+package sso
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"lan-relay/internal/auth"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// loginTimeout is how long a user has to finish signing in at the provider
+const loginTimeout = 10 * time.Minute
+
+// rolePriority orders roles from most to least privileged, so a user in
+// several mapped groups gets the strongest role
+var rolePriority = []string{auth.RoleAdmin, auth.RoleOperator, auth.RoleViewer, auth.RoleProxyOnly}
+
+// Config holds the relying party settings
+type Config struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	Scopes        []string
+	UsernameClaim string
+	GroupsClaim   string
+	RoleMapping   map[string]string // group -> role
+	DefaultRole   string            // role for users in no mapped group; "" refuses them
+}
+
+// Pending is a login started at the provider and not yet completed
+type Pending struct {
+	State       string
+	Nonce       string
+	Verifier    string
+	RedirectURL string
+	ReturnTo    string
+	Expires     time.Time
+}
+
+// Identity is a user signed in by the provider
+type Identity struct {
+	Issuer   string
+	Subject  string
+	Username string
+	Email    string
+	Name     string
+	Groups   []string
+}
+
+// Provider is a discovered OpenID provider
+type Provider struct {
+	cfg      Config
+	client   *http.Client
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+}
+
+// New discovers the provider's endpoints and signing keys. client is used
+// for every request to the provider; nil means http.DefaultClient.
+func New(ctx context.Context, cfg Config, client *http.Client) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, fmt.Errorf("issuer and client ID are required")
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{oidc.ScopeOpenID, "profile", "email"}
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "preferred_username"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, client), strings.TrimSuffix(cfg.Issuer, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+
+	return &Provider{
+		cfg:      cfg,
+		client:   client,
+		provider: provider,
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+func (p *Provider) oauthConfig(redirectURL string) *oauth2.Config {
+	scopes := p.cfg.Scopes
+	if !auth.HasScope(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     p.provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+	}
+}
+
+// Start begins a login, returning the provider URL to send the user to and
+// the state to keep until the callback
+func (p *Provider) Start(redirectURL, returnTo string) (string, Pending, error) {
+	state, err := randomString()
+	if err != nil {
+		return "", Pending{}, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", Pending{}, err
+	}
+
+	pending := Pending{
+		State:       state,
+		Nonce:       nonce,
+		Verifier:    oauth2.GenerateVerifier(),
+		RedirectURL: redirectURL,
+		ReturnTo:    returnTo,
+		Expires:     time.Now().Add(loginTimeout),
+	}
+	authURL := p.oauthConfig(redirectURL).AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(pending.Verifier))
+	return authURL, pending, nil
+}
+
+// Finish exchanges the authorization code and validates the ID token
+func (p *Provider) Finish(ctx context.Context, code string, pending Pending) (*Identity, error) {
+	if time.Now().After(pending.Expires) {
+		return nil, fmt.Errorf("sign-in took too long, try again")
+	}
+
+	ctx = context.WithValue(oidc.ClientContext(ctx, p.client), oauth2.HTTPClient, p.client)
+	token, err := p.oauthConfig(pending.RedirectURL).Exchange(ctx, code, oauth2.VerifierOption(pending.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("code exchange failed: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, fmt.Errorf("provider returned no ID token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if idToken.Nonce != pending.Nonce {
+		return nil, fmt.Errorf("ID token nonce doesn't match")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+
+	identity := &Identity{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+		Email:   stringClaim(claims, "email"),
+		Name:    stringClaim(claims, "name"),
+		Groups:  listClaim(claims, p.cfg.GroupsClaim),
+	}
+	for _, candidate := range []string{stringClaim(claims, p.cfg.UsernameClaim), identity.Email, identity.Subject} {
+		if auth.ValidateUsername(candidate) == nil {
+			identity.Username = candidate
+			break
+		}
+	}
+	if identity.Username == "" {
+		return nil, fmt.Errorf("no usable username in the %s, email or sub claims", p.cfg.UsernameClaim)
+	}
+	return identity, nil
+}
+
+// RoleFor returns the role for a user's groups: the most privileged mapped
+// role, else the default role. ok is false when the user gets no role.
+func (p *Provider) RoleFor(groups []string) (string, bool) {
+	roles := make([]string, 0)
+	for _, group := range groups {
+		if role, ok := p.cfg.RoleMapping[group]; ok {
+			roles = append(roles, role)
+		}
+	}
+	for _, role := range rolePriority {
+		if auth.HasScope(roles, role) {
+			return role, true
+		}
+	}
+	return p.cfg.DefaultRole, p.cfg.DefaultRole != ""
+}
+
+// ParseRoleMapping reads "group=role" pairs separated by commas or newlines
+func ParseRoleMapping(value string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, pair := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		group, role, ok := strings.Cut(pair, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if group == "" && role == "" {
+			continue
+		}
+		if !ok || group == "" {
+			return nil, fmt.Errorf("invalid role mapping %q, expected group=role", strings.TrimSpace(pair))
+		}
+		if err := auth.ValidateRole(role); err != nil {
+			return nil, err
+		}
+		mapping[group] = role
+	}
+	return mapping, nil
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// listClaim reads a claim that providers send as either a list or a single string
+func listClaim(claims map[string]interface{}, name string) []string {
+	values := make([]string, 0)
+	switch value := claims[name].(type) {
+	case string:
+		values = append(values, value)
+	case []interface{}:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
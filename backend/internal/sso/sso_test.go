@@ -0,0 +1,146 @@
+package sso
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"lan-relay/internal/auth"
+	"lan-relay/internal/sso/ssotest"
+)
+
+const testRedirect = "http://relay.test/api/auth/oidc/callback"
+
+func newTestProvider(t *testing.T) (*ssotest.Provider, *Provider) {
+	t.Helper()
+	idp := ssotest.NewProvider()
+	t.Cleanup(idp.Close)
+	provider, err := New(context.Background(), Config{
+		Issuer:       idp.URL,
+		ClientID:     ssotest.ClientID,
+		ClientSecret: ssotest.ClientSecret,
+		RoleMapping:  map[string]string{"admins": auth.RoleAdmin, "staff": auth.RoleViewer},
+	}, nil)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return idp, provider
+}
+
+// authorize follows the provider's login page and returns the code it sends
+// back, checking the state round trip
+func authorize(t *testing.T, authURL string, pending Pending) string {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize = %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if back.Query().Get("state") != pending.State {
+		t.Fatalf("state = %q, want %q", back.Query().Get("state"), pending.State)
+	}
+	return back.Query().Get("code")
+}
+
+func TestLogin(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	idp.SignInAs(map[string]interface{}{"sub": "u-1", "preferred_username": "alice", "email": "alice@example.com", "groups": []string{"staff", "admins"}})
+
+	authURL, pending, err := provider.Start(testRedirect, "/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, _ := url.Parse(authURL)
+	if query.Query().Get("code_challenge") == "" || query.Query().Get("code_challenge_method") != "S256" {
+		t.Fatalf("login URL %s doesn't use PKCE", authURL)
+	}
+	if strings.Contains(authURL, pending.Verifier) {
+		t.Fatal("the PKCE verifier was sent to the provider's login page")
+	}
+
+	identity, err := provider.Finish(context.Background(), authorize(t, authURL, pending), pending)
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if identity.Issuer != idp.URL || identity.Subject != "u-1" || identity.Username != "alice" || len(identity.Groups) != 2 {
+		t.Fatalf("Finish() = %+v", identity)
+	}
+	if role, ok := provider.RoleFor(identity.Groups); !ok || role != auth.RoleAdmin {
+		t.Errorf("RoleFor(%v) = %q, %v, want admin", identity.Groups, role, ok)
+	}
+}
+
+func TestFinishChecksVerifierAndNonce(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(*ssotest.Provider, *Pending)
+		wantErr string
+	}{
+		{"wrong PKCE verifier", func(_ *ssotest.Provider, p *Pending) {
+			p.Verifier = "another-verifier-another-verifier-another-verifier"
+		}, "code exchange failed"},
+		{"nonce mismatch", func(idp *ssotest.Provider, _ *Pending) { idp.ForceNonce("someone-elses-nonce") }, "nonce doesn't match"},
+		{"wrong redirect", func(_ *ssotest.Provider, p *Pending) { p.RedirectURL = "http://evil.test/callback" }, "code exchange failed"},
+		{"expired", func(_ *ssotest.Provider, p *Pending) { p.Expires = time.Now().Add(-time.Second) }, "took too long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp, provider := newTestProvider(t)
+			idp.SignInAs(map[string]interface{}{"sub": "u-1", "preferred_username": "alice"})
+			authURL, pending, err := provider.Start(testRedirect, "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(idp, &pending)
+			code := authorize(t, authURL, pending)
+			if _, err := provider.Finish(context.Background(), code, pending); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Finish() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFinishPicksUsableUsername(t *testing.T) {
+	idp, provider := newTestProvider(t)
+	// The preferred username isn't a valid relay username, so the email is used
+	idp.SignInAs(map[string]interface{}{"sub": "u-2", "preferred_username": "a b", "email": "bob@example.com"})
+	authURL, pending, _ := provider.Start(testRedirect, "/")
+	identity, err := provider.Finish(context.Background(), authorize(t, authURL, pending), pending)
+	if err != nil || identity.Username != "bob@example.com" {
+		t.Fatalf("Finish() = %+v, %v, want the email as username", identity, err)
+	}
+}
+
+func TestRoleFor(t *testing.T) {
+	provider := &Provider{cfg: Config{RoleMapping: map[string]string{"ops": auth.RoleOperator, "all": auth.RoleViewer}}}
+	if role, ok := provider.RoleFor([]string{"all", "ops"}); !ok || role != auth.RoleOperator {
+		t.Errorf("RoleFor = %q, %v, want the strongest mapped role", role, ok)
+	}
+	if _, ok := provider.RoleFor([]string{"guests"}); ok {
+		t.Error("RoleFor gave a role to an unmapped group without a default")
+	}
+	provider.cfg.DefaultRole = auth.RoleProxyOnly
+	if role, ok := provider.RoleFor(nil); !ok || role != auth.RoleProxyOnly {
+		t.Errorf("RoleFor = %q, %v, want the default role", role, ok)
+	}
+}
+
+func TestParseRoleMapping(t *testing.T) {
+	mapping, err := ParseRoleMapping("admins=admin, staff = viewer\nops=operator")
+	if err != nil || len(mapping) != 3 || mapping["staff"] != auth.RoleViewer {
+		t.Fatalf("ParseRoleMapping() = %v, %v", mapping, err)
+	}
+	for _, value := range []string{"admins", "=admin", "admins=root"} {
+		if _, err := ParseRoleMapping(value); err == nil {
+			t.Errorf("ParseRoleMapping(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// quickTunnelURL matches the URL cloudflared prints for a quick tunnel
+var quickTunnelURL = regexp.MustCompile(`https://[a-z0-9-]+\.trycloudflare\.com`)
+
+// Cloudflared runs a Cloudflare Tunnel with the cloudflared client. Without
+// a token it opens a quick tunnel at a random trycloudflare.com address;
+// with one it runs the named tunnel the token belongs to, whose public
+// hostname is configured in Cloudflare and given here.
+type Cloudflared struct {
+	*process
+	port     int
+	token    string
+	hostname string
+}
+
+// NewCloudflared creates a cloudflared provider
+func NewCloudflared(token, hostname string, opts Options) *Cloudflared {
+	return &Cloudflared{
+		process:  newProcess("cloudflared", opts.Notify),
+		port:     opts.Port,
+		token:    token,
+		hostname: hostname,
+	}
+}
+
+// Name identifies the provider
+func (c *Cloudflared) Name() string {
+	return "cloudflared"
+}
+
+// Check verifies a named tunnel has the hostname it's reached at
+func (c *Cloudflared) Check() error {
+	if c.token != "" && c.hostname == "" {
+		return fmt.Errorf("a named Cloudflare tunnel needs its public hostname")
+	}
+	return nil
+}
+
+// Start opens the tunnel and returns its public URL
+func (c *Cloudflared) Start() (string, error) {
+	if err := c.Check(); err != nil {
+		return "", err
+	}
+
+	if c.token == "" {
+		args := []string{"tunnel", "--no-autoupdate", "--url", fmt.Sprintf("http://localhost:%d", c.port)}
+		return c.start("cloudflared", args, nil, func(line string) string {
+			return quickTunnelURL.FindString(line)
+		})
+	}
+
+	// The named tunnel's ingress rules decide where traffic goes; it's up
+	// once a connection to Cloudflare is registered. cloudflared reads the
+	// token from TUNNEL_TOKEN, which keeps it out of the process list.
+	url := publicURL(c.hostname, "https")
+	args := []string{"tunnel", "--no-autoupdate", "run"}
+	return c.start("cloudflared", args, []string{"TUNNEL_TOKEN=" + c.token}, func(line string) string {
+		if strings.Contains(line, "Registered tunnel connection") {
+			return url
+		}
+		return ""
+	})
+}
+
+// publicURL turns a hostname, with or without a scheme, into a URL
+func publicURL(host, scheme string) string {
+	host = strings.TrimSuffix(strings.TrimSpace(host), "/")
+	if strings.Contains(host, "://") {
+		return host
+	}
+	return scheme + "://" + host
+}
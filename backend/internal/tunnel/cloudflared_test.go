@@ -0,0 +1,53 @@
+package tunnel
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeCloudflared puts a cloudflared on PATH that reports its arguments and
+// token, then stays up like a connected tunnel
+func fakeCloudflared(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"args: $*\"\necho \"token: $TUNNEL_TOKEN\"\necho \"Registered tunnel connection\"\nexec sleep 30\n"
+	if err := os.WriteFile(filepath.Join(dir, "cloudflared"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestCloudflaredPassesTokenInEnvironment(t *testing.T) {
+	fakeCloudflared(t)
+	tunnel := NewCloudflared("secret-token", "relay.example.com", Options{Port: 8080})
+	t.Cleanup(func() { tunnel.Stop() })
+
+	url, err := tunnel.Start()
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if url != "https://relay.example.com" {
+		t.Errorf("Start() = %q, want the named tunnel's hostname", url)
+	}
+
+	var args, token string
+	for _, event := range tunnel.Events() {
+		if strings.HasPrefix(event.Message, "args: ") {
+			args = event.Message
+		}
+		if strings.HasPrefix(event.Message, "token: ") {
+			token = event.Message
+		}
+		if event.Type == EventStarting && strings.Contains(event.Message, "secret-token") {
+			t.Errorf("the starting event shows the token: %q", event.Message)
+		}
+	}
+	if args != "args: tunnel --no-autoupdate run" {
+		t.Errorf("cloudflared got %q, want no token in its arguments", args)
+	}
+	if token != "token: secret-token" {
+		t.Errorf("cloudflared got %q, want the token in TUNNEL_TOKEN", token)
+	}
+}
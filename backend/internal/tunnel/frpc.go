@@ -0,0 +1,105 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FRPC exposes the relay through an frp server with the frpc client, as an
+// HTTP proxy on a custom domain routed to the server
+type FRPC struct {
+	*process
+	port   int
+	server string
+	token  string
+	domain string
+}
+
+// NewFRPC creates an frpc provider. server is the frp server's HOST:PORT
+// and domain the custom domain it routes to the relay, optionally with a
+// scheme for the public URL (http by default).
+func NewFRPC(server, token, domain string, opts Options) *FRPC {
+	return &FRPC{
+		process: newProcess("frpc", opts.Notify),
+		port:    opts.Port,
+		server:  server,
+		token:   token,
+		domain:  domain,
+	}
+}
+
+// Name identifies the provider
+func (f *FRPC) Name() string {
+	return "frpc"
+}
+
+// Check verifies the server and domain are set
+func (f *FRPC) Check() error {
+	if _, port, err := net.SplitHostPort(f.server); err != nil || port == "" {
+		return fmt.Errorf("the frp server must be HOST:PORT")
+	}
+	if f.domain == "" {
+		return fmt.Errorf("frpc needs the custom domain the frp server routes to the relay")
+	}
+	return nil
+}
+
+// Start writes the frpc configuration and opens the tunnel
+func (f *FRPC) Start() (string, error) {
+	if err := f.Check(); err != nil {
+		return "", err
+	}
+
+	public := publicURL(f.domain, "http")
+	parsed, err := url.Parse(public)
+	if err != nil || parsed.Hostname() == "" {
+		return "", fmt.Errorf("invalid frp domain %q", f.domain)
+	}
+
+	configFile, err := f.writeConfig(parsed.Hostname())
+	if err != nil {
+		return "", fmt.Errorf("failed to write the frpc configuration: %v", err)
+	}
+	// The configuration holds the token, so it only lives as long as frpc
+	f.cleanup = func() { os.Remove(configFile) }
+
+	tunnelURL, err := f.start("frpc", []string{"-c", configFile}, nil, func(line string) string {
+		if strings.Contains(line, "start proxy success") {
+			return public
+		}
+		return ""
+	})
+	if err != nil {
+		os.Remove(configFile)
+	}
+	return tunnelURL, err
+}
+
+// writeConfig writes a TOML configuration readable only by the relay
+func (f *FRPC) writeConfig(domain string) (string, error) {
+	host, port, _ := net.SplitHostPort(f.server)
+	var config strings.Builder
+	fmt.Fprintf(&config, "serverAddr = %s\n", strconv.Quote(host))
+	fmt.Fprintf(&config, "serverPort = %s\n", port)
+	if f.token != "" {
+		fmt.Fprintf(&config, "auth.token = %s\n", strconv.Quote(f.token))
+	}
+	fmt.Fprintf(&config, "\n[[proxies]]\nname = \"lan-relay\"\ntype = \"http\"\n")
+	fmt.Fprintf(&config, "localIP = \"127.0.0.1\"\nlocalPort = %d\n", f.port)
+	fmt.Fprintf(&config, "customDomains = [%s]\n", strconv.Quote(domain))
+
+	file, err := os.CreateTemp("", "lan-relay-frpc-*.toml")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if _, err := file.WriteString(config.String()); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,195 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// startTimeout is how long a tunnel client gets to come up
+const startTimeout = 30 * time.Second
+
+// process runs a tunnel client as a subprocess, turning its output into
+// events. It's the common part of the subprocess providers.
+type process struct {
+	name   string
+	events *EventLog
+
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	url       string
+	state     string
+	lastError string
+	startedAt *time.Time
+	exited    chan struct{}
+	// cleanup, if set, runs once the process has exited
+	cleanup func()
+}
+
+func newProcess(name string, notify func(Event)) *process {
+	return &process{name: name, events: NewEventLog(name, notify), state: StateStopped}
+}
+
+// start runs a command and waits until ready finds the public URL in a line
+// of its output. ready may return "" for lines that don't announce it.
+// Secrets go in env, added to the relay's environment, rather than in args,
+// which any local user can read from the process list.
+func (p *process) start(command string, args, env []string, ready func(line string) string) (string, error) {
+	p.mu.Lock()
+	if p.cmd != nil {
+		p.mu.Unlock()
+		return "", fmt.Errorf("%s is already running", p.name)
+	}
+	p.state, p.url, p.lastError = StateStarting, "", ""
+	p.mu.Unlock()
+	p.events.Add(EventStarting, command+" "+strings.Join(args, " "))
+
+	if _, err := exec.LookPath(command); err != nil {
+		return "", p.fail(fmt.Errorf("%s isn't installed or not in PATH", command))
+	}
+
+	cmd := exec.Command(command, args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	output, writer := io.Pipe()
+	cmd.Stdout, cmd.Stderr = writer, writer
+	if err := cmd.Start(); err != nil {
+		return "", p.fail(fmt.Errorf("failed to start %s: %v", command, err))
+	}
+
+	exited := make(chan struct{})
+	p.mu.Lock()
+	p.cmd, p.exited = cmd, exited
+	p.mu.Unlock()
+
+	found := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			p.events.Add(EventOutput, line)
+			if url := ready(line); url != "" {
+				select {
+				case found <- url:
+				default:
+				}
+			}
+		}
+	}()
+	go func() {
+		err := cmd.Wait()
+		writer.Close()
+		p.exit(cmd, err)
+		if p.cleanup != nil {
+			p.cleanup()
+		}
+		close(exited)
+	}()
+
+	select {
+	case url := <-found:
+		now := time.Now()
+		p.mu.Lock()
+		p.state, p.url, p.startedAt = StateRunning, url, &now
+		p.mu.Unlock()
+		p.events.Add(EventStarted, url)
+		return url, nil
+	case <-exited:
+		return "", p.fail(fmt.Errorf("%s exited before the tunnel was up: %s", command, p.lastOutput()))
+	case <-time.After(startTimeout):
+		p.Stop()
+		return "", p.fail(fmt.Errorf("timeout waiting for %s to open the tunnel: %s", command, p.lastOutput()))
+	}
+}
+
+// exit records that the process ended, unless it was stopped on purpose
+func (p *process) exit(cmd *exec.Cmd, err error) {
+	p.mu.Lock()
+	if p.cmd != cmd {
+		p.mu.Unlock()
+		return
+	}
+	p.cmd, p.url, p.startedAt = nil, "", nil
+	p.state = StateFailed
+	if err != nil {
+		p.lastError = err.Error()
+	} else {
+		p.lastError = "exited"
+	}
+	message := p.lastError
+	p.mu.Unlock()
+
+	p.events.Add(EventExited, message)
+}
+
+// fail records a failed start and returns its error
+func (p *process) fail(err error) error {
+	p.mu.Lock()
+	p.state, p.lastError = StateFailed, err.Error()
+	p.mu.Unlock()
+	p.events.Add(EventError, err.Error())
+	return err
+}
+
+// lastOutput returns the last line the process printed, to explain failures
+func (p *process) lastOutput() string {
+	events := p.events.Events()
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Type == EventOutput {
+			return events[i].Message
+		}
+	}
+	return "no output"
+}
+
+// Stop kills the process and waits for it to exit
+func (p *process) Stop() error {
+	p.mu.Lock()
+	cmd, exited := p.cmd, p.exited
+	p.cmd, p.url, p.startedAt = nil, "", nil
+	p.state = StateStopped
+	p.mu.Unlock()
+	if cmd == nil || cmd.Process == nil {
+		return nil
+	}
+
+	err := cmd.Process.Kill()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+	}
+	p.events.Add(EventStopped, "")
+	return err
+}
+
+// Status reports the process's state
+func (p *process) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := Status{Provider: p.name, State: p.state, URL: p.url, StartedAt: p.startedAt}
+	if p.state == StateFailed {
+		status.Error = p.lastError
+	}
+	return status
+}
+
+// PublicURL returns the tunnel's URL while it's running
+func (p *process) PublicURL() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.url
+}
+
+// Events returns the process's recent events
+func (p *process) Events() []Event {
+	return p.events.Events()
+}
@@ -0,0 +1,381 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// sshKeepalive is how often an idle connection is checked by default
+	sshKeepalive = 30 * time.Second
+	// sshDialTimeout bounds connecting and authenticating to the server
+	sshDialTimeout = 15 * time.Second
+	// sshMaxBackoff caps the wait between reconnection attempts
+	sshMaxBackoff = time.Minute
+)
+
+// SSHConfig configures an SSH reverse tunnel
+type SSHConfig struct {
+	// Server is the SSH server's HOST:PORT, port 22 by default
+	Server string
+	User   string
+	// PrivateKey is the PEM or OpenSSH private key the user signs in with
+	PrivateKey string
+	// HostKey pins the server's host key, either as an authorized_keys line
+	// or as its SHA256 fingerprint. Connections to any other key are refused.
+	HostKey string
+	// RemoteAddr is the address the server listens on for the relay, like
+	// ssh -R. It defaults to all interfaces on the relay's port.
+	RemoteAddr string
+	// PublicURL is the URL the remote address is reached at, when it isn't
+	// http://SERVER:PORT, such as a proxy on the server in front of it
+	PublicURL string
+	// Keepalive is how often the connection is checked, 30 seconds by default
+	Keepalive time.Duration
+}
+
+// SSH exposes the relay through a remote port forward on an SSH server,
+// without any client to install. It reconnects with backoff when the
+// connection drops or stops answering keepalives.
+type SSH struct {
+	config SSHConfig
+	serve  func(net.Conn)
+	events *EventLog
+
+	mu        sync.Mutex
+	state     string
+	url       string
+	lastError string
+	startedAt *time.Time
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewSSH creates an SSH reverse tunnel provider
+func NewSSH(config SSHConfig, opts Options) *SSH {
+	if config.Keepalive <= 0 {
+		config.Keepalive = sshKeepalive
+	}
+	if config.RemoteAddr == "" {
+		config.RemoteAddr = net.JoinHostPort("0.0.0.0", strconv.Itoa(opts.Port))
+	}
+	if config.Server != "" {
+		if _, _, err := net.SplitHostPort(config.Server); err != nil {
+			config.Server = net.JoinHostPort(config.Server, "22")
+		}
+	}
+	return &SSH{config: config, serve: opts.Serve, events: NewEventLog("ssh", opts.Notify), state: StateStopped}
+}
+
+// Name identifies the provider
+func (s *SSH) Name() string {
+	return "ssh"
+}
+
+// Check verifies the server, user, key and remote address
+func (s *SSH) Check() error {
+	if s.serve == nil {
+		return fmt.Errorf("the SSH tunnel has nowhere to send its connections")
+	}
+	if _, _, err := net.SplitHostPort(s.config.Server); err != nil || s.config.Server == "" {
+		return fmt.Errorf("the SSH server must be HOST or HOST:PORT")
+	}
+	if s.config.User == "" {
+		return fmt.Errorf("the SSH tunnel needs a user to sign in as")
+	}
+	if _, err := ssh.ParsePrivateKey([]byte(s.config.PrivateKey)); err != nil {
+		return fmt.Errorf("invalid SSH private key: %v", err)
+	}
+	if s.config.HostKey != "" && !strings.HasPrefix(s.config.HostKey, "SHA256:") {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s.config.HostKey)); err != nil {
+			return fmt.Errorf("invalid pinned host key: %v", err)
+		}
+	}
+	if _, port, err := net.SplitHostPort(s.config.RemoteAddr); err != nil {
+		return fmt.Errorf("the remote address must be HOST:PORT")
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid remote port %q", port)
+	}
+	return nil
+}
+
+// Start connects to the server and opens the remote port forward. The first
+// connection must succeed; later drops are retried in the background.
+func (s *SSH) Start() (string, error) {
+	if err := s.Check(); err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	if s.stop != nil {
+		s.mu.Unlock()
+		return "", fmt.Errorf("the SSH tunnel is already running")
+	}
+	s.state, s.url, s.lastError = StateStarting, "", ""
+	s.mu.Unlock()
+	s.events.Add(EventStarting, fmt.Sprintf("%s@%s -R %s", s.config.User, s.config.Server, s.config.RemoteAddr))
+
+	client, listener, url, err := s.connect()
+	if err != nil {
+		return "", s.fail(err)
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	s.mu.Lock()
+	s.stop, s.done = stop, done
+	s.mu.Unlock()
+	s.up(url, stop)
+
+	go s.run(client, listener, stop, done)
+	return url, nil
+}
+
+// connect signs in to the server and asks it to listen on the remote address
+func (s *SSH) connect() (*ssh.Client, net.Listener, string, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(s.config.PrivateKey))
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("invalid SSH private key: %v", err)
+	}
+
+	client, err := ssh.Dial("tcp", s.config.Server, &ssh.ClientConfig{
+		User:            s.config.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: s.checkHostKey,
+		Timeout:         sshDialTimeout,
+	})
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to connect to %s: %v", s.config.Server, err)
+	}
+
+	listener, err := client.Listen("tcp", s.config.RemoteAddr)
+	if err != nil {
+		client.Close()
+		return nil, nil, "", fmt.Errorf("the server refused to listen on %s: %v", s.config.RemoteAddr, err)
+	}
+
+	return client, listener, s.publicURL(listener.Addr()), nil
+}
+
+// checkHostKey only accepts the pinned host key. When none is pinned yet,
+// the error tells the fingerprint to pin after checking it out of band.
+func (s *SSH) checkHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	fingerprint := ssh.FingerprintSHA256(key)
+	pinned := strings.TrimSpace(s.config.HostKey)
+	if pinned == "" {
+		return fmt.Errorf("the server's host key isn't pinned; it presented %s %s", key.Type(), fingerprint)
+	}
+
+	if strings.HasPrefix(pinned, "SHA256:") {
+		if strings.TrimRight(pinned, "=") == fingerprint {
+			return nil
+		}
+	} else if pinnedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pinned)); err == nil &&
+		bytes.Equal(pinnedKey.Marshal(), key.Marshal()) {
+		return nil
+	}
+	return fmt.Errorf("host key mismatch: the server presented %s %s", key.Type(), fingerprint)
+}
+
+// publicURL returns where the forwarded port is reached
+func (s *SSH) publicURL(listening net.Addr) string {
+	if s.config.PublicURL != "" {
+		return publicURL(s.config.PublicURL, "http")
+	}
+
+	host, _, _ := net.SplitHostPort(s.config.Server)
+	_, port, _ := net.SplitHostPort(s.config.RemoteAddr)
+	if addr, ok := listening.(*net.TCPAddr); ok && addr.Port != 0 {
+		port = strconv.Itoa(addr.Port)
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// run serves the tunnel, reconnecting whenever the connection is lost,
+// until it's stopped
+func (s *SSH) run(client *ssh.Client, listener net.Listener, stop, done chan struct{}) {
+	defer close(done)
+
+	for {
+		lost := s.accept(client, listener, stop)
+		if lost == nil {
+			return
+		}
+		s.down(lost, stop)
+
+		var url string
+		for delay := time.Second; ; delay = min(delay*2, sshMaxBackoff) {
+			select {
+			case <-stop:
+				return
+			case <-time.After(delay):
+			}
+
+			var err error
+			if client, listener, url, err = s.connect(); err == nil {
+				break
+			}
+			s.mu.Lock()
+			if s.stop == stop {
+				s.lastError = err.Error()
+			}
+			s.mu.Unlock()
+			s.events.Add(EventError, fmt.Sprintf("reconnecting in %v: %v", min(delay*2, sshMaxBackoff), err))
+		}
+
+		// The tunnel may have been stopped while connecting
+		select {
+		case <-stop:
+			client.Close()
+			return
+		default:
+		}
+		s.up(url, stop)
+	}
+}
+
+// accept hands the forwarded connections to the relay until the tunnel is
+// stopped, which returns nil, or the connection is lost, which returns why
+func (s *SSH) accept(client *ssh.Client, listener net.Listener, stop chan struct{}) error {
+	defer client.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.Wait()
+	}()
+
+	ticker := time.NewTicker(s.config.Keepalive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-closed:
+			if err == nil {
+				err = io.EOF
+			}
+			return fmt.Errorf("connection closed: %v", err)
+		case <-ticker.C:
+			if err := s.keepalive(client); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// keepalive checks the server still answers, like ServerAliveInterval
+func (s *SSH) keepalive(client *ssh.Client) error {
+	answered := make(chan error, 1)
+	go func() {
+		// Servers that don't know the request reply with a failure, which
+		// still shows they're alive
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		answered <- err
+	}()
+
+	select {
+	case err := <-answered:
+		if err != nil {
+			return fmt.Errorf("keepalive failed: %v", err)
+		}
+		return nil
+	case <-time.After(s.config.Keepalive):
+		return fmt.Errorf("keepalive timed out after %v", s.config.Keepalive)
+	}
+}
+
+// up records that the tunnel is open at url, unless it was stopped
+func (s *SSH) up(url string, stop chan struct{}) {
+	now := time.Now()
+	s.mu.Lock()
+	if s.stop != stop {
+		s.mu.Unlock()
+		return
+	}
+	s.state, s.url, s.lastError, s.startedAt = StateRunning, url, "", &now
+	s.mu.Unlock()
+	s.events.Add(EventStarted, url)
+}
+
+// down records that the connection was lost and is being retried, unless
+// it was stopped
+func (s *SSH) down(err error, stop chan struct{}) {
+	s.mu.Lock()
+	if s.stop != stop {
+		s.mu.Unlock()
+		return
+	}
+	s.state, s.url, s.lastError, s.startedAt = StateStarting, "", err.Error(), nil
+	s.mu.Unlock()
+	s.events.Add(EventExited, err.Error())
+}
+
+// fail records a failed start and returns its error
+func (s *SSH) fail(err error) error {
+	s.mu.Lock()
+	s.state, s.lastError = StateFailed, err.Error()
+	s.mu.Unlock()
+	s.events.Add(EventError, err.Error())
+	return err
+}
+
+// Stop closes the tunnel and stops reconnecting
+func (s *SSH) Stop() error {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.state, s.url, s.startedAt = StateStopped, "", nil
+	s.mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+	}
+	s.events.Add(EventStopped, "")
+	return nil
+}
+
+// Status reports the tunnel's state. While reconnecting it's starting, with
+// the reason the connection was lost.
+func (s *SSH) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := Status{Provider: "ssh", State: s.state, URL: s.url, StartedAt: s.startedAt}
+	if s.state != StateRunning && s.state != StateStopped {
+		status.Error = s.lastError
+	}
+	return status
+}
+
+// PublicURL returns the tunnel's URL while it's running
+func (s *SSH) PublicURL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.url
+}
+
+// Events returns the tunnel's recent events
+func (s *SSH) Events() []Event {
+	return s.events.Events()
+}
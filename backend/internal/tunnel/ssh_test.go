@@ -0,0 +1,360 @@
+package tunnel
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testOrigin is the client address the test server reports for forwarded
+// connections
+const testOrigin = "203.0.113.7:4444"
+
+// sshServer is a minimal SSH server that accepts one client key and
+// handles remote port forwards
+type sshServer struct {
+	t        *testing.T
+	listener net.Listener
+	hostKey  ssh.Signer
+	config   *ssh.ServerConfig
+
+	mu    sync.Mutex
+	conns []*ssh.ServerConn
+}
+
+func newKey(t *testing.T) (ssh.Signer, string) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, string(pem.EncodeToMemory(block))
+}
+
+func startSSHServer(t *testing.T, clientKey ssh.PublicKey) *sshServer {
+	t.Helper()
+	hostKey, _ := newKey(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &sshServer{t: t, listener: listener, hostKey: hostKey, config: config}
+	go s.accept()
+	t.Cleanup(func() {
+		listener.Close()
+		s.dropAll()
+	})
+	return s
+}
+
+func (s *sshServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *sshServer) fingerprint() string {
+	return ssh.FingerprintSHA256(s.hostKey.PublicKey())
+}
+
+func (s *sshServer) accept() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// dropAll cuts every client connection, as a lost network would
+func (s *sshServer) dropAll() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+func (s *sshServer) handle(c net.Conn) {
+	conn, chans, requests, err := ssh.NewServerConn(c, s.config)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+
+	go func() {
+		for channel := range chans {
+			channel.Reject(ssh.Prohibited, "no sessions")
+		}
+	}()
+	for request := range requests {
+		if request.Type != "tcpip-forward" {
+			if request.WantReply {
+				request.Reply(false, nil)
+			}
+			continue
+		}
+
+		var forward struct {
+			Addr string
+			Port uint32
+		}
+		ssh.Unmarshal(request.Payload, &forward)
+		listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(forward.Port))))
+		if err != nil {
+			request.Reply(false, nil)
+			continue
+		}
+		port := uint32(listener.Addr().(*net.TCPAddr).Port)
+		reply := make([]byte, 4)
+		binary.BigEndian.PutUint32(reply, port)
+		request.Reply(true, reply)
+
+		go func() {
+			conn.Wait()
+			listener.Close()
+		}()
+		go s.forward(conn, listener, forward.Addr, port)
+	}
+}
+
+// forward opens a forwarded-tcpip channel for every connection to a
+// forwarded port
+func (s *sshServer) forward(conn *ssh.ServerConn, listener net.Listener, addr string, port uint32) {
+	originHost, originPort, _ := net.SplitHostPort(testOrigin)
+	originPortNumber, _ := strconv.Atoi(originPort)
+	for {
+		in, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer in.Close()
+			channel, requests, err := conn.OpenChannel("forwarded-tcpip", ssh.Marshal(struct {
+				Addr       string
+				Port       uint32
+				OriginAddr string
+				OriginPort uint32
+			}{addr, port, originHost, uint32(originPortNumber)}))
+			if err != nil {
+				return
+			}
+			defer channel.Close()
+			go ssh.DiscardRequests(requests)
+			go func() {
+				io.Copy(channel, in)
+				channel.CloseWrite()
+			}()
+			io.Copy(in, channel)
+		}()
+	}
+}
+
+// echoServe answers each tunnel connection with what it sends, prefixed by
+// the address it came from
+func echoServe(conn net.Conn) {
+	defer conn.Close()
+	io.WriteString(conn, conn.RemoteAddr().String()+" ")
+	io.Copy(conn, conn)
+}
+
+// roundTrip connects to a tunnel URL and returns the echo of message
+func roundTrip(t *testing.T, url, message string) string {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(url, "http://"), 5*time.Second)
+	if err != nil {
+		t.Fatalf("dialing the tunnel: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, message)
+	conn.(*net.TCPConn).CloseWrite()
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("reading through the tunnel: %v", err)
+	}
+	return string(reply)
+}
+
+func newTestSSH(t *testing.T, server *sshServer, privateKey, hostKey string) *SSH {
+	t.Helper()
+	tunnel := NewSSH(SSHConfig{
+		Server:     server.addr(),
+		User:       "relay",
+		PrivateKey: privateKey,
+		HostKey:    hostKey,
+		RemoteAddr: "127.0.0.1:0",
+	}, Options{Port: 8080, Serve: echoServe})
+	t.Cleanup(func() { tunnel.Stop() })
+	return tunnel
+}
+
+func TestSSHHostKeyPinning(t *testing.T) {
+	clientKey, privateKey := newKey(t)
+	server := startSSHServer(t, clientKey.PublicKey())
+	other, _ := newKey(t)
+
+	tests := []struct {
+		name    string
+		hostKey string
+		wantErr string
+	}{
+		{"unpinned", "", "isn't pinned; it presented ssh-ed25519 " + server.fingerprint()},
+		{"other fingerprint", ssh.FingerprintSHA256(other.PublicKey()), "host key mismatch"},
+		{"other key", string(ssh.MarshalAuthorizedKey(other.PublicKey())), "host key mismatch"},
+		{"pinned fingerprint", server.fingerprint(), ""},
+		{"pinned key", string(ssh.MarshalAuthorizedKey(server.hostKey.PublicKey())), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tunnel := newTestSSH(t, server, privateKey, tt.hostKey)
+			_, err := tunnel.Start()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Start() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Start() error = %v, want %q", err, tt.wantErr)
+			}
+			if status := tunnel.Status(); status.State != StateFailed {
+				t.Errorf("Status().State = %q, want %q", status.State, StateFailed)
+			}
+		})
+	}
+}
+
+func TestSSHForwardsConnections(t *testing.T) {
+	clientKey, privateKey := newKey(t)
+	server := startSSHServer(t, clientKey.PublicKey())
+	tunnel := newTestSSH(t, server, privateKey, server.fingerprint())
+
+	url, err := tunnel.Start()
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if !strings.HasPrefix(url, "http://127.0.0.1:") || tunnel.PublicURL() != url {
+		t.Fatalf("Start() URL = %q, PublicURL() = %q", url, tunnel.PublicURL())
+	}
+
+	// Connections are handed over with the address the server reports
+	if reply := roundTrip(t, url, "ping"); reply != testOrigin+" ping" {
+		t.Fatalf("tunnel replied %q, want %q", reply, testOrigin+" ping")
+	}
+
+	tunnel.Stop()
+	if status := tunnel.Status(); status.State != StateStopped || status.URL != "" {
+		t.Fatalf("Status() after Stop = %+v", status)
+	}
+
+	// The server closes the forwarded port once it sees the client go
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", strings.TrimPrefix(url, "http://"), time.Second)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("the forwarded port is still open after Stop")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSSHReconnects(t *testing.T) {
+	clientKey, privateKey := newKey(t)
+	server := startSSHServer(t, clientKey.PublicKey())
+	tunnel := newTestSSH(t, server, privateKey, server.fingerprint())
+
+	first, err := tunnel.Start()
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	server.dropAll()
+
+	deadline := time.Now().Add(10 * time.Second)
+	var second string
+	for {
+		second = tunnel.PublicURL()
+		if second != "" && second != first {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the tunnel didn't reconnect, status %+v", tunnel.Status())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if reply := roundTrip(t, second, "again"); reply != testOrigin+" again" {
+		t.Fatalf("tunnel replied %q after reconnecting", reply)
+	}
+	var exited, started int
+	for _, event := range tunnel.Events() {
+		switch event.Type {
+		case EventExited:
+			exited++
+		case EventStarted:
+			started++
+		}
+	}
+	if exited != 1 || started != 2 {
+		t.Errorf("got %d exited and %d started events, want 1 and 2", exited, started)
+	}
+}
+
+func TestSSHCheck(t *testing.T) {
+	_, privateKey := newKey(t)
+	valid := SSHConfig{Server: "vps.example.com", User: "relay", PrivateKey: privateKey}
+	serve := Options{Port: 8080, Serve: echoServe}
+
+	if err := NewSSH(valid, serve).Check(); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if err := NewSSH(valid, Options{Port: 8080}).Check(); err == nil {
+		t.Error("Check() accepted a tunnel with nowhere to send its connections")
+	}
+	noUser := valid
+	noUser.User = ""
+	badKey := valid
+	badKey.PrivateKey = "not a key"
+	badPin := valid
+	badPin.HostKey = "not a key"
+	for _, config := range []SSHConfig{noUser, badKey, badPin} {
+		if err := NewSSH(config, serve).Check(); err == nil {
+			t.Errorf("Check() accepted %+v", config)
+		}
+	}
+}
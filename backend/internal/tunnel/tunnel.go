@@ -0,0 +1,117 @@
+package tunnel
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Provider exposes the relay at a public URL, such as an ngrok or
+// cloudflared tunnel
+type Provider interface {
+	// Name identifies the provider in settings and the API
+	Name() string
+	// Start opens the tunnel and returns its public URL once it's up
+	Start() (string, error)
+	// Stop closes the tunnel
+	Stop() error
+	// Status reports the tunnel's state
+	Status() Status
+	// PublicURL returns the tunnel's URL, or "" when it isn't running
+	PublicURL() string
+	// Events returns what recently happened to the tunnel, oldest first
+	Events() []Event
+}
+
+// Checker is implemented by providers that can verify their credentials
+// before starting
+type Checker interface {
+	Check() error
+}
+
+// Tunnel states
+const (
+	StateStopped  = "stopped"
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateFailed   = "failed"
+)
+
+// Event types
+const (
+	EventStarting = "starting"
+	EventStarted  = "started"
+	EventStopped  = "stopped"
+	EventExited   = "exited" // the tunnel client quit on its own
+	EventOutput   = "output" // a line the tunnel client printed
+	EventError    = "error"
+)
+
+// Status describes a tunnel
+type Status struct {
+	Provider  string     `json:"provider"`
+	State     string     `json:"state"`
+	URL       string     `json:"url,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+// Event is something that happened to a tunnel
+type Event struct {
+	Time     time.Time `json:"time"`
+	Provider string    `json:"provider"`
+	Type     string    `json:"type"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// Options are shared by all providers
+type Options struct {
+	// Port is the local port the relay listens on
+	Port int
+	// Notify, if set, is called with every event
+	Notify func(Event)
+	// Serve takes the connections of providers that accept them in-process,
+	// so the relay knows they came through the tunnel rather than from this
+	// host. It must be set for the ssh provider.
+	Serve func(net.Conn)
+}
+
+// maxEvents is how many events a provider keeps
+const maxEvents = 100
+
+// EventLog keeps a provider's recent events and passes them on to Notify
+type EventLog struct {
+	provider string
+	notify   func(Event)
+
+	mu     sync.Mutex
+	events []Event
+}
+
+// NewEventLog creates the event log of a provider
+func NewEventLog(provider string, notify func(Event)) *EventLog {
+	return &EventLog{provider: provider, notify: notify}
+}
+
+// Add records an event
+func (l *EventLog) Add(eventType, message string) {
+	event := Event{Time: time.Now(), Provider: l.provider, Type: eventType, Message: message}
+
+	l.mu.Lock()
+	l.events = append(l.events, event)
+	if len(l.events) > maxEvents {
+		l.events = l.events[len(l.events)-maxEvents:]
+	}
+	l.mu.Unlock()
+
+	if l.notify != nil {
+		l.notify(event)
+	}
+}
+
+// Events returns the recorded events, oldest first
+func (l *EventLog) Events() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Event(nil), l.events...)
+}
@@ -0,0 +1,155 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const keySize = 32
+
+// Vault encrypts and decrypts secrets with AES-256-GCM
+type Vault struct {
+	aead cipher.AEAD
+}
+
+// New creates a vault from a 32-byte master key
+func New(key []byte) (*Vault, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", keySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Vault{aead: aead}, nil
+}
+
+// Load reads the master key from a base64 value (usually an env var) or, if
+// that is empty, from a key file. A missing key file is created with a new
+// random key and owner-only permissions.
+func Load(encodedKey, keyFile string) (*Vault, error) {
+	key, err := LoadKey(encodedKey, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return New(key)
+}
+
+// LoadKey reads a 32-byte key the way Load does, for other uses such as
+// signing
+func LoadKey(encodedKey, keyFile string) ([]byte, error) {
+	if encodedKey != "" {
+		key, err := decodeKey(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key: %v", err)
+		}
+		return key, nil
+	}
+
+	if keyFile == "" {
+		return nil, fmt.Errorf("no master key or key file configured")
+	}
+
+	data, err := os.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		return generateKeyFile(keyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	key, err := decodeKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %v", keyFile, err)
+	}
+	return key, nil
+}
+
+// GenerateKey returns a new random master key, base64-encoded
+func GenerateKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func generateKeyFile(path string) ([]byte, error) {
+	encoded, err := GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate master key: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(encoded + "\n"); err != nil {
+		return nil, fmt.Errorf("failed to write key file: %v", err)
+	}
+
+	return decodeKey(encoded)
+}
+
+// WriteKeyFile replaces a key file with a base64-encoded key, owner-only. The
+// key is written to a temporary file first so the old key is never half
+// overwritten.
+func WriteKeyFile(path, encodedKey string) error {
+	if _, err := decodeKey(encodedKey); err != nil {
+		return fmt.Errorf("invalid master key: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(encodedKey+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write key file: %v", err)
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace key file: %v", err)
+	}
+	return nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+}
+
+// Encrypt seals plaintext, returning nonce||ciphertext
+func (v *Vault) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return v.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens data produced by Encrypt
+func (v *Vault) Decrypt(data []byte) ([]byte, error) {
+	nonceSize := v.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	plaintext, err := v.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret: %v", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,149 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newVault(t *testing.T, fill byte) *Vault {
+	t.Helper()
+	v, err := New(bytes.Repeat([]byte{fill}, keySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestSealAndOpen(t *testing.T) {
+	v := newVault(t, 1)
+	other := newVault(t, 2)
+	secret := []byte("hunter2")
+
+	sealed, err := v.Encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, secret) {
+		t.Fatal("Encrypt() left the plaintext in its output")
+	}
+	again, err := v.Encrypt(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sealed, again) {
+		t.Error("Encrypt() reused a nonce")
+	}
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name    string
+		vault   *Vault
+		data    []byte
+		wantErr bool
+	}{
+		{"same key", v, sealed, false},
+		{"other key", other, sealed, true},
+		{"tampered ciphertext", v, tampered, true},
+		{"truncated", v, sealed[:5], true},
+		{"empty", v, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := tt.vault.Decrypt(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Decrypt() = %q, want an error", plaintext)
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(plaintext, secret) {
+				t.Fatalf("Decrypt() = %q, %v, want %q", plaintext, err, secret)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, size := range []int{0, 16, 31, 33} {
+		if _, err := New(make([]byte, size)); err == nil {
+			t.Errorf("New() accepted a %d-byte key", size)
+		}
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{7}, keySize)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	keyFile := filepath.Join(dir, "vault.key")
+	if err := os.WriteFile(keyFile, []byte(encoded+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		keyFile string
+		want    []byte
+		wantErr bool
+	}{
+		{"value", encoded, "", key, false},
+		{"value over key file", encoded, filepath.Join(dir, "unused.key"), key, false},
+		{"key file", "", keyFile, key, false},
+		{"value that isn't base64", "not base64!", "", nil, true},
+		{"nothing configured", "", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadKey(tt.encoded, tt.keyFile)
+			if (err != nil) != tt.wantErr || !bytes.Equal(got, tt.want) {
+				t.Fatalf("LoadKey() = %x, %v, want %x", got, err, tt.want)
+			}
+		})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unused.key")); !os.IsNotExist(err) {
+		t.Error("LoadKey() created a key file although a key was given")
+	}
+}
+
+func TestLoadKeyCreatesAMissingKeyFile(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "vault.key")
+	created, err := LoadKey("", keyFile)
+	if err != nil || len(created) != keySize {
+		t.Fatalf("LoadKey() = %x, %v", created, err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// The next start reads the same key
+	reloaded, err := LoadKey("", keyFile)
+	if err != nil || !bytes.Equal(reloaded, created) {
+		t.Fatalf("LoadKey() on restart = %x, %v, want %x", reloaded, err, created)
+	}
+
+	replacement, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKeyFile(keyFile, replacement); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Load("", keyFile); err != nil || got == nil {
+		t.Fatalf("Load() after WriteKeyFile() = %v, %v", got, err)
+	}
+	if err := WriteKeyFile(keyFile, "not base64!"); err == nil {
+		t.Error("WriteKeyFile() accepted a key that isn't base64")
+	}
+	if _, err := os.Stat(keyFile + ".tmp"); !os.IsNotExist(err) {
+		t.Error("WriteKeyFile() left its temporary file behind")
+	}
+}